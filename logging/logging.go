@@ -0,0 +1,173 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FormatJSON writes each message as a single line JSON object ({"time":...,"level":...,"msg":...})
+	FormatJSON = "json"
+	// FormatText writes each message as free-form text (the standard log package format)
+	FormatText = "text"
+	// LevelDebug is the level for verbose messages (Example: why a pod is not routable)
+	LevelDebug = "debug"
+	// LevelError is the level for failures
+	LevelError = "error"
+	// LevelInfo is the level for messages describing the normal operation of the router
+	LevelInfo = "info"
+	// LevelWarn is the level for problems the router works around (Example: pod routing issues)
+	LevelWarn = "warn"
+)
+
+// levelSeverities is the severity of each level, messages below the configured level's severity are suppressed
+var levelSeverities = map[string]int{
+	LevelDebug: 0,
+	LevelInfo:  1,
+	LevelWarn:  2,
+	LevelError: 3,
+}
+
+var format = FormatText
+var level = LevelInfo
+var logMutex sync.Mutex
+var output io.Writer = os.Stderr
+var textLogger = log.New(output, "", log.LstdFlags)
+
+type jsonMessage struct {
+	Time  string `json:"time"`
+	Level string `json:"level"`
+	Msg   string `json:"msg"`
+}
+
+/*
+IsValidFormat returns whether the provided format is one of text or json
+*/
+func IsValidFormat(logFormat string) bool {
+	return logFormat == FormatText || logFormat == FormatJSON
+}
+
+/*
+IsValidLevel returns whether the provided level is one of debug, info, warn or error
+*/
+func IsValidLevel(logLevel string) bool {
+	_, ok := levelSeverities[logLevel]
+
+	return ok
+}
+
+/*
+Configure sets the level below which messages are suppressed and the format messages are written in
+*/
+func Configure(logLevel, logFormat string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	if IsValidLevel(logLevel) {
+		level = logLevel
+	}
+
+	if IsValidFormat(logFormat) {
+		format = logFormat
+	}
+}
+
+/*
+SetOutput sets the destination of the messages (stderr by default)
+*/
+func SetOutput(writer io.Writer) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	output = writer
+	textLogger = log.New(output, "", log.LstdFlags)
+}
+
+func write(messageLevel, messageFormat string, args ...interface{}) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	if levelSeverities[messageLevel] < levelSeverities[level] {
+		return
+	}
+
+	message := fmt.Sprintf(messageFormat, args...)
+
+	if format == FormatJSON && strings.TrimSpace(message) == "" {
+		// Blank lines only separate text messages
+		return
+	} else if format == FormatJSON {
+		encoded, err := json.Marshal(&jsonMessage{
+			Time:  time.Now().UTC().Format(time.RFC3339),
+			Level: messageLevel,
+			Msg:   strings.TrimSpace(message),
+		})
+
+		if err != nil {
+			textLogger.Printf("Failed to encode the log message (%s): %v", message, err)
+		} else {
+			output.Write(append(encoded, '\n'))
+		}
+	} else {
+		textLogger.Print(message)
+	}
+}
+
+/*
+Debugf writes a debug message
+*/
+func Debugf(messageFormat string, args ...interface{}) {
+	write(LevelDebug, messageFormat, args...)
+}
+
+/*
+Errorf writes an error message
+*/
+func Errorf(messageFormat string, args ...interface{}) {
+	write(LevelError, messageFormat, args...)
+}
+
+/*
+Fatalf writes an error message and exits
+*/
+func Fatalf(messageFormat string, args ...interface{}) {
+	write(LevelError, messageFormat, args...)
+
+	os.Exit(1)
+}
+
+/*
+Infof writes an info message
+*/
+func Infof(messageFormat string, args ...interface{}) {
+	write(LevelInfo, messageFormat, args...)
+}
+
+/*
+Warnf writes a warning message
+*/
+func Warnf(messageFormat string, args ...interface{}) {
+	write(LevelWarn, messageFormat, args...)
+}
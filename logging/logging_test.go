@@ -0,0 +1,137 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+/*
+captureOutput configures the level and format and returns the buffer the messages are written to
+*/
+func captureOutput(logLevel, logFormat string) *bytes.Buffer {
+	var buffer bytes.Buffer
+
+	Configure(logLevel, logFormat)
+	SetOutput(&buffer)
+
+	return &buffer
+}
+
+/*
+resetOutput restores the default level, format and output
+*/
+func resetOutput() {
+	Configure(LevelInfo, FormatText)
+	SetOutput(os.Stderr)
+}
+
+/*
+Test for github.com/30x/k8s-router/logging#IsValidLevel and github.com/30x/k8s-router/logging#IsValidFormat
+*/
+func TestIsValidLevelAndFormat(t *testing.T) {
+	for _, logLevel := range []string{LevelDebug, LevelInfo, LevelWarn, LevelError} {
+		if !IsValidLevel(logLevel) {
+			t.Fatalf("%s should be a valid level", logLevel)
+		}
+	}
+
+	for _, logLevel := range []string{"", "trace", "INFO", "warning"} {
+		if IsValidLevel(logLevel) {
+			t.Fatalf("%s should not be a valid level", logLevel)
+		}
+	}
+
+	if !IsValidFormat(FormatText) || !IsValidFormat(FormatJSON) || IsValidFormat("xml") || IsValidFormat("") {
+		t.Fatal("Only text and json should be valid formats")
+	}
+}
+
+/*
+Test for the suppression of messages below the configured level
+*/
+func TestLevels(t *testing.T) {
+	defer resetOutput()
+
+	buffer := captureOutput(LevelWarn, FormatText)
+
+	Debugf("debug %s", "message")
+	Infof("info %s", "message")
+	Warnf("warn %s", "message")
+	Errorf("error %s", "message")
+
+	logged := buffer.String()
+
+	if strings.Contains(logged, "debug message") || strings.Contains(logged, "info message") {
+		t.Fatalf("Messages below the warn level should be suppressed:\n%s", logged)
+	} else if !strings.Contains(logged, "warn message\n") || !strings.Contains(logged, "error message\n") {
+		t.Fatalf("Messages at or above the warn level should be written:\n%s", logged)
+	}
+
+	// Debug writes everything
+	buffer = captureOutput(LevelDebug, FormatText)
+
+	Debugf("    Pod (%s) is not routable: Not running (%s)\n", "testing", "Pending")
+
+	if logged = buffer.String(); !strings.HasSuffix(logged, "    Pod (testing) is not routable: Not running (Pending)\n") {
+		t.Fatalf("Debug messages should be written at the debug level:\n%s", logged)
+	}
+
+	// Invalid levels are ignored
+	buffer = captureOutput("trace", FormatText)
+
+	Infof("info message")
+
+	if logged = buffer.String(); !strings.Contains(logged, "info message") {
+		t.Fatalf("An invalid level should not change the configured level:\n%s", logged)
+	}
+}
+
+/*
+Test for the json format
+*/
+func TestJSONFormat(t *testing.T) {
+	defer resetOutput()
+
+	buffer := captureOutput(LevelInfo, FormatJSON)
+
+	Debugf("debug message")
+	Infof("")
+	Warnf("    Pod (%s) routing issue: %s\n", "testing", "\"quoted\"")
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+
+	if len(lines) != 1 {
+		t.Fatalf("Only the warn message should be written:\n%s", buffer.String())
+	}
+
+	var message jsonMessage
+
+	if err := json.Unmarshal([]byte(lines[0]), &message); err != nil {
+		t.Fatalf("The message should be valid JSON (%s): %v", lines[0], err)
+	} else if message.Level != LevelWarn {
+		t.Fatalf("Expected level %s but found %s", LevelWarn, message.Level)
+	} else if message.Msg != "Pod (testing) routing issue: \"quoted\"" {
+		t.Fatalf("Unexpected message: %s", message.Msg)
+	} else if message.Time == "" {
+		t.Fatal("The message should have a time")
+	}
+}
@@ -17,10 +17,17 @@ limitations under the License.
 package main
 
 import (
-	"log"
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/30x/k8s-router/kubernetes"
+	"github.com/30x/k8s-router/logging"
+	"github.com/30x/k8s-router/metrics"
 	"github.com/30x/k8s-router/nginx"
 	"github.com/30x/k8s-router/router"
 
@@ -29,72 +36,293 @@ import (
 	"k8s.io/kubernetes/pkg/watch"
 )
 
-func initController(config *router.Config, kubeClient *client.Client) (*router.Cache, watch.Interface, watch.Interface) {
-	log.Println("Searching for routable pods")
+/*
+loadRouterSecrets queries the initial list of router secrets (retrying to tolerate a briefly unavailable API server) and
+only adds them to the cache once the list succeeds, so that a failed list never leaves the cache partially populated
+*/
+func loadRouterSecrets(config *router.Config, cache *router.Cache, listSecrets func() (*api.SecretList, error)) error {
+	var secrets *api.SecretList
+
+	err := router.RetryOnStartup(config, "query the initial list of secrets", func() error {
+		var err error
 
-	// Query the initial list of Pods
-	pods, err := router.GetRoutablePodList(config, kubeClient)
+		secrets, err = listSecrets()
+
+		return err
+	})
 
 	if err != nil {
-		log.Fatalf("Failed to query the initial list of pods: %v.", err)
+		return err
+	}
+
+	// Turn the secrets into a map based on the secret's namespace
+	for i, secret := range secrets.Items {
+		cache.Secrets[secret.Namespace] = router.ConvertSecretToModel(config, &(secrets.Items[i]))
 	}
 
-	log.Printf("  Pods found: %d", len(pods.Items))
+	logging.Infof("  Secrets found: %d", len(secrets.Items))
+
+	return nil
+}
+
+/*
+buildCache queries the routable pods, router secrets and TLS secrets into a new cache and returns it along with the
+resource version of the pod list to start watching from
+*/
+func buildCache(config *router.Config, kubeClient *client.Client) (*router.Cache, string) {
+	logging.Infof("Searching for routable pods")
+
+	// Query the initial list of Pods (retrying to tolerate a briefly unavailable API server)
+	var pods *api.PodList
+
+	err := router.RetryOnStartup(config, "query the initial list of pods", func() error {
+		var err error
+
+		pods, err = router.GetRoutablePodList(config, kubeClient)
+
+		return err
+	})
+
+	if err != nil {
+		logging.Fatalf("Failed to query the initial list of pods: %v.", err)
+	}
+
+	logging.Infof("  Pods found: %d", len(pods.Items))
 
 	// Create a cache to keep track of the router "API Keys" and Pods (with routes)
 	cache := &router.Cache{
-		Pods:    make(map[string]*router.PodWithRoutes),
-		Secrets: make(map[string][]byte),
+		Pods:     make(map[string]*router.PodWithRoutes),
+		Secrets:  make(map[string]*api.Secret),
+		TLSCerts: make(map[string]*api.Secret),
 	}
 
-	// Turn the pods into a map based on the pod's name
-	for i, pod := range pods.Items {
-		cache.Pods[pod.Name] = router.ConvertPodToModel(config, &(pods.Items[i]))
+	// Turn the pods into a map based on the pod's namespace and name
+	for i := range pods.Items {
+		cache.Pods[router.GetPodCacheKey(&(pods.Items[i]))] = router.ConvertPodToModel(config, &(pods.Items[i]))
 	}
 
-	// Query the initial list of Secrets
-	secrets, err := router.GetRouterSecretList(config, kubeClient)
+	err = loadRouterSecrets(config, cache, func() (*api.SecretList, error) {
+		return router.GetRouterSecretList(config, kubeClient)
+	})
 
 	if err != nil {
-		log.Fatalf("Failed to query the initial list of secrets: %v", err)
+		logging.Fatalf("Failed to query the initial list of secrets: %v", err)
 	}
 
-	// Turn the secrets into a map based on the secret's namespace
-	for i, secret := range secrets.Items {
-		cache.Secrets[secret.Namespace] = router.ConvertSecretToModel(config, &(secrets.Items[i]))
+	if config.TLSSecret != "" {
+		// Query the initial list of TLS secrets (retrying to tolerate a briefly unavailable API server)
+		var tlsSecrets *api.SecretList
+
+		err = router.RetryOnStartup(config, "query the initial list of TLS secrets", func() error {
+			var err error
+
+			tlsSecrets, err = router.GetTLSSecretList(config, kubeClient)
+
+			return err
+		})
+
+		if err != nil {
+			logging.Fatalf("Failed to query the initial list of TLS secrets: %v", err)
+		}
+
+		// Turn the TLS secrets into a map based on the secret's namespace
+		for i, secret := range tlsSecrets.Items {
+			cache.TLSCerts[secret.Namespace] = &(tlsSecrets.Items[i])
+		}
+
+		logging.Infof("  TLS secrets found: %d", len(tlsSecrets.Items))
 	}
 
-	log.Printf("  Secrets found: %d", len(secrets.Items))
+	return cache, pods.ListMeta.ResourceVersion
+}
 
-	// Generate the nginx configuration and restart nginx
-	nginx.RestartServer(nginx.GetConf(config, cache), false)
+/*
+dryRun writes the nginx configuration generated for the cache to out, without writing or starting anything
+*/
+func dryRun(config *router.Config, cache *router.Cache, out io.Writer) error {
+	_, err := io.WriteString(out, nginx.GetConf(config, cache))
+
+	return err
+}
+
+func initController(config *router.Config, kubeClient *client.Client, state *router.ControllerState) (*router.Cache, watch.Interface, watch.Interface, watch.Interface) {
+	cache, resourceVersion := buildCache(config, kubeClient)
 
 	// Get the list options so we can create the watch
 	podWatchOptions := api.ListOptions{
-		LabelSelector:   config.RoutableLabelSelector,
-		ResourceVersion: pods.ListMeta.ResourceVersion,
+		LabelSelector:   router.GetRoutableListSelector(config),
+		ResourceVersion: resourceVersion,
 	}
 
-	// Create a watcher to be notified of Pod events
-	podWatcher, err := kubeClient.Pods(api.NamespaceAll).Watch(podWatchOptions)
+	// Create a watcher to be notified of Pod events (retrying to tolerate a briefly unavailable API server)
+	var podWatcher watch.Interface
+
+	err := router.RetryOnStartup(config, "create the pod watcher", func() error {
+		var err error
+
+		podWatcher, err = kubeClient.Pods(api.NamespaceAll).Watch(podWatchOptions)
+
+		return err
+	})
 
 	if err != nil {
-		log.Fatalf("Failed to create pod watcher: %v.", err)
+		logging.Fatalf("Failed to create pod watcher: %v.", err)
 	}
 
 	// Get the list options so we can create the watch
 	secretWatchOptions := api.ListOptions{
-		ResourceVersion: pods.ListMeta.ResourceVersion,
+		ResourceVersion: resourceVersion,
+	}
+
+	// Create a watcher to be notified of Secret events (retrying to tolerate a briefly unavailable API server)
+	var secretWatcher watch.Interface
+
+	err = router.RetryOnStartup(config, "create the secret watcher", func() error {
+		var err error
+
+		secretWatcher, err = kubeClient.Secrets(api.NamespaceAll).Watch(secretWatchOptions)
+
+		return err
+	})
+
+	if err != nil {
+		logging.Fatalf("Failed to create secret watcher: %v.", err)
 	}
 
-	// Create a watcher to be notified of Pod events
-	secretWatcher, err := kubeClient.Secrets(api.NamespaceAll).Watch(secretWatchOptions)
+	// Create a watcher to be notified of Endpoints events, for the pods routed to a service by the routingService
+	// annotation (retrying to tolerate a briefly unavailable API server)
+	var endpointsWatcher watch.Interface
+
+	err = router.RetryOnStartup(config, "create the endpoints watcher", func() error {
+		var err error
+
+		endpointsWatcher, err = kubeClient.Endpoints(api.NamespaceAll).Watch(api.ListOptions{
+			ResourceVersion: resourceVersion,
+		})
+
+		return err
+	})
 
 	if err != nil {
-		log.Fatalf("Failed to create secret watcher: %v.", err)
+		logging.Fatalf("Failed to create endpoints watcher: %v.", err)
+	}
+
+	// Incorporate the events received while the cluster settles into the initial configuration
+	if config.StartupSettleDelay > 0 {
+		logging.Infof("  Waiting %s for the cluster to settle", config.StartupSettleDelay)
+
+		router.SettleCache(config, cache, podWatcher, secretWatcher)
+	}
+
+	metrics.SetRoutablePods(router.CountRoutablePods(cache.Pods))
+
+	state.SetCacheBuilt()
+
+	// Generate the nginx configuration and restart nginx
+	nginx.WriteTLSCerts(cache)
+	state.RecordReload(nginx.RestartServer(config, nginx.GetConf(config, cache), false))
+
+	return cache, podWatcher, secretWatcher, endpointsWatcher
+}
+
+// eventBatch is a window worth of events collected from the pod, secret and endpoints watchers
+type eventBatch struct {
+	endpointsEvents []watch.Event
+	podEvents       []watch.Event
+	secretEvents    []watch.Event
+	tlsCertEvents   []watch.Event
+	// Whether a watcher was closed, requiring the controller to be recreated
+	restart bool
+	// Whether the router is shutting down
+	done bool
+}
+
+/*
+collectEvents collects the pod, secret and endpoints events of a burst: the window starts when the first event is
+recorded and is restarted by each recorded event, so the batch ends once no event is recorded for the duration of
+window, but never later than maxWindow after the first event.  Endpoints events are only recorded for the services
+cached pods are routed to.  Collection stops early when any channel is closed by Kubernetes or when done is closed.
+*/
+func collectEvents(config *router.Config, cache *router.Cache, podChan, secretChan, endpointsChan <-chan watch.Event, done <-chan struct{}, window, maxWindow time.Duration) *eventBatch {
+	batch := &eventBatch{}
+
+	// The windows are only started once the first event is recorded (a nil channel blocks until then)
+	var maxWindowEnd <-chan time.Time
+	var windowEnd <-chan time.Time
+
+	startWindow := func() {
+		if maxWindowEnd == nil {
+			maxWindowEnd = time.After(maxWindow)
+		}
+
+		windowEnd = time.After(window)
 	}
 
-	return cache, podWatcher, secretWatcher
+	for {
+		select {
+		case event, ok := <-podChan:
+			if !ok {
+				logging.Warnf("Kubernetes closed the pod watcher, restarting")
+
+				batch.restart = true
+
+				return batch
+			}
+
+			batch.podEvents = append(batch.podEvents, event)
+
+			startWindow()
+
+		case event, ok := <-secretChan:
+			if !ok {
+				logging.Warnf("Kubernetes closed the secret watcher, restarting")
+
+				batch.restart = true
+
+				return batch
+			}
+
+			secret := event.Object.(*api.Secret)
+
+			// Only record secret events for secrets with the name we are interested in
+			if secret.Name == config.APIKeySecret {
+				batch.secretEvents = append(batch.secretEvents, event)
+
+				startWindow()
+			} else if router.IsTLSSecret(config, secret) {
+				batch.tlsCertEvents = append(batch.tlsCertEvents, event)
+
+				startWindow()
+			}
+
+		case event, ok := <-endpointsChan:
+			if !ok {
+				logging.Warnf("Kubernetes closed the endpoints watcher, restarting")
+
+				batch.restart = true
+
+				return batch
+			}
+
+			// Only record endpoints events for the services pods are routed to
+			if router.IsRoutingServiceEndpoints(cache.Pods, event.Object.(*api.Endpoints)) {
+				batch.endpointsEvents = append(batch.endpointsEvents, event)
+
+				startWindow()
+			}
+
+		case <-done:
+			batch.done = true
+
+			return batch
+
+		case <-windowEnd:
+			return batch
+
+		case <-maxWindowEnd:
+			return batch
+		}
+	}
 }
 
 /*
@@ -108,117 +336,270 @@ proper kube config is detected.  (This can be useful for inspecting the routing
 cluster.)
 */
 func main() {
-	log.Println("Starting the Kubernetes Router")
+	logging.Infof("Starting the Kubernetes Router")
 
 	// Get the configuration
 	config, err := router.ConfigFromEnv()
 
 	if err != nil {
-		log.Fatalf("Invalid configuration: %v.", err)
+		logging.Fatalf("Invalid configuration: %v.", err)
 	}
 
+	// The --dry-run flag is equivalent to DRY_RUN=true
+	dryRunFlag := flag.Bool("dry-run", false, "Print the nginx configuration of the cluster and exit without starting nginx")
+
+	flag.Parse()
+
+	config.DryRun = config.DryRun || *dryRunFlag
+
+	// Configure the router logs
+	logging.Configure(config.LogLevel, config.LogFormat)
+
 	// Print the configuration
-	log.Println("  Using configuration:")
-	log.Printf("    API Key Header Name: %s\n", config.APIKeyHeader)
-	log.Printf("    API Key Secret Name: %s\n", config.APIKeySecret)
-	log.Printf("    API Key Secret Data Field: %s\n", config.APIKeySecretDataField)
-	log.Printf("    Hosts Annotation: %s\n", config.HostsAnnotation)
-	log.Printf("    Max client request size (0 indicates there is no maximum): %s\n", config.ClientMaxBodySize)
-	log.Printf("    Paths Annotation: %s\n", config.PathsAnnotation)
-	log.Printf("    Port (nginx): %d\n", config.Port)
-	log.Printf("    Routable Label Selector: %s\n", config.RoutableLabelSelector)
-	log.Println("")
+	logging.Infof("  Using configuration:")
+	logging.Infof("    Absolute Redirect: %t\n", config.AbsoluteRedirect)
+	logging.Infof("    Access Log Format: %s\n", config.AccessLogFormat)
+	logging.Infof("    Access Log Path: %s\n", config.AccessLogPath)
+	logging.Infof("    Always Add Headers: %t\n", config.AlwaysAddHeaders)
+	logging.Infof("    Annotation Delimiter: %q\n", config.AnnotationDelimiter)
+	logging.Infof("    API Key Header Name: %s\n", config.APIKeyHeader)
+	logging.Infof("    API Key Secret Name: %s\n", config.APIKeySecret)
+	logging.Infof("    API Key Secret Data Field: %s\n", config.APIKeySecretDataField)
+	logging.Infof("    Basic Auth Secret Data Field: %s\n", config.BasicAuthSecretDataField)
+	logging.Infof("    Charset: %s\n", config.Charset)
+	logging.Infof("    Client Header Buffer Size: %s\n", config.ClientHeaderBufferSize)
+	logging.Infof("    Consolidate Upstreams: %t\n", config.ConsolidateUpstreams)
+	logging.Infof("    Default Server Return: %d\n", config.DefaultServerReturn)
+	logging.Infof("    Default Type: %s\n", config.DefaultType)
+	logging.Infof("    Dry Run: %t\n", config.DryRun)
+	logging.Infof("    Empty Cache Retry After: %d\n", config.EmptyCacheRetryAfter)
+	logging.Infof("    Empty Cache Status: %d\n", config.EmptyCacheStatus)
+	logging.Infof("    Empty Path To Root: %t\n", config.EmptyPathToRoot)
+	logging.Infof("    Empty Secret Action: %s\n", config.EmptySecretAction)
+	logging.Infof("    Enable Access Log: %t\n", config.EnableAccessLog)
+	logging.Infof("    Enable Debug Endpoints: %t\n", config.EnableDebugEndpoints)
+	logging.Infof("    Enable Dynamic Upstreams: %t\n", config.EnableDynamicUpstreams)
+	logging.Infof("    Enable Gzip: %t\n", config.EnableGzip)
+	logging.Infof("    Enable Nginx Upstream Check Module: %t\n", config.EnableNginxUpstreamCheckModule)
+	logging.Infof("    Enable Rate Limit: %t\n", config.EnableRateLimit)
+	logging.Infof("    Enable TLS Passthrough: %t\n", config.EnableTLSPassthrough)
+	logging.Infof("    Enable Traffic Status: %t\n", config.EnableTrafficStatus)
+	logging.Infof("    Error Log Level: %s\n", config.ErrorLogLevel)
+	logging.Infof("    Error Log To Stderr: %t\n", config.ErrorLogToStderr)
+	logging.Infof("    Error Pages: %v\n", config.ErrorPages)
+	logging.Infof("    Fallback Backend: %s\n", config.FallbackBackend)
+	logging.Infof("    Forward Port: %t\n", config.ForwardPort)
+	logging.Infof("    Forwarded Port (0 indicates the port nginx received the request on): %d\n", config.ForwardedPort)
+	logging.Infof("    Gzip Types: %s\n", config.GzipTypes)
+	logging.Infof("    Health Check Probes: %s\n", strings.Join(config.HealthCheckProbes, " "))
+	logging.Infof("    Hide Backend Headers: %s\n", strings.Join(config.HideBackendHeaders, " "))
+	logging.Infof("    Hosts Annotation: %s\n", config.HostsAnnotation)
+	logging.Infof("    Include Files: %s\n", strings.Join(config.IncludeFiles, " "))
+	logging.Infof("    Limit Conn Status: %d\n", config.LimitConnStatus)
+	logging.Infof("    Limit Req Status: %d\n", config.LimitReqStatus)
+	logging.Infof("    Listen IPv6: %t\n", config.ListenIPv6)
+	logging.Infof("    Load Balance Method: %s\n", config.LoadBalanceMethod)
+	logging.Infof("    Log Format: %s\n", config.LogFormat)
+	logging.Infof("    Log Level: %s\n", config.LogLevel)
+	logging.Infof("    Max Connections (0 indicates worker_connections is not derived): %d\n", config.MaxConnections)
+	logging.Infof("    Max Locations Per Host (0 indicates unlimited): %d\n", config.MaxLocationsPerHost)
+	logging.Infof("    Max Reloads Before Restart (0 indicates never): %d\n", config.MaxReloadsBeforeRestart)
+	logging.Infof("    Max client request size (0 indicates there is no maximum): %s\n", config.ClientMaxBodySize)
+	logging.Infof("    Nginx Binary: %s\n", config.NginxBinary)
+	logging.Infof("    Nginx Conf Path: %s\n", config.NginxConfPath)
+	logging.Infof("    Not Found Backend: %s\n", config.NotFoundBackend)
+	logging.Infof("    Paths Annotation: %s\n", config.PathsAnnotation)
+	logging.Infof("    PID Path (nginx): %s\n", config.PidPath)
+	logging.Infof("    Port (nginx): %d\n", config.Port)
+	logging.Infof("    Port In Redirect: %t\n", config.PortInRedirect)
+	logging.Infof("    Previous API Key Field: %s\n", config.PreviousAPIKeyField)
+	logging.Infof("    Proxy Cache Max Size: %s\n", config.ProxyCacheMaxSize)
+	logging.Infof("    Proxy Cache Path: %s\n", config.ProxyCachePath)
+	logging.Infof("    Proxy Connect Timeout: %s\n", config.ProxyConnectTimeout)
+	logging.Infof("    Proxy Socket Keepalive: %t\n", config.ProxySocketKeepalive)
+	logging.Infof("    Rate Limit Burst: %d\n", config.RateLimitBurst)
+	logging.Infof("    Rate Limit Rate: %s\n", config.RateLimitRate)
+	logging.Infof("    Readiness Port (0 indicates the readiness, health, config hash and metrics endpoints are disabled): %d\n", config.ReadinessPort)
+	logging.Infof("    Reload Via Signal: %t\n", config.ReloadViaSignal)
+	logging.Infof("    Resolver: %s\n", strings.Join(config.Resolver, " "))
+	logging.Infof("    Resolver Timeout: %s\n", config.ResolverTimeout)
+	logging.Infof("    Routable Label Boolean: %t\n", config.RoutableLabelBoolean)
+	logging.Infof("    Routable Label Selector: %s\n", config.RoutableLabelSelector)
+	logging.Infof("    Shutdown Grace Period: %s\n", config.ShutdownGracePeriod)
+	logging.Infof("    Startup Retries: %d\n", config.StartupRetries)
+	logging.Infof("    Startup Retry Interval: %s\n", config.StartupRetryInterval)
+	logging.Infof("    Startup Settle Delay: %s\n", config.StartupSettleDelay)
+	logging.Infof("    TCP Nodelay: %t\n", config.TCPNodelay)
+	logging.Infof("    TCP Nopush: %t\n", config.TCPNopush)
+	logging.Infof("    TLS Passthrough Port: %d\n", config.TLSPassthroughPort)
+	logging.Infof("    TLS Port: %d\n", config.TLSPort)
+	logging.Infof("    TLS Secret (empty indicates TLS termination is disabled): %s\n", config.TLSSecret)
+	logging.Infof("    Traffic Status Port: %d\n", config.TrafficStatusPort)
+	logging.Infof("    Upstream Keepalive: %d\n", config.UpstreamKeepalive)
+	logging.Infof("    Upstream Keepalive Requests: %d\n", config.UpstreamKeepaliveRequests)
+	logging.Infof("    Upstream Keepalive Time: %s\n", config.UpstreamKeepaliveTime)
+	logging.Infof("    Upstream Server Order: %s\n", config.UpstreamServerOrder)
+	logging.Infof("    Warn On Duplicate Routes: %t\n", config.WarnOnDuplicateRoutes)
+	logging.Infof("    Weight By Resources: %t\n", config.WeightByResources)
+	logging.Infof("    Worker Connections: %d\n", config.WorkerConnections)
+	logging.Infof("    Worker Processes: %s\n", config.WorkerProcesses)
+	logging.Infof("")
 
 	// Create the Kubernetes Client
 	kubeClient, err := kubernetes.GetClient()
 
 	if err != nil {
-		log.Fatalf("Failed to create client: %v.", err)
+		logging.Fatalf("Failed to create client: %v.", err)
+	}
+
+	// Resolve the endpoints of the services named by the routingService annotation
+	router.ServiceEndpointsResolver = func(namespace, name string) ([]string, error) {
+		return router.GetServiceEndpoints(kubeClient, namespace, name)
+	}
+
+	// Print the configuration for the current state of the cluster without starting nginx (regardless of mock mode)
+	if config.DryRun {
+		cache, _ := buildCache(config, kubeClient)
+
+		if err := dryRun(config, cache, os.Stdout); err != nil {
+			logging.Fatalf("Failed to print the nginx configuration: %v.", err)
+		}
+
+		os.Exit(0)
 	}
 
 	// Don't write nginx conf when not in cluster
 	nginx.RunInMockMode = !(kubernetes.RunningInCluster())
 
 	// Start nginx with the default configuration to start nginx as a daemon
-	nginx.StartServer(nginx.GetDefaultConf(config))
+	nginx.StartServer(config, nginx.GetDefaultConf(config))
 
-	// Create the initial cache and watcher
-	cache, podWatcher, secretWatcher := initController(config, kubeClient)
+	// Report the health of the watch loop while the initial cache is built (the router is ready once nginx is
+	// successfully configured)
+	state := &router.ControllerState{}
 
-	// Loop forever
-	for {
-		var podEvents []watch.Event
-		var secretEvents []watch.Event
+	router.StartReadinessServer(config, state)
 
-		// Get a 2 seconds window worth of events
-		for {
-			doRestart := false
-			doStop := false
+	// Create the initial cache and watcher
+	cache, podWatcher, secretWatcher, endpointsWatcher := initController(config, kubeClient, state)
 
-			select {
-			case event, ok := <-podWatcher.ResultChan():
-				if !ok {
-					log.Println("Kubernetes closed the pod watcher, restarting")
+	// Drain the router when Kubernetes stops the pod (or the router is interrupted)
+	shutdownSignals := make(chan os.Signal, 1)
+	done := make(chan struct{})
 
-					doRestart = true
-				} else {
-					podEvents = append(podEvents, event)
-				}
+	signal.Notify(shutdownSignals, syscall.SIGINT, syscall.SIGTERM)
 
-			case event, ok := <-secretWatcher.ResultChan():
-				if !ok {
-					log.Println("Kubernetes closed the secret watcher, restarting")
+	go func() {
+		sig := <-shutdownSignals
 
-					doRestart = true
-				} else {
-					secret := event.Object.(*api.Secret)
+		logging.Infof("Received %s", sig)
 
-					// Only record secret events for secrets with the name we are interested in
-					if secret.Name == config.APIKeySecret {
-						secretEvents = append(secretEvents, event)
-					}
-				}
+		close(done)
+	}()
 
-			// TODO: Rewrite to start the two seconds after the first post-restart event is seen
-			case <-time.After(2 * time.Second):
-				doStop = true
-			}
+	// Loop until shut down
+	for {
+		// Get the events of a burst (until 2 seconds without events, at most 5 seconds after the first event)
+		batch := collectEvents(config, cache, podWatcher.ResultChan(), secretWatcher.ResultChan(),
+			endpointsWatcher.ResultChan(), done, 2*time.Second, 5*time.Second)
 
-			if doStop {
-				break
-			} else if doRestart {
+		if batch.done {
+			router.Shutdown(config, func() {
 				podWatcher.Stop()
 				secretWatcher.Stop()
+				endpointsWatcher.Stop()
+			}, func() {
+				nginx.QuitServer(config)
+			})
 
-				cache, podWatcher, secretWatcher = initController(config, kubeClient)
-			}
+			return
+		} else if batch.restart {
+			podWatcher.Stop()
+			secretWatcher.Stop()
+			endpointsWatcher.Stop()
+
+			// The initial list replaces the cache so the events collected so far are no longer needed
+			cache, podWatcher, secretWatcher, endpointsWatcher = initController(config, kubeClient, state)
+
+			continue
 		}
 
+		endpointsEvents := batch.endpointsEvents
+		podEvents := batch.podEvents
+		secretEvents := batch.secretEvents
+		tlsCertEvents := batch.tlsCertEvents
+
+		// Keep the cache nginx is configured with so that the changes can be classified
+		previous := cache.Copy()
 		needsRestart := false
+		tlsCertsChanged := false
 
 		if len(podEvents) > 0 {
-			log.Printf("%d pod events found", len(podEvents))
+			logging.Infof("%d pod events found", len(podEvents))
 
 			// Update the cache based on the events and check if the server needs to be restarted
 			needsRestart = router.UpdatePodCacheForEvents(config, cache.Pods, podEvents)
 		}
 
 		if !needsRestart && len(secretEvents) > 0 {
-			log.Printf("%d secret events found", len(secretEvents))
+			logging.Infof("%d secret events found", len(secretEvents))
 
 			// Update the cache based on the events and check if the server needs to be restarted
 			needsRestart = router.UpdateSecretCacheForEvents(config, cache.Secrets, secretEvents)
 		}
 
+		if len(endpointsEvents) > 0 {
+			logging.Infof("%d endpoints events found", len(endpointsEvents))
+
+			// Update the routes of the pods routed to the services and check if the server needs to be restarted
+			if router.UpdateRoutingServiceCacheForEvents(config, cache.Pods, endpointsEvents, func(namespace, name string) (*api.Pod, error) {
+				return kubeClient.Pods(namespace).Get(name)
+			}) {
+				needsRestart = true
+			}
+		}
+
+		if len(tlsCertEvents) > 0 {
+			logging.Infof("%d TLS secret events found", len(tlsCertEvents))
+
+			// Always update the TLS certificate cache so that the written certificates are never stale
+			if router.UpdateTLSCertCacheForEvents(config, cache.TLSCerts, tlsCertEvents) {
+				needsRestart = true
+				tlsCertsChanged = true
+			}
+		}
+
 		// Wrapped in an if/else to limit logging
-		if len(podEvents) > 0 || len(secretEvents) > 0 {
-			if needsRestart {
-				log.Println("  Requires nginx restart: yes")
+		if len(podEvents) > 0 || len(secretEvents) > 0 || len(endpointsEvents) > 0 || len(tlsCertEvents) > 0 {
+			plan := nginx.NoReload
+
+			if tlsCertsChanged {
+				// Renewed certificates are written to the same paths so only a reload picks them up
+				plan = nginx.FullReload
+			} else if needsRestart {
+				plan = nginx.DiffConf(config, previous, cache)
+			}
+
+			if plan == nginx.DynamicUpstream {
+				logging.Infof("  Requires nginx restart: no (upstream servers changed)")
+
+				if err := nginx.UpdateUpstreams(config, previous, cache); err != nil {
+					logging.Warnf("Failed to update the upstream servers, restarting nginx: %v", err)
+
+					plan = nginx.FullReload
+				} else {
+					state.RecordReload(nil)
+				}
+			}
+
+			if plan == nginx.FullReload {
+				logging.Infof("  Requires nginx restart: yes")
 
 				// Restart nginx
-				nginx.RestartServer(nginx.GetConf(config, cache), false)
-			} else {
-				log.Println("  Requires nginx restart: no")
+				nginx.WriteTLSCerts(cache)
+				state.RecordReload(nginx.RestartServer(config, nginx.GetConf(config, cache), false))
+			} else if plan == nginx.NoReload {
+				logging.Infof("  Requires nginx restart: no")
 			}
 		}
 	}
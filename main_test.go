@@ -0,0 +1,345 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/30x/k8s-router/nginx"
+	"github.com/30x/k8s-router/router"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+var collectConfig = &router.Config{
+	APIKeySecret: "routing",
+	TLSSecret:    "routing-tls",
+}
+
+var collectCache = &router.Cache{
+	Pods: map[string]*router.PodWithRoutes{
+		"testing/routed": {
+			Name:           "routed",
+			Namespace:      "testing",
+			RoutingService: "backend",
+		},
+	},
+}
+
+func makeEndpointsEvent(name string) watch.Event {
+	return watch.Event{
+		Type: watch.Modified,
+		Object: &api.Endpoints{
+			ObjectMeta: api.ObjectMeta{
+				Name:      name,
+				Namespace: "testing",
+			},
+		},
+	}
+}
+
+func makeSecretEvent(name string) watch.Event {
+	return watch.Event{
+		Type: watch.Added,
+		Object: &api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:      name,
+				Namespace: "testing",
+			},
+		},
+	}
+}
+
+/*
+Test for main#collectEvents
+*/
+func TestCollectEvents(t *testing.T) {
+	podChan := make(chan watch.Event, 3)
+	secretChan := make(chan watch.Event, 3)
+	endpointsChan := make(chan watch.Event, 2)
+	done := make(chan struct{})
+
+	podChan <- watch.Event{Type: watch.Added, Object: &api.Pod{}}
+	secretChan <- makeSecretEvent("routing")
+	secretChan <- makeSecretEvent("routing-tls")
+	secretChan <- makeSecretEvent("unrelated")
+	endpointsChan <- makeEndpointsEvent("backend")
+	endpointsChan <- makeEndpointsEvent("unrelated")
+
+	batch := collectEvents(collectConfig, collectCache, podChan, secretChan, endpointsChan, done, 10*time.Millisecond,
+		time.Second)
+
+	if batch.done || batch.restart {
+		t.Fatalf("The window should end without a restart or shutdown: %+v", batch)
+	} else if len(batch.podEvents) != 1 {
+		t.Fatalf("Expected 1 pod event but found %d", len(batch.podEvents))
+	} else if len(batch.secretEvents) != 1 {
+		t.Fatalf("Expected 1 secret event but found %d", len(batch.secretEvents))
+	} else if len(batch.tlsCertEvents) != 1 {
+		t.Fatalf("Expected 1 TLS secret event but found %d", len(batch.tlsCertEvents))
+	} else if len(batch.endpointsEvents) != 1 {
+		t.Fatalf("Expected 1 endpoints event but found %d", len(batch.endpointsEvents))
+	}
+
+	// Closed endpoints watcher
+	close(endpointsChan)
+
+	if batch = collectEvents(collectConfig, collectCache, podChan, secretChan, endpointsChan, done, time.Minute, time.Minute); !batch.restart || batch.done {
+		t.Fatalf("A closed endpoints watcher should end the window with a restart: %+v", batch)
+	}
+
+	// Closed watcher
+	close(podChan)
+
+	if batch = collectEvents(collectConfig, collectCache, podChan, secretChan, nil, done, time.Minute, time.Minute); !batch.restart || batch.done {
+		t.Fatalf("A closed watcher should end the window with a restart: %+v", batch)
+	}
+}
+
+/*
+Test for main#collectEvents when shutting down
+*/
+func TestCollectEventsDone(t *testing.T) {
+	podChan := make(chan watch.Event)
+	secretChan := make(chan watch.Event)
+	done := make(chan struct{})
+
+	go func() {
+		podChan <- watch.Event{Type: watch.Added, Object: &api.Pod{}}
+
+		close(done)
+	}()
+
+	batch := collectEvents(collectConfig, collectCache, podChan, secretChan, nil, done, time.Minute, time.Minute)
+
+	if !batch.done || batch.restart {
+		t.Fatalf("Closing done should end the window with a shutdown: %+v", batch)
+	} else if len(batch.podEvents) != 1 {
+		t.Fatalf("Expected 1 pod event but found %d", len(batch.podEvents))
+	}
+}
+
+/*
+sendPodEvents sends a pod event after each of the delays
+*/
+func sendPodEvents(podChan chan<- watch.Event, delays ...time.Duration) {
+	for _, delay := range delays {
+		time.Sleep(delay)
+
+		podChan <- watch.Event{Type: watch.Added, Object: &api.Pod{}}
+	}
+}
+
+/*
+Test for main#collectEvents starting the window with the first event and restarting it with each event
+*/
+func TestCollectEventsWindow(t *testing.T) {
+	window := 100 * time.Millisecond
+	podChan := make(chan watch.Event, 5)
+	secretChan := make(chan watch.Event, 1)
+	done := make(chan struct{})
+	start := time.Now()
+
+	// Ignored secret events do not start the window
+	secretChan <- makeSecretEvent("unrelated")
+
+	// A burst trickling in over longer than the window, starting after longer than the window
+	go sendPodEvents(podChan, 2*window, window/2, window/2, window/2)
+
+	batch := collectEvents(collectConfig, collectCache, podChan, secretChan, nil, done, window, 10*window)
+	elapsed := time.Since(start)
+
+	if batch.done || batch.restart {
+		t.Fatalf("The window should end without a restart or shutdown: %+v", batch)
+	} else if len(batch.podEvents) != 4 {
+		t.Fatalf("Expected the burst in a single batch of 4 pod events but found %d", len(batch.podEvents))
+	} else if elapsed < 9*window/2 {
+		t.Fatalf("The window should end a window after the last event but ended after %s", elapsed)
+	}
+}
+
+/*
+Test for main#collectEvents bounding the window by the max window
+*/
+func TestCollectEventsMaxWindow(t *testing.T) {
+	window := 100 * time.Millisecond
+	podChan := make(chan watch.Event, 10)
+	secretChan := make(chan watch.Event)
+	done := make(chan struct{})
+	start := time.Now()
+
+	// Events every half window would restart the window forever
+	go sendPodEvents(podChan, 0, window/2, window/2, window/2, window/2, window/2, window/2, window/2, window/2,
+		window/2)
+
+	batch := collectEvents(collectConfig, collectCache, podChan, secretChan, nil, done, window, 2*window)
+	elapsed := time.Since(start)
+
+	if batch.done || batch.restart {
+		t.Fatalf("The window should end without a restart or shutdown: %+v", batch)
+	} else if elapsed < 2*window || elapsed >= 3*window {
+		t.Fatalf("The window should end at the max window but ended after %s", elapsed)
+	} else if len(batch.podEvents) == 0 || len(batch.podEvents) == 10 {
+		t.Fatalf("Expected only the events within the max window but found %d", len(batch.podEvents))
+	}
+}
+
+/*
+statPath returns a description of the file at path (its modification time and size or that it does not exist)
+*/
+func statPath(path string) string {
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return "missing"
+	}
+
+	return fmt.Sprintf("%s %d", info.ModTime(), info.Size())
+}
+
+/*
+Test for main#dryRun
+*/
+func TestDryRun(t *testing.T) {
+	config, err := router.ConfigFromEnv()
+
+	if err != nil {
+		t.Fatalf("Problem retrieving configuration: %v", err)
+	}
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "test.github.com",
+				"routingPaths": "80:/",
+			},
+			Name:      "testing",
+			Namespace: "testing",
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(80),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.16",
+		},
+	}
+	cache := &router.Cache{
+		Pods:     map[string]*router.PodWithRoutes{router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod)},
+		Secrets:  make(map[string]*api.Secret),
+		TLSCerts: make(map[string]*api.Secret),
+	}
+	confBefore := statPath(config.NginxConfPath)
+	certsBefore := statPath(nginx.NginxCertsDir)
+
+	var out bytes.Buffer
+
+	if err := dryRun(config, cache, &out); err != nil {
+		t.Fatalf("Unexpected error printing the nginx configuration: %v", err)
+	}
+
+	if expected := nginx.GetConf(config, cache); out.String() != expected {
+		t.Fatalf("Expected the nginx configuration to be printed:\n%s\nbut found:\n%s", expected, out.String())
+	} else if !strings.Contains(out.String(), "server 10.244.1.16") {
+		t.Fatalf("Expected the nginx configuration to route to the pod but found:\n%s", out.String())
+	} else if confAfter := statPath(config.NginxConfPath); confAfter != confBefore {
+		t.Fatalf("Expected %s to be left alone (%s) but found %s", config.NginxConfPath, confBefore, confAfter)
+	} else if certsAfter := statPath(nginx.NginxCertsDir); certsAfter != certsBefore {
+		t.Fatalf("Expected %s to be left alone (%s) but found %s", nginx.NginxCertsDir, certsBefore, certsAfter)
+	}
+}
+
+/*
+Test for main#loadRouterSecrets
+*/
+func TestLoadRouterSecrets(t *testing.T) {
+	config := &router.Config{
+		StartupRetries:       1,
+		StartupRetryInterval: time.Millisecond,
+	}
+	makeSecretList := func(namespaces ...string) *api.SecretList {
+		secrets := &api.SecretList{}
+
+		for _, namespace := range namespaces {
+			secrets.Items = append(secrets.Items, api.Secret{
+				ObjectMeta: api.ObjectMeta{
+					Name:      "routing",
+					Namespace: namespace,
+				},
+			})
+		}
+
+		return secrets
+	}
+
+	// A failed list is retried and its (partial) items are never cached
+	calls := 0
+	cache := &router.Cache{
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	err := loadRouterSecrets(config, cache, func() (*api.SecretList, error) {
+		calls++
+
+		if calls == 1 {
+			return makeSecretList("partial"), errors.New("the server is currently unable to handle the request")
+		}
+
+		return makeSecretList("testing", "other"), nil
+	})
+
+	if err != nil {
+		t.Fatalf("Unexpected error loading the secrets: %v", err)
+	} else if calls != 2 {
+		t.Fatalf("Expected the failed list to be retried but found %d calls", calls)
+	} else if _, ok := cache.Secrets["partial"]; ok || len(cache.Secrets) != 2 {
+		t.Fatalf("Expected only the secrets of the successful list to be cached but found: %v", cache.Secrets)
+	}
+
+	// A list failing every retry returns the error without caching anything
+	calls = 0
+	cache.Secrets = make(map[string]*api.Secret)
+
+	err = loadRouterSecrets(config, cache, func() (*api.SecretList, error) {
+		calls++
+
+		return makeSecretList("partial"), errors.New("the server is currently unable to handle the request")
+	})
+
+	if err == nil {
+		t.Fatal("Expected the list error to be returned")
+	} else if calls != 2 {
+		t.Fatalf("Expected the list to be tried twice but found %d calls", calls)
+	} else if len(cache.Secrets) != 0 {
+		t.Fatalf("Expected a failed list to leave the cache empty but found: %v", cache.Secrets)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	configInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "router_config_info",
+		Help: "Always 1, the hash label is the hash of the nginx configuration nginx was last asked to load.",
+	}, []string{"hash"})
+	nginxReloads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "router_nginx_reloads_total",
+		Help: "Number of times nginx was successfully reloaded.",
+	})
+	podEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "router_pod_events_total",
+		Help: "Number of pod events processed, by event type.",
+	}, []string{"type"})
+	routablePods = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "router_routable_pods",
+		Help: "Number of cached pods with at least one route.",
+	})
+	secretEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "router_secret_events_total",
+		Help: "Number of secret events processed, by event type.",
+	}, []string{"type"})
+)
+
+func init() {
+	prometheus.MustRegister(configInfo)
+	prometheus.MustRegister(nginxReloads)
+	prometheus.MustRegister(podEvents)
+	prometheus.MustRegister(routablePods)
+	prometheus.MustRegister(secretEvents)
+}
+
+/*
+Handler returns the handler serving the registered metrics in the Prometheus exposition format
+*/
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+/*
+RecordNginxReload records a successful nginx reload
+*/
+func RecordNginxReload() {
+	nginxReloads.Inc()
+}
+
+/*
+RecordPodEvent records a processed pod event of the event type (ADDED, MODIFIED or DELETED)
+*/
+func RecordPodEvent(eventType string) {
+	podEvents.WithLabelValues(eventType).Inc()
+}
+
+/*
+RecordSecretEvent records a processed secret event of the event type (ADDED, MODIFIED or DELETED)
+*/
+func RecordSecretEvent(eventType string) {
+	secretEvents.WithLabelValues(eventType).Inc()
+}
+
+/*
+SetConfigHash records the hash of the nginx configuration nginx was asked to load, replacing the previous hash
+*/
+func SetConfigHash(hash uint64) {
+	configInfo.Reset()
+	configInfo.WithLabelValues(fmt.Sprintf("%016x", hash)).Set(1)
+}
+
+/*
+SetRoutablePods records the number of cached pods with at least one route
+*/
+func SetRoutablePods(count int) {
+	routablePods.Set(float64(count))
+}
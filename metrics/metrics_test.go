@@ -0,0 +1,129 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+/*
+scrape returns the metric samples served by the handler, keyed by their name and labels
+*/
+func scrape(t *testing.T) map[string]float64 {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/metrics", nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create the request: %v", err)
+	}
+
+	Handler().ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected a 200 but found %d", recorder.Code)
+	}
+
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(recorder.Body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		index := strings.LastIndex(line, " ")
+		value, err := strconv.ParseFloat(line[index+1:], 64)
+
+		if err != nil {
+			t.Fatalf("Failed to parse the sample (%s): %v", line, err)
+		}
+
+		samples[line[:index]] = value
+	}
+
+	return samples
+}
+
+/*
+Test for github.com/30x/k8s-router/metrics/metrics#Handler after simulated events and reloads
+*/
+func TestHandler(t *testing.T) {
+	before := scrape(t)
+
+	RecordPodEvent("ADDED")
+	RecordPodEvent("ADDED")
+	RecordPodEvent("DELETED")
+	RecordSecretEvent("MODIFIED")
+	RecordNginxReload()
+	SetRoutablePods(3)
+
+	after := scrape(t)
+
+	for sample, increment := range map[string]float64{
+		"router_nginx_reloads_total":                    1,
+		"router_pod_events_total{type=\"ADDED\"}":       2,
+		"router_pod_events_total{type=\"DELETED\"}":     1,
+		"router_secret_events_total{type=\"MODIFIED\"}": 1,
+	} {
+		if after[sample]-before[sample] != increment {
+			t.Fatalf("Expected %s to increment by %v but found %v (was %v)", sample, increment, after[sample],
+				before[sample])
+		}
+	}
+
+	if after["router_routable_pods"] != 3 {
+		t.Fatalf("Expected router_routable_pods to be 3 but found %v", after["router_routable_pods"])
+	}
+
+	// The gauge is recomputed rather than incremented
+	SetRoutablePods(1)
+
+	if value := scrape(t)["router_routable_pods"]; value != 1 {
+		t.Fatalf("Expected router_routable_pods to be 1 but found %v", value)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/metrics/metrics#SetConfigHash
+*/
+func TestSetConfigHash(t *testing.T) {
+	defer configInfo.Reset()
+
+	// Hashes above 2^53 cannot be represented exactly as a sample value so the hash is a label
+	SetConfigHash(0xfedcba9876543211)
+
+	if value := scrape(t)["router_config_info{hash=\"fedcba9876543211\"}"]; value != 1 {
+		t.Fatalf("Expected router_config_info for the hash to be 1 but found %v", value)
+	}
+
+	SetConfigHash(0x1234abcd)
+
+	samples := scrape(t)
+
+	if value := samples["router_config_info{hash=\"000000001234abcd\"}"]; value != 1 {
+		t.Fatalf("Expected router_config_info for the new hash to be 1 but found %v", value)
+	} else if _, ok := samples["router_config_info{hash=\"fedcba9876543211\"}"]; ok {
+		t.Fatal("The previous hash should no longer be reported")
+	}
+}
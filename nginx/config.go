@@ -21,27 +21,67 @@ import (
 	"encoding/base64"
 	"fmt"
 	"hash/fnv"
-	"log"
+	"net"
+	"path"
 	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/30x/k8s-router/logging"
 	"github.com/30x/k8s-router/router"
+	"github.com/30x/k8s-router/utils"
+
+	"k8s.io/kubernetes/pkg/api"
 )
 
 const (
 	defaultNginxConfTmpl = `
 # A very simple nginx configuration file that forces nginx to start as a daemon.
-events {}
-http {` + defaultNginxServerConfTmpl + `}
+{{if .ErrorLogToStderr}}error_log /dev/stderr {{.ErrorLogLevel}};
+{{end}}events {}
+http {
+{{with .ErrorPages}}` + errorPagesTmpl + `{{end}}{{if .EmptyCacheStatus}}` + emptyCacheServerBlockTmpl + `{{else}}` + defaultNginxServerBlockTmpl + `{{end}}}
 daemon on;
 `
-	defaultNginxServerConfTmpl = `
-  # Default server that will just close the connection as if there was no server available
+	defaultNginxServerBlockTmpl = `{{if eq .DefaultServerReturn 444}}  # Default server that will just close the connection as if there was no server available
+{{else}}  # Default server that will return {{.DefaultServerReturn}} for requests to unknown hosts
+{{end}}  server {
+    listen {{.Port}} default_server;
+{{if .ListenIPv6}}    listen [::]:{{.Port}} default_server;
+{{end}}    return {{.DefaultServerReturn}};
+  }
+`
+	defaultNginxServerConfTmpl     = "\n" + defaultNginxServerBlockTmpl
+	dynamicUpstreamsServerConfTmpl = `
+  # Dynamic upstream API (ngx_dynamic_upstream) used to update the upstream servers without a reload
+  server {
+    listen unix:` + NginxDynamicUpstreamsSocket + `;
+
+    location /dynamic {
+      dynamic_upstream;
+    }
+  }
+`
+	trafficStatusServerConfTmpl = `
+  # Traffic status (nginx-module-vts) with the request and byte counters of each host and upstream
+  server {
+    listen {{.Config.TrafficStatusPort}};
+
+    location /status {
+      vhost_traffic_status_display;
+      vhost_traffic_status_display_format html;
+    }
+  }
+`
+	emptyCacheServerBlockTmpl = `  # Default server that will tell clients to back off since there are no routable pods
   server {
     listen {{.Port}} default_server;
-    return 444;
+{{if .ListenIPv6}}    listen [::]:{{.Port}} default_server;
+{{end}}{{if .EmptyCacheRetryAfter}}    add_header Retry-After {{.EmptyCacheRetryAfter}} always;
+{{end}}    return {{.EmptyCacheStatus}};
   }
 `
 	defaultNginxLocationTmpl = `
@@ -49,6 +89,34 @@ daemon on;
     location / {
       return 404;
     }
+`
+	errorPagesTmpl = `  # Error pages served in place of the nginx error responses (including those of the default servers and locations)
+{{range $status, $uri := .}}  error_page {{$status}} {{$uri}};
+{{end}}
+`
+	loadBalanceMethodTmpl = `{{if eq . "ip_hash"}}    # Pin clients to a pod (IPv4 clients by their first three octets, IPv6 clients by their full address)
+    ip_hash;
+{{else if eq . "least_conn"}}    # Send requests to the pod with the fewest active connections
+    least_conn;
+{{end}}`
+	notFoundServerConfTmpl = `
+  # Upstream for the not found backend
+  upstream not_found_backend {
+{{with .Config.LoadBalanceMethod}}` + loadBalanceMethodTmpl + `{{end}}{{if .Config.EnableDynamicUpstreams}}    # Shared memory zone so that the servers can be updated without a reload
+    zone not_found_backend 128k;
+{{end}}{{range $server := .NotFoundServers}}    # Pod {{$server.Pod.Name}} (namespace: {{$server.Pod.Namespace}})
+    server {{$server.Target}};
+{{end}}  }
+
+  # Default server that will proxy requests for unknown hosts and paths to the not found backend
+  server {
+    listen {{.Port}} default_server;
+{{if .ListenIPv6}}    listen [::]:{{.Port}} default_server;
+{{end}}
+    location / {
+      proxy_pass http://not_found_backend;
+    }
+  }
 `
 	httpConfPreambleTmpl = `
   # http://nginx.org/en/docs/http/ngx_http_core_module.html
@@ -56,90 +124,415 @@ daemon on;
   server_names_hash_max_size 512;
   server_names_hash_bucket_size 64;
 
+  # Type of responses without a Content-Type
+  default_type {{.Config.DefaultType}};
+{{if .Config.Charset}}  charset {{.Config.Charset}};
+{{end}}{{if .Config.EnableAccessLog}}
+{{if eq .Config.AccessLogFormat "timing"}}  # Access log including the request time and the upstream address and response time of each request
+  log_format timing '$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent '
+                    '"$http_referer" "$http_user_agent" request_time=$request_time '
+                    'upstream_addr=$upstream_addr upstream_response_time=$upstream_response_time';
+{{else if eq .Config.AccessLogFormat "upstream"}}  # The upstream (or pod) each request was proxied to, set by each pod location
+  map $host $router_upstream {
+    default "-";
+  }
+
+  # Access log including the request time, the upstream address and response time and the router upstream of each request
+  log_format upstream '$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent '
+                      '"$http_referer" "$http_user_agent" request_time=$request_time '
+                      'upstream_addr=$upstream_addr upstream_response_time=$upstream_response_time '
+                      'router_upstream=$router_upstream';
+{{else}}  # Access log using the nginx predefined combined log_format
+{{end}}  access_log {{.Config.AccessLogPath}} {{.Config.AccessLogFormat}};
+{{end}}
   # Maximum body size in request
   client_max_body_size {{.Config.ClientMaxBodySize}};
 
+  # Buffer size for reading client request headers (larger request lines and headers use large_client_header_buffers)
+  client_header_buffer_size {{.Config.ClientHeaderBufferSize}};
+
+  # Status codes returned for requests rejected by rate and connection limits
+  limit_req_status {{.Config.LimitReqStatus}};
+  limit_conn_status {{.Config.LimitConnStatus}};
+
+{{if .Config.EnableRateLimit}}  # Limit the request rate of each client address
+  limit_req_zone $binary_remote_addr zone=perhost:10m rate={{.Config.RateLimitRate}};
+
+{{end}}{{if .Config.EnableTrafficStatus}}  # Count the requests and bytes of each host and upstream (nginx-module-vts)
+  vhost_traffic_status_zone;
+  vhost_traffic_status_filter_by_host on;
+
+{{end}}{{with .Config.ErrorPages}}` + errorPagesTmpl + `{{end}}  # Redirects generated by nginx (trailing slashes, index files, etc.)
+  absolute_redirect {{if .Config.AbsoluteRedirect}}on{{else}}off{{end}};
+  port_in_redirect {{if .Config.PortInRedirect}}on{{else}}off{{end}};
+
+{{if .Config.EnableGzip}}  # Compress responses
+  gzip on;
+  gzip_types {{.Config.GzipTypes}};
+  gzip_min_length 1024;
+
+{{end}}  # TCP tuning for client and upstream connections
+  tcp_nodelay {{if .Config.TCPNodelay}}on{{else}}off{{end}};
+  tcp_nopush {{if .Config.TCPNopush}}on{{else}}off{{end}};
+  proxy_socket_keepalive {{if .Config.ProxySocketKeepalive}}on{{else}}off{{end}};
+{{if .Config.Resolver}}
+  # Resolve hostnames at request time (requests fail with a 502 when resolution fails or times out)
+  resolver{{range $address := .Config.Resolver}} {{$address}}{{end}};
+{{if .Config.ResolverTimeout}}  resolver_timeout {{.Config.ResolverTimeout}};
+{{end}}{{end}}
   # Force HTTP 1.1 for upstream requests
   proxy_http_version 1.1;
 
-  # When nginx proxies to an upstream, the default value used for 'Connection' is 'close'.  We use this variable to do
+  # Fail fast when connecting to unreachable pods so that the next pod is tried
+  proxy_connect_timeout {{.Config.ProxyConnectTimeout}};
+
+{{if .Config.ProxyCachePath}}  # Cache the responses of the pods with the proxyCache annotation on
+  proxy_cache_path {{.Config.ProxyCachePath}} levels=1:2 keys_zone=router_cache:10m max_size={{.Config.ProxyCacheMaxSize}} inactive=60m;
+
+{{end}}  # When nginx proxies to an upstream, the default value used for 'Connection' is 'close'.  We use this variable to do
   # the same thing so that whenever a 'Connection' header is in the request, the variable reflects the provided value
   # otherwise, it defaults to 'close'.  This is opposed to just using "proxy_set_header Connection $http_connection"
   # which would remove the 'Connection' header from the upstream request whenever the request does not contain a
-  # 'Connection' header, which is a deviation from the nginx norm.
+  # 'Connection' header, which is a deviation from the nginx norm.  With upstream keepalive every value but an upgrade
+  # is cleared instead so that clients cannot close the connections to the pods, which are reused.  The locations that
+  # set headers set the variable too since setting a header in a location replaces every inherited header.
   map $http_connection $p_connection {
-    default $http_connection;
+{{if .Config.UpstreamKeepalive}}    default   "";
+    ~*upgrade $http_connection;
+{{else}}    default $http_connection;
     ''      close;
-  }
+{{end}}  }
 
   # Pass through the appropriate headers
   proxy_set_header Connection $p_connection;
   proxy_set_header Host $http_host;
   proxy_set_header Upgrade $http_upgrade;
-`
+{{if .Config.ForwardPort}}  proxy_set_header X-Forwarded-Port {{if .Config.ForwardedPort}}{{.Config.ForwardedPort}}{{else}}$server_port{{end}};
+{{end}}{{if .Config.HideBackendHeaders}}
+  # Hide backend response headers from clients (nginx already hides the 'Server' header)
+{{range $header := .Config.HideBackendHeaders}}  proxy_hide_header {{$header}};
+{{end}}{{end}}{{if .Config.IncludeFiles}}
+  # Additional configuration provided by the operator
+{{range $pattern := .Config.IncludeFiles}}  include {{$pattern}};
+{{end}}{{end}}`
 	nginxConfTmpl = `
-events {
-  worker_connections 1024;
+{{if .Config.ErrorLogToStderr}}error_log /dev/stderr {{.Config.ErrorLogLevel}};
+{{end}}{{if .Config.WorkerProcesses}}worker_processes {{.Config.WorkerProcesses}};
+{{end}}events {
+  worker_connections {{.WorkerConnections}};
 }
 http {` + httpConfPreambleTmpl + `{{range $key, $upstream := .Upstreams}}
-  # Upstream for {{$upstream.Path}} traffic on {{$upstream.Host}}
-  upstream {{$upstream.Name}} {
-{{range $server := $upstream.Servers}}    # Pod {{$server.Pod.Name}} (namespace: {{$server.Pod.Namespace}})
-    server {{$server.Target}};
+{{if $upstream.SharedBy}}  # Upstream shared by the traffic on {{range $i, $sharedBy := $upstream.SharedBy}}{{if $i}}, {{end}}{{$sharedBy}}{{end}}
+{{else}}  # Upstream for {{$upstream.Path}} traffic on {{$upstream.Host}}
+{{end}}  upstream {{$upstream.Name}} {
+{{with $upstream.LoadBalanceMethod $.Config}}` + loadBalanceMethodTmpl + `{{end}}{{if $.Config.EnableDynamicUpstreams}}    # Shared memory zone so that the servers can be updated without a reload
+    zone {{$upstream.Name}} 128k;
+{{end}}{{if $.Config.EnableNginxUpstreamCheckModule}}{{with $upstream.HealthCheck}}    # Health check derived from the {{.Probe}} probe
+    check interval={{.Interval}} rise={{.Rise}} fall={{.Fall}} timeout={{.Timeout}} port={{.Port}} type=http;
+    check_http_send "GET {{.Path}} HTTP/1.0\r\n\r\n";
+    check_http_expect_alive http_2xx http_3xx;
+{{end}}{{end}}{{if $.Config.UpstreamKeepalive}}    # Reuse connections to the pods
+    keepalive {{$.Config.UpstreamKeepalive}};
+{{if $.Config.UpstreamKeepaliveRequests}}    keepalive_requests {{$.Config.UpstreamKeepaliveRequests}};
+{{end}}{{if $.Config.UpstreamKeepaliveTime}}    keepalive_time {{$.Config.UpstreamKeepaliveTime}};
+{{end}}{{end}}{{range $server := $upstream.Servers}}    # Pod {{$server.Pod.Name}} (namespace: {{$server.Pod.Namespace}})
+    server {{$server.Target}}{{if $server.Weight}} weight={{$server.Weight}}{{end}};
+{{end}}{{if and $.Config.FallbackBackend (ne ($upstream.LoadBalanceMethod $.Config) "ip_hash")}}    # Fallback backend used when all of the pods are down
+    server {{$.Config.FallbackBackend}} backup;
+{{end}}  }
+{{end}}{{range $host, $server := .Hosts}}{{range $path, $location := $server.Locations}}{{if $location.Mirror}}{{if lt $location.Mirror.Percentage 100}}
+  # Mirror sampling for {{$path}} traffic on {{$host}}
+  split_clients "${request_id}" ${{$location.Mirror.Name}} {
+    {{$location.Mirror.Percentage}}% 1;
+    * "";
+  }
+{{end}}{{end}}{{end}}{{end}}{{range $host, $server := .Hosts}}{{range $path, $location := $server.Locations}}{{if $location.MethodRewrite}}
+  # Request method rewrites for {{$path}} traffic on {{$host}}
+  map $request_method ${{$location.MethodRewrite.Name}} {
+    default $request_method;
+{{range $from, $to := $location.MethodRewrite.Methods}}    {{$from}} {{$to}};
 {{end}}  }
-{{end}}{{range $host, $server := .Hosts}}
+{{end}}{{end}}{{end}}{{range $host, $server := .Hosts}}{{range $path, $location := $server.Locations}}{{if $location.Split}}
+  # Canary split for {{$location.Path}} traffic on {{$host}}
+  split_clients "${remote_addr}" ${{$location.Split.Name}} {
+    {{$location.Split.Percent}}% {{$location.Split.Canary}};
+    * {{$location.Split.Stable}};
+  }
+{{end}}{{end}}{{end}}{{range $host, $server := .Hosts}}{{range $listen := $server.Listens}}
   server {
-    listen {{$.Port}};
-    server_name {{$host}};
-{{if $server.NeedsDefaultLocation}}` + defaultNginxLocationTmpl + `{{end}}{{range $path, $location := $server.Locations}}
+    listen {{$listen.Port}}{{if $listen.Certificate}} ssl{{end}};
+{{if $.ListenIPv6}}    listen [::]:{{$listen.Port}}{{if $listen.Certificate}} ssl{{end}};
+{{end}}    server_name {{$server.Name}};
+{{if $listen.Certificate}}
+    # Terminate TLS using the certificate of the TLS secret (namespace: {{$listen.Namespace}})
+    ssl_certificate {{$listen.Certificate}};
+    ssl_certificate_key {{$listen.CertificateKey}};
+{{end}}{{if $server.NeedsDefaultLocation}}` + defaultNginxLocationTmpl + `{{end}}{{range $path, $location := $server.Locations}}
     location {{$path}} {
-      {{if ne $location.Secret ""}}# Check the Routing API Key (namespace: {{$location.Namespace}})
-      if ($http_{{$.APIKeyHeader}} != "{{$location.Secret}}") {
+      {{if $.Config.EnableRateLimit}}# Limit the request rate of each client address, rejecting requests beyond the burst
+      limit_req zone=perhost burst={{$.Config.RateLimitBurst}} nodelay;
+
+      {{end}}{{if and $.Config.EnableAccessLog (eq $.Config.AccessLogFormat "upstream")}}# Record the upstream for the access log
+      set $router_upstream {{$location.Server.Target}};
+
+      {{end}}{{if ne $location.CORSOrigin ""}}# Allow cross-origin requests (preflight requests are answered before any authorization)
+      if ($request_method = OPTIONS) {
+        add_header Access-Control-Allow-Origin "{{$location.CORSOrigin}}";
+        add_header Access-Control-Allow-Methods "{{$location.CORSMethods}}";
+        add_header Access-Control-Allow-Headers $http_access_control_request_headers;
+        add_header Access-Control-Max-Age 86400;
+        return 204;
+      }
+      add_header Access-Control-Allow-Origin "{{$location.CORSOrigin}}"{{if $.Config.AlwaysAddHeaders}} always{{end}};
+      add_header Access-Control-Allow-Methods "{{$location.CORSMethods}}"{{if $.Config.AlwaysAddHeaders}} always{{end}};
+
+      {{end}}{{if $location.DenyAll}}# Deny all requests since the router secret value is empty (namespace: {{$location.Namespace}})
+      return 403;
+
+      {{end}}{{if ne $location.Secret ""}}# Check the Routing API Key (namespace: {{$location.Namespace}})
+      {{if ne $location.SecretPattern ""}}if ($http_{{$location.APIKeyHeader}} !~ "{{$location.SecretPattern}}") {{else}}if ($http_{{$location.APIKeyHeader}} != "{{$location.Secret}}") {{end}}{
         return 403;
       }
 
-      {{end}}{{if $location.Server.IsUpstream}}# Upstream {{$location.Server.Target}}{{else}}# Pod {{$location.Server.Pod.Name}} (namespace: {{$location.Server.Pod.Namespace}}){{end}}
-      proxy_pass http://{{$location.Server.Target}};
+      {{end}}{{if ne $location.BasicAuth ""}}# Check the Basic Authorization credentials (namespace: {{$location.Namespace}})
+      if ($http_authorization != "{{$location.BasicAuth}}") {
+        add_header WWW-Authenticate 'Basic realm="{{$location.Namespace}}"'{{if $.Config.AlwaysAddHeaders}} always{{end}};
+        return 401;
+      }
+
+      {{end}}{{if $location.AuthRequest}}# Authorize the request via the external auth service
+      auth_request {{$location.AuthRequest.Path}};
+{{if $location.AuthRequest.SigninURL}}      error_page 401 = {{$location.AuthRequest.SigninLocation}};
+{{end}}
+      {{end}}{{if $location.ProxyCache}}# Cache the pod's responses
+      proxy_cache router_cache;
+
+      {{end}}{{if ne $location.ProxyCacheLockTimeout ""}}# Only allow one request at a time to populate a cache element
+      proxy_cache_lock on;
+      proxy_cache_lock_timeout {{$location.ProxyCacheLockTimeout}};
+
+      {{end}}{{if ne $location.ProxyCacheUseStale ""}}# Serve stale cached responses when the backend is unavailable
+      proxy_cache_use_stale {{$location.ProxyCacheUseStale}};
+
+      {{end}}{{if ne $location.CacheBypass ""}}# Neither serve from nor store in the cache requests with any of these variables set
+      proxy_cache_bypass {{$location.CacheBypass}};
+      proxy_no_cache {{$location.CacheBypass}};
+
+      {{end}}{{if ne $location.Gzip ""}}# Override the inherited gzip compression of responses
+      gzip {{$location.Gzip}};
+
+      {{end}}{{if ne $location.RequestBuffering ""}}# Override the inherited buffering of request bodies (client_max_body_size still applies)
+      proxy_request_buffering {{$location.RequestBuffering}};
+
+      {{end}}{{if ne $location.ProxyIgnoreHeaders ""}}proxy_ignore_headers {{$location.ProxyIgnoreHeaders}};
+
+      {{end}}{{with $location.Timeouts}}# Override the default proxy timeouts
+{{if .Connect}}      proxy_connect_timeout {{.Connect}};
+{{end}}{{if .Read}}      proxy_read_timeout {{.Read}};
+{{end}}{{if .Send}}      proxy_send_timeout {{.Send}};
+{{end}}
+      {{end}}{{if $location.SetsHeaders}}# Set every proxied header (setting a header replaces every inherited header)
+      proxy_set_header Connection {{if $location.Websocket}}"upgrade"{{else}}$p_connection{{end}};
+      proxy_set_header Host {{if ne $location.BackendHost ""}}{{$location.BackendHost}}{{else}}$http_host{{end}};
+      proxy_set_header Upgrade $http_upgrade;
+{{if $.Config.ForwardPort}}      proxy_set_header X-Forwarded-Port {{if $.Config.ForwardedPort}}{{$.Config.ForwardedPort}}{{else}}$server_port{{end}};
+{{end}}{{if $location.StripAuthorization}}      proxy_set_header Authorization "";
+{{end}}{{if $location.SubFilters}}      proxy_set_header Accept-Encoding "";
+{{end}}
+      {{end}}{{if ne $location.BackendHost ""}}# Proxy to the backend's name-based virtual host (the server name is only used for https backends)
+      proxy_ssl_name {{$location.BackendHost}};
+
+      {{end}}{{if $location.MethodRewrite}}# Rewrite the request method before proxying
+      proxy_method ${{$location.MethodRewrite.Name}};
+
+      {{end}}{{if $location.SubFilters}}# Rewrite the response body (Accept-Encoding is cleared so the body is not compressed)
+{{range $subFilter := $location.SubFilters}}      sub_filter '{{$subFilter.From}}' '{{$subFilter.To}}';
+{{end}}      sub_filter_once off;
+
+      {{end}}{{if ne $location.Rewrite ""}}# Replace the routing path prefix before proxying
+      rewrite {{$location.Rewrite}} break;
+
+      {{end}}{{if $location.Mirror}}# Mirror traffic to {{$location.Mirror.Target}} for shadow testing
+      mirror {{$location.Mirror.Path}};
+
+      {{end}}{{if $location.Split}}# Canary split ({{$location.Split.Percent}}% to {{$location.Split.Canary}}, the rest to {{$location.Split.Stable}}){{else if $location.Server.IsUpstream}}# Upstream {{$location.Server.Target}}{{else}}# Pod {{$location.Server.Pod.Name}} (namespace: {{$location.Server.Pod.Namespace}}){{end}}
+      proxy_pass http://{{$location.Server.Target}}{{if and $location.ProxyPassURI $location.Server.IsUnixSocket}}:{{end}}{{$location.ProxyPassURI}};
+    }
+{{end}}{{range $path, $location := $server.Locations}}{{if $location.Mirror}}
+    # Shadow backend for {{$path}} traffic (responses are discarded)
+    location = {{$location.Mirror.Path}} {
+      internal;
+{{if lt $location.Mirror.Percentage 100}}      if (${{$location.Mirror.Name}} = "") {
+        return 204;
+      }
+{{end}}      proxy_pass http://{{$location.Mirror.Target}}$request_uri;
+    }
+{{end}}{{end}}{{range $path, $location := $server.Locations}}{{if $location.AuthRequest}}
+    # External auth service for {{$path}} traffic (the request body is not forwarded)
+    location = {{$location.AuthRequest.Path}} {
+      internal;
+      proxy_pass_request_body off;
+      proxy_set_header Content-Length "";
+      proxy_set_header X-Original-URI $request_uri;
+      proxy_pass {{$location.AuthRequest.URL}};
     }
+{{if $location.AuthRequest.SigninURL}}
+    # Sign in redirect for unauthorized {{$path}} traffic
+    location {{$location.AuthRequest.SigninLocation}} {
+      return 302 {{$location.AuthRequest.SigninURL}};
+    }
+{{end}}{{end}}{{end}}  }
+{{end}}{{end}}{{if .Config.EnableDynamicUpstreams}}` + dynamicUpstreamsServerConfTmpl + `{{end}}{{if .Config.EnableTrafficStatus}}` + trafficStatusServerConfTmpl + `{{end}}{{if .NotFoundServers}}` + notFoundServerConfTmpl + `{{else}}` + defaultNginxServerConfTmpl + `{{end}}}
+{{if .TLSPassthroughUpstreams}}` + tlsPassthroughConfTmpl + `{{end}}`
+	tlsPassthroughConfTmpl = `stream {
+  # Route TLS connections to the pods by their SNI server name without terminating TLS
+  map $ssl_preread_server_name $tls_passthrough_backend {
+    # Match wildcard hosts like server names
+    hostnames;
+{{range $host, $upstream := .TLSPassthroughUpstreams}}    {{$host}} {{$upstream.Name}};
+{{end}}  }
+{{range $host, $upstream := .TLSPassthroughUpstreams}}
+  # Upstream for TLS passthrough traffic on {{$host}}
+  upstream {{$upstream.Name}} {
+{{range $server := $upstream.Servers}}    # Pod {{$server.Pod.Name}} (namespace: {{$server.Pod.Namespace}})
+    server {{$server.Target}};
 {{end}}  }
-{{end}}` + defaultNginxServerConfTmpl + `}
+{{end}}
+  server {
+    listen {{.Config.TLSPassthroughPort}};
+{{if .ListenIPv6}}    listen [::]:{{.Config.TLSPassthroughPort}};
+{{end}}    ssl_preread on;
+    proxy_pass $tls_passthrough_backend;
+  }
+}
 `
-	// NginxConfPath is The nginx configuration file path
-	NginxConfPath = "/etc/nginx/nginx.conf"
+	// NginxCertsDir is the directory the TLS certificates are written to (one directory per namespace)
+	NginxCertsDir = "/etc/nginx/certs"
+	// NginxDynamicUpstreamsSocket is the unix socket the dynamic upstream API is served on
+	NginxDynamicUpstreamsSocket = "/var/run/nginx-dynamic-upstreams.sock"
 )
 
+// subFilterEscaper escapes backslashes and single quotes within single-quoted nginx strings
+var subFilterEscaper = strings.NewReplacer("\\", "\\\\", "'", "\\'")
+
+// numCPU returns the CPU count used for "worker_processes auto" (Replaced in tests)
+var numCPU = runtime.NumCPU
+
 // Cannot declare as a constant
 var defaultNginxConf string
 var defaultNginxConfTemplate *template.Template
-var nginxAPIKeyHeader string
 var nginxConfTemplate *template.Template
 
+// nginxAPIKeyHeaders caches the nginx variable name suffix of each API Key header converted for nginx
+var nginxAPIKeyHeaders = make(map[string]string)
+
 type hostT struct {
+	Listens              []*listenT
 	Locations            map[string]*locationT
+	Name                 string
 	NeedsDefaultLocation bool
 }
 
+// listenT is a server block of a host, terminating TLS when it has a certificate
+type listenT struct {
+	Certificate    string
+	CertificateKey string
+	Namespace      string
+	Port           string
+}
+
+type authRequestT struct {
+	Path           string
+	SigninLocation string
+	SigninURL      string
+	URL            string
+}
+
 type locationT struct {
-	Namespace string
-	Path      string
-	Secret    string
-	Server    *serverT
+	APIKeyHeader          string
+	AuthRequest           *authRequestT
+	BackendHost           string
+	BasicAuth             string
+	CacheBypass           string
+	CORSMethods           string
+	CORSOrigin            string
+	DenyAll               bool
+	Gzip                  string
+	MethodRewrite         *methodRewriteT
+	Mirror                *mirrorT
+	Namespace             string
+	Path                  string
+	ProxyCache            bool
+	ProxyCacheLockTimeout string
+	ProxyCacheUseStale    string
+	ProxyIgnoreHeaders    string
+	RequestBuffering      string
+	ProxyPassURI          string
+	Rewrite               string
+	Secret                string
+	SecretPattern         string
+	Server                *serverT
+	Split                 *splitT
+	StripAuthorization    bool
+	SubFilters            []*router.SubFilter
+	Timeouts              *router.Timeouts
+	Websocket             bool
+}
+
+/*
+SetsHeaders returns whether the location sets proxied headers itself, in which case it has to set every header set at the
+http level since nginx only inherits proxy_set_header directives when a location sets none
+*/
+func (location *locationT) SetsHeaders() bool {
+	return location.Websocket || location.BackendHost != "" || location.StripAuthorization || len(location.SubFilters) > 0
+}
+
+type methodRewriteT struct {
+	Methods map[string]string
+	Name    string
+}
+
+type mirrorT struct {
+	Name       string
+	Path       string
+	Percentage int
+	Target     string
+}
+
+type splitT struct {
+	Canary  string
+	Name    string
+	Percent int
+	Stable  string
 }
 
 type serverT struct {
 	IsUpstream bool
 	Pod        *router.PodWithRoutes
 	Target     string
+	Weight     int
+}
+
+/*
+IsUnixSocket returns whether the location proxies directly to a unix socket, whose URI has to be separated from the socket
+path by a ':'
+*/
+func (server *serverT) IsUnixSocket() bool {
+	return !server.IsUpstream && strings.HasPrefix(server.Target, "unix:")
 }
 
 type serversT []*serverT
 
 type templateDataT struct {
-	APIKeyHeader string
-	Hosts        map[string]*hostT
-	Port         int
-	Upstreams    map[string]*upstreamT
-	Config *router.Config
+	DefaultServerReturn     int
+	Hosts                   map[string]*hostT
+	ListenIPv6              bool
+	NotFoundServers         serversT
+	Port                    int
+	TLSPassthroughUpstreams map[string]*upstreamT
+	Upstreams               map[string]*upstreamT
+	WorkerConnections       int
+	Config                  *router.Config
 }
 
 type upstreamT struct {
@@ -147,6 +540,46 @@ type upstreamT struct {
 	Name    string
 	Path    string
 	Servers serversT
+	// The hosts and paths ({HOST}{PATH}) sharing the upstream when router.Config.ConsolidateUpstreams is enabled
+	SharedBy []string
+}
+
+/*
+HealthCheck returns the health check of the first of the upstream's pods with one
+*/
+func (upstream *upstreamT) HealthCheck() *router.HealthCheck {
+	for _, server := range upstream.Servers {
+		if server.Pod.HealthCheck != nil {
+			return server.Pod.HealthCheck
+		}
+	}
+
+	return nil
+}
+
+/*
+LoadBalanceMethod returns the method the upstream balances requests with, ip_hash when any of the upstream's pods
+requested it and the cluster-wide router.Config.LoadBalanceMethod otherwise
+*/
+func (upstream *upstreamT) LoadBalanceMethod(config *router.Config) string {
+	if upstream.IPHash() {
+		return router.LoadBalanceMethodIPHash
+	}
+
+	return config.LoadBalanceMethod
+}
+
+/*
+IPHash returns whether any of the upstream's pods requested ip_hash load balancing
+*/
+func (upstream *upstreamT) IPHash() bool {
+	for _, server := range upstream.Servers {
+		if server.Pod.LoadBalanceMethod == router.LoadBalanceMethodIPHash {
+			return true
+		}
+	}
+
+	return false
 }
 
 func (slice serversT) Len() int {
@@ -154,6 +587,15 @@ func (slice serversT) Len() int {
 }
 
 func (slice serversT) Less(i, j int) bool {
+	// Pods with the same name in different namespaces are ordered by namespace and the servers of the same pod by target
+	if slice[i].Pod.Name == slice[j].Pod.Name {
+		if slice[i].Pod.Namespace == slice[j].Pod.Namespace {
+			return slice[i].Target < slice[j].Target
+		}
+
+		return slice[i].Pod.Namespace < slice[j].Pod.Namespace
+	}
+
 	return slice[i].Pod.Name < slice[j].Pod.Name
 }
 
@@ -161,17 +603,284 @@ func (slice serversT) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
+// podsByNamespace orders the pods by namespace and then by name
+type podsByNamespace []*router.PodWithRoutes
+
+func (slice podsByNamespace) Len() int {
+	return len(slice)
+}
+
+func (slice podsByNamespace) Less(i, j int) bool {
+	if slice[i].Namespace == slice[j].Namespace {
+		return slice[i].Name < slice[j].Name
+	}
+
+	return slice[i].Namespace < slice[j].Namespace
+}
+
+func (slice podsByNamespace) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
+// serversByCreation orders the servers by the creation of their pods, falling back to the pod name order
+type serversByCreation struct {
+	serversT
+}
+
+func (slice serversByCreation) Less(i, j int) bool {
+	iCreated := slice.serversT[i].Pod.Created
+	jCreated := slice.serversT[j].Pod.Created
+
+	if iCreated.Equal(jCreated) {
+		return slice.serversT.Less(i, j)
+	}
+
+	return iCreated.Before(jCreated)
+}
+
+// serversByIP orders the servers by the IP address and port of their targets
+type serversByIP struct {
+	serversT
+}
+
+func (slice serversByIP) Less(i, j int) bool {
+	iIP, iPort := splitTarget(slice.serversT[i].Target)
+	jIP, jPort := splitTarget(slice.serversT[j].Target)
+
+	if compared := bytes.Compare(iIP, jIP); compared != 0 {
+		return compared < 0
+	} else if iPort != jPort {
+		return iPort < jPort
+	}
+
+	return slice.serversT[i].Target < slice.serversT[j].Target
+}
+
+/*
+Returns the IP address (in its 16 byte form) and port of a server target, the port defaults to 80 when omitted
+*/
+func splitTarget(target string) (net.IP, int) {
+	host, portStr, err := net.SplitHostPort(target)
+
+	if err != nil {
+		host = target
+		portStr = "80"
+	}
+
+	port, _ := strconv.Atoi(portStr)
+
+	return net.ParseIP(host).To16(), port
+}
+
+/*
+Sorts the servers using the router.Config.UpstreamServerOrder strategy
+*/
+func sortServers(config *router.Config, servers serversT) {
+	switch config.UpstreamServerOrder {
+	case router.UpstreamServerOrderInsertionStable:
+		sort.Stable(serversByCreation{servers})
+	case router.UpstreamServerOrderIP:
+		sort.Stable(serversByIP{servers})
+	default:
+		sort.Stable(servers)
+	}
+}
+
+/*
+Returns the worker_connections value, derived from the total number of connections divided by the number of worker
+processes when router.Config.MaxConnections is set
+*/
+func getWorkerConnections(config *router.Config) int {
+	if config.MaxConnections == 0 {
+		return config.WorkerConnections
+	}
+
+	workers := 1
+
+	if config.WorkerProcesses == "auto" {
+		workers = numCPU()
+	} else if config.WorkerProcesses != "" {
+		// Validated by router.ConfigFromEnv
+		workers, _ = strconv.Atoi(config.WorkerProcesses)
+	}
+
+	// Round up so the workers can always handle at least the total number of connections
+	return (config.MaxConnections + workers - 1) / workers
+}
+
+/*
+Returns the sub filters with their values escaped for use within single-quoted nginx strings
+*/
+func escapeSubFilters(subFilters []*router.SubFilter) []*router.SubFilter {
+	var escaped []*router.SubFilter
+
+	for _, subFilter := range subFilters {
+		escaped = append(escaped, &router.SubFilter{
+			From: subFilterEscaper.Replace(subFilter.From),
+			To:   subFilterEscaper.Replace(subFilter.To),
+		})
+	}
+
+	return escaped
+}
+
+/*
+denyEmptySecret returns whether the routes of the namespace deny all requests because the router secret value is empty,
+which would otherwise let requests without credentials through, based on config.EmptySecretAction
+*/
+func denyEmptySecret(config *router.Config, namespace, field string) bool {
+	if config.EmptySecretAction == router.EmptySecretActionDeny {
+		logging.Warnf("    Namespace (%s) routing issue: the %s router secret value is empty, denying all requests\n", namespace, field)
+
+		return true
+	}
+
+	logging.Warnf("    Namespace (%s) routing issue: the %s router secret value is empty, its routes are NOT secured\n", namespace, field)
+
+	return false
+}
+
+/*
+getSecretPattern returns the regex matching either the API Key or the previous API Key, so that both are accepted while
+the API Key is rotated, or an empty string when the secret has no previous API Key
+*/
+func getSecretPattern(config *router.Config, secret *api.Secret, locationSecret string) string {
+	if config.PreviousAPIKeyField == "" {
+		return ""
+	}
+
+	previousAPIKey, ok := secret.Data[config.PreviousAPIKeyField]
+
+	if !ok || len(previousAPIKey) == 0 {
+		return ""
+	}
+
+	return "^(" + regexp.QuoteMeta(locationSecret) + "|" + regexp.QuoteMeta(base64.StdEncoding.EncodeToString(previousAPIKey)) + ")$"
+}
+
 func hash(s string) uint32 {
 	h := fnv.New32a()
 	h.Write([]byte(s))
 	return h.Sum32()
 }
 
-func convertAPIKeyHeaderForNginx(config *router.Config) {
-	if nginxAPIKeyHeader == "" {
-		// Convert the API Key header to nginx
-		nginxAPIKeyHeader = strings.ToLower(regexp.MustCompile("[^A-Za-z0-9]").ReplaceAllString(config.APIKeyHeader, "_"))
+/*
+Converts a routing path with {name} captures into an nginx regex location with named captures, routing paths without
+captures are returned as-is.  Templated paths are anchored to the end of the path since the templated proxy_pass URI
+replaces the whole request URI, so longer paths would otherwise lose their remainder.
+*/
+func getLocationPath(path string, templated bool) string {
+	pathSegments := strings.Split(path, "/")
+	hasCaptures := false
+
+	for i, pathSegment := range pathSegments {
+		if name, isCapture := router.GetPathCaptureName(pathSegment); isCapture {
+			pathSegments[i] = "(?<" + name + ">[^/]+)"
+			hasCaptures = true
+		} else {
+			pathSegments[i] = regexp.QuoteMeta(pathSegment)
+		}
+	}
+
+	if !hasCaptures {
+		return path
+	}
+
+	locationPath := "~ ^" + strings.Join(pathSegments, "/")
+
+	if templated {
+		locationPath += "$"
 	}
+
+	return locationPath
+}
+
+/*
+Converts a backend path template with {name} references into an nginx URI using the named capture variables
+*/
+func getProxyPassURI(pathTemplate string) string {
+	return strings.NewReplacer("{", "${").Replace(pathTemplate)
+}
+
+/*
+Converts a routing path prefix rewrite into the nginx rewrite regex and replacement, keeping the rest of the path
+*/
+func getRewrite(path, target string) string {
+	if target == "" {
+		return ""
+	}
+
+	return "^" + regexp.QuoteMeta(strings.TrimSuffix(path, "/")) + "/?(.*)$ " + strings.TrimSuffix(target, "/") + "/$1"
+}
+
+/*
+Adds the canary pod's route target to the canary upstream for the location, splitting the location's traffic between
+the stable upstream and the canary upstream
+*/
+func addCanaryServer(tmplData *templateDataT, location *locationT, cacheEntry *router.PodWithRoutes, route *router.Route, upstreamKey, upstreamName, target string) {
+	canaryKey := upstreamKey + "#canary"
+	canary, ok := tmplData.Upstreams[canaryKey]
+
+	if !ok {
+		// The stable servers need to be in an upstream to be split
+		if !location.Server.IsUpstream {
+			tmplData.Upstreams[upstreamKey] = &upstreamT{
+				Name:    upstreamName,
+				Host:    route.Incoming.HostKey(),
+				Path:    route.Incoming.Path,
+				Servers: []*serverT{location.Server},
+			}
+		}
+
+		canary = &upstreamT{
+			Name: upstreamName + "_canary",
+			Host: route.Incoming.HostKey(),
+			Path: route.Incoming.Path,
+		}
+
+		tmplData.Upstreams[canaryKey] = canary
+
+		location.Split = &splitT{
+			Canary:  canary.Name,
+			Name:    "variant" + fmt.Sprint(hash(upstreamKey)),
+			Percent: cacheEntry.CanaryPercent,
+			Stable:  upstreamName,
+		}
+		location.Server = &serverT{
+			IsUpstream: true,
+			Target:     "$" + location.Split.Name,
+		}
+	}
+
+	for _, server := range canary.Servers {
+		if server.Target == target {
+			return
+		}
+	}
+
+	canary.Servers = append(canary.Servers, &serverT{
+		Pod:    cacheEntry,
+		Target: target,
+		Weight: route.Outgoing.Weight,
+	})
+
+	// Sort to keep the upstream stable across reloads
+	sortServers(tmplData.Config, canary.Servers)
+}
+
+/*
+convertAPIKeyHeaderForNginx returns the API Key header converted to the nginx variable name suffix ($http_{SUFFIX}),
+converting each distinct header once
+*/
+func convertAPIKeyHeaderForNginx(header string) string {
+	nginxHeader, ok := nginxAPIKeyHeaders[header]
+
+	if !ok {
+		nginxHeader = strings.ToLower(regexp.MustCompile("[^A-Za-z0-9]").ReplaceAllString(header, "_"))
+		nginxAPIKeyHeaders[header] = nginxHeader
+	}
+
+	return nginxHeader
 }
 
 func init() {
@@ -179,7 +888,7 @@ func init() {
 	t, err := template.New("nginx-default").Parse(defaultNginxConfTmpl)
 
 	if err != nil {
-		log.Fatalf("Failed to render default nginx.conf template: %v.", err)
+		logging.Fatalf("Failed to render default nginx.conf template: %v.", err)
 	}
 
 	defaultNginxConfTemplate = t
@@ -188,71 +897,400 @@ func init() {
 	t2, err := template.New("nginx").Parse(nginxConfTmpl)
 
 	if err != nil {
-		log.Fatalf("Failed to render nginx.conf template: %v.", err)
+		logging.Fatalf("Failed to render nginx.conf template: %v.", err)
 	}
 
 	nginxConfTemplate = t2
 }
 
+/*
+Adds the pod, using its TLS passthrough port, to the TLS passthrough upstream of each of its hosts
+*/
+func addTLSPassthroughServers(tmplData *templateDataT, cacheEntry *router.PodWithRoutes) {
+	for _, route := range cacheEntry.Routes {
+		// The SNI server name does not include a port so hosts with different ports share an upstream
+		upstream, ok := tmplData.TLSPassthroughUpstreams[route.Incoming.Host]
+
+		if !ok {
+			upstream = &upstreamT{
+				Host: route.Incoming.Host,
+				Name: "tls_passthrough" + fmt.Sprint(hash(route.Incoming.Host)),
+			}
+
+			tmplData.TLSPassthroughUpstreams[route.Incoming.Host] = upstream
+		}
+
+		target := route.Outgoing.IP + ":" + cacheEntry.TLSPassthroughPort
+		found := false
+
+		for _, server := range upstream.Servers {
+			if server.Target == target {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			upstream.Servers = append(upstream.Servers, &serverT{
+				Pod:    cacheEntry,
+				Target: target,
+			})
+		}
+	}
+}
+
+/*
+Adds a TLS terminating server block to each host (without an explicit port) whose certificate is found in the TLS secret
+of one of the namespaces of its pods.  Hosts without a matching certificate keep only their plain HTTP server block.
+*/
+func addTLSServers(config *router.Config, cache *router.Cache, tmplData *templateDataT) {
+	for hostKey, host := range tmplData.Hosts {
+		// Hosts with an explicit port are only served on that port
+		if hostKey != host.Name {
+			continue
+		}
+
+		var namespaces []string
+		seen := make(map[string]bool)
+
+		for _, location := range host.Locations {
+			if !seen[location.Namespace] {
+				seen[location.Namespace] = true
+				namespaces = append(namespaces, location.Namespace)
+			}
+		}
+
+		// Sort to pick the same certificate across reloads
+		sort.Strings(namespaces)
+
+		for _, namespace := range namespaces {
+			secret, ok := cache.TLSCerts[namespace]
+
+			if ok && router.TLSSecretMatchesHost(secret, host.Name) {
+				certsDir := path.Join(nginxCertsDir, namespace)
+
+				host.Listens = append(host.Listens, &listenT{
+					Certificate:    path.Join(certsDir, api.TLSCertKey),
+					CertificateKey: path.Join(certsDir, api.TLSPrivateKeyKey),
+					Namespace:      namespace,
+					Port:           strconv.Itoa(config.TLSPort),
+				})
+
+				break
+			}
+		}
+	}
+}
+
+/*
+Drops the locations beyond config.MaxLocationsPerHost from each host, along with their upstreams, keeping the locations
+with the lowest sorting paths so that the same locations are kept across reloads
+*/
+func limitLocations(config *router.Config, tmplData *templateDataT) {
+	if config.MaxLocationsPerHost == 0 {
+		return
+	}
+
+	for hostKey, host := range tmplData.Hosts {
+		if len(host.Locations) <= config.MaxLocationsPerHost {
+			continue
+		}
+
+		var paths []string
+
+		for path := range host.Locations {
+			paths = append(paths, path)
+		}
+
+		sort.Strings(paths)
+
+		for _, path := range paths[config.MaxLocationsPerHost:] {
+			upstreamKey := hostKey + host.Locations[path].Path
+
+			logging.Warnf("    Host (%s) has more than %d locations, dropping the location for %s\n", hostKey, config.MaxLocationsPerHost, host.Locations[path].Path)
+
+			delete(tmplData.Upstreams, upstreamKey)
+			delete(tmplData.Upstreams, upstreamKey+"#canary")
+			delete(host.Locations, path)
+		}
+	}
+}
+
+/*
+Replaces the upstreams with identical servers, across hosts and paths, with a single upstream named after the hash of its
+servers and points the locations (and canary splits) of the replaced upstreams at it
+*/
+func consolidateUpstreams(tmplData *templateDataT) {
+	var upstreamKeys []string
+
+	for upstreamKey := range tmplData.Upstreams {
+		upstreamKeys = append(upstreamKeys, upstreamKey)
+	}
+
+	// Sort so that the same upstream is kept across reloads
+	sort.Strings(upstreamKeys)
+
+	var serversKeys []string
+	groups := make(map[string][]string)
+
+	for _, upstreamKey := range upstreamKeys {
+		var servers []string
+
+		for _, server := range tmplData.Upstreams[upstreamKey].Servers {
+			servers = append(servers, server.Target+" weight="+strconv.Itoa(server.Weight))
+		}
+
+		// The order of the servers depends on router.Config.UpstreamServerOrder so it is not part of the key
+		sort.Strings(servers)
+
+		serversKey := strings.Join(servers, " ")
+
+		if _, ok := groups[serversKey]; !ok {
+			serversKeys = append(serversKeys, serversKey)
+		}
+
+		groups[serversKey] = append(groups[serversKey], upstreamKey)
+	}
+
+	names := make(map[string]string)
+
+	for _, serversKey := range serversKeys {
+		group := groups[serversKey]
+
+		if len(group) < 2 {
+			continue
+		}
+
+		shared := tmplData.Upstreams[group[0]]
+		sharedName := "upstream" + fmt.Sprint(hash(serversKey))
+
+		for i, upstreamKey := range group {
+			upstream := tmplData.Upstreams[upstreamKey]
+
+			names[upstream.Name] = sharedName
+			shared.SharedBy = append(shared.SharedBy, upstream.Host+upstream.Path)
+
+			if i > 0 {
+				delete(tmplData.Upstreams, upstreamKey)
+			}
+		}
+
+		shared.Name = sharedName
+	}
+
+	for _, host := range tmplData.Hosts {
+		for _, location := range host.Locations {
+			if name, ok := names[location.Server.Target]; ok && location.Server.IsUpstream {
+				location.Server.Target = name
+			}
+
+			if location.Split != nil {
+				if name, ok := names[location.Split.Canary]; ok {
+					location.Split.Canary = name
+				}
+
+				if name, ok := names[location.Split.Stable]; ok {
+					location.Split.Stable = name
+				}
+			}
+		}
+	}
+}
+
+/*
+ConfWarning is a routing conflict found while generating the nginx configuration
+*/
+type ConfWarning struct {
+	// The host of the conflicting routes
+	Host string
+	// The path of the conflicting routes
+	Path string
+	// The cache keys (namespace/name) of the pod whose credentials are used and of the conflicting pod
+	Pods []string
+	// The description of the conflict
+	Message string
+}
+
 /*
 GetConf takes the router cache and returns a generated nginx configuration
 */
 func GetConf(config *router.Config, cache *router.Cache) string {
+	conf, _ := GetConfWithWarnings(config, cache)
+
+	return conf
+}
+
+/*
+GetConfWithWarnings takes the router cache and returns a generated nginx configuration along with the routing conflicts
+found while generating it.  Pods in different namespaces routing the same host and path share the location secured by
+the credentials of the lowest namespace (by name), every pod whose namespace uses different credentials is a conflict.
+*/
+func GetConfWithWarnings(config *router.Config, cache *router.Cache) (string, []ConfWarning) {
+	var warnings []ConfWarning
+
 	// Quick out if there are no pods in the cache
 	if len(cache.Pods) == 0 {
-		return GetDefaultConf(config)
+		return GetDefaultConf(config), warnings
 	}
 
-	// Make sure we've converted the API Key to nginx format
-	convertAPIKeyHeaderForNginx(config)
-
 	tmplData := templateDataT{
-		APIKeyHeader: nginxAPIKeyHeader,
-		Hosts:        make(map[string]*hostT),
-		Port:         config.Port,
-		Upstreams:    make(map[string]*upstreamT),
-		Config: config,
+		DefaultServerReturn:     config.DefaultServerReturn,
+		Hosts:                   make(map[string]*hostT),
+		ListenIPv6:              config.ListenIPv6,
+		Port:                    config.Port,
+		TLSPassthroughUpstreams: make(map[string]*upstreamT),
+		Upstreams:               make(map[string]*upstreamT),
+		WorkerConnections:       getWorkerConnections(config),
+		Config:                  config,
 	}
 
-	// Process the pods to populate the nginx configuration data structure
+	var cacheEntries []*router.PodWithRoutes
+	var canaryEntries []*router.PodWithRoutes
+
+	// Process the canary pods last so that they can be split from the stable pods serving the same host and path
 	for _, cacheEntry := range cache.Pods {
+		if cacheEntry.CanaryPercent > 0 {
+			canaryEntries = append(canaryEntries, cacheEntry)
+		} else {
+			cacheEntries = append(cacheEntries, cacheEntry)
+		}
+	}
+
+	// Sort so that the pod creating each location, and whose namespace secures it, does not depend on the map order
+	sort.Sort(podsByNamespace(cacheEntries))
+	sort.Sort(podsByNamespace(canaryEntries))
+
+	cacheEntries = append(cacheEntries, canaryEntries...)
+	locationOwners := make(map[string]*router.PodWithRoutes)
+	stableLocations := make(map[string]bool)
+
+	// Process the pods to populate the nginx configuration data structure
+	for _, cacheEntry := range cacheEntries {
 		// Process each pod route
 		for _, route := range cacheEntry.Routes {
-			host, ok := tmplData.Hosts[route.Incoming.Host]
+			// Skip routes with an invalid target port since nginx rejects the whole configuration (Example: 10.244.1.16:0)
+			if port, err := strconv.Atoi(route.Outgoing.Port); err != nil || !utils.IsValidPort(port) {
+				logging.Warnf("    Pod (%s) routing issue: route (%s) has an invalid port (%s), skipping the route\n", cacheEntry.Name, route, route.Outgoing.Port)
+
+				continue
+			}
+
+			hostKey := route.Incoming.HostKey()
+			host, ok := tmplData.Hosts[hostKey]
 
 			if !ok {
-				tmplData.Hosts[route.Incoming.Host] = &hostT{
+				port := route.Incoming.Port
+
+				if port == "" {
+					port = strconv.Itoa(config.Port)
+				}
+
+				tmplData.Hosts[hostKey] = &hostT{
+					Listens: []*listenT{
+						&listenT{
+							Port: port,
+						},
+					},
 					Locations:            make(map[string]*locationT),
+					Name:                 route.Incoming.Host,
 					NeedsDefaultLocation: true,
 				}
-				host = tmplData.Hosts[route.Incoming.Host]
+				host = tmplData.Hosts[hostKey]
 			}
 
+			var locationAPIKeyHeader string
+			var locationBasicAuth string
+			var locationDenyAll bool
 			var locationSecret string
+			var locationSecretPattern string
 			namespace := cacheEntry.Namespace
 			secret, ok := cache.Secrets[namespace]
 
+			// Use the secret data field required by the pod's authorization mode
 			if ok {
-				// There is guaranteed to be an API Key so no need to double check
-				locationSecret = base64.StdEncoding.EncodeToString(secret)
+				if cacheEntry.AuthMode == router.AuthModeBasic {
+					if credentials, ok := secret.Data[config.BasicAuthSecretDataField]; ok {
+						if len(credentials) == 0 {
+							locationDenyAll = denyEmptySecret(config, namespace, config.BasicAuthSecretDataField)
+						} else {
+							locationBasicAuth = "Basic " + base64.StdEncoding.EncodeToString(credentials)
+						}
+					}
+				} else if apiKey, ok := secret.Data[config.APIKeySecretDataField]; ok {
+					if len(apiKey) == 0 {
+						locationDenyAll = denyEmptySecret(config, namespace, config.APIKeySecretDataField)
+					} else {
+						locationAPIKeyHeader = convertAPIKeyHeaderForNginx(router.GetAPIKeyHeader(config, secret))
+						locationSecret = base64.StdEncoding.EncodeToString(apiKey)
+						locationSecretPattern = getSecretPattern(config, secret, locationSecret)
+					}
+				}
 			}
 
-			location, ok := host.Locations[route.Incoming.Path]
-			upstreamKey := route.Incoming.Host + route.Incoming.Path
+			locationPath := getLocationPath(route.Incoming.Path, route.Outgoing.PathTemplate != "")
+			location, ok := host.Locations[locationPath]
+			// A location can only proxy to a single upstream so all servers for a host+path share one upstream, each
+			// server keeps its own port (as part of its target) so mixed-port servers remain distinct
+			upstreamKey := hostKey + route.Incoming.Path
 			upstreamHash := fmt.Sprint(hash(upstreamKey))
 			upstreamName := "upstream" + upstreamHash
 			target := route.Outgoing.IP
 
-			if route.Outgoing.Port != "80" && route.Outgoing.Port != "443" {
+			// Only the default http port can be omitted, otherwise different ports on the same IP would share a target
+			if route.Outgoing.Port != "80" {
 				target += ":" + route.Outgoing.Port
 			}
 
+			// Sidecars sharing a unix socket with the router are proxied to over the socket instead of the pod IP
+			if cacheEntry.UnixSocket != "" {
+				target = "unix:" + cacheEntry.UnixSocket
+			}
+
+			// Record the not found backend servers
+			if router.IsNotFoundBackend(config, cacheEntry) {
+				found := false
+
+				for _, server := range tmplData.NotFoundServers {
+					if server.Target == target {
+						found = true
+						break
+					}
+				}
+
+				if !found {
+					tmplData.NotFoundServers = append(tmplData.NotFoundServers, &serverT{
+						Pod:    cacheEntry,
+						Target: target,
+					})
+				}
+			}
+
 			// Unset the need for a default location if necessary
 			if host.NeedsDefaultLocation && route.Incoming.Path == "/" {
 				host.NeedsDefaultLocation = false
 			}
 
-			if ok {
+			// Pods from other namespaces are added to the location as is, secured by the credentials of its namespace
+			if ok && location.Namespace != namespace && (location.APIKeyHeader != locationAPIKeyHeader ||
+				location.BasicAuth != locationBasicAuth || location.DenyAll != locationDenyAll ||
+				location.Secret != locationSecret || location.SecretPattern != locationSecretPattern) {
+				owner := locationOwners[upstreamKey]
+				warning := ConfWarning{
+					Host: hostKey,
+					Path: route.Incoming.Path,
+					Pods: []string{owner.Namespace + "/" + owner.Name, namespace + "/" + cacheEntry.Name},
+					Message: fmt.Sprintf("pods %s/%s and %s/%s use different credentials, using the credentials of namespace (%s)",
+						owner.Namespace, owner.Name, namespace, cacheEntry.Name, owner.Namespace),
+				}
+
+				logging.Warnf("    Host (%s) path (%s) routing issue: %s\n", warning.Host, warning.Path, warning.Message)
+
+				warnings = append(warnings, warning)
+			}
+
+			if ok && cacheEntry.CanaryPercent > 0 && stableLocations[upstreamKey] {
+				addCanaryServer(&tmplData, location, cacheEntry, route, upstreamKey, upstreamName, target)
+			} else if ok {
 				// If the current target is different than the new one, create/update the upstream accordingly
 				if location.Server.Target != target {
 					if upstream, ok := tmplData.Upstreams[upstreamKey]; ok {
@@ -271,25 +1309,32 @@ func GetConf(config *router.Config, cache *router.Cache) string {
 							upstream.Servers = append(upstream.Servers, &serverT{
 								Pod:    cacheEntry,
 								Target: target,
+								Weight: route.Outgoing.Weight,
 							})
 
-							// Sort to make finding your pods in an upstream easier
-							sort.Sort(upstream.Servers)
+							// Sort to keep the upstream stable across reloads
+							sortServers(config, upstream.Servers)
 						}
 					} else {
 						// Create the new upstream
-						tmplData.Upstreams[upstreamKey] = &upstreamT{
+						upstream := &upstreamT{
 							Name: upstreamName,
-							Host: route.Incoming.Host,
+							Host: route.Incoming.HostKey(),
 							Path: route.Incoming.Path,
 							Servers: []*serverT{
 								location.Server,
 								&serverT{
 									Pod:    cacheEntry,
 									Target: target,
+									Weight: route.Outgoing.Weight,
 								},
 							},
 						}
+
+						// Sort to keep the upstream stable across reloads
+						sortServers(config, upstream.Servers)
+
+						tmplData.Upstreams[upstreamKey] = upstream
 					}
 
 					// Update the location server
@@ -299,41 +1344,227 @@ func GetConf(config *router.Config, cache *router.Cache) string {
 					}
 				}
 			} else {
-				host.Locations[route.Incoming.Path] = &locationT{
-					Namespace: namespace,
-					Path:      route.Incoming.Path,
-					Secret:    locationSecret,
+				locationOwners[upstreamKey] = cacheEntry
+				stableLocations[upstreamKey] = cacheEntry.CanaryPercent == 0
+
+				var mirror *mirrorT
+
+				if cacheEntry.MirrorTarget != "" {
+					mirrorHash := fmt.Sprint(hash(hostKey + route.Incoming.Path))
+
+					mirror = &mirrorT{
+						Name:       "mirror" + mirrorHash,
+						Path:       "/_mirror" + mirrorHash,
+						Percentage: cacheEntry.MirrorPercentage,
+						Target:     cacheEntry.MirrorTarget,
+					}
+				}
+
+				var authRequest *authRequestT
+
+				if cacheEntry.AuthRequest != "" {
+					authHash := fmt.Sprint(hash(hostKey + route.Incoming.Path))
+
+					authRequest = &authRequestT{
+						Path:           "/_auth" + authHash,
+						SigninLocation: "@signin" + authHash,
+						SigninURL:      cacheEntry.AuthRequestSigninURL,
+						URL:            cacheEntry.AuthRequest,
+					}
+				}
+
+				var methodRewrite *methodRewriteT
+
+				if len(cacheEntry.MethodRewrites) > 0 {
+					methodRewrite = &methodRewriteT{
+						Methods: cacheEntry.MethodRewrites,
+						Name:    "method" + fmt.Sprint(hash(hostKey+route.Incoming.Path)),
+					}
+				}
+
+				host.Locations[locationPath] = &locationT{
+					APIKeyHeader:          locationAPIKeyHeader,
+					AuthRequest:           authRequest,
+					BackendHost:           cacheEntry.BackendHost,
+					BasicAuth:             locationBasicAuth,
+					DenyAll:               locationDenyAll,
+					CacheBypass:           strings.Join(cacheEntry.CacheBypass, " "),
+					CORSMethods:           cacheEntry.CORSMethods,
+					CORSOrigin:            cacheEntry.CORSOrigin,
+					Gzip:                  cacheEntry.Gzip,
+					MethodRewrite:         methodRewrite,
+					Mirror:                mirror,
+					Namespace:             namespace,
+					Path:                  route.Incoming.Path,
+					ProxyCache:            cacheEntry.ProxyCache,
+					ProxyCacheLockTimeout: cacheEntry.ProxyCacheLockTimeout,
+					ProxyCacheUseStale:    strings.Join(cacheEntry.ProxyCacheUseStale, " "),
+					ProxyIgnoreHeaders:    strings.Join(cacheEntry.ProxyIgnoreHeaders, " "),
+					RequestBuffering:      cacheEntry.RequestBuffering,
+					ProxyPassURI:          getProxyPassURI(route.Outgoing.PathTemplate),
+					Rewrite:               getRewrite(route.Incoming.Path, route.Incoming.Rewrite),
+					Secret:                locationSecret,
+					SecretPattern:         locationSecretPattern,
+					StripAuthorization:    cacheEntry.StripAuthorization,
+					SubFilters:            escapeSubFilters(cacheEntry.SubFilters),
+					Timeouts:              route.Outgoing.Timeouts,
+					Websocket:             route.Outgoing.Websocket,
 					Server: &serverT{
 						Pod:    cacheEntry,
 						Target: target,
+						Weight: route.Outgoing.Weight,
 					},
 				}
 			}
 		}
+
+		if config.EnableTLSPassthrough && cacheEntry.TLSPassthroughPort != "" {
+			addTLSPassthroughServers(&tmplData, cacheEntry)
+		}
+	}
+
+	limitLocations(config, &tmplData)
+
+	if config.ConsolidateUpstreams {
+		consolidateUpstreams(&tmplData)
+	}
+
+	if config.TLSSecret != "" {
+		addTLSServers(config, cache, &tmplData)
+	}
+
+	// Sort to keep the not found backend stable across reloads
+	sortServers(config, tmplData.NotFoundServers)
+
+	// Sort to keep the TLS passthrough upstreams stable across reloads
+	for _, upstream := range tmplData.TLSPassthroughUpstreams {
+		sortServers(config, upstream.Servers)
 	}
 
 	var doc bytes.Buffer
 
 	// Useful for debugging
 	if err := nginxConfTemplate.Execute(&doc, tmplData); err != nil {
-		log.Fatalf("Failed to write template %v", err)
+		logging.Fatalf("Failed to write template %v", err)
 	}
 
-	return doc.String()
+	return doc.String(), warnings
+}
+
+/*
+GetConfHash returns the hash (64-bit FNV-1a) of the nginx configuration, used to detect configuration changes
+*/
+func GetConfHash(conf string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(conf))
+	return h.Sum64()
+}
+
+// ReloadPlan is how a change of the nginx configuration is applied
+type ReloadPlan int
+
+const (
+	// NoReload is the plan for changes that do not change the nginx configuration
+	NoReload ReloadPlan = iota
+	// DynamicUpstream is the plan for changes that only add or remove servers of existing upstreams
+	DynamicUpstream
+	// FullReload is the plan for any other change, applied by reloading nginx
+	FullReload
+)
+
+/*
+splitUpstreamServers splits the servers (and their pod comments) out of the http upstreams of the nginx configuration,
+returning the remaining configuration and the servers of each upstream.  Backup servers are static so they are kept.
+*/
+func splitUpstreamServers(conf string) (string, map[string][]string) {
+	var lines []string
+	inStream := false
+	servers := make(map[string][]string)
+	upstream := ""
+
+	for _, line := range strings.Split(conf, "\n") {
+		// ngx_dynamic_upstream only updates http upstreams
+		if line == "stream {" {
+			inStream = true
+		}
+
+		if !inStream {
+			if upstream == "" && strings.HasPrefix(line, "  upstream ") && strings.HasSuffix(line, " {") {
+				upstream = strings.TrimSuffix(strings.TrimPrefix(line, "  upstream "), " {")
+				servers[upstream] = []string{}
+			} else if upstream != "" && line == "  }" {
+				upstream = ""
+			} else if upstream != "" && strings.HasPrefix(line, "    # Pod ") {
+				continue
+			} else if upstream != "" && strings.HasPrefix(line, "    server ") && !strings.HasSuffix(line, " backup;") {
+				servers[upstream] = append(servers[upstream], strings.TrimSuffix(strings.TrimPrefix(line, "    server "), ";"))
+
+				continue
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n"), servers
+}
+
+/*
+DiffConf classifies the change between the nginx configurations of the old and new caches.  Changes that only add or
+remove servers of existing upstreams can be applied with UpdateUpstreams when config.EnableDynamicUpstreams is enabled,
+every other change requires a reload.
+*/
+func DiffConf(config *router.Config, old, new *router.Cache) ReloadPlan {
+	oldConf := GetConf(config, old)
+	newConf := GetConf(config, new)
+
+	if oldConf == newConf {
+		return NoReload
+	} else if !config.EnableDynamicUpstreams {
+		return FullReload
+	}
+
+	oldRest, oldServers := splitUpstreamServers(oldConf)
+	newRest, newServers := splitUpstreamServers(newConf)
+
+	if oldRest != newRest || serverParamsChanged(oldServers, newServers) {
+		return FullReload
+	}
+
+	return DynamicUpstream
+}
+
+/*
+serverParamsChanged returns whether any server kept in an upstream has different parameters (like its weight), which
+can only be applied by a reload
+*/
+func serverParamsChanged(oldServers, newServers map[string][]string) bool {
+	for upstream, servers := range newServers {
+		previous := make(map[string]string)
+
+		for _, server := range oldServers[upstream] {
+			previous[strings.Fields(server)[0]] = server
+		}
+
+		for _, server := range servers {
+			if old, ok := previous[strings.Fields(server)[0]]; ok && old != server {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 /*
 GetDefaultConf returns the default nginx.conf
 */
 func GetDefaultConf(config *router.Config) string {
-	// Make sure we've converted the API Key to nginx format
-	convertAPIKeyHeaderForNginx(config)
-
 	if defaultNginxConf == "" {
 		var doc bytes.Buffer
 
 		if err := defaultNginxConfTemplate.Execute(&doc, config); err != nil {
-			log.Fatalf("Failed to write template %v", err)
+			logging.Fatalf("Failed to write template %v", err)
 		} else {
 			defaultNginxConf = doc.String()
 		}
@@ -18,15 +18,26 @@ package nginx
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/pem"
+	"fmt"
 	"log"
+	"math/big"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/30x/k8s-router/router"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/util/intstr"
 )
 
 var config *router.Config
@@ -85,27 +96,125 @@ func getConfPreamble(config *router.Config) string {
 	return doc.String()
 }
 
+func getRoutablePod(annotations map[string]string) *api.Pod {
+	podAnnotations := map[string]string{
+		"routingHosts": "test.github.com",
+		"routingPaths": "80:/",
+	}
+
+	for name, value := range annotations {
+		podAnnotations[name] = value
+	}
+
+	return &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: podAnnotations,
+			Name:        "testing",
+			Namespace:   "testing",
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(80),
+						},
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.16",
+		},
+	}
+}
+
+/*
+getUpstreamCache returns a cache with a routable pod, sharing the same host and path, for each of the pod IPs
+*/
+func getUpstreamCache(ips ...string) *router.Cache {
+	cache := &router.Cache{
+		Pods: make(map[string]*router.PodWithRoutes),
+	}
+
+	for _, ip := range ips {
+		pod := getRoutablePod(nil)
+
+		pod.Name = "testing-" + ip
+		pod.Status.PodIP = ip
+
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
+	}
+
+	return cache
+}
+
+/*
+getTLSSecret returns a TLS secret with a self-signed certificate for the provided hosts
+*/
+func getTLSSecret(t *testing.T, namespace string, hosts ...string) *api.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Unable to generate the private key: %v", err)
+	}
+
+	certTemplate := &x509.Certificate{
+		DNSNames:     hosts,
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now(),
+		SerialNumber: big.NewInt(1),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, certTemplate, certTemplate, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("Unable to create the certificate: %v", err)
+	}
+
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      config.TLSSecret,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			api.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			api.TLSPrivateKeyKey: []byte("private-key"),
+		},
+		Type: api.SecretTypeTLS,
+	}
+}
+
 func resetConf() {
 	// Reset the cached default server (At runtime, we cache the results because they will never change)
 	defaultNginxConf = ""
 	// Change the config port
 	config.Port = 80
-	// Reset the cached API Key header (At runtime, we cache the results because they will never change)
-	nginxAPIKeyHeader = ""
+	// Reset the API Key header and the cached nginx API Key headers
+	config.APIKeyHeader = router.DefaultAPIKeyHeader
+	nginxAPIKeyHeaders = make(map[string]string)
 }
 
 func validateConf(t *testing.T, desc, expected string, pods []*api.Pod, secrets []*api.Secret) {
 	cache := &router.Cache{
-		Pods:    make(map[string]*router.PodWithRoutes),
-		Secrets: make(map[string][]byte),
+		Pods:     make(map[string]*router.PodWithRoutes),
+		Secrets:  make(map[string]*api.Secret),
+		TLSCerts: make(map[string]*api.Secret),
 	}
 
 	for _, pod := range pods {
-		cache.Pods[pod.Name] = router.ConvertPodToModel(config, pod)
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
 	}
 
 	for _, secret := range secrets {
-		cache.Secrets[secret.Namespace] = router.ConvertSecretToModel(config, secret)
+		if router.IsTLSSecret(config, secret) {
+			cache.TLSCerts[secret.Namespace] = secret
+		} else {
+			cache.Secrets[secret.Namespace] = router.ConvertSecretToModel(config, secret)
+		}
 	}
 
 	actual := GetConf(config, cache)
@@ -166,13 +275,153 @@ daemon on;
 	resetConf()
 }
 
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with an empty cache and an empty cache status
+*/
+func TestGetConfNoRoutablePodsEmptyCacheStatus(t *testing.T) {
+	resetConf()
+
+	defer func() {
+		config.EmptyCacheRetryAfter = router.DefaultEmptyCacheRetryAfter
+		config.EmptyCacheStatus = router.DefaultEmptyCacheStatus
+
+		resetConf()
+	}()
+
+	config.EmptyCacheRetryAfter = 30
+	config.EmptyCacheStatus = 503
+
+	conf := GetConf(config, &router.Cache{})
+
+	if conf != `
+# A very simple nginx configuration file that forces nginx to start as a daemon.
+events {}
+http {
+  # Default server that will tell clients to back off since there are no routable pods
+  server {
+    listen 80 default_server;
+    add_header Retry-After 30 always;
+    return 503;
+  }
+}
+daemon on;
+` {
+		t.Fatalf("The default nginx.conf should return the empty cache status for an empty cache:\n%s", conf)
+	}
+
+	// Without a Retry-After
+	resetConf()
+
+	config.EmptyCacheRetryAfter = 0
+
+	if conf = GetConf(config, &router.Cache{}); strings.Contains(conf, "Retry-After") || !strings.Contains(conf, "    return 503;\n") {
+		t.Fatalf("The default nginx.conf should return the empty cache status without a Retry-After:\n%s", conf)
+	}
+
+	// The empty cache status is not used once there are routable pods
+	conf = GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing/testing": router.ConvertPodToModel(config, getRoutablePod(nil)),
+		},
+	})
+
+	if strings.Contains(conf, "return 503;") || !strings.Contains(conf, "    return 444;\n") {
+		t.Fatalf("The empty cache status should not be used when there are routable pods:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with an empty cache, a custom default server return and error
+pages
+*/
+func TestGetConfNoRoutablePodsDefaultServerReturn(t *testing.T) {
+	resetConf()
+
+	defer func() {
+		config.DefaultServerReturn = router.DefaultDefaultServerReturn
+		config.ErrorPages = map[int]string{}
+
+		resetConf()
+	}()
+
+	config.DefaultServerReturn = 404
+	config.ErrorPages = map[int]string{
+		503: "https://errors.example.com/503.html",
+		404: "/errors/404.html",
+	}
+
+	conf := GetConf(config, &router.Cache{})
+
+	if conf != `
+# A very simple nginx configuration file that forces nginx to start as a daemon.
+events {}
+http {
+  # Error pages served in place of the nginx error responses (including those of the default servers and locations)
+  error_page 404 /errors/404.html;
+  error_page 503 https://errors.example.com/503.html;
+
+  # Default server that will return 404 for requests to unknown hosts
+  server {
+    listen 80 default_server;
+    return 404;
+  }
+}
+daemon on;
+` {
+		t.Fatalf("The default nginx.conf should use the default server return and error pages for an empty cache:\n%s", conf)
+	}
+
+	// The default server return and error pages are also used once there are routable pods
+	conf = GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing/testing": router.ConvertPodToModel(config, getRoutablePod(nil)),
+		},
+	})
+
+	if !strings.Contains(conf, "  error_page 404 /errors/404.html;\n  error_page 503 https://errors.example.com/503.html;\n") ||
+		!strings.Contains(conf, "  # Default server that will return 404 for requests to unknown hosts\n") ||
+		strings.Contains(conf, "return 444;") {
+		t.Fatalf("The default server return and error pages should be used when there are routable pods:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a route whose target port is invalid
+*/
+func TestGetConfInvalidTargetPort(t *testing.T) {
+	resetConf()
+
+	for _, port := range []string{"0", "", "-1", "65536", "abc"} {
+		invalidPod := router.ConvertPodToModel(config, getRoutablePod(nil))
+		validPod := router.ConvertPodToModel(config, getRoutablePod(map[string]string{
+			"routingHosts": "valid.github.com",
+		}))
+
+		invalidPod.Routes[0].Outgoing.Port = port
+		validPod.Name = "valid"
+
+		conf := GetConf(config, &router.Cache{
+			Pods: map[string]*router.PodWithRoutes{
+				"testing/testing": invalidPod,
+				"testing/valid":   validPod,
+			},
+		})
+
+		if strings.Contains(conf, "server_name test.github.com;") || strings.Contains(conf, "10.244.1.16:"+port+";") {
+			t.Fatalf("Routes with an invalid target port (%s) should be skipped:\n%s", port, conf)
+		} else if !strings.Contains(conf, "server_name valid.github.com;") {
+			t.Fatalf("Routes with a valid target port should not be skipped:\n%s", conf)
+		}
+	}
+}
+
 /*
 Test for github.com/30x/k8s-router/nginx/config#GetConf with single pod and multiple paths
 */
 func TestGetConfMultiplePaths(t *testing.T) {
 	expectedConf := `
 events {
-  worker_connections 1024;
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
 }
 http {` + getConfPreamble(config) + `
   server {
@@ -235,7 +484,7 @@ func TestGetConfMultiplePathsCustomPort(t *testing.T) {
 
 	expectedConf := `
 events {
-  worker_connections 1024;
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
 }
 http {` + getConfPreamble(config) + `
   server {
@@ -295,7 +544,7 @@ Test for github.com/30x/k8s-router/nginx/config#GetConf with multiple, single po
 func TestGetConfMultipleRoutableServices(t *testing.T) {
 	expectedConf := `
 events {
-  worker_connections 1024;
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
 }
 http {` + getConfPreamble(config) + `
   server {
@@ -377,12 +626,13 @@ http {` + getConfPreamble(config) + `
 }
 
 /*
-Test for github.com/30x/k8s-router/nginx/config#GetConf with single, multiple pod services
+Test for github.com/30x/k8s-router/nginx/config#GetConf with single, multiple pod services (Pods serving the same host
+and path share a single upstream even when their ports differ since a location can only proxy to one upstream)
 */
 func TestGetConfMultiplePodRoutableServices(t *testing.T) {
 	expectedConf := `
 events {
-  worker_connections 1024;
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
 }
 http {` + getConfPreamble(config) + `
   # Upstream for / traffic on test.github.com
@@ -495,7 +745,7 @@ func TestGetConfWithAPIKey(t *testing.T) {
 	apiKey := []byte("Updated-API-Key")
 	expectedConf := `
 events {
-  worker_connections 1024;
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
 }
 http {` + getConfPreamble(config) + `
   server {
@@ -553,6 +803,134 @@ http {` + getConfPreamble(config) + `
 	validateConf(t, "pod with API Key", expectedConf, []*api.Pod{&pod}, []*api.Secret{&secret})
 }
 
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with an API Key and a previous API Key
+*/
+func TestGetConfWithPreviousAPIKey(t *testing.T) {
+	defer func() {
+		config.PreviousAPIKeyField = ""
+	}()
+
+	apiKey := []byte("Current-API-Key")
+	previousAPIKey := []byte("Previous-API-Key")
+	secret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      config.APIKeySecret,
+			Namespace: "testing",
+		},
+		Data: map[string][]byte{
+			"api-key":          apiKey,
+			"api-key-previous": previousAPIKey,
+		},
+	}
+	getExpectedConf := func(check string) string {
+		return `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Check the Routing API Key (namespace: testing)
+      ` + check + ` {
+        return 403;
+      }
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+	}
+
+	// The previous API Key is ignored unless configured
+	validateConf(t, "pod with API Key and unconfigured previous API Key", getExpectedConf(`if ($http_x_routing_api_key != "`+
+		base64.StdEncoding.EncodeToString(apiKey)+`")`), []*api.Pod{getRoutablePod(nil)}, []*api.Secret{secret})
+
+	config.PreviousAPIKeyField = "api-key-previous"
+
+	validateConf(t, "pod with API Key and previous API Key", getExpectedConf(`if ($http_x_routing_api_key !~ "^(`+
+		base64.StdEncoding.EncodeToString(apiKey)+`|`+base64.StdEncoding.EncodeToString(previousAPIKey)+`)$")`),
+		[]*api.Pod{getRoutablePod(nil)}, []*api.Secret{secret})
+
+	// An empty previous API Key is ignored
+	secret.Data["api-key-previous"] = []byte{}
+
+	validateConf(t, "pod with API Key and empty previous API Key", getExpectedConf(`if ($http_x_routing_api_key != "`+
+		base64.StdEncoding.EncodeToString(apiKey)+`")`), []*api.Pod{getRoutablePod(nil)}, []*api.Secret{secret})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with an empty API Key (and basic auth credentials)
+*/
+func TestGetConfWithEmptySecretValue(t *testing.T) {
+	defer func() {
+		config.EmptySecretAction = router.DefaultEmptySecretAction
+	}()
+
+	getSecret := func(field string) *api.Secret {
+		return &api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:      config.APIKeySecret,
+				Namespace: "testing",
+			},
+			Data: map[string][]byte{
+				field: []byte{},
+			},
+		}
+	}
+	deniedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Deny all requests since the router secret value is empty (namespace: testing)
+      return 403;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+	allowedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+	basicAuthPod := getRoutablePod(map[string]string{
+		router.AuthModeAnnotation: router.AuthModeBasic,
+	})
+
+	validateConf(t, "pod with empty API Key (deny)", deniedConf, []*api.Pod{getRoutablePod(nil)}, []*api.Secret{getSecret("api-key")})
+	validateConf(t, "pod with empty basic auth credentials (deny)", deniedConf, []*api.Pod{basicAuthPod}, []*api.Secret{getSecret("basic-auth")})
+
+	config.EmptySecretAction = router.EmptySecretActionAllow
+
+	validateConf(t, "pod with empty API Key (allow)", allowedConf, []*api.Pod{getRoutablePod(nil)}, []*api.Secret{getSecret("api-key")})
+	validateConf(t, "pod with empty basic auth credentials (allow)", allowedConf, []*api.Pod{basicAuthPod}, []*api.Secret{getSecret("basic-auth")})
+}
+
 /*
 Test for github.com/30x/k8s-router/nginx/config#GetConf with custom API Key header
 */
@@ -565,7 +943,7 @@ func TestGetConfWithCustomAPIKeyHeader(t *testing.T) {
 	apiKey := []byte("Updated-API-Key")
 	expectedConf := `
 events {
-  worker_connections 1024;
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
 }
 http {` + getConfPreamble(config) + `
   server {
@@ -626,13 +1004,2939 @@ http {` + getConfPreamble(config) + `
 }
 
 /*
-Test for ClientMaxBodySize config variable in Nginx Template
+Test for github.com/30x/k8s-router/nginx/config#GetConf with namespaces using different API Key headers
 */
-func TestClientMaxBodySize(t *testing.T) {
-	config.ClientMaxBodySize = "1234m"
-	doc := getConfPreamble(config)
-	idx := strings.Index(doc, "client_max_body_size 1234m;")
-	if (idx < 0) {
-		log.Fatalf("Failed to include client_max_body_size from config.")
+func TestGetConfWithNamespaceAPIKeyHeaders(t *testing.T) {
+	apiKey1 := []byte("Tenant-1-API-Key")
+	apiKey2 := []byte("Tenant-2-API-Key")
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name tenant1.github.com;
+
+    location / {
+      # Check the Routing API Key (namespace: tenant1)
+      if ($http_x_routing_api_key != "` + base64.StdEncoding.EncodeToString(apiKey1) + `") {
+        return 403;
+      }
+
+      # Pod testing (namespace: tenant1)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  server {
+    listen 80;
+    server_name tenant2.github.com;
+
+    location / {
+      # Check the Routing API Key (namespace: tenant2)
+      if ($http_x_tenant_2_key != "` + base64.StdEncoding.EncodeToString(apiKey2) + `") {
+        return 403;
+      }
+
+      # Pod testing (namespace: tenant2)
+      proxy_pass http://10.244.1.17;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod1 := getRoutablePod(map[string]string{
+		"routingHosts": "tenant1.github.com",
+	})
+	pod2 := getRoutablePod(map[string]string{
+		"routingHosts": "tenant2.github.com",
+	})
+
+	pod1.Namespace = "tenant1"
+	pod2.Namespace = "tenant2"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	// The first namespace uses the API Key header while the second one stores its own header name
+	secret1 := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      config.APIKeySecret,
+			Namespace: "tenant1",
+		},
+		Data: map[string][]byte{
+			"api-key": apiKey1,
+		},
+	}
+	secret2 := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      config.APIKeySecret,
+			Namespace: "tenant2",
+		},
+		Data: map[string][]byte{
+			"api-key":                          apiKey2,
+			router.APIKeyHeaderSecretDataField: []byte("X-Tenant-2-Key"),
+		},
+	}
+
+	validateConf(t, "pods in namespaces with different API Key headers", expectedConf, []*api.Pod{pod1, pod2},
+		[]*api.Secret{secret1, secret2})
+
+	resetConf()
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConfWithWarnings with pods in namespaces with different API Keys
+routing the same host and path
+*/
+func TestGetConfWithWarningsConflictingAPIKeys(t *testing.T) {
+	apiKey1 := []byte("Alpha-API-Key")
+	apiKey2 := []byte("Beta-API-Key")
+	pod1 := getRoutablePod(map[string]string{})
+	pod2 := getRoutablePod(map[string]string{})
+
+	pod1.Namespace = "alpha"
+	pod2.Namespace = "beta"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod1): router.ConvertPodToModel(config, pod1),
+			router.GetPodCacheKey(pod2): router.ConvertPodToModel(config, pod2),
+		},
+		Secrets: map[string]*api.Secret{
+			"alpha": &api.Secret{
+				ObjectMeta: api.ObjectMeta{
+					Name:      config.APIKeySecret,
+					Namespace: "alpha",
+				},
+				Data: map[string][]byte{
+					"api-key": apiKey1,
+				},
+			},
+			"beta": &api.Secret{
+				ObjectMeta: api.ObjectMeta{
+					Name:      config.APIKeySecret,
+					Namespace: "beta",
+				},
+				Data: map[string][]byte{
+					"api-key": apiKey2,
+				},
+			},
+		},
+	}
+
+	conf, warnings := GetConfWithWarnings(config, cache)
+
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning but found %d", len(warnings))
+	} else if warnings[0].Host != "test.github.com" || warnings[0].Path != "/" {
+		t.Fatalf("Unexpected warning host (%s) and path (%s)", warnings[0].Host, warnings[0].Path)
+	} else if strings.Join(warnings[0].Pods, " ") != "alpha/testing beta/testing" {
+		t.Fatalf("Unexpected warning pods: %s", strings.Join(warnings[0].Pods, " "))
+	} else if !strings.Contains(conf, "# Check the Routing API Key (namespace: alpha)") ||
+		!strings.Contains(conf, base64.StdEncoding.EncodeToString(apiKey1)) {
+		t.Fatal("The location should be secured by the API Key of the lowest namespace")
+	} else if strings.Contains(conf, base64.StdEncoding.EncodeToString(apiKey2)) {
+		t.Fatal("The location should not use the API Key of the conflicting namespace")
+	} else if !strings.Contains(conf, "server 10.244.1.16") || !strings.Contains(conf, "server 10.244.1.17") {
+		t.Fatal("Both pods should still be upstream servers of the location")
+	}
+
+	// The resolution should not depend on the cache iteration order
+	for i := 0; i < 10; i++ {
+		if actual := GetConf(config, cache); actual != conf {
+			t.Fatalf("Unexpected nginx.conf was generated\nExpected: %s\n\nActual: %s\n", conf, actual)
+		}
+	}
+
+	// Namespaces using the same API Key do not conflict
+	cache.Secrets["beta"].Data["api-key"] = apiKey1
+
+	if _, warnings = GetConfWithWarnings(config, cache); len(warnings) != 0 {
+		t.Fatalf("Expected no warnings but found %d", len(warnings))
+	}
+}
+
+/*
+Test for ClientMaxBodySize config variable in Nginx Template
+*/
+func TestClientMaxBodySize(t *testing.T) {
+	config.ClientMaxBodySize = "1234m"
+	doc := getConfPreamble(config)
+	idx := strings.Index(doc, "client_max_body_size 1234m;")
+	if (idx < 0) {
+		log.Fatalf("Failed to include client_max_body_size from config.")
+	}
+}
+
+/*
+Test for ClientHeaderBufferSize config variable in Nginx Template
+*/
+func TestClientHeaderBufferSize(t *testing.T) {
+	defer func() {
+		config.ClientHeaderBufferSize = router.DefaultClientHeaderBufferSize
+	}()
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  client_header_buffer_size 1k;\n") {
+		t.Fatalf("Failed to include the default client_header_buffer_size from config:\n%s", doc)
+	}
+
+	config.ClientHeaderBufferSize = "4k"
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  client_header_buffer_size 4k;\n") {
+		t.Fatalf("Failed to include client_header_buffer_size from config:\n%s", doc)
+	}
+}
+
+/*
+Test for DefaultType and Charset config variables in Nginx Template
+*/
+func TestDefaultTypeAndCharset(t *testing.T) {
+	defer func() {
+		config.Charset = router.DefaultCharset
+		config.DefaultType = router.DefaultDefaultType
+	}()
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  default_type application/octet-stream;\n") {
+		t.Fatalf("Failed to include the default default_type from config:\n%s", doc)
+	} else if strings.Contains(doc, "charset") {
+		t.Fatalf("The charset should not be included without a charset:\n%s", doc)
+	}
+
+	config.Charset = "utf-8"
+	config.DefaultType = "text/plain"
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  default_type text/plain;\n  charset utf-8;\n") {
+		t.Fatalf("Failed to include default_type and charset from config:\n%s", doc)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the proxyIgnoreHeaders annotation
+*/
+func TestGetConfWithProxyIgnoreHeaders(t *testing.T) {
+	config.ProxyCachePath = "/var/cache/nginx/router"
+
+	defer func() {
+		config.ProxyCachePath = router.DefaultProxyCachePath
+	}()
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  proxy_cache_path /var/cache/nginx/router levels=1:2 keys_zone=router_cache:10m max_size=1g inactive=60m;\n") {
+		t.Fatalf("Failed to include proxy_cache_path from config:\n%s", doc)
+	}
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Cache the pod's responses
+      proxy_cache router_cache;
+
+      proxy_ignore_headers Cache-Control Expires;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.ProxyCacheAnnotation:         "on",
+		router.ProxyIgnoreHeadersAnnotation: "cache-control Expires",
+	})
+
+	validateConf(t, "pod with proxyIgnoreHeaders", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the proxyCacheUseStale annotation
+*/
+func TestGetConfWithProxyCacheUseStale(t *testing.T) {
+	config.ProxyCachePath = "/var/cache/nginx/router"
+
+	defer func() {
+		config.ProxyCachePath = router.DefaultProxyCachePath
+	}()
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Cache the pod's responses
+      proxy_cache router_cache;
+
+      # Serve stale cached responses when the backend is unavailable
+      proxy_cache_use_stale error timeout updating http_500 http_502 http_503 http_504;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.ProxyCacheAnnotation:         "on",
+		router.ProxyCacheUseStaleAnnotation: "error timeout updating http_500 http_502 http_503 http_504",
+	})
+
+	validateConf(t, "pod with proxyCacheUseStale", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// The stale conditions are dropped when the pod's responses are not cached
+	delete(pod.Annotations, router.ProxyCacheAnnotation)
+
+	if conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+	}); strings.Contains(conf, "proxy_cache_use_stale") {
+		t.Fatalf("The stale conditions should not be rendered for pods whose responses are not cached:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the proxyTimeouts annotation
+*/
+func TestGetConfWithProxyTimeouts(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Override the default proxy timeouts
+      proxy_connect_timeout 5s;
+      proxy_read_timeout 120s;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.ProxyTimeoutsAnnotation: "read=120s connect=5s",
+	})
+
+	validateConf(t, "pod with proxyTimeouts", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a pod using the requestBuffering annotation
+*/
+func TestGetConfWithRequestBuffering(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Override the inherited buffering of request bodies (client_max_body_size still applies)
+      proxy_request_buffering off;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.RequestBufferingAnnotation: "off",
+	})
+
+	validateConf(t, "pod with requestBuffering off", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	expectedConf = `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod = getRoutablePod(map[string]string{
+		router.RequestBufferingAnnotation: "streaming",
+	})
+
+	validateConf(t, "pod with an invalid requestBuffering", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a pod using the websocket annotation
+*/
+func TestGetConfWithWebsocket(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Override the default proxy timeouts
+      proxy_read_timeout 3600s;
+
+      # Set every proxied header (setting a header replaces every inherited header)
+      proxy_set_header Connection "upgrade";
+      proxy_set_header Host $http_host;
+      proxy_set_header Upgrade $http_upgrade;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.WebsocketAnnotation: "true",
+	})
+
+	validateConf(t, "pod with websocket", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	pod = getRoutablePod(map[string]string{
+		router.WebsocketAnnotation: "false",
+	})
+
+	expectedConf = `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	validateConf(t, "pod with websocket disabled", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with pods using wildcard and regex hosts
+*/
+func TestGetConfWildcardAndRegexHosts(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name *.example.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  server {
+    listen 80;
+    server_name ~^api\d+\.example\.com$;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		"routingHosts": "*.example.com ~^api\\d+\\.example\\.com$",
+	})
+
+	validateConf(t, "pod with wildcard and regex hosts", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with pods using mixed-case hosts
+*/
+func TestGetConfMixedCaseHosts(t *testing.T) {
+	pod1 := getRoutablePod(map[string]string{
+		"routingHosts": "Test.GitHub.com",
+	})
+	pod2 := getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com",
+	})
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			pod1.Name: router.ConvertPodToModel(config, pod1),
+			pod2.Name: router.ConvertPodToModel(config, pod2),
+		},
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	conf := GetConf(config, cache)
+
+	if strings.Count(conf, "server_name test.github.com;") != 1 {
+		t.Fatalf("Mixed-case hosts should be merged into a single server block: %s", conf)
+	} else if strings.Contains(conf, "Test.GitHub.com") {
+		t.Fatalf("Hosts should be normalized to lowercase: %s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a limit on the number of locations per host
+*/
+func TestGetConfMaxLocationsPerHost(t *testing.T) {
+	defer func() {
+		config.MaxLocationsPerHost = router.DefaultMaxLocationsPerHost
+	}()
+
+	config.MaxLocationsPerHost = 2
+
+	pod1 := getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com other.github.com",
+		"routingPaths": "80:/c 80:/a 3000:/b",
+	})
+	pod2 := getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com",
+		"routingPaths": "3000:/b",
+	})
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	cache := &router.Cache{
+		Pods:    make(map[string]*router.PodWithRoutes),
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	for _, pod := range []*api.Pod{pod1, pod2} {
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
+	}
+
+	conf := GetConf(config, cache)
+
+	if strings.Count(conf, "    location /a {") != 2 || strings.Count(conf, "    location /b {") != 2 {
+		t.Fatalf("The locations with the lowest sorting paths should be kept for each host:\n%s", conf)
+	} else if strings.Contains(conf, "location /c") {
+		t.Fatalf("The locations beyond the limit should be dropped:\n%s", conf)
+	}
+
+	// Dropping a location that uses an upstream drops its upstream
+	config.MaxLocationsPerHost = 1
+
+	conf = GetConf(config, cache)
+
+	if strings.Contains(conf, "location /b") || strings.Contains(conf, "upstream upstream") {
+		t.Fatalf("The dropped location's upstream should also be dropped:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with hosts that have ports
+*/
+func TestGetConfHostsWithPorts(t *testing.T) {
+	resetConf()
+
+	pod1 := getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com test.github.com:8080",
+	})
+	pod2 := getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com:8080",
+	})
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			pod1.Name: router.ConvertPodToModel(config, pod1),
+			pod2.Name: router.ConvertPodToModel(config, pod2),
+		},
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	conf := GetConf(config, cache)
+
+	if strings.Count(conf, "server_name test.github.com;") != 2 {
+		t.Fatalf("Each host:port should have its own server block: %s", conf)
+	} else if !strings.Contains(conf, "    listen 8080;\n    server_name test.github.com;") {
+		t.Fatalf("Host with a port should listen on its port: %s", conf)
+	} else if !strings.Contains(conf, "    listen 80;\n    server_name test.github.com;") {
+		t.Fatalf("Host without a port should listen on the default port: %s", conf)
+	} else if strings.Contains(conf, "server_name test.github.com:8080;") {
+		t.Fatalf("The port should not be part of the server_name: %s", conf)
+	} else if strings.Count(conf, "server 10.244.1.17:80;") != 1 {
+		t.Fatalf("Pods sharing a host:port should share an upstream: %s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the stripAuthorization annotation
+*/
+func TestGetConfWithStripAuthorization(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Set every proxied header (setting a header replaces every inherited header)
+      proxy_set_header Connection $p_connection;
+      proxy_set_header Host $http_host;
+      proxy_set_header Upgrade $http_upgrade;
+      proxy_set_header Authorization "";
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.StripAuthorizationAnnotation: "true",
+	})
+
+	validateConf(t, "pod with stripAuthorization", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// The location still has to set the headers set at the http level, the Host header in particular
+	config.ForwardPort = true
+
+	defer func() {
+		config.ForwardPort = router.DefaultForwardPort
+	}()
+
+	conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+	})
+
+	if !strings.Contains(conf, "      proxy_set_header Host $http_host;\n") {
+		t.Fatalf("The Host header should be set along with the stripped Authorization header:\n%s", conf)
+	} else if !strings.Contains(conf, "      proxy_set_header X-Forwarded-Port $server_port;\n") {
+		t.Fatalf("The X-Forwarded-Port header should be set along with the stripped Authorization header:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a secret used for API Key and basic auth
+*/
+func TestGetConfWithMultipleAuthModes(t *testing.T) {
+	apiKey := []byte("supersecret")
+	credentials := []byte("user:password")
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Check the Routing API Key (namespace: testing)
+      if ($http_x_routing_api_key != "` + base64.StdEncoding.EncodeToString(apiKey) + `") {
+        return 403;
+      }
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+
+    location /admin {
+      # Check the Basic Authorization credentials (namespace: testing)
+      if ($http_authorization != "Basic ` + base64.StdEncoding.EncodeToString(credentials) + `") {
+        add_header WWW-Authenticate 'Basic realm="testing"' always;
+        return 401;
+      }
+
+      # Pod testing-admin (namespace: testing)
+      proxy_pass http://10.244.1.17;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	adminPod := getRoutablePod(map[string]string{
+		router.AuthModeAnnotation: router.AuthModeBasic,
+		"routingPaths":            "80:/admin",
+	})
+
+	adminPod.Name = "testing-admin"
+	adminPod.Status.PodIP = "10.244.1.17"
+
+	secret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      config.APIKeySecret,
+			Namespace: "testing",
+		},
+		Data: map[string][]byte{
+			"api-key":    apiKey,
+			"basic-auth": credentials,
+		},
+	}
+
+	validateConf(t, "pods with multiple auth modes", expectedConf, []*api.Pod{getRoutablePod(nil), adminPod}, []*api.Secret{secret})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a not found backend
+*/
+func TestGetConfWithNotFoundBackend(t *testing.T) {
+	config.NotFoundBackend = "testing/not-found"
+
+	defer func() {
+		config.NotFoundBackend = ""
+	}()
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name errors.github.com;
+
+    location / {
+      # Pod not-found-abcde (namespace: testing)
+      proxy_pass http://10.244.1.17:3000;
+    }
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  # Upstream for the not found backend
+  upstream not_found_backend {
+    # Pod not-found-abcde (namespace: testing)
+    server 10.244.1.17:3000;
+  }
+
+  # Default server that will proxy requests for unknown hosts and paths to the not found backend
+  server {
+    listen 80 default_server;
+
+    location / {
+      proxy_pass http://not_found_backend;
+    }
+  }
+}
+`
+
+	notFoundPod := getRoutablePod(map[string]string{
+		"routingHosts": "errors.github.com",
+		"routingPaths": "3000:/",
+	})
+
+	notFoundPod.Name = "not-found-abcde"
+	notFoundPod.Status.PodIP = "10.244.1.17"
+
+	validateConf(t, "not found backend", expectedConf, []*api.Pod{getRoutablePod(nil), notFoundPod}, []*api.Secret{})
+}
+
+/*
+Test for LimitReqStatus and LimitConnStatus config variables in Nginx Template
+*/
+func TestLimitStatuses(t *testing.T) {
+	config.LimitReqStatus = 429
+	config.LimitConnStatus = 503
+
+	defer func() {
+		config.LimitConnStatus = router.DefaultLimitConnStatus
+	}()
+
+	doc := getConfPreamble(config)
+
+	if !strings.Contains(doc, "limit_req_status 429;") {
+		t.Fatal("Failed to include limit_req_status from config.")
+	} else if !strings.Contains(doc, "limit_conn_status 503;") {
+		t.Fatal("Failed to include limit_conn_status from config.")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a single pod serving the same path on different ports
+*/
+func TestGetConfSamePathDifferentPorts(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {
+    # Pod testing (namespace: testing)
+    server 10.244.1.16;
+    # Pod testing (namespace: testing)
+    server 10.244.1.16:443;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Upstream upstream619897598
+      proxy_pass http://upstream619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		"routingPaths": "80:/ 443:/",
+	})
+
+	pod.Spec.Containers[0].Ports = append(pod.Spec.Containers[0].Ports, api.ContainerPort{
+		ContainerPort: int32(443),
+	})
+
+	validateConf(t, "single pod, same path on different ports", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for ProxySocketKeepalive, TCPNodelay and TCPNopush config variables in Nginx Template
+*/
+func TestTCPTuning(t *testing.T) {
+	config.ProxySocketKeepalive = true
+	config.TCPNodelay = true
+	config.TCPNopush = false
+
+	defer func() {
+		config.ProxySocketKeepalive = router.DefaultProxySocketKeepalive
+	}()
+
+	doc := getConfPreamble(config)
+
+	if !strings.Contains(doc, "tcp_nodelay on;") {
+		t.Fatal("Failed to include tcp_nodelay from config.")
+	} else if !strings.Contains(doc, "tcp_nopush off;") {
+		t.Fatal("Failed to include tcp_nopush from config.")
+	} else if !strings.Contains(doc, "proxy_socket_keepalive on;") {
+		t.Fatal("Failed to include proxy_socket_keepalive from config.")
+	}
+}
+
+/*
+Test for AbsoluteRedirect and PortInRedirect config variables in Nginx Template
+*/
+func TestRedirects(t *testing.T) {
+	defer func() {
+		config.AbsoluteRedirect = router.DefaultAbsoluteRedirect
+		config.PortInRedirect = router.DefaultPortInRedirect
+	}()
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "  absolute_redirect on;\n  port_in_redirect on;\n") {
+		t.Fatalf("Failed to include the default redirect directives from config:\n%s", doc)
+	}
+
+	config.AbsoluteRedirect = false
+	config.PortInRedirect = false
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "  absolute_redirect off;\n  port_in_redirect off;\n") {
+		t.Fatalf("Failed to include the disabled redirect directives from config:\n%s", doc)
+	}
+}
+
+/*
+Test for ForwardPort and ForwardedPort config variables in Nginx Template
+*/
+func TestForwardPort(t *testing.T) {
+	defer func() {
+		config.ForwardPort = router.DefaultForwardPort
+		config.ForwardedPort = router.DefaultForwardedPort
+	}()
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "X-Forwarded-Port") {
+		t.Fatalf("X-Forwarded-Port should not be rendered when the port is not forwarded:\n%s", doc)
+	}
+
+	config.ForwardPort = true
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "  proxy_set_header X-Forwarded-Port $server_port;\n") {
+		t.Fatalf("Failed to include the dynamic X-Forwarded-Port header from config:\n%s", doc)
+	}
+
+	config.ForwardedPort = 8443
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "  proxy_set_header X-Forwarded-Port 8443;\n") {
+		t.Fatalf("Failed to include the fixed X-Forwarded-Port header from config:\n%s", doc)
+	}
+}
+
+/*
+Test for EnableRateLimit, RateLimitRate and RateLimitBurst config variables in Nginx Template
+*/
+func TestRateLimit(t *testing.T) {
+	defer func() {
+		config.EnableRateLimit = router.DefaultEnableRateLimit
+		config.RateLimitBurst = router.DefaultRateLimitBurst
+		config.RateLimitRate = router.DefaultRateLimitRate
+	}()
+
+	pod := getRoutablePod(map[string]string{})
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	if conf := GetConf(config, cache); strings.Contains(conf, "limit_req ") || strings.Contains(conf, "limit_req_zone") {
+		t.Fatalf("The rate limit should not be rendered when the rate limit is disabled:\n%s", conf)
+	}
+
+	config.EnableRateLimit = true
+	config.RateLimitBurst = 5
+	config.RateLimitRate = "600r/m"
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Limit the request rate of each client address, rejecting requests beyond the burst
+      limit_req zone=perhost burst=5 nodelay;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "  limit_req_zone $binary_remote_addr zone=perhost:10m rate=600r/m;\n") {
+		t.Fatalf("Failed to include the rate limit zone from config:\n%s", doc)
+	}
+
+	validateConf(t, "rate limit enabled", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for EnableTrafficStatus and TrafficStatusPort config variables in Nginx Template
+*/
+func TestTrafficStatus(t *testing.T) {
+	defer func() {
+		config.EnableTrafficStatus = router.DefaultEnableTrafficStatus
+		config.TrafficStatusPort = router.DefaultTrafficStatusPort
+	}()
+
+	pod := getRoutablePod(map[string]string{})
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	if conf := GetConf(config, cache); strings.Contains(conf, "vhost_traffic_status") {
+		t.Fatalf("The traffic status should not be rendered when the traffic status is disabled:\n%s", conf)
+	}
+
+	config.EnableTrafficStatus = true
+	config.TrafficStatusPort = 9913
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "  vhost_traffic_status_zone;\n  vhost_traffic_status_filter_by_host on;\n") {
+		t.Fatalf("Failed to include the traffic status zone from config:\n%s", doc)
+	}
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  # Traffic status (nginx-module-vts) with the request and byte counters of each host and upstream
+  server {
+    listen 9913;
+
+    location /status {
+      vhost_traffic_status_display;
+      vhost_traffic_status_display_format html;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	validateConf(t, "traffic status enabled", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for EnableGzip and GzipTypes config variables in Nginx Template
+*/
+func TestGzip(t *testing.T) {
+	defer func() {
+		config.EnableGzip = router.DefaultEnableGzip
+		config.GzipTypes = router.DefaultGzipTypes
+	}()
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "gzip") {
+		t.Fatalf("gzip should not be rendered when gzip is disabled:\n%s", doc)
+	}
+
+	config.EnableGzip = true
+
+	expected := "  gzip on;\n  gzip_types " + router.DefaultGzipTypes + ";\n  gzip_min_length 1024;\n"
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, expected) {
+		t.Fatalf("Failed to include the gzip directives from config:\n%s", doc)
+	}
+
+	config.GzipTypes = "application/json text/xml"
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "gzip_types application/json text/xml;") {
+		t.Fatalf("Failed to include gzip_types from config:\n%s", doc)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the error_log written to stderr
+*/
+func TestGetConfErrorLogToStderr(t *testing.T) {
+	defer func() {
+		config.ErrorLogLevel = router.DefaultErrorLogLevel
+		config.ErrorLogToStderr = router.DefaultErrorLogToStderr
+		resetConf()
+	}()
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing": router.ConvertPodToModel(config, getRoutablePod(nil)),
+		},
+	}
+
+	// Disabled
+	if conf := GetConf(config, cache); strings.Contains(conf, "error_log") {
+		t.Fatalf("The error_log should not be rendered when not writing to stderr:\n%s", conf)
+	}
+
+	config.ErrorLogToStderr = true
+
+	for _, level := range []string{"info", "warn", "error"} {
+		config.ErrorLogLevel = level
+
+		expected := "error_log /dev/stderr " + level + ";\n"
+
+		if conf := GetConf(config, cache); !strings.HasPrefix(conf, "\n"+expected+"events {") {
+			t.Fatalf("Failed to include the stderr error_log (%s) from config:\n%s", level, conf)
+		}
+
+		// The default nginx.conf is cached so it has to be reset to be rendered with the new level
+		resetConf()
+
+		if conf := GetConf(config, &router.Cache{}); !strings.Contains(conf, expected) {
+			t.Fatalf("Failed to include the stderr error_log (%s) in the default nginx.conf:\n%s", level, conf)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the access log format presets
+*/
+func TestAccessLogFormat(t *testing.T) {
+	defer func() {
+		config.AccessLogFormat = router.DefaultAccessLogFormat
+		config.AccessLogPath = router.DefaultAccessLogPath
+		config.EnableAccessLog = router.DefaultEnableAccessLog
+	}()
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "log_format") || strings.Contains(doc, "access_log") {
+		t.Fatalf("The access log should not be rendered when the access log is disabled:\n%s", doc)
+	}
+
+	config.EnableAccessLog = true
+	config.AccessLogFormat = router.AccessLogFormatCombined
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "log_format") || !strings.Contains(doc, "\n  access_log /var/log/nginx/access.log combined;\n") {
+		t.Fatalf("The combined preset should use the nginx predefined log_format:\n%s", doc)
+	}
+
+	config.AccessLogFormat = router.AccessLogFormatTiming
+	config.AccessLogPath = "/dev/stdout"
+
+	doc := getConfPreamble(config)
+
+	if !strings.Contains(doc, `
+  log_format timing '$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent '
+                    '"$http_referer" "$http_user_agent" request_time=$request_time '
+                    'upstream_addr=$upstream_addr upstream_response_time=$upstream_response_time';
+  access_log /dev/stdout timing;
+`) {
+		t.Fatalf("Failed to include the timing log_format from config:\n%s", doc)
+	}
+
+	config.AccessLogFormat = router.AccessLogFormatUpstream
+
+	if doc = getConfPreamble(config); !strings.Contains(doc, `
+  map $host $router_upstream {
+    default "-";
+  }
+`) || !strings.Contains(doc, `
+                      'router_upstream=$router_upstream';
+  access_log /dev/stdout upstream;
+`) {
+		t.Fatalf("Failed to include the upstream log_format from config:\n%s", doc)
+	}
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Record the upstream for the access log
+      set $router_upstream 10.244.1.16;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	validateConf(t, "upstream access log format", expectedConf, []*api.Pod{getRoutablePod(nil)}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with backend response headers hidden from clients
+*/
+func TestHideBackendHeaders(t *testing.T) {
+	defer func() {
+		config.HideBackendHeaders = []string{router.DefaultHideBackendHeaders}
+	}()
+
+	doc := getConfPreamble(config)
+
+	if !strings.Contains(doc, "\n  proxy_hide_header X-Powered-By;\n") {
+		t.Fatalf("Failed to include the default proxy_hide_header from config:\n%s", doc)
+	}
+
+	config.HideBackendHeaders = []string{"X-Powered-By", "X-AspNet-Version"}
+
+	doc = getConfPreamble(config)
+
+	if !strings.Contains(doc, "\n  proxy_hide_header X-Powered-By;\n  proxy_hide_header X-AspNet-Version;\n") {
+		t.Fatalf("Failed to include each proxy_hide_header from config:\n%s", doc)
+	}
+
+	config.HideBackendHeaders = nil
+
+	if doc = getConfPreamble(config); strings.Contains(doc, "proxy_hide_header") {
+		t.Fatalf("proxy_hide_header should not be rendered without headers to hide:\n%s", doc)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with additional nginx config files
+*/
+func TestIncludeFiles(t *testing.T) {
+	defer func() {
+		config.IncludeFiles = nil
+	}()
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "include") {
+		t.Fatalf("include should not be rendered without include files:\n%s", doc)
+	}
+
+	config.IncludeFiles = []string{"/etc/nginx/conf.d/*.conf", "/etc/nginx/geo.conf"}
+
+	conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing/testing": router.ConvertPodToModel(config, getRoutablePod(nil)),
+		},
+	})
+
+	if !strings.Contains(conf, "\n  include /etc/nginx/conf.d/*.conf;\n  include /etc/nginx/geo.conf;\n") {
+		t.Fatalf("Failed to include the include directives from config:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a resolver
+*/
+func TestResolver(t *testing.T) {
+	defer func() {
+		config.Resolver = nil
+		config.ResolverTimeout = ""
+	}()
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "resolver") {
+		t.Fatalf("resolver should not be rendered without a resolver:\n%s", doc)
+	}
+
+	config.ResolverTimeout = "5s"
+
+	if doc := getConfPreamble(config); strings.Contains(doc, "resolver") {
+		t.Fatalf("resolver_timeout should not be rendered without a resolver:\n%s", doc)
+	}
+
+	config.Resolver = []string{"10.96.0.10", "kube-dns.kube-system:5353"}
+
+	conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing/testing": router.ConvertPodToModel(config, getRoutablePod(nil)),
+		},
+	})
+
+	if !strings.Contains(conf, "\n  resolver 10.96.0.10 kube-dns.kube-system:5353;\n  resolver_timeout 5s;\n") {
+		t.Fatalf("Failed to include the resolver directives from config:\n%s", conf)
+	}
+
+	config.ResolverTimeout = ""
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "resolver 10.96.0.10 kube-dns.kube-system:5353;") ||
+		strings.Contains(doc, "resolver_timeout") {
+		t.Fatalf("resolver_timeout should not be rendered without a resolver timeout:\n%s", doc)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the PROXY_CONNECT_TIMEOUT configuration
+*/
+func TestProxyConnectTimeout(t *testing.T) {
+	defer func() {
+		config.ProxyConnectTimeout = router.DefaultProxyConnectTimeout
+	}()
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  proxy_connect_timeout 2s;\n") {
+		t.Fatalf("Failed to include the default proxy_connect_timeout:\n%s", doc)
+	}
+
+	config.ProxyConnectTimeout = "500ms"
+
+	if doc := getConfPreamble(config); !strings.Contains(doc, "\n  proxy_connect_timeout 500ms;\n") ||
+		strings.Contains(doc, "proxy_connect_timeout 2s;") {
+		t.Fatalf("Failed to include proxy_connect_timeout from config:\n%s", doc)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the mirrorTarget and mirrorPercentage annotations
+*/
+func TestGetConfWithMirrorTarget(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Mirror sampling for / traffic on test.github.com
+  split_clients "${request_id}" $mirror619897598 {
+    10% 1;
+    * "";
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Mirror traffic to 10.244.1.20:8080 for shadow testing
+      mirror /_mirror619897598;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+
+    # Shadow backend for / traffic (responses are discarded)
+    location = /_mirror619897598 {
+      internal;
+      if ($mirror619897598 = "") {
+        return 204;
+      }
+      proxy_pass http://10.244.1.20:8080$request_uri;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.MirrorPercentageAnnotation: "10",
+		router.MirrorTargetAnnotation:     "10.244.1.20:8080",
+	})
+
+	validateConf(t, "pod with mirrorTarget", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// Mirror all traffic
+	pod = getRoutablePod(map[string]string{
+		router.MirrorTargetAnnotation: "shadow.testing.svc.cluster.local:8080",
+	})
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			pod.Name: router.ConvertPodToModel(config, pod),
+		},
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	conf := GetConf(config, cache)
+
+	if strings.Contains(conf, "split_clients") {
+		t.Fatalf("Mirroring all traffic should not sample requests: %s", conf)
+	} else if !strings.Contains(conf, "proxy_pass http://shadow.testing.svc.cluster.local:8080$request_uri;") {
+		t.Fatalf("Mirror location should proxy to the mirror target: %s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with captured routing paths and the pathTemplate annotation
+*/
+func TestGetConfWithPathTemplate(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+` + defaultNginxLocationTmpl + `
+    location ~ ^/(?<version>[^/]+)/api$ {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16:3000/api?v=${version};
+    }
+
+    location ~ ^/users/(?<id>[^/]+) {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16:3000;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		"routingPaths":                "3000:/{version}/api 3000:/users/{id}",
+		router.PathTemplateAnnotation: "/{version}/api=/api?v={version}",
+	})
+
+	validateConf(t, "pod with pathTemplate", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// Templates that reference no capture would be a static URI in a regex location, which nginx rejects
+	pod = getRoutablePod(map[string]string{
+		"routingPaths":                "3000:/{version}/api",
+		router.PathTemplateAnnotation: "/{version}/api=/api",
+	})
+
+	untemplatedLocation := `
+    location ~ ^/(?<version>[^/]+)/api {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16:3000;`
+
+	if conf := GetConf(config, &router.Cache{
+		Pods:    map[string]*router.PodWithRoutes{router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod)},
+		Secrets: make(map[string]*api.Secret),
+	}); !strings.Contains(conf, untemplatedLocation) {
+		t.Fatalf("Templates without capture references should be ignored:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the unixSocket annotation
+*/
+func TestGetConfWithUnixSocket(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+` + defaultNginxLocationTmpl + `
+    location /users {
+      # Pod testing (namespace: testing)
+      proxy_pass http://unix:/var/run/app/app.sock;
+    }
+
+    location ~ ^/(?<version>[^/]+)/api$ {
+      # Pod testing (namespace: testing)
+      proxy_pass http://unix:/var/run/app/app.sock:/api?v=${version};
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		"routingPaths":                "3000:/{version}/api 3000:/users",
+		router.PathTemplateAnnotation: "/{version}/api=/api?v={version}",
+		router.UnixSocketAnnotation:   "/var/run/app/app.sock",
+	})
+
+	validateConf(t, "pod with unix socket", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// Invalid socket paths are ignored
+	pod = getRoutablePod(map[string]string{
+		router.UnixSocketAnnotation: "var/run/app/app.sock",
+	})
+
+	if conf := GetConf(config, &router.Cache{
+		Pods:    map[string]*router.PodWithRoutes{router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod)},
+		Secrets: make(map[string]*api.Secret),
+	}); !strings.Contains(conf, "proxy_pass http://10.244.1.16;") {
+		t.Fatalf("Pods with an invalid unix socket should be proxied to over TCP:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the proxyCacheLock annotation
+*/
+func TestGetConfWithProxyCacheLock(t *testing.T) {
+	config.ProxyCachePath = "/var/cache/nginx/router"
+
+	defer func() {
+		config.ProxyCachePath = router.DefaultProxyCachePath
+	}()
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Cache the pod's responses
+      proxy_cache router_cache;
+
+      # Only allow one request at a time to populate a cache element
+      proxy_cache_lock on;
+      proxy_cache_lock_timeout 10s;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.ProxyCacheAnnotation:            "on",
+		router.ProxyCacheLockAnnotation:        "on",
+		router.ProxyCacheLockTimeoutAnnotation: "10s",
+	})
+
+	validateConf(t, "pod with proxyCacheLock", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// The cache lock is dropped when the pod's responses are not cached
+	delete(pod.Annotations, router.ProxyCacheAnnotation)
+
+	if conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+	}); strings.Contains(conf, "proxy_cache_lock") {
+		t.Fatalf("The cache lock should not be rendered for pods whose responses are not cached:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the cacheBypass annotation
+*/
+func TestGetConfWithCacheBypass(t *testing.T) {
+	config.ProxyCachePath = "/var/cache/nginx/router"
+
+	defer func() {
+		config.ProxyCachePath = router.DefaultProxyCachePath
+	}()
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Cache the pod's responses
+      proxy_cache router_cache;
+
+      # Only allow one request at a time to populate a cache element
+      proxy_cache_lock on;
+      proxy_cache_lock_timeout 5s;
+
+      # Neither serve from nor store in the cache requests with any of these variables set
+      proxy_cache_bypass $cookie_session $http_authorization;
+      proxy_no_cache $cookie_session $http_authorization;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.CacheBypassAnnotation:    "$cookie_session ${invalid} $http_authorization",
+		router.ProxyCacheAnnotation:     "on",
+		router.ProxyCacheLockAnnotation: "on",
+	})
+
+	validateConf(t, "pod with cacheBypass", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// The cache bypass is dropped when the pod's responses are not cached
+	delete(pod.Annotations, router.ProxyCacheAnnotation)
+
+	if conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+	}); strings.Contains(conf, "proxy_cache_bypass") || strings.Contains(conf, "proxy_no_cache") {
+		t.Fatalf("The cache bypass should not be rendered for pods whose responses are not cached:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the gzip annotation
+*/
+func TestGetConfWithGzip(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Override the inherited gzip compression of responses
+      gzip off;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.GzipAnnotation: "off",
+	})
+
+	validateConf(t, "pod with gzip", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConfHash
+*/
+func TestGetConfHash(t *testing.T) {
+	getConfHash := func(annotations map[string]string) uint64 {
+		return GetConfHash(GetConf(config, &router.Cache{
+			Pods: map[string]*router.PodWithRoutes{
+				"testing/testing": router.ConvertPodToModel(config, getRoutablePod(annotations)),
+			},
+		}))
+	}
+
+	if getConfHash(nil) != getConfHash(nil) {
+		t.Fatal("Identical caches should produce the same config hash")
+	} else if getConfHash(nil) == getConfHash(map[string]string{router.GzipAnnotation: "off"}) {
+		t.Fatal("Different caches should produce different config hashes")
+	} else if GetConfHash(GetDefaultConf(config)) == getConfHash(nil) {
+		t.Fatal("The default config and the generated config should produce different config hashes")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#DiffConf
+*/
+func TestDiffConf(t *testing.T) {
+	defer func() {
+		config.EnableDynamicUpstreams = router.DefaultEnableDynamicUpstreams
+	}()
+
+	withGzip := getUpstreamCache("10.244.1.16")
+
+	withGzip.Pods["testing/testing-10.244.1.16"].Gzip = "off"
+
+	withWeight := getUpstreamCache("10.244.1.16", "10.244.1.17")
+
+	withWeight.Pods["testing/testing-10.244.1.17"].Routes[0].Outgoing.Weight = 2
+
+	tests := []struct {
+		desc     string
+		dynamic  bool
+		old      *router.Cache
+		new      *router.Cache
+		expected ReloadPlan
+	}{
+		{"unchanged", true, getUpstreamCache("10.244.1.16", "10.244.1.17"), getUpstreamCache("10.244.1.16", "10.244.1.17"), NoReload},
+		{"upstream server replaced", true, getUpstreamCache("10.244.1.16", "10.244.1.17"), getUpstreamCache("10.244.1.16", "10.244.1.18"), DynamicUpstream},
+		{"upstream server added", true, getUpstreamCache("10.244.1.16", "10.244.1.17"), getUpstreamCache("10.244.1.16", "10.244.1.17", "10.244.1.18"), DynamicUpstream},
+		{"upstream server added without dynamic upstreams", false, getUpstreamCache("10.244.1.16", "10.244.1.17"), getUpstreamCache("10.244.1.16", "10.244.1.17", "10.244.1.18"), FullReload},
+		{"upstream created", true, getUpstreamCache("10.244.1.16"), getUpstreamCache("10.244.1.16", "10.244.1.17"), FullReload},
+		{"upstream removed", true, getUpstreamCache("10.244.1.16", "10.244.1.17"), getUpstreamCache("10.244.1.16"), FullReload},
+		{"location changed", true, getUpstreamCache("10.244.1.16"), withGzip, FullReload},
+		{"upstream server weight changed", true, getUpstreamCache("10.244.1.16", "10.244.1.17"), withWeight, FullReload},
+	}
+
+	for _, test := range tests {
+		config.EnableDynamicUpstreams = test.dynamic
+
+		if plan := DiffConf(config, test.old, test.new); plan != test.expected {
+			t.Fatalf("Expected reload plan %d but found %d (%s)", test.expected, plan, test.desc)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with dynamic upstreams
+*/
+func TestGetConfWithDynamicUpstreams(t *testing.T) {
+	defer func() {
+		config.EnableDynamicUpstreams = router.DefaultEnableDynamicUpstreams
+	}()
+
+	config.EnableDynamicUpstreams = true
+
+	conf := GetConf(config, getUpstreamCache("10.244.1.16", "10.244.1.17"))
+
+	if !strings.Contains(conf, " {\n    # Shared memory zone so that the servers can be updated without a reload\n    zone upstream") {
+		t.Fatalf("Failed to include the upstream zone:\n%s", conf)
+	} else if !strings.Contains(conf, "    listen unix:"+NginxDynamicUpstreamsSocket+";\n\n    location /dynamic {\n      dynamic_upstream;\n    }\n") {
+		t.Fatalf("Failed to include the dynamic upstream API server:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the routingWeight annotation
+*/
+func TestGetConfWithRoutingWeight(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {
+    # Pod testing (namespace: testing)
+    server 10.244.1.16 weight=3;
+    # Pod testing2 (namespace: testing)
+    server 10.244.1.17;
+    # Pod testing3 (namespace: testing)
+    server 10.244.1.18 weight=1;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Upstream upstream619897598
+      proxy_pass http://upstream619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod1 := getRoutablePod(map[string]string{
+		router.RoutingWeightAnnotation: "3",
+	})
+	pod2 := getRoutablePod(map[string]string{
+		router.RoutingWeightAnnotation: "0",
+	})
+	pod3 := getRoutablePod(map[string]string{
+		router.RoutingWeightAnnotation: "1",
+	})
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+	pod3.Name = "testing3"
+	pod3.Status.PodIP = "10.244.1.18"
+
+	validateConf(t, "pods with routing weights", expectedConf, []*api.Pod{pod3, pod1, pod2}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the rewritePaths annotation
+*/
+func TestGetConfWithRewritePaths(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location /api/v1 {
+      # Replace the routing path prefix before proxying
+      rewrite ^/api/v1/?(.*)$ /$1 break;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+
+    location /legacy/ {
+      # Replace the routing path prefix before proxying
+      rewrite ^/legacy/?(.*)$ /v2/$1 break;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+
+    location /web {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		"routingPaths":                "80:/api/v1 80:/legacy/ 80:/web",
+		router.RewritePathsAnnotation: "/api/v1=/ /legacy/=/v2/ /web=web",
+	})
+
+	validateConf(t, "pod with rewrite paths", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the backendHost annotation
+*/
+func TestGetConfWithBackendHost(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Set every proxied header (setting a header replaces every inherited header)
+      proxy_set_header Connection $p_connection;
+      proxy_set_header Host api.internal;
+      proxy_set_header Upgrade $http_upgrade;
+
+      # Proxy to the backend's name-based virtual host (the server name is only used for https backends)
+      proxy_ssl_name api.internal;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.BackendHostAnnotation: "api.internal",
+	})
+
+	validateConf(t, "pod with backend host", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// Websocket locations upgrade the connection to the backend's virtual host
+	pod = getRoutablePod(map[string]string{
+		router.BackendHostAnnotation: "api.internal",
+		router.WebsocketAnnotation:   "true",
+	})
+
+	conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+	})
+
+	if !strings.Contains(conf, "      proxy_set_header Connection \"upgrade\";\n      proxy_set_header Host api.internal;\n      proxy_set_header Upgrade $http_upgrade;\n") {
+		t.Fatalf("The websocket headers should be set along with the backend host:\n%s", conf)
+	} else if strings.Count(conf, "proxy_set_header Host") != 2 {
+		t.Fatalf("The Host header should only be set at the http level and once in the location:\n%s", conf)
+	}
+
+	expectedConf = `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod = getRoutablePod(map[string]string{
+		router.BackendHostAnnotation: "api.internal;",
+	})
+
+	validateConf(t, "pod with invalid backend host", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the cors and corsMethods annotations
+*/
+func TestGetConfWithCORS(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Allow cross-origin requests (preflight requests are answered before any authorization)
+      if ($request_method = OPTIONS) {
+        add_header Access-Control-Allow-Origin "*";
+        add_header Access-Control-Allow-Methods "GET, POST, PUT, PATCH, DELETE, OPTIONS";
+        add_header Access-Control-Allow-Headers $http_access_control_request_headers;
+        add_header Access-Control-Max-Age 86400;
+        return 204;
+      }
+      add_header Access-Control-Allow-Origin "*";
+      add_header Access-Control-Allow-Methods "GET, POST, PUT, PATCH, DELETE, OPTIONS";
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.CORSAnnotation: "*",
+	})
+
+	validateConf(t, "pod with a wildcard CORS origin", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// The preflight is answered before the API Key check
+	expectedConf = `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Allow cross-origin requests (preflight requests are answered before any authorization)
+      if ($request_method = OPTIONS) {
+        add_header Access-Control-Allow-Origin "https://app.example.com";
+        add_header Access-Control-Allow-Methods "GET, POST";
+        add_header Access-Control-Allow-Headers $http_access_control_request_headers;
+        add_header Access-Control-Max-Age 86400;
+        return 204;
+      }
+      add_header Access-Control-Allow-Origin "https://app.example.com";
+      add_header Access-Control-Allow-Methods "GET, POST";
+
+      # Check the Routing API Key (namespace: testing)
+      if ($http_x_routing_api_key != "c2VjcmV0") {
+        return 403;
+      }
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod = getRoutablePod(map[string]string{
+		router.CORSAnnotation:        "https://app.example.com",
+		router.CORSMethodsAnnotation: "GET POST",
+	})
+
+	validateConf(t, "pod with a CORS origin and methods", expectedConf, []*api.Pod{pod}, []*api.Secret{
+		&api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:      config.APIKeySecret,
+				Namespace: "testing",
+			},
+			Data: map[string][]byte{
+				config.APIKeySecretDataField: []byte("secret"),
+			},
+		},
+	})
+
+	// Pods with an invalid origin are unchanged
+	pod = getRoutablePod(map[string]string{
+		router.CORSAnnotation: "app.example.com",
+	})
+
+	if conf := GetConf(config, &router.Cache{
+		Pods:    map[string]*router.PodWithRoutes{router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod)},
+		Secrets: make(map[string]*api.Secret),
+	}); strings.Contains(conf, "Access-Control-Allow") {
+		t.Fatalf("Pods with an invalid CORS origin should not add CORS headers:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a canary pod splitting traffic with a stable pod
+*/
+func TestGetConfWithCanaryPercent(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {
+    # Pod testing (namespace: testing)
+    server 10.244.1.16;
+  }
+
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598_canary {
+    # Pod testing-canary (namespace: testing)
+    server 10.244.1.17;
+  }
+
+  # Canary split for / traffic on test.github.com
+  split_clients "${remote_addr}" $variant619897598 {
+    10% upstream619897598_canary;
+    * upstream619897598;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Canary split (10% to upstream619897598_canary, the rest to upstream619897598)
+      proxy_pass http://$variant619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	canaryPod := getRoutablePod(map[string]string{
+		router.CanaryPercentAnnotation: "10",
+	})
+
+	canaryPod.Name = "testing-canary"
+	canaryPod.Status.PodIP = "10.244.1.17"
+
+	validateConf(t, "stable and canary pods", expectedConf, []*api.Pod{canaryPod, getRoutablePod(nil)}, []*api.Secret{})
+
+	// A canary without stable pods receives all of the traffic
+	expectedConf = `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing-canary (namespace: testing)
+      proxy_pass http://10.244.1.17;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	validateConf(t, "canary pod only", expectedConf, []*api.Pod{canaryPod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf adding the 'always' flag to add_header directives
+*/
+func TestGetConfAlwaysAddHeaders(t *testing.T) {
+	defer func() {
+		config.AlwaysAddHeaders = router.DefaultAlwaysAddHeaders
+	}()
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing": router.ConvertPodToModel(config, getRoutablePod(map[string]string{
+				router.AuthModeAnnotation: router.AuthModeBasic,
+			})),
+		},
+		Secrets: map[string]*api.Secret{
+			"testing": &api.Secret{
+				Data: map[string][]byte{
+					"basic-auth": []byte("user:password"),
+				},
+			},
+		},
+	}
+
+	config.AlwaysAddHeaders = true
+
+	if !strings.Contains(GetConf(config, cache), `add_header WWW-Authenticate 'Basic realm="testing"' always;`) {
+		t.Fatal("Generated add_header directives should use the 'always' flag")
+	}
+
+	config.AlwaysAddHeaders = false
+
+	if !strings.Contains(GetConf(config, cache), `add_header WWW-Authenticate 'Basic realm="testing"';`) {
+		t.Fatal("Generated add_header directives should not use the 'always' flag")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the methodRewrites annotation handling HEAD via GET
+*/
+func TestGetConfWithMethodRewrites(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Request method rewrites for / traffic on test.github.com
+  map $request_method $method619897598 {
+    default $request_method;
+    HEAD GET;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Rewrite the request method before proxying
+      proxy_method $method619897598;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.MethodRewritesAnnotation: "HEAD:GET",
+	})
+
+	validateConf(t, "pod with methodRewrites", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#getWorkerConnections
+*/
+func TestGetWorkerConnections(t *testing.T) {
+	defer func() {
+		config.MaxConnections = router.DefaultMaxConnections
+		config.WorkerConnections = router.DefaultWorkerConnections
+		config.WorkerProcesses = ""
+		numCPU = runtime.NumCPU
+	}()
+
+	numCPU = func() int {
+		return 8
+	}
+
+	validateConnections := func(maxConnections int, workerProcesses string, expected int) {
+		config.MaxConnections = maxConnections
+		config.WorkerProcesses = workerProcesses
+
+		if actual := getWorkerConnections(config); actual != expected {
+			t.Fatalf("Expected %d worker connections for %d connections and worker processes (%s) but found %d", expected, maxConnections, workerProcesses, actual)
+		}
+	}
+
+	// Not derived
+	validateConnections(0, "4", router.DefaultWorkerConnections)
+
+	config.WorkerConnections = 2048
+
+	validateConnections(0, "4", 2048)
+
+	// Nginx default worker processes
+	validateConnections(4096, "", 4096)
+
+	// Fixed worker processes
+	validateConnections(4096, "4", 1024)
+	validateConnections(4097, "4", 1025)
+
+	// Auto worker processes
+	validateConnections(4096, "auto", 512)
+
+	// Rendered in the configuration
+	config.MaxConnections = 4096
+	config.WorkerProcesses = "auto"
+
+	conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing": router.ConvertPodToModel(config, getRoutablePod(nil)),
+		},
+	})
+
+	if !strings.HasPrefix(conf, "\nworker_processes auto;\nevents {\n  worker_connections 512;\n}") {
+		t.Fatalf("Failed to include the derived worker_connections from config:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the subFilter annotation
+*/
+func TestGetConfWithSubFilter(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Set every proxied header (setting a header replaces every inherited header)
+      proxy_set_header Connection $p_connection;
+      proxy_set_header Host $http_host;
+      proxy_set_header Upgrade $http_upgrade;
+      proxy_set_header Accept-Encoding "";
+
+      # Rewrite the response body (Accept-Encoding is cleared so the body is not compressed)
+      sub_filter 'http://legacy.internal/' 'https://test.github.com/';
+      sub_filter 'it\'s' 'it\\s';
+      sub_filter_once off;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.SubFilterAnnotation: `http://legacy.internal/ https://test.github.com/ it's it\s`,
+	})
+
+	validateConf(t, "pod with subFilter", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+
+	// The location still has to set the headers set at the http level, the Host header in particular
+	config.ForwardPort = true
+
+	defer func() {
+		config.ForwardPort = router.DefaultForwardPort
+	}()
+
+	conf := GetConf(config, &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			router.GetPodCacheKey(pod): router.ConvertPodToModel(config, pod),
+		},
+	})
+
+	if !strings.Contains(conf, "      proxy_set_header Host $http_host;\n") {
+		t.Fatalf("The Host header should be set along with the cleared Accept-Encoding header:\n%s", conf)
+	} else if !strings.Contains(conf, "      proxy_set_header X-Forwarded-Port $server_port;\n") {
+		t.Fatalf("The X-Forwarded-Port header should be set along with the cleared Accept-Encoding header:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with pods of the same name in different namespaces
+*/
+func TestGetConfSamePodNameDifferentNamespaces(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {
+    # Pod testing (namespace: other)
+    server 10.244.1.17;
+    # Pod testing (namespace: testing)
+    server 10.244.1.16;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Upstream upstream619897598
+      proxy_pass http://upstream619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	otherPod := getRoutablePod(nil)
+
+	otherPod.Namespace = "other"
+	otherPod.Status.PodIP = "10.244.1.17"
+
+	validateConf(t, "same pod name, different namespaces", expectedConf, []*api.Pod{getRoutablePod(nil), otherPod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the loadBalanceMethod annotation set to ip_hash
+*/
+func TestGetConfWithIPHash(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {
+    # Pin clients to a pod (IPv4 clients by their first three octets, IPv6 clients by their full address)
+    ip_hash;
+    # Pod testing (namespace: testing)
+    server 10.244.1.16;
+    # Pod testing2 (namespace: testing)
+    server 10.244.1.17;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Upstream upstream619897598
+      proxy_pass http://upstream619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod1 := getRoutablePod(map[string]string{
+		router.LoadBalanceMethodAnnotation: router.LoadBalanceMethodIPHash,
+	})
+	pod2 := getRoutablePod(map[string]string{
+		router.LoadBalanceMethodAnnotation: router.LoadBalanceMethodIPHash,
+	})
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	validateConf(t, "pods with ip_hash", expectedConf, []*api.Pod{pod1, pod2}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the LB_METHOD configuration
+*/
+func TestGetConfWithLoadBalanceMethod(t *testing.T) {
+	defer func() {
+		config.LoadBalanceMethod = router.DefaultLoadBalanceMethod
+	}()
+
+	methods := map[string]string{
+		router.LoadBalanceMethodIPHash: `
+    # Pin clients to a pod (IPv4 clients by their first three octets, IPv6 clients by their full address)
+    ip_hash;`,
+		router.LoadBalanceMethodLeastConn: `
+    # Send requests to the pod with the fewest active connections
+    least_conn;`,
+		router.LoadBalanceMethodRoundRobin: "",
+	}
+
+	for method, directives := range methods {
+		config.LoadBalanceMethod = method
+
+		expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {` + directives + `
+    # Pod testing (namespace: testing)
+    server 10.244.1.16;
+    # Pod testing2 (namespace: testing)
+    server 10.244.1.17;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Upstream upstream619897598
+      proxy_pass http://upstream619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+		pod1 := getRoutablePod(map[string]string{})
+		pod2 := getRoutablePod(map[string]string{})
+
+		pod2.Name = "testing2"
+		pod2.Status.PodIP = "10.244.1.17"
+
+		validateConf(t, "pods with the "+method+" load balance method", expectedConf, []*api.Pod{pod1, pod2},
+			[]*api.Secret{})
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with the authRequest and authRequestSigninUrl annotations
+*/
+func TestGetConfWithAuthRequest(t *testing.T) {
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Authorize the request via the external auth service
+      auth_request /_auth619897598;
+      error_page 401 = @signin619897598;
+
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+
+    # External auth service for / traffic (the request body is not forwarded)
+    location = /_auth619897598 {
+      internal;
+      proxy_pass_request_body off;
+      proxy_set_header Content-Length "";
+      proxy_set_header X-Original-URI $request_uri;
+      proxy_pass http://auth.auth-system.svc.cluster.local/verify;
+    }
+
+    # Sign in redirect for unauthorized / traffic
+    location @signin619897598 {
+      return 302 https://login.github.com/signin;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pod := getRoutablePod(map[string]string{
+		router.AuthRequestAnnotation:          "http://auth.auth-system.svc.cluster.local/verify",
+		router.AuthRequestSigninURLAnnotation: "https://login.github.com/signin",
+	})
+
+	validateConf(t, "pod with authRequest", expectedConf, []*api.Pod{pod}, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with upstream health checks derived from a liveness probe
+*/
+func TestGetConfWithHealthCheck(t *testing.T) {
+	defer func() {
+		config.EnableNginxUpstreamCheckModule = router.DefaultEnableNginxUpstreamCheckModule
+	}()
+
+	config.EnableNginxUpstreamCheckModule = true
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  # Upstream for / traffic on test.github.com
+  upstream upstream619897598 {
+    # Health check derived from the liveness probe
+    check interval=10000 rise=1 fall=3 timeout=1000 port=80 type=http;
+    check_http_send "GET /healthz HTTP/1.0\r\n\r\n";
+    check_http_expect_alive http_2xx http_3xx;
+    # Pod testing (namespace: testing)
+    server 10.244.1.16;
+    # Pod testing2 (namespace: testing)
+    server 10.244.1.17;
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Upstream upstream619897598
+      proxy_pass http://upstream619897598;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	pods := []*api.Pod{getRoutablePod(nil), getRoutablePod(nil)}
+
+	pods[1].Name = "testing2"
+	pods[1].Status.PodIP = "10.244.1.17"
+
+	for _, pod := range pods {
+		pod.Spec.Containers[0].LivenessProbe = &api.Probe{
+			Handler: api.Handler{
+				HTTPGet: &api.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.FromInt(80),
+				},
+			},
+		}
+	}
+
+	validateConf(t, "pods with liveness probes", expectedConf, pods, []*api.Secret{})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a health check port that differs from the traffic port
+*/
+func TestGetConfWithHealthCheckPort(t *testing.T) {
+	defer func() {
+		config.EnableNginxUpstreamCheckModule = router.DefaultEnableNginxUpstreamCheckModule
+	}()
+
+	config.EnableNginxUpstreamCheckModule = true
+
+	pods := []*api.Pod{
+		getRoutablePod(map[string]string{
+			router.HealthCheckPortAnnotation: "3000",
+		}),
+		getRoutablePod(map[string]string{
+			router.HealthCheckPortAnnotation: "3000",
+		}),
+	}
+
+	pods[1].Name = "testing2"
+	pods[1].Status.PodIP = "10.244.1.17"
+
+	cache := &router.Cache{
+		Pods:    make(map[string]*router.PodWithRoutes),
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	for _, pod := range pods {
+		pod.Spec.Containers[0].LivenessProbe = &api.Probe{
+			Handler: api.Handler{
+				HTTPGet: &api.HTTPGetAction{
+					Path: "/healthz",
+					Port: intstr.FromInt(80),
+				},
+			},
+		}
+
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
+	}
+
+	conf := GetConf(config, cache)
+
+	if !strings.Contains(conf, "    check interval=10000 rise=1 fall=3 timeout=1000 port=3000 type=http;\n") {
+		t.Fatalf("Health check should connect to the healthCheckPort port:\n%s", conf)
+	} else if !strings.Contains(conf, "    server 10.244.1.16;\n") || !strings.Contains(conf, "    server 10.244.1.17;\n") {
+		t.Fatalf("Traffic should still be proxied to the routingPaths port:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with TLS passthrough
+*/
+func TestGetConfWithTLSPassthrough(t *testing.T) {
+	defer func() {
+		config.EnableTLSPassthrough = router.DefaultEnableTLSPassthrough
+	}()
+
+	pod1 := getRoutablePod(map[string]string{
+		"routingHosts":                      "a.example.com b.example.com:8080",
+		router.TLSPassthroughPortAnnotation: "3000",
+	})
+	pod2 := getRoutablePod(map[string]string{
+		"routingHosts":                      "a.example.com",
+		router.TLSPassthroughPortAnnotation: "3000",
+	})
+	pod3 := getRoutablePod(map[string]string{
+		"routingHosts": "c.example.com",
+	})
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+	pod3.Name = "testing3"
+	pod3.Status.PodIP = "10.244.1.18"
+
+	cache := &router.Cache{
+		Pods:    make(map[string]*router.PodWithRoutes),
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	for _, pod := range []*api.Pod{pod1, pod2, pod3} {
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
+	}
+
+	// Disabled
+	if conf := GetConf(config, cache); strings.Contains(conf, "stream {") {
+		t.Fatalf("The stream block should not be rendered when TLS passthrough is disabled:\n%s", conf)
+	}
+
+	config.EnableTLSPassthrough = true
+
+	conf := GetConf(config, cache)
+
+	if !strings.HasSuffix(conf, `
+stream {
+  # Route TLS connections to the pods by their SNI server name without terminating TLS
+  map $ssl_preread_server_name $tls_passthrough_backend {
+    # Match wildcard hosts like server names
+    hostnames;
+    a.example.com tls_passthrough14126923;
+    b.example.com tls_passthrough824456610;
+  }
+
+  # Upstream for TLS passthrough traffic on a.example.com
+  upstream tls_passthrough14126923 {
+    # Pod testing (namespace: testing)
+    server 10.244.1.16:3000;
+    # Pod testing2 (namespace: testing)
+    server 10.244.1.17:3000;
+  }
+
+  # Upstream for TLS passthrough traffic on b.example.com
+  upstream tls_passthrough824456610 {
+    # Pod testing (namespace: testing)
+    server 10.244.1.16:3000;
+  }
+
+  server {
+    listen 443;
+    ssl_preread on;
+    proxy_pass $tls_passthrough_backend;
+  }
+}
+`) {
+		t.Fatalf("Failed to render the TLS passthrough stream block:\n%s", conf)
+	} else if !strings.Contains(conf, "server_name c.example.com;") {
+		t.Fatalf("HTTP routing should not be affected by TLS passthrough:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with a fallback backend
+*/
+func TestGetConfWithFallbackBackend(t *testing.T) {
+	defer func() {
+		config.FallbackBackend = ""
+	}()
+
+	config.FallbackBackend = "maintenance.example.com:8080"
+
+	getCache := func(annotations map[string]string) *router.Cache {
+		pod2 := getRoutablePod(annotations)
+
+		pod2.Name = "testing2"
+		pod2.Status.PodIP = "10.244.1.17"
+
+		return &router.Cache{
+			Pods: map[string]*router.PodWithRoutes{
+				"testing/testing":  router.ConvertPodToModel(config, getRoutablePod(annotations)),
+				"testing/testing2": router.ConvertPodToModel(config, pod2),
+			},
+		}
+	}
+
+	conf := GetConf(config, getCache(nil))
+
+	if !strings.Contains(conf, `
+    # Pod testing2 (namespace: testing)
+    server 10.244.1.17;
+    # Fallback backend used when all of the pods are down
+    server maintenance.example.com:8080 backup;
+  }
+`) {
+		t.Fatalf("Failed to include the fallback backend as a backup server:\n%s", conf)
+	}
+
+	// Upstreams using ip_hash do not support backup servers
+	conf = GetConf(config, getCache(map[string]string{
+		router.LoadBalanceMethodAnnotation: router.LoadBalanceMethodIPHash,
+	}))
+
+	if strings.Contains(conf, "backup;") {
+		t.Fatalf("The fallback backend should not be added to ip_hash upstreams:\n%s", conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with upstream keepalive
+*/
+func TestGetConfWithUpstreamKeepalive(t *testing.T) {
+	defer func() {
+		config.UpstreamKeepalive = router.DefaultUpstreamKeepalive
+		config.UpstreamKeepaliveRequests = router.DefaultUpstreamKeepaliveRequests
+		config.UpstreamKeepaliveTime = ""
+	}()
+
+	pod2 := getRoutablePod(nil)
+
+	pod2.Name = "testing2"
+	pod2.Status.PodIP = "10.244.1.17"
+
+	cache := &router.Cache{
+		Pods: map[string]*router.PodWithRoutes{
+			"testing/testing":  router.ConvertPodToModel(config, getRoutablePod(nil)),
+			"testing/testing2": router.ConvertPodToModel(config, pod2),
+		},
+	}
+
+	// Pods whose locations set headers themselves
+	for i, annotations := range []map[string]string{
+		{router.BackendHostAnnotation: "api.internal"},
+		{router.StripAuthorizationAnnotation: "true"},
+		{router.SubFilterAnnotation: "http://legacy.internal/ https://test.github.com/"},
+	} {
+		annotations["routingPaths"] = fmt.Sprintf("80:/headers%d", i)
+
+		pod := getRoutablePod(annotations)
+
+		pod.Name = fmt.Sprintf("headers%d", i)
+		pod.Status.PodIP = fmt.Sprintf("10.244.2.%d", i)
+
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
+	}
+
+	keepaliveDirectives := `
+    # Reuse connections to the pods
+    keepalive 32;
+    keepalive_requests 10000;
+    keepalive_time 1h;
+`
+
+	// Keepalive disabled
+	config.UpstreamKeepaliveRequests = 10000
+	config.UpstreamKeepaliveTime = "1h"
+
+	if conf := GetConf(config, cache); strings.Contains(conf, "# Reuse connections to the pods") {
+		t.Fatalf("Keepalive directives should not be generated without upstream keepalive:\n%s", conf)
+	}
+
+	// Keepalive enabled
+	config.UpstreamKeepalive = 32
+
+	conf := GetConf(config, cache)
+
+	if !strings.Contains(conf, keepaliveDirectives) {
+		t.Fatalf("Failed to include the keepalive directives from config:\n%s", conf)
+	} else if !strings.Contains(conf, "    default   \"\";\n    ~*upgrade $http_connection;\n") {
+		t.Fatalf("Upstream requests should only keep an upgrade Connection header:\n%s", conf)
+	} else if strings.Contains(conf, "default $http_connection;") {
+		t.Fatalf("Client Connection headers like close should not be passed to the pods:\n%s", conf)
+	} else if !strings.Contains(conf, "  proxy_set_header Connection $p_connection;\n") {
+		t.Fatalf("The cleared Connection header should be set at the http level:\n%s", conf)
+	}
+
+	// Every location either inherits the cleared Connection header or sets it itself
+	locations := strings.Split(conf, "    location ")[1:]
+
+	if len(locations) != 4 {
+		t.Fatalf("Expected 4 locations but found %d:\n%s", len(locations), conf)
+	}
+
+	for _, location := range locations {
+		location = location[:strings.Index(location, "\n    }\n")]
+
+		if strings.Contains(location, "proxy_set_header") && !strings.Contains(location, "      proxy_set_header Connection $p_connection;\n") {
+			t.Fatalf("Locations setting headers should set the cleared Connection header:\n%s", location)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with TLS termination
+*/
+func TestGetConfWithTLSSecret(t *testing.T) {
+	config.TLSSecret = "routing-tls"
+
+	defer func() {
+		config.TLSSecret = ""
+	}()
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    server_name other.github.com;
+
+    location / {
+      # Pod other (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  server {
+    listen 80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  server {
+    listen 443 ssl;
+    server_name test.github.com;
+
+    # Terminate TLS using the certificate of the TLS secret (namespace: testing)
+    ssl_certificate /etc/nginx/certs/testing/tls.crt;
+    ssl_certificate_key /etc/nginx/certs/testing/tls.key;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+` + getDefaultServerConf(config) + `}
+`
+
+	// The certificate does not match other.github.com so it is only served over plain HTTP
+	otherPod := getRoutablePod(map[string]string{
+		"routingHosts": "other.github.com",
+	})
+
+	otherPod.Name = "other"
+
+	validateConf(t, "pods with a TLS secret", expectedConf, []*api.Pod{getRoutablePod(nil), otherPod},
+		[]*api.Secret{getTLSSecret(t, "testing", "test.github.com")})
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with IPv6 listen directives
+*/
+func TestGetConfWithListenIPv6(t *testing.T) {
+	config.ListenIPv6 = true
+	config.TLSSecret = "routing-tls"
+
+	defer func() {
+		config.ListenIPv6 = router.DefaultListenIPv6
+		config.TLSSecret = ""
+	}()
+
+	expectedConf := `
+events {
+  worker_connections ` + strconv.Itoa(config.WorkerConnections) + `;
+}
+http {` + getConfPreamble(config) + `
+  server {
+    listen 80;
+    listen [::]:80;
+    server_name test.github.com;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  server {
+    listen 443 ssl;
+    listen [::]:443 ssl;
+    server_name test.github.com;
+
+    # Terminate TLS using the certificate of the TLS secret (namespace: testing)
+    ssl_certificate /etc/nginx/certs/testing/tls.crt;
+    ssl_certificate_key /etc/nginx/certs/testing/tls.key;
+
+    location / {
+      # Pod testing (namespace: testing)
+      proxy_pass http://10.244.1.16;
+    }
+  }
+
+  # Default server that will just close the connection as if there was no server available
+  server {
+    listen 80 default_server;
+    listen [::]:80 default_server;
+    return 444;
+  }
+}
+`
+
+	validateConf(t, "pods with IPv6 listen directives", expectedConf, []*api.Pod{getRoutablePod(nil)},
+		[]*api.Secret{getTLSSecret(t, "testing", "test.github.com")})
+
+	// The default server conf (no routable pods) also listens on IPv6
+	if defaultServerConf := getDefaultServerConf(config); !strings.Contains(defaultServerConf,
+		"    listen 80 default_server;\n    listen [::]:80 default_server;\n") {
+		t.Fatalf("Expected the default server to listen on IPv6 but found:\n%s", defaultServerConf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#sortServers for each upstream server ordering strategy
+*/
+func TestSortServers(t *testing.T) {
+	created := time.Date(2016, time.June, 1, 0, 0, 0, 0, time.UTC)
+	makeServer := func(name, target string, age time.Duration) *serverT {
+		return &serverT{
+			Pod: &router.PodWithRoutes{
+				Created:   created.Add(-age),
+				Name:      name,
+				Namespace: "testing",
+			},
+			Target: target,
+		}
+	}
+	getOrder := func(order string) string {
+		servers := serversT{
+			makeServer("c", "10.244.1.9", 2*time.Hour),
+			makeServer("a", "10.244.1.10:3000", time.Hour),
+			makeServer("d", "10.244.1.10", time.Hour),
+			makeServer("b", "10.244.0.20", 3*time.Hour),
+		}
+
+		sortServers(&router.Config{
+			UpstreamServerOrder: order,
+		}, servers)
+
+		var names []string
+
+		for _, server := range servers {
+			names = append(names, server.Pod.Name)
+		}
+
+		return strings.Join(names, " ")
+	}
+
+	for order, expected := range map[string]string{
+		router.UpstreamServerOrderInsertionStable: "b c a d",
+		router.UpstreamServerOrderIP:              "b c d a",
+		router.UpstreamServerOrderName:            "a b c d",
+	} {
+		if actual := getOrder(order); actual != expected {
+			t.Fatalf("Expected the %s order to be (%s) but found (%s)", order, expected, actual)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/config#GetConf with router.Config.ConsolidateUpstreams
+*/
+func TestConsolidateUpstreams(t *testing.T) {
+	defer func() {
+		config.ConsolidateUpstreams = router.DefaultConsolidateUpstreams
+	}()
+
+	cache := &router.Cache{
+		Pods:    make(map[string]*router.PodWithRoutes),
+		Secrets: make(map[string]*api.Secret),
+	}
+
+	// Two hosts routed to the same pods and a host routed to a different pod
+	for _, ip := range []string{"10.244.1.16", "10.244.1.17", "10.244.1.18"} {
+		hosts := "test.github.com other.github.com"
+
+		if ip == "10.244.1.18" {
+			hosts = "third.github.com"
+		}
+
+		pod := getRoutablePod(map[string]string{
+			"routingHosts": hosts,
+		})
+
+		pod.Name = "testing-" + ip
+		pod.Status.PodIP = ip
+
+		cache.Pods[router.GetPodCacheKey(pod)] = router.ConvertPodToModel(config, pod)
+	}
+
+	config.ConsolidateUpstreams = false
+
+	if conf := GetConf(config, cache); strings.Count(conf, "\n  upstream ") != 2 {
+		t.Fatalf("Expected an upstream per host when upstreams are not consolidated:\n%s", conf)
+	}
+
+	// Upstreams are consolidated by default
+	config.ConsolidateUpstreams = router.DefaultConsolidateUpstreams
+
+	conf := GetConf(config, cache)
+	sharedName := "upstream" + fmt.Sprint(hash("10.244.1.16 weight=0 10.244.1.17 weight=0"))
+
+	if strings.Count(conf, "\n  upstream ") != 1 {
+		t.Fatalf("Expected the hosts with identical servers to share one upstream:\n%s", conf)
+	} else if !strings.Contains(conf, "  # Upstream shared by the traffic on other.github.com/, test.github.com/\n  upstream "+sharedName+" {\n") {
+		t.Fatalf("Expected the shared upstream (%s) to be rendered:\n%s", sharedName, conf)
+	} else if strings.Count(conf, "proxy_pass http://"+sharedName+";") != 2 {
+		t.Fatalf("Expected both hosts to proxy to the shared upstream (%s):\n%s", sharedName, conf)
+	} else if !strings.Contains(conf, "proxy_pass http://10.244.1.18;") {
+		t.Fatalf("Expected the host with a different pod to keep its own server:\n%s", conf)
+	}
+
+	// The shared upstream still lists its pods
+	for _, ip := range []string{"10.244.1.16", "10.244.1.17"} {
+		if !strings.Contains(conf, "    # Pod testing-"+ip+" (namespace: testing)\n    server "+ip+";\n") {
+			t.Fatalf("Expected the shared upstream to list pod (testing-%s):\n%s", ip, conf)
+		}
+	}
+
+	// The shared upstream does not change between renders
+	for i := 0; i < 10; i++ {
+		if GetConf(config, cache) != conf {
+			t.Fatal("Expected the consolidated configuration to be deterministic")
+		}
 	}
 }
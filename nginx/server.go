@@ -17,73 +17,525 @@ limitations under the License.
 package nginx
 
 import (
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/30x/k8s-router/logging"
+	"github.com/30x/k8s-router/metrics"
+	"github.com/30x/k8s-router/router"
+
+	"k8s.io/kubernetes/pkg/api"
 )
 
 // If running locally enabled mock mode to not call sh commands or write config
 var RunInMockMode bool
 
-func shellOut(cmd string, exitOnFailure bool) {
+// commandRunner executes the shell command and returns its combined output (Replaceable for testing)
+var commandRunner = func(cmd string) ([]byte, error) {
+	return exec.Command("sh", "-c", cmd).CombinedOutput()
+}
+
+// dynamicUpstreamClient sends requests to the dynamic upstream API over its unix socket
+var dynamicUpstreamClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", NginxDynamicUpstreamsSocket)
+		},
+	},
+}
+
+// dynamicUpstreamRunner sends the query to the dynamic upstream API (Replaceable for testing)
+var dynamicUpstreamRunner = func(query url.Values) error {
+	resp, err := dynamicUpstreamClient.Get("http://nginx/dynamic?" + query.Encode())
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// nginxCertsDir is the directory the TLS certificates are written to (Replaceable for testing)
+var nginxCertsDir = NginxCertsDir
+
+// pendingConf is the latest configuration requested for a reload but not yet applied
+var pendingConf *string
+
+// pendingConfMutex guards pendingConf
+var pendingConfMutex sync.Mutex
+
+// reloadMutex serializes nginx reloads so that two reloads never overlap
+var reloadMutex sync.Mutex
+
+// reloadsSinceStart is the number of nginx reloads since nginx was last (re)started, guarded by reloadMutex
+var reloadsSinceStart int
+
+// serverExitTimeout is how long a full restart waits for the old nginx master to exit gracefully (Replaceable for testing)
+var serverExitTimeout = 10 * time.Second
+
+// serverStartTimeout is how long a full restart waits for the new nginx master to start (Replaceable for testing)
+var serverStartTimeout = 10 * time.Second
+
+/*
+shellOut executes the shell command and returns its combined output, along with an error describing the failure
+*/
+func shellOut(cmd string, exitOnFailure bool) (string, error) {
 	if RunInMockMode {
-		return
+		return "", nil
 	}
 
-	out, err := exec.Command("sh", "-c", cmd).CombinedOutput()
+	out, err := commandRunner(cmd)
 
 	if err != nil {
 		msg := fmt.Sprintf("Failed to execute (%v): %v, err: %v", cmd, string(out), err)
 
 		if exitOnFailure {
-			log.Fatal(msg)
+			logging.Fatalf("%s\n", msg)
 		} else {
-			log.Println(msg)
+			logging.Errorf("%s\n", msg)
 		}
+
+		return string(out), errors.New(msg)
 	}
+
+	return string(out), nil
 }
 
-func writeNginxConf(conf string) {
-	log.Println(conf)
+/*
+nginxCommand returns the nginx command, using router.Config.NginxBinary, with the provided arguments and pointed at
+router.Config.NginxConfPath when it is not the default nginx configuration path
+*/
+func nginxCommand(config *router.Config, args ...string) string {
+	cmd := append([]string{config.NginxBinary}, args...)
+
+	if config.NginxConfPath != router.DefaultNginxConfPath {
+		cmd = append(cmd, "-c", config.NginxConfPath)
+	}
+
+	return strings.Join(cmd, " ")
+}
+
+func writeNginxConf(config *router.Config, conf string) {
+	logging.Infof("%s\n", conf)
 
 	if RunInMockMode {
 		return;
 	}
 
 	// Create the nginx.conf file based on the template
-	if w, err := os.Create(NginxConfPath); err != nil {
-		log.Fatalf("Failed to open %s: %v", NginxConfPath, err)
+	if w, err := os.Create(config.NginxConfPath); err != nil {
+		logging.Fatalf("Failed to open %s: %v", config.NginxConfPath, err)
 	} else if _, err := io.WriteString(w, conf); err != nil {
-		log.Fatalf("Failed to write template %v", err)
+		logging.Fatalf("Failed to write template %v", err)
 	}
 
-	log.Printf("Wrote nginx configuration to %s\n", NginxConfPath)
+	logging.Infof("Wrote nginx configuration to %s\n", config.NginxConfPath)
 }
 
 /*
-RestartServer restarts nginx using the provided configuration.
+writeTestedNginxConf tests the configuration with nginx -t and only replaces the nginx configuration with it when the
+test passes, otherwise the previous configuration is kept and the reason the test failed is returned
 */
-func RestartServer(conf string, exitOnFailure bool) {
-	log.Println("Reloading nginx with the following configuration:")
+func writeTestedNginxConf(config *router.Config, conf string) error {
+	logging.Infof("%s\n", conf)
+
+	if RunInMockMode {
+		return nil
+	}
 
-	writeNginxConf(conf)
+	// Test a temporary copy next to the nginx configuration so that it can be swapped in with a rename
+	tmpFile, err := ioutil.TempFile(filepath.Dir(config.NginxConfPath), filepath.Base(config.NginxConfPath)+".")
+
+	if err != nil {
+		return fmt.Errorf("Failed to create the temporary nginx configuration: %v", err)
+	}
+
+	tmpPath := tmpFile.Name()
+
+	if _, err = io.WriteString(tmpFile, conf); err == nil {
+		err = tmpFile.Chmod(0644)
+	}
+
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+
+		return fmt.Errorf("Failed to write the temporary nginx configuration %s: %v", tmpPath, err)
+	}
 
-	log.Println("Restarting nginx")
+	if _, err := shellOut(config.NginxBinary+" -t -c "+tmpPath, false); err != nil {
+		os.Remove(tmpPath)
 
-	shellOut("nginx -s reload", exitOnFailure)
+		return fmt.Errorf("Invalid nginx configuration, keeping the previous configuration: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, config.NginxConfPath); err != nil {
+		os.Remove(tmpPath)
+
+		return fmt.Errorf("Failed to replace %s: %v", config.NginxConfPath, err)
+	}
+
+	logging.Infof("Wrote nginx configuration to %s\n", config.NginxConfPath)
+
+	return nil
+}
+
+// serverSignals maps the nginx -s signal names to the signals sent to the nginx master process
+var serverSignals = map[string]string{
+	"quit":   "QUIT",
+	"reload": "HUP",
+}
+
+/*
+reportServerFailure logs the failure to control nginx, exiting when exitOnFailure is set, and returns it as an error
+*/
+func reportServerFailure(msg string, exitOnFailure bool) error {
+	if exitOnFailure {
+		logging.Fatalf("%s\n", msg)
+	} else {
+		logging.Errorf("%s\n", msg)
+	}
+
+	return errors.New(msg)
+}
+
+/*
+readServerPid returns the PID of the nginx master process stored in the PID file
+*/
+func readServerPid(pidPath string) (int, error) {
+	pidStr, err := ioutil.ReadFile(pidPath)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(pidStr)))
+}
+
+func signalServer(config *router.Config, signal string, exitOnFailure bool) error {
+	if !config.ReloadViaSignal {
+		_, err := shellOut(nginxCommand(config, "-s", signal), exitOnFailure)
+
+		return err
+	}
+
+	if RunInMockMode {
+		return nil
+	}
+
+	// Signal the nginx master process directly using its PID file
+	pid, err := readServerPid(config.PidPath)
+
+	if err != nil {
+		return reportServerFailure(fmt.Sprintf("Failed to read the nginx master PID from %s: %v", config.PidPath, err), exitOnFailure)
+	}
+
+	_, err = shellOut(fmt.Sprintf("kill -%s %d", serverSignals[signal], pid), exitOnFailure)
+
+	return err
+}
+
+/*
+fullRestartServer replaces the nginx master process using the nginx binary upgrade so that the ports stay bound and
+in-flight requests complete: the old master starts a new master (USR2), which inherits the listening sockets, and is
+gracefully shut down (QUIT) once the new master has written its PID file.  When the new master does not start, the old
+master keeps serving.  Worker processes of the old master that do not exit within serverExitTimeout, because of stuck
+connections, are stopped (TERM) closing those connections.
+*/
+func fullRestartServer(config *router.Config, exitOnFailure bool) error {
+	if RunInMockMode {
+		return nil
+	}
+
+	oldPid, err := readServerPid(config.PidPath)
+
+	if err != nil {
+		return reportServerFailure(fmt.Sprintf("Failed to read the nginx master PID from %s: %v", config.PidPath, err), exitOnFailure)
+	}
+
+	if _, err := shellOut(fmt.Sprintf("kill -USR2 %d", oldPid), exitOnFailure); err != nil {
+		return err
+	}
+
+	// The old master renames its PID file (nginx.pid.oldbin) and the new master writes its own once it is running
+	deadline := time.Now().Add(serverStartTimeout)
+
+	for {
+		if pid, err := readServerPid(config.PidPath); err == nil && pid != oldPid {
+			break
+		} else if time.Now().After(deadline) {
+			return reportServerFailure(fmt.Sprintf("The new nginx master did not start within %s, the old master (%d) keeps serving",
+				serverStartTimeout, oldPid), exitOnFailure)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if _, err := shellOut(fmt.Sprintf("kill -QUIT %d", oldPid), exitOnFailure); err != nil {
+		return err
+	}
+
+	// The old master removes its renamed PID file once its worker processes have exited
+	deadline = time.Now().Add(serverExitTimeout)
+
+	for {
+		if _, err := os.Stat(config.PidPath + ".oldbin"); os.IsNotExist(err) {
+			return nil
+		} else if time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	logging.Warnf("The old nginx master (%d) did not exit within %s, stopping it\n", oldPid, serverExitTimeout)
+
+	_, err = shellOut(fmt.Sprintf("kill -TERM %d", oldPid), exitOnFailure)
+
+	return err
+}
+
+/*
+RestartServer restarts nginx using the provided configuration.  Reloads are serialized and concurrent requests are
+coalesced so that callers waiting on an in-flight reload result in a single reload using the latest configuration.  The
+returned error is the reason nginx failed to reload (nil when another caller already applied the configuration).
+*/
+func RestartServer(config *router.Config, conf string, exitOnFailure bool) error {
+	// Record the latest configuration to apply
+	pendingConfMutex.Lock()
+	pendingConf = &conf
+	pendingConfMutex.Unlock()
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	// Take the latest configuration, if any, so that it is only applied once
+	pendingConfMutex.Lock()
+	latest := pendingConf
+	pendingConf = nil
+	pendingConfMutex.Unlock()
+
+	// Another caller already reloaded nginx with the latest configuration
+	if latest == nil {
+		return nil
+	}
+
+	logging.Infof("Reloading nginx with the following configuration:\n")
+
+	// Only reload nginx when the configuration passes nginx -t so that nginx keeps serving the previous configuration
+	err := writeTestedNginxConf(config, *latest)
+
+	if err != nil {
+		logging.Errorf("%v\n", err)
+
+		return err
+	}
+
+	// Fully restart nginx once in a while so that the worker processes of previous reloads do not pile up
+	if config.MaxReloadsBeforeRestart > 0 && reloadsSinceStart >= config.MaxReloadsBeforeRestart {
+		logging.Infof("Fully restarting nginx after %d reloads\n", reloadsSinceStart)
+
+		if err = fullRestartServer(config, exitOnFailure); err == nil {
+			reloadsSinceStart = 0
+		}
+	} else {
+		logging.Infof("Restarting nginx\n")
+
+		if err = signalServer(config, "reload", exitOnFailure); err == nil {
+			reloadsSinceStart++
+		}
+	}
+
+	// Only report the configuration once nginx is running it
+	if err == nil {
+		router.SetConfigHash(GetConfHash(*latest))
+		metrics.RecordNginxReload()
+	}
+
+	return err
+}
+
+func updateUpstreamServer(upstream, server, action string) error {
+	logging.Infof("  %s %s (upstream: %s)", strings.Title(action), server, upstream)
+
+	if RunInMockMode {
+		return nil
+	}
+
+	serverParts := strings.Fields(server)
+	server = serverParts[0]
+
+	// nginx names the servers without a port by their address with the default port
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server += ":80"
+	}
+
+	query := url.Values{}
+
+	query.Set("upstream", upstream)
+	query.Set("server", server)
+	query.Set(action, "")
+
+	// Added servers keep their parameters
+	if action == "add" {
+		for _, param := range serverParts[1:] {
+			if strings.HasPrefix(param, "weight=") {
+				query.Set("weight", strings.TrimPrefix(param, "weight="))
+			}
+		}
+	}
+
+	if err := dynamicUpstreamRunner(query); err != nil {
+		return fmt.Errorf("Failed to %s %s (upstream: %s): %v", action, server, upstream, err)
+	}
+
+	return nil
+}
+
+/*
+UpdateUpstreams applies a DynamicUpstream change (See DiffConf) by adding and removing the upstream servers through the
+dynamic upstream API instead of reloading nginx.  The new configuration is still written so that it is used by later
+reloads.  When an error is returned, nginx should be reloaded to apply the change.
+*/
+func UpdateUpstreams(config *router.Config, old, new *router.Cache) error {
+	_, oldServers := splitUpstreamServers(GetConf(config, old))
+	conf := GetConf(config, new)
+	_, newServers := splitUpstreamServers(conf)
+
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	logging.Infof("Updating the nginx upstream servers\n")
+
+	for upstream, servers := range newServers {
+		previous := make(map[string]bool)
+		current := make(map[string]bool)
+
+		for _, server := range oldServers[upstream] {
+			previous[server] = true
+		}
+
+		// Add the new servers before removing the old ones so that the upstream is never left without servers
+		for _, server := range servers {
+			current[server] = true
+
+			if !previous[server] {
+				if err := updateUpstreamServer(upstream, server, "add"); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, server := range oldServers[upstream] {
+			if !current[server] {
+				if err := updateUpstreamServer(upstream, server, "remove"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	writeNginxConf(config, conf)
+
+	router.SetConfigHash(GetConfHash(conf))
+
+	return nil
+}
+
+/*
+QuitServer gracefully shuts down nginx, letting the worker processes finish serving the in-flight requests.
+*/
+func QuitServer(config *router.Config) {
+	logging.Infof("Quitting nginx\n")
+
+	signalServer(config, "quit", false)
+}
+
+/*
+WriteTLSCerts writes the certificate and private key of each cached TLS secret to its namespace directory within
+NginxCertsDir, removing the directories of namespaces that no longer have a TLS secret.
+*/
+func WriteTLSCerts(cache *router.Cache) {
+	if RunInMockMode {
+		return
+	}
+
+	// Remove the certificates of the deleted TLS secrets
+	if dirs, err := ioutil.ReadDir(nginxCertsDir); err == nil {
+		for _, dir := range dirs {
+			if _, ok := cache.TLSCerts[dir.Name()]; !ok {
+				os.RemoveAll(filepath.Join(nginxCertsDir, dir.Name()))
+			}
+		}
+	}
+
+	for namespace, secret := range cache.TLSCerts {
+		certsDir := filepath.Join(nginxCertsDir, namespace)
+
+		if err := os.MkdirAll(certsDir, 0700); err != nil {
+			logging.Fatalf("Failed to create %s: %v", certsDir, err)
+		}
+
+		for _, field := range []string{api.TLSCertKey, api.TLSPrivateKeyKey} {
+			certPath := filepath.Join(certsDir, field)
+
+			if err := ioutil.WriteFile(certPath, secret.Data[field], 0600); err != nil {
+				logging.Fatalf("Failed to write %s: %v", certPath, err)
+			}
+		}
+	}
 }
 
 /*
 StartServer starts nginx using the provided configuration.
 */
-func StartServer(conf string) {
-	log.Println("Starting nginx with the following configuration:")
+func StartServer(config *router.Config, conf string) {
+	logging.Infof("Starting nginx with the following configuration:\n")
+
+	writeNginxConf(config, conf)
+
+	logging.Infof("Starting nginx\n")
+
+	cmd := nginxCommand(config)
+
+	// The nginx binary upgrade of full restarts re-executes the binary nginx was started with without searching the PATH
+	if config.MaxReloadsBeforeRestart > 0 {
+		if binary, err := exec.LookPath(config.NginxBinary); err == nil {
+			if binary, err = filepath.Abs(binary); err == nil {
+				cmd = binary + strings.TrimPrefix(cmd, config.NginxBinary)
+			}
+		}
+	}
 
-	writeNginxConf(conf)
+	shellOut(cmd, true)
 
-	log.Println("Starting nginx")
+	reloadMutex.Lock()
+	reloadsSinceStart = 0
+	reloadMutex.Unlock()
 
-	shellOut("nginx", true)
+	router.SetConfigHash(GetConfHash(conf))
 }
@@ -0,0 +1,675 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nginx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/30x/k8s-router/router"
+)
+
+/*
+passConfTest wraps the command runner so that the configuration tests (nginx -t) pass without being recorded
+*/
+func passConfTest(runner func(cmd string) ([]byte, error)) func(cmd string) ([]byte, error) {
+	return func(cmd string) ([]byte, error) {
+		if strings.HasPrefix(cmd, "nginx -t ") {
+			return nil, nil
+		}
+
+		return runner(cmd)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#RestartServer with concurrent reload requests
+*/
+func TestRestartServerSerializesReloads(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+
+	defer func() {
+		commandRunner = origRunner
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+	}()
+
+	var mutex sync.Mutex
+	inFlight := 0
+	maxInFlight := 0
+	reloads := 0
+
+	RunInMockMode = false
+	config.NginxConfPath = filepath.Join(tmpDir, "nginx.conf")
+	commandRunner = passConfTest(func(cmd string) ([]byte, error) {
+		mutex.Lock()
+		inFlight++
+		reloads++
+
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+
+		mutex.Unlock()
+
+		// Give the other reload requests time to queue up
+		time.Sleep(50 * time.Millisecond)
+
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+
+		return nil, nil
+	})
+
+	requests := 10
+	var wg sync.WaitGroup
+
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			RestartServer(config, fmt.Sprintf("conf-%d", i), false)
+		}(i)
+	}
+
+	wg.Wait()
+
+	if maxInFlight != 1 {
+		t.Fatalf("Expected reloads to be serialized but found %d concurrent reloads", maxInFlight)
+	} else if reloads == 0 || reloads >= requests {
+		t.Fatalf("Expected concurrent reload requests to be coalesced but found %d reloads for %d requests", reloads, requests)
+	} else if pendingConf != nil {
+		t.Fatal("There should be no pending configuration after all reloads complete")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#RestartServer reloading via the nginx master PID file
+*/
+func TestRestartServerViaSignal(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+	origPidPath := config.PidPath
+
+	defer func() {
+		commandRunner = origRunner
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+		config.PidPath = origPidPath
+		config.ReloadViaSignal = false
+	}()
+
+	var cmds []string
+
+	RunInMockMode = false
+	config.NginxConfPath = filepath.Join(tmpDir, "nginx.conf")
+	confArg := " -c " + config.NginxConfPath
+	commandRunner = passConfTest(func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+
+		return nil, nil
+	})
+
+	config.PidPath = filepath.Join(tmpDir, "nginx.pid")
+	config.ReloadViaSignal = true
+
+	if err := ioutil.WriteFile(config.PidPath, []byte("1234\n"), 0644); err != nil {
+		t.Fatalf("Unable to write PID file: %v", err)
+	}
+
+	if err := RestartServer(config, "conf-signal", false); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	} else if len(cmds) != 1 || cmds[0] != "kill -HUP 1234" {
+		t.Fatalf("Expected nginx to be reloaded by signaling its PID but found: %v", cmds)
+	}
+
+	// A missing PID file should not run any command
+	cmds = nil
+	config.PidPath = filepath.Join(tmpDir, "missing.pid")
+
+	if err := RestartServer(config, "conf-missing-pid", false); err == nil {
+		t.Fatal("Expected a reload error for a missing PID file")
+	} else if len(cmds) != 0 {
+		t.Fatalf("Expected no reload command for a missing PID file but found: %v", cmds)
+	}
+
+	// Reloading without the signal should use nginx itself
+	config.ReloadViaSignal = false
+
+	RestartServer(config, "conf-nginx", false)
+
+	if len(cmds) != 1 || cmds[0] != "nginx -s reload"+confArg {
+		t.Fatalf("Expected nginx to be reloaded using nginx -s reload but found: %v", cmds)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#RestartServer fully restarting nginx after MaxReloadsBeforeRestart reloads
+*/
+func TestRestartServerMaxReloadsBeforeRestart(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+	origPidPath := config.PidPath
+	origExitTimeout := serverExitTimeout
+	origStartTimeout := serverStartTimeout
+
+	defer func() {
+		commandRunner = origRunner
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+		config.MaxReloadsBeforeRestart = router.DefaultMaxReloadsBeforeRestart
+		config.PidPath = origPidPath
+		reloadsSinceStart = 0
+		serverExitTimeout = origExitTimeout
+		serverStartTimeout = origStartTimeout
+	}()
+
+	var cmds []string
+
+	RunInMockMode = false
+	config.NginxConfPath = filepath.Join(tmpDir, "nginx.conf")
+	config.PidPath = filepath.Join(tmpDir, "nginx.pid")
+	confArg := " -c " + config.NginxConfPath
+	oldBinPath := config.PidPath + ".oldbin"
+	serverExitTimeout = 200 * time.Millisecond
+	serverStartTimeout = 200 * time.Millisecond
+
+	// upgradeServer simulates the nginx binary upgrade of master 1234, the new master (5678) only starts when started is
+	// set and the old master only exits gracefully when exits is set
+	upgradeServer := func(started, exits bool) func(cmd string) ([]byte, error) {
+		return passConfTest(func(cmd string) ([]byte, error) {
+			cmds = append(cmds, cmd)
+
+			switch cmd {
+			case "kill -USR2 1234":
+				os.Rename(config.PidPath, oldBinPath)
+
+				if started {
+					ioutil.WriteFile(config.PidPath, []byte("5678\n"), 0644)
+				} else {
+					os.Rename(oldBinPath, config.PidPath)
+				}
+			case "kill -QUIT 1234":
+				if exits {
+					os.Remove(oldBinPath)
+				}
+			case "kill -TERM 1234":
+				os.Remove(oldBinPath)
+			}
+
+			return nil, nil
+		})
+	}
+
+	writePid := func() {
+		if err := ioutil.WriteFile(config.PidPath, []byte("1234\n"), 0644); err != nil {
+			t.Fatalf("Unable to write PID file: %v", err)
+		}
+	}
+
+	commandRunner = upgradeServer(true, true)
+	config.MaxReloadsBeforeRestart = 2
+	reloadsSinceStart = 0
+
+	writePid()
+
+	for i := 0; i < 4; i++ {
+		if err := RestartServer(config, fmt.Sprintf("conf-%d", i), false); err != nil {
+			t.Fatalf("Unexpected reload error: %v", err)
+		}
+	}
+
+	reload := "nginx -s reload" + confArg
+	expected := strings.Join([]string{reload, reload, "kill -USR2 1234", "kill -QUIT 1234", reload}, ",")
+
+	if actual := strings.Join(cmds, ","); actual != expected {
+		t.Fatalf("Expected commands (%s) but found: %s", expected, actual)
+	} else if reloadsSinceStart != 1 {
+		t.Fatalf("Expected the reload count to restart after the full restart but found %d", reloadsSinceStart)
+	} else if pid, err := readServerPid(config.PidPath); err != nil || pid != 5678 {
+		t.Fatalf("Expected the new nginx master to be running but found %d (%v)", pid, err)
+	}
+
+	// Old masters whose worker processes do not exit are stopped
+	cmds = nil
+	reloadsSinceStart = 2
+	commandRunner = upgradeServer(true, false)
+
+	writePid()
+
+	if err := RestartServer(config, "conf-lingering", false); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	} else if strings.Join(cmds, ",") != "kill -USR2 1234,kill -QUIT 1234,kill -TERM 1234" {
+		t.Fatalf("Expected the lingering old nginx master to be stopped but found: %v", cmds)
+	}
+
+	// The old master keeps serving when the new master does not start
+	cmds = nil
+	reloadsSinceStart = 2
+	commandRunner = upgradeServer(false, true)
+
+	writePid()
+
+	if err := RestartServer(config, "conf-not-started", false); err == nil {
+		t.Fatal("Expected a restart error")
+	} else if strings.Join(cmds, ",") != "kill -USR2 1234" || reloadsSinceStart != 2 {
+		t.Fatalf("Expected the old nginx master to be kept along with the reload count but found %d (%v)", reloadsSinceStart, cmds)
+	}
+
+	// A failed full restart should be retried by the next reload
+	cmds = nil
+	commandRunner = passConfTest(func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+
+		return []byte("failed"), errors.New("exit status 1")
+	})
+
+	if err := RestartServer(config, "conf-failed", false); err == nil {
+		t.Fatal("Expected a restart error")
+	} else if strings.Join(cmds, ",") != "kill -USR2 1234" || reloadsSinceStart != 2 {
+		t.Fatalf("Expected a failed full restart to keep the reload count but found %d (%v)", reloadsSinceStart, cmds)
+	}
+
+	// Disabled by default
+	cmds = nil
+	config.MaxReloadsBeforeRestart = 0
+	commandRunner = passConfTest(func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+
+		return nil, nil
+	})
+
+	RestartServer(config, "conf-disabled", false)
+
+	if strings.Join(cmds, ",") != reload {
+		t.Fatalf("Expected nginx to be reloaded when full restarts are disabled but found: %v", cmds)
+	}
+
+	// The binary upgrade needs nginx to be started using the path of its binary
+	cmds = nil
+	config.MaxReloadsBeforeRestart = 2
+	config.NginxBinary = "sh"
+
+	defer func() {
+		config.NginxBinary = router.DefaultNginxBinary
+	}()
+
+	StartServer(config, "conf-start")
+
+	if binary, err := exec.LookPath("sh"); err != nil {
+		t.Fatalf("Unable to find sh: %v", err)
+	} else if len(cmds) != 1 || cmds[0] != binary+confArg {
+		t.Fatalf("Expected nginx to be started using the path of its binary (%s) but found: %v", binary, cmds)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#RestartServer testing the configuration before reloading
+*/
+func TestRestartServerTestsConf(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+
+	defer func() {
+		commandRunner = origRunner
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+	}()
+
+	var cmds []string
+	var testedConf string
+
+	RunInMockMode = false
+	config.NginxConfPath = filepath.Join(tmpDir, "nginx.conf")
+	confArg := " -c " + config.NginxConfPath
+	commandRunner = func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+
+		if strings.HasPrefix(cmd, "nginx -t -c ") {
+			conf, err := ioutil.ReadFile(strings.TrimPrefix(cmd, "nginx -t -c "))
+
+			if err != nil {
+				return []byte(err.Error()), err
+			}
+
+			testedConf = string(conf)
+
+			if testedConf == "conf-invalid" {
+				return []byte("nginx: [emerg] unknown directive \"conf-invalid\""), errors.New("exit status 1")
+			}
+		}
+
+		return nil, nil
+	}
+
+	if err := RestartServer(config, "conf-valid", false); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	} else if len(cmds) != 2 || !strings.HasPrefix(cmds[0], "nginx -t -c "+filepath.Join(tmpDir, "nginx.conf.")) || cmds[1] != "nginx -s reload"+confArg {
+		t.Fatalf("Expected the configuration to be tested before reloading nginx but found: %v", cmds)
+	} else if testedConf != "conf-valid" {
+		t.Fatalf("Expected the new configuration to be tested but found: %s", testedConf)
+	} else if conf, _ := ioutil.ReadFile(config.NginxConfPath); string(conf) != "conf-valid" {
+		t.Fatalf("Expected the tested configuration to be written but found: %s", conf)
+	}
+
+	// An invalid configuration keeps the previous configuration and does not reload nginx
+	cmds = nil
+
+	if err := RestartServer(config, "conf-invalid", false); err == nil || !strings.Contains(err.Error(), "unknown directive") {
+		t.Fatalf("Expected the configuration test failure but found: %v", err)
+	} else if len(cmds) != 1 {
+		t.Fatalf("Expected nginx not to be reloaded but found: %v", cmds)
+	} else if conf, _ := ioutil.ReadFile(config.NginxConfPath); string(conf) != "conf-valid" {
+		t.Fatalf("Expected the previous configuration to be kept but found: %s", conf)
+	} else if files, _ := ioutil.ReadDir(tmpDir); len(files) != 1 {
+		t.Fatalf("Expected the tested configuration to be removed but found %d files", len(files))
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#RestartServer only reporting the configuration hash of successful reloads
+*/
+func TestRestartServerConfigHash(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+
+	defer func() {
+		commandRunner = origRunner
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+		reloadsSinceStart = 0
+		router.SetConfigHash(0)
+	}()
+
+	RunInMockMode = false
+	config.NginxConfPath = filepath.Join(tmpDir, "nginx.conf")
+	commandRunner = passConfTest(func(cmd string) ([]byte, error) {
+		return nil, nil
+	})
+
+	if err := RestartServer(config, "conf-reloaded", false); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	} else if router.GetConfigHash() != GetConfHash("conf-reloaded") {
+		t.Fatalf("Expected the configuration hash of the reloaded configuration but found %d", router.GetConfigHash())
+	}
+
+	// A failed reload leaves nginx running the previous configuration
+	commandRunner = passConfTest(func(cmd string) ([]byte, error) {
+		return []byte("failed"), errors.New("exit status 1")
+	})
+
+	if err := RestartServer(config, "conf-failed", false); err == nil {
+		t.Fatal("Expected a reload error")
+	} else if router.GetConfigHash() != GetConfHash("conf-reloaded") {
+		t.Fatalf("Expected the configuration hash not to change after a failed reload but found %d", router.GetConfigHash())
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#StartServer and RestartServer using the configured nginx binary
+*/
+func TestNginxBinary(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+
+	defer func() {
+		commandRunner = origRunner
+		config.NginxBinary = router.DefaultNginxBinary
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+		reloadsSinceStart = 0
+		router.SetConfigHash(0)
+	}()
+
+	var cmds []string
+
+	RunInMockMode = false
+	commandRunner = func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+
+		return nil, nil
+	}
+
+	// The default configuration path is not passed to nginx
+	config.NginxConfPath = router.DefaultNginxConfPath
+
+	if cmd := nginxCommand(config, "-s", "reload"); cmd != "nginx -s reload" {
+		t.Fatalf("Expected the default nginx command but found: %s", cmd)
+	}
+
+	binary := "/usr/local/openresty/nginx/sbin/nginx"
+	confPath := filepath.Join(tmpDir, "nginx.conf")
+
+	config.NginxBinary = binary
+	config.NginxConfPath = confPath
+
+	StartServer(config, "conf-start")
+
+	if err := RestartServer(config, "conf-reload", false); err != nil {
+		t.Fatalf("Unexpected reload error: %v", err)
+	}
+
+	if len(cmds) != 3 {
+		t.Fatalf("Expected nginx to be started, tested and reloaded but found: %v", cmds)
+	} else if cmds[0] != binary+" -c "+confPath {
+		t.Fatalf("Expected nginx to be started using the configured binary and configuration but found: %s", cmds[0])
+	} else if !strings.HasPrefix(cmds[1], binary+" -t -c "+confPath+".") {
+		t.Fatalf("Expected the configuration to be tested using the configured binary but found: %s", cmds[1])
+	} else if cmds[2] != binary+" -s reload -c "+confPath {
+		t.Fatalf("Expected nginx to be reloaded using the configured binary and configuration but found: %s", cmds[2])
+	} else if conf, _ := ioutil.ReadFile(confPath); string(conf) != "conf-reload" {
+		t.Fatalf("Expected the configuration to be written to %s but found: %s", confPath, conf)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#QuitServer
+*/
+func TestQuitServer(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := commandRunner
+	origMockMode := RunInMockMode
+	origPidPath := config.PidPath
+
+	defer func() {
+		commandRunner = origRunner
+		RunInMockMode = origMockMode
+		config.PidPath = origPidPath
+		config.ReloadViaSignal = false
+	}()
+
+	var cmds []string
+
+	RunInMockMode = false
+	commandRunner = func(cmd string) ([]byte, error) {
+		cmds = append(cmds, cmd)
+
+		return nil, nil
+	}
+
+	QuitServer(config)
+
+	if len(cmds) != 1 || cmds[0] != "nginx -s quit" {
+		t.Fatalf("Expected nginx to be stopped using nginx -s quit but found: %v", cmds)
+	}
+
+	// Quitting via the signal should signal the PID in the PID file
+	cmds = nil
+	config.PidPath = filepath.Join(tmpDir, "nginx.pid")
+	config.ReloadViaSignal = true
+
+	if err := ioutil.WriteFile(config.PidPath, []byte("1234\n"), 0644); err != nil {
+		t.Fatalf("Unable to write PID file: %v", err)
+	}
+
+	QuitServer(config)
+
+	if len(cmds) != 1 || cmds[0] != "kill -QUIT 1234" {
+		t.Fatalf("Expected nginx to be stopped by signaling its PID but found: %v", cmds)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/nginx/server#UpdateUpstreams
+*/
+func TestUpdateUpstreams(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "k8s-router")
+
+	if err != nil {
+		t.Fatalf("Unable to create temporary directory: %v", err)
+	}
+
+	defer os.RemoveAll(tmpDir)
+
+	origRunner := dynamicUpstreamRunner
+	origConfPath := config.NginxConfPath
+	origMockMode := RunInMockMode
+
+	defer func() {
+		config.EnableDynamicUpstreams = router.DefaultEnableDynamicUpstreams
+		dynamicUpstreamRunner = origRunner
+		config.NginxConfPath = origConfPath
+		RunInMockMode = origMockMode
+		router.SetConfigHash(0)
+	}()
+
+	var queries []string
+	var upstreams []string
+
+	config.EnableDynamicUpstreams = true
+	RunInMockMode = false
+	config.NginxConfPath = filepath.Join(tmpDir, "nginx.conf")
+	dynamicUpstreamRunner = func(query url.Values) error {
+		upstreams = append(upstreams, query.Get("upstream"))
+		query.Del("upstream")
+		queries = append(queries, query.Encode())
+
+		return nil
+	}
+
+	old := getUpstreamCache("10.244.1.16", "10.244.1.17")
+	new := getUpstreamCache("10.244.1.16", "10.244.1.18")
+
+	// Added servers keep their weight
+	new.Pods["testing/testing-10.244.1.18"].Routes[0].Outgoing.Weight = 2
+
+	if err := UpdateUpstreams(config, old, new); err != nil {
+		t.Fatalf("Failed to update the upstreams: %v", err)
+	}
+
+	sort.Strings(queries)
+
+	expected := []string{"add=&server=10.244.1.18%3A80&weight=2", "remove=&server=10.244.1.17%3A80"}
+
+	if fmt.Sprint(queries) != fmt.Sprint(expected) {
+		t.Fatalf("Expected the upstream updates %v but found %v", expected, queries)
+	} else if upstreams[0] == "" || upstreams[0] != upstreams[1] {
+		t.Fatalf("Expected the updates to target the same upstream but found %v", upstreams)
+	}
+
+	conf := GetConf(config, new)
+
+	if written, err := ioutil.ReadFile(config.NginxConfPath); err != nil || string(written) != conf {
+		t.Fatalf("Expected the new configuration to be written (err: %v)", err)
+	} else if router.GetConfigHash() != GetConfHash(conf) {
+		t.Fatal("Expected the config hash of the new configuration")
+	}
+
+	// A failed update should be returned so that nginx is reloaded instead
+	dynamicUpstreamRunner = func(query url.Values) error {
+		return errors.New("400 Bad Request")
+	}
+
+	if err := UpdateUpstreams(config, new, old); err == nil {
+		t.Fatal("Expected the failed upstream update to be returned")
+	}
+}
@@ -19,9 +19,12 @@ package router
 import (
 	"fmt"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/30x/k8s-router/logging"
 	"github.com/30x/k8s-router/utils"
 
 	"k8s.io/kubernetes/pkg/labels"
@@ -29,6 +32,22 @@ import (
 )
 
 const (
+	// AccessLogFormatCombined is the EnvVarAccessLogFormat value for the nginx predefined combined log_format
+	AccessLogFormatCombined = "combined"
+	// AccessLogFormatTiming is the EnvVarAccessLogFormat value for the combined log_format with upstream timing
+	AccessLogFormatTiming = "timing"
+	// AccessLogFormatUpstream is the EnvVarAccessLogFormat value for the timing log_format with the router upstream of each location
+	AccessLogFormatUpstream = "upstream"
+	// DefaultAbsoluteRedirect is the default value for EnvVarAbsoluteRedirect (true)
+	DefaultAbsoluteRedirect = true
+	// DefaultAccessLogFormat is the default value for EnvVarAccessLogFormat (upstream)
+	DefaultAccessLogFormat = AccessLogFormatUpstream
+	// DefaultAccessLogPath is the default value for EnvVarAccessLogPath (/var/log/nginx/access.log)
+	DefaultAccessLogPath = "/var/log/nginx/access.log"
+	// DefaultAlwaysAddHeaders is the default value for EnvVarAlwaysAddHeaders (true)
+	DefaultAlwaysAddHeaders = true
+	// DefaultAnnotationDelimiter is the default value for EnvVarAnnotationDelimiter (space)
+	DefaultAnnotationDelimiter = " "
 	// DefaultAPIKeyHeader is the default value for the header used to identify the API Key (X-ROUTING-API-KEY)
 	DefaultAPIKeyHeader = "X-ROUTING-API-KEY"
 	// DefaultAPIKeySecret is the default value for the first portion of the DefaultAPIKeySecretLocation (routing)
@@ -37,56 +56,594 @@ const (
 	DefaultAPIKeySecretDataField = "api-key"
 	// DefaultAPIKeySecretLocation is the default value for the EnvVarAPIKeySecretLocation (routing:api-key)
 	DefaultAPIKeySecretLocation = DefaultAPIKeySecret + ":" + DefaultAPIKeySecretDataField
+	// DefaultBasicAuthSecretDataField is the default value for EnvVarBasicAuthSecretDataField (basic-auth)
+	DefaultBasicAuthSecretDataField = "basic-auth"
+	// DefaultCharset is the default value for EnvVarCharset (none, no charset is added to the Content-Type)
+	DefaultCharset = ""
+	// DefaultClientHeaderBufferSize is the default value for EnvVarClientHeaderBufferSize (1k, the nginx default)
+	DefaultClientHeaderBufferSize = "1k"
 	// DefaultClientMaxBodySize for nginx max client request size. Default 100mb
 	DefaultClientMaxBodySize = "0"
+	// DefaultConsolidateUpstreams is the default value for EnvVarConsolidateUpstreams (true)
+	DefaultConsolidateUpstreams = true
+	// DefaultDefaultServerReturn is the default value for EnvVarDefaultServerReturn (444, the connection is closed)
+	DefaultDefaultServerReturn = 444
+	// DefaultDefaultType is the default value for EnvVarDefaultType (application/octet-stream, the nginx default)
+	DefaultDefaultType = "application/octet-stream"
+	// DefaultDryRun is the default value for EnvVarDryRun (false)
+	DefaultDryRun = false
+	// DefaultEmptyCacheRetryAfter is the default value for EnvVarEmptyCacheRetryAfter (0, no Retry-After header)
+	DefaultEmptyCacheRetryAfter = 0
+	// DefaultEmptyCacheStatus is the default value for EnvVarEmptyCacheStatus (0, the connection is closed)
+	DefaultEmptyCacheStatus = 0
+	// DefaultEmptySecretAction is the default value for EnvVarEmptySecretAction (deny)
+	DefaultEmptySecretAction = EmptySecretActionDeny
+	// DefaultEmptyPathToRoot is the default value for EnvVarEmptyPathToRoot (false)
+	DefaultEmptyPathToRoot = false
+	// DefaultEnableNginxUpstreamCheckModule is the default value for EnvVarEnableNginxUpstreamCheckModule (false)
+	DefaultEnableNginxUpstreamCheckModule = false
+	// DefaultErrorLogLevel is the default value for EnvVarErrorLogLevel (error)
+	DefaultErrorLogLevel = "error"
+	// DefaultErrorLogToStderr is the default value for EnvVarErrorLogToStderr (false)
+	DefaultErrorLogToStderr = false
+	// DefaultGzipTypes is the default value for EnvVarGzipTypes (application/json text/plain text/css application/javascript)
+	DefaultGzipTypes = "application/json text/plain text/css application/javascript"
+	// DefaultHealthCheckProbes is the default value for EnvVarHealthCheckProbes (readiness liveness)
+	DefaultHealthCheckProbes = HealthCheckProbeReadiness + " " + HealthCheckProbeLiveness
+	// DefaultEnableAccessLog is the default value for EnvVarEnableAccessLog (false)
+	DefaultEnableAccessLog = false
+	// DefaultEnableDebugEndpoints is the default value for EnvVarEnableDebugEndpoints (false)
+	DefaultEnableDebugEndpoints = false
+	// DefaultEnableDynamicUpstreams is the default value for EnvVarEnableDynamicUpstreams (false)
+	DefaultEnableDynamicUpstreams = false
+	// DefaultEnableGzip is the default value for EnvVarEnableGzip (false)
+	DefaultEnableGzip = false
+	// DefaultEnableRateLimit is the default value for EnvVarEnableRateLimit (false)
+	DefaultEnableRateLimit = false
+	// DefaultEnableTrafficStatus is the default value for EnvVarEnableTrafficStatus (false)
+	DefaultEnableTrafficStatus = false
+	// DefaultEnableTLSPassthrough is the default value for EnvVarEnableTLSPassthrough (false)
+	DefaultEnableTLSPassthrough = false
+	// DefaultForwardPort is the default value for EnvVarForwardPort (false)
+	DefaultForwardPort = false
+	// DefaultForwardedPort is the default value for EnvVarForwardedPort (0, the port nginx received the request on)
+	DefaultForwardedPort = 0
+	// DefaultHideBackendHeaders is the default value for EnvVarHideBackendHeaders (X-Powered-By)
+	DefaultHideBackendHeaders = "X-Powered-By"
 	// DefaultHostsAnnotation is the default value for EnvVarHostsAnnotation (routingHosts)
 	DefaultHostsAnnotation = "routingHosts"
+	// DefaultLimitConnStatus is the default value for EnvVarLimitConnStatus (429)
+	DefaultLimitConnStatus = 429
+	// DefaultLimitReqStatus is the default value for EnvVarLimitReqStatus (429)
+	DefaultLimitReqStatus = 429
+	// DefaultListenIPv6 is the default value for EnvVarListenIPv6 (false)
+	DefaultListenIPv6 = false
+	// DefaultLoadBalanceMethod is the default value for EnvVarLoadBalanceMethod (round_robin)
+	DefaultLoadBalanceMethod = LoadBalanceMethodRoundRobin
+	// DefaultLogFormat is the default value for EnvVarLogFormat (text)
+	DefaultLogFormat = logging.FormatText
+	// DefaultLogLevel is the default value for EnvVarLogLevel (info)
+	DefaultLogLevel = logging.LevelInfo
+	// DefaultMaxConnections is the default value for EnvVarMaxConnections (0, worker_connections is not derived)
+	DefaultMaxConnections = 0
+	// DefaultMaxLocationsPerHost is the default value for EnvVarMaxLocationsPerHost (0, unlimited)
+	DefaultMaxLocationsPerHost = 0
+	// DefaultMaxReloadsBeforeRestart is the default value for EnvVarMaxReloadsBeforeRestart (0, never restart)
+	DefaultMaxReloadsBeforeRestart = 0
+	// DefaultNginxBinary is the default value for EnvVarNginxBinary (nginx, found on the PATH)
+	DefaultNginxBinary = "nginx"
+	// DefaultNginxConfPath is the default value for EnvVarNginxConfPath (/etc/nginx/nginx.conf)
+	DefaultNginxConfPath = "/etc/nginx/nginx.conf"
 	// DefaultPathsAnnotation is the default value for the EnvVarHostsAnnotation (routingPaths)
 	DefaultPathsAnnotation = "routingPaths"
+	// DefaultPidPath is the default value for EnvVarPidPath (/var/run/nginx.pid)
+	DefaultPidPath = "/var/run/nginx.pid"
 	// DefaultPort is the default value for the EnvVarPort (80)
 	DefaultPort = 80
+	// DefaultPortInRedirect is the default value for EnvVarPortInRedirect (true)
+	DefaultPortInRedirect = true
+	// DefaultProxyCacheMaxSize is the default value for EnvVarProxyCacheMaxSize (1g)
+	DefaultProxyCacheMaxSize = "1g"
+	// DefaultProxyCachePath is the default value for EnvVarProxyCachePath (none, the proxy cache is disabled)
+	DefaultProxyCachePath = ""
+	// DefaultProxyConnectTimeout is the default value for EnvVarProxyConnectTimeout (2s)
+	DefaultProxyConnectTimeout = "2s"
+	// DefaultProxySocketKeepalive is the default value for EnvVarProxySocketKeepalive (false)
+	DefaultProxySocketKeepalive = false
+	// DefaultRateLimitBurst is the default value for EnvVarRateLimitBurst (20)
+	DefaultRateLimitBurst = 20
+	// DefaultRateLimitRate is the default value for EnvVarRateLimitRate (10r/s)
+	DefaultRateLimitRate = "10r/s"
+	// DefaultReadinessPort is the default value for EnvVarReadinessPort (9000)
+	DefaultReadinessPort = 9000
+	// DefaultReloadViaSignal is the default value for EnvVarReloadViaSignal (false)
+	DefaultReloadViaSignal = false
+	// DefaultRoutableLabelBoolean is the default value for EnvVarRoutableLabelBoolean (false)
+	DefaultRoutableLabelBoolean = false
 	// DefaultRoutableLabelSelector is the default value for EnvVarRoutableLabelSelector (routable=true)
 	DefaultRoutableLabelSelector = "routable=true"
+	// DefaultShutdownGracePeriod is the default value for EnvVarShutdownGracePeriod (0s, quit nginx immediately)
+	DefaultShutdownGracePeriod = 0 * time.Second
+	// DefaultStartupRetries is the default value for EnvVarStartupRetries (0)
+	DefaultStartupRetries = 0
+	// DefaultStartupRetryInterval is the default value for EnvVarStartupRetryInterval (1s)
+	DefaultStartupRetryInterval = time.Second
+	// DefaultStartupSettleDelay is the default value for EnvVarStartupSettleDelay (0s, reload immediately)
+	DefaultStartupSettleDelay = 0 * time.Second
+	// DefaultTCPNodelay is the default value for EnvVarTCPNodelay (true)
+	DefaultTCPNodelay = true
+	// DefaultTCPNopush is the default value for EnvVarTCPNopush (false)
+	DefaultTCPNopush = false
+	// DefaultTLSPassthroughPort is the default value for EnvVarTLSPassthroughPort (443)
+	DefaultTLSPassthroughPort = 443
+	// DefaultTLSPort is the default value for EnvVarTLSPort (443)
+	DefaultTLSPort = 443
+	// DefaultTrafficStatusPort is the default value for EnvVarTrafficStatusPort (9001)
+	DefaultTrafficStatusPort = 9001
+	// DefaultUpstreamKeepalive is the default value for EnvVarUpstreamKeepalive (0, disabled)
+	DefaultUpstreamKeepalive = 0
+	// DefaultUpstreamKeepaliveRequests is the default value for EnvVarUpstreamKeepaliveRequests (0, nginx default)
+	DefaultUpstreamKeepaliveRequests = 0
+	// DefaultUpstreamServerOrder is the default value for EnvVarUpstreamServerOrder (name)
+	DefaultUpstreamServerOrder = UpstreamServerOrderName
+	// DefaultWarnOnDuplicateRoutes is the default value for EnvVarWarnOnDuplicateRoutes (true)
+	DefaultWarnOnDuplicateRoutes = true
+	// DefaultWeightByResources is the default value for EnvVarWeightByResources (false)
+	DefaultWeightByResources = false
+	// DefaultWorkerConnections is the default value for EnvVarWorkerConnections (1024)
+	DefaultWorkerConnections = 1024
+	// EnvVarAbsoluteRedirect Environment variable name for enabling absolute redirects generated by nginx
+	EnvVarAbsoluteRedirect = "ABSOLUTE_REDIRECT"
+	// EnvVarAccessLogFormat Environment variable name for providing the access log format preset (combined, timing or upstream)
+	EnvVarAccessLogFormat = "ACCESS_LOG_FORMAT"
+	// EnvVarAccessLogPath Environment variable name for providing the access log path used with the access log format
+	EnvVarAccessLogPath = "ACCESS_LOG_PATH"
+	// EnvVarAlwaysAddHeaders Environment variable name for adding the 'always' flag to generated add_header directives
+	EnvVarAlwaysAddHeaders = "ALWAYS_ADD_HEADERS"
+	// EnvVarAnnotationDelimiter Environment variable name for providing the delimiter used to split the hosts and paths annotations
+	EnvVarAnnotationDelimiter = "ANNOTATION_DELIMITER"
 	// EnvVarAPIKeyHeader Environment variable name for providing the header name used to identify the API Key header
 	EnvVarAPIKeyHeader = "API_KEY_HEADER"
 	// EnvVarAPIKeySecretLocation Environment variable name for providing the location of the secret (name:field) to identify API Key secrets
 	EnvVarAPIKeySecretLocation = "API_KEY_SECRET_LOCATION"
+	// EnvVarBasicAuthSecretDataField Environment variable name for providing the secret data field name used for basic auth
+	EnvVarBasicAuthSecretDataField = "BASIC_AUTH_SECRET_DATA_FIELD"
+	// EnvVarCharset Environment variable name for providing the charset added to the Content-Type of text responses
+	EnvVarCharset = "CHARSET"
+	// EnvVarClientHeaderBufferSize Environment variable name for providing the buffer size for reading client request headers
+	EnvVarClientHeaderBufferSize = "CLIENT_HEADER_BUFFER_SIZE"
+	// EnvVarConsolidateUpstreams Environment variable name for sharing one upstream between the hosts and paths with identical servers
+	EnvVarConsolidateUpstreams = "CONSOLIDATE_UPSTREAMS"
+	// EnvVarDefaultServerReturn Environment variable name for providing the status code the default server returns for unknown hosts
+	EnvVarDefaultServerReturn = "DEFAULT_SERVER_RETURN"
+	// EnvVarDefaultType Environment variable name for providing the MIME type of responses without a Content-Type
+	EnvVarDefaultType = "DEFAULT_TYPE"
+	// EnvVarDryRun Environment variable name for printing the nginx configuration of the cluster and exiting without starting nginx
+	EnvVarDryRun = "DRY_RUN"
+	// EnvVarEmptyCacheRetryAfter Environment variable name for providing the Retry-After seconds returned when there are no routable pods
+	EnvVarEmptyCacheRetryAfter = "EMPTY_CACHE_RETRY_AFTER"
+	// EnvVarEmptyCacheStatus Environment variable name for providing the status code returned when there are no routable pods
+	EnvVarEmptyCacheStatus = "EMPTY_CACHE_STATUS"
+	// EnvVarEmptySecretAction Environment variable name for providing how routes are secured when the router secret value is empty
+	EnvVarEmptySecretAction = "EMPTY_SECRET_ACTION"
+	// EnvVarEmptyPathToRoot Environment variable name for routing paths annotation entries with an empty path to /
+	EnvVarEmptyPathToRoot = "EMPTY_PATH_TO_ROOT"
+	// EnvVarEnableAccessLog Environment variable name for enabling the access log written with the access log format
+	EnvVarEnableAccessLog = "ENABLE_ACCESS_LOG"
+	// EnvVarEnableDebugEndpoints Environment variable name for serving the debug endpoints (/validate) on the readiness port
+	EnvVarEnableDebugEndpoints = "ENABLE_DEBUG_ENDPOINTS"
+	// EnvVarEnableDynamicUpstreams Environment variable name for updating upstream servers without a reload (ngx_dynamic_upstream)
+	EnvVarEnableDynamicUpstreams = "ENABLE_DYNAMIC_UPSTREAMS"
+	// EnvVarEnableGzip Environment variable name for enabling gzip compression of responses
+	EnvVarEnableGzip = "ENABLE_GZIP"
+	// EnvVarEnableNginxUpstreamCheckModule Environment variable name for enabling upstream health checks (nginx_upstream_check_module)
+	EnvVarEnableNginxUpstreamCheckModule = "ENABLE_NGINX_UPSTREAM_CHECK_MODULE"
+	// EnvVarEnableRateLimit Environment variable name for enabling the request rate limit of each client address
+	EnvVarEnableRateLimit = "ENABLE_RATE_LIMIT"
+	// EnvVarEnableTrafficStatus Environment variable name for enabling the per host and upstream traffic counters (nginx-module-vts)
+	EnvVarEnableTrafficStatus = "ENABLE_TRAFFIC_STATUS"
+	// EnvVarEnableTLSPassthrough Environment variable name for enabling SNI based TLS passthrough (stream module)
+	EnvVarEnableTLSPassthrough = "ENABLE_TLS_PASSTHROUGH"
+	// EnvVarErrorLogLevel Environment variable name for providing the level of the nginx error_log written to stderr
+	EnvVarErrorLogLevel = "ERROR_LOG_LEVEL"
+	// EnvVarErrorLogToStderr Environment variable name for writing the nginx error_log to stderr
+	EnvVarErrorLogToStderr = "ERROR_LOG_TO_STDERR"
+	// EnvVarErrorPages Environment variable name for providing the space delimited error pages ({STATUS}={URI}) served by nginx
+	EnvVarErrorPages = "ERROR_PAGES"
+	// EnvVarFallbackBackend Environment variable name for providing the backend ({HOST}:{PORT}) added to every upstream as a backup server
+	EnvVarFallbackBackend = "FALLBACK_BACKEND"
+	// EnvVarForwardPort Environment variable name for enabling the X-Forwarded-Port header sent to the pods
+	EnvVarForwardPort = "FORWARD_PORT"
+	// EnvVarForwardedPort Environment variable name for providing a fixed X-Forwarded-Port value (the external port)
+	EnvVarForwardedPort = "FORWARDED_PORT"
+	// EnvVarGzipTypes Environment variable name for providing the space delimited MIME types compressed when gzip is enabled
+	EnvVarGzipTypes = "GZIP_TYPES"
+	// EnvVarHealthCheckProbes Environment variable name for providing the space delimited preference order of the probes health checks are derived from
+	EnvVarHealthCheckProbes = "HEALTH_CHECK_PROBES"
+	// EnvVarHideBackendHeaders Environment variable name for providing the backend response headers hidden from clients
+	EnvVarHideBackendHeaders = "HIDE_BACKEND_HEADERS"
 	// EnvVarHostsAnnotation Environment variable name for providing the name of the hosts annotation
 	EnvVarHostsAnnotation = "HOSTS_ANNOTATION"
+	// EnvVarIncludeFiles Environment variable name for providing the nginx config files (glob patterns) included in the http block
+	EnvVarIncludeFiles = "INCLUDE_FILES"
+	// EnvVarLimitConnStatus Environment variable name for providing the status code returned for connection limited requests
+	EnvVarLimitConnStatus = "LIMIT_CONN_STATUS"
+	// EnvVarLimitReqStatus Environment variable name for providing the status code returned for rate limited requests
+	EnvVarLimitReqStatus = "LIMIT_REQ_STATUS"
+	// EnvVarListenIPv6 Environment variable name for enabling the IPv6 listen directives of every nginx server
+	EnvVarListenIPv6 = "LISTEN_IPV6"
+	// EnvVarLoadBalanceMethod Environment variable name for providing the cluster-wide method upstreams balance requests with
+	EnvVarLoadBalanceMethod = "LB_METHOD"
+	// EnvVarLogFormat Environment variable name for providing the format of the router logs (text or json)
+	EnvVarLogFormat = "LOG_FORMAT"
+	// EnvVarLogLevel Environment variable name for providing the level below which router log messages are suppressed
+	EnvVarLogLevel = "LOG_LEVEL"
+	// EnvVarMaxConnections Environment variable name for providing the total number of connections across all nginx workers
+	EnvVarMaxConnections = "MAX_CONNECTIONS"
+	// EnvVarMaxLocationsPerHost Environment variable name for providing the maximum number of locations per host
+	EnvVarMaxLocationsPerHost = "MAX_LOCATIONS_PER_HOST"
+	// EnvVarMaxReloadsBeforeRestart Environment variable name for providing the nginx reloads before nginx is fully restarted
+	EnvVarMaxReloadsBeforeRestart = "MAX_RELOADS_BEFORE_RESTART"
+	// EnvVarNginxBinary Environment variable name for providing the nginx binary used to start, test and reload nginx
+	EnvVarNginxBinary = "NGINX_BINARY"
+	// EnvVarNginxConfPath Environment variable name for providing the path the nginx configuration is written to
+	EnvVarNginxConfPath = "NGINX_CONF_PATH"
+	// EnvVarNotFoundBackend Environment variable name for providing the backend ({NAMESPACE}/{NAME}) to proxy unmatched requests to
+	EnvVarNotFoundBackend = "NOT_FOUND_BACKEND"
 	// EnvVarPathsAnnotation Environment variable name for providing the the name of the paths annotation
 	EnvVarPathsAnnotation = "PATHS_ANNOTATION"
+	// EnvVarPidPath Environment variable name for providing the path to the nginx master PID file
+	EnvVarPidPath = "PID_PATH"
 	// EnvVarPort Environment variable for providing the port nginx should listen on
 	EnvVarPort = "PORT"
+	// EnvVarPortInRedirect Environment variable name for enabling the port in absolute redirects generated by nginx
+	EnvVarPortInRedirect = "PORT_IN_REDIRECT"
+	// EnvVarPreviousAPIKeyField Environment variable name for providing the secret data field name of the API Key being rotated out
+	EnvVarPreviousAPIKeyField = "PREVIOUS_API_KEY_FIELD"
+	// EnvVarProxyCacheMaxSize Environment variable name for providing the maximum size of the proxy cache
+	EnvVarProxyCacheMaxSize = "PROXY_CACHE_MAX_SIZE"
+	// EnvVarProxyCachePath Environment variable name for providing the directory cached responses are stored in (enables the proxy cache)
+	EnvVarProxyCachePath = "PROXY_CACHE_PATH"
+	// EnvVarProxyConnectTimeout Environment variable name for providing how long nginx waits to connect to a pod
+	EnvVarProxyConnectTimeout = "PROXY_CONNECT_TIMEOUT"
+	// EnvVarProxySocketKeepalive Environment variable name for enabling TCP keepalive on upstream connections
+	EnvVarProxySocketKeepalive = "PROXY_SOCKET_KEEPALIVE"
 	// EnvClientMaxBodySize Environment variable for max client request body size
 	EnvClientMaxBodySize = "CLIENT_MAX_BODY_SIZE"
+	// EnvVarRateLimitBurst Environment variable name for providing the requests a client can make in excess of the rate limit
+	EnvVarRateLimitBurst = "RATE_LIMIT_BURST"
+	// EnvVarRateLimitRate Environment variable name for providing the request rate limit of each client address
+	EnvVarRateLimitRate = "RATE_LIMIT_RATE"
+	// EnvVarReadinessPort Environment variable name for providing the port the router serves its /ready and /healthz
+	// endpoints on (0 disables them)
+	EnvVarReadinessPort = "READINESS_PORT"
+	// EnvVarReloadViaSignal Environment variable name for reloading nginx by signaling the PID in the PID file
+	EnvVarReloadViaSignal = "RELOAD_VIA_SIGNAL"
+	// EnvVarResolver Environment variable name for providing the DNS servers ({HOST} or {HOST}:{PORT}) nginx resolves hostnames with
+	EnvVarResolver = "RESOLVER"
+	// EnvVarResolverTimeout Environment variable name for providing how long nginx waits on a DNS server before failing resolution
+	EnvVarResolverTimeout = "RESOLVER_TIMEOUT"
+	// EnvVarRoutableLabelBoolean Environment variable name for treating the routable label values as booleans
+	EnvVarRoutableLabelBoolean = "ROUTABLE_LABEL_BOOLEAN"
 	// EnvVarRoutableLabelSelector Environment variable name for providing the label selector for identifying routable objects
 	EnvVarRoutableLabelSelector = "ROUTABLE_LABEL_SELECTOR"
+	// EnvVarShutdownGracePeriod Environment variable name for providing how long connections are drained before nginx quits
+	EnvVarShutdownGracePeriod = "SHUTDOWN_GRACE_PERIOD"
+	// EnvVarStartupRetries Environment variable name for providing the number of times the initial cluster query is retried
+	EnvVarStartupRetries = "STARTUP_RETRIES"
+	// EnvVarStartupRetryInterval Environment variable name for providing the initial interval between startup retries
+	EnvVarStartupRetryInterval = "STARTUP_RETRY_INTERVAL"
+	// EnvVarStartupSettleDelay Environment variable name for providing how long to collect events before the first reload
+	EnvVarStartupSettleDelay = "STARTUP_SETTLE_DELAY"
+	// EnvVarTCPNodelay Environment variable name for enabling tcp_nodelay
+	EnvVarTCPNodelay = "TCP_NODELAY"
+	// EnvVarTCPNopush Environment variable name for enabling tcp_nopush
+	EnvVarTCPNopush = "TCP_NOPUSH"
+	// EnvVarTLSPassthroughPort Environment variable name for providing the port nginx listens on for TLS passthrough
+	EnvVarTLSPassthroughPort = "TLS_PASSTHROUGH_PORT"
+	// EnvVarTLSPort Environment variable name for providing the port nginx listens on for TLS terminated traffic
+	EnvVarTLSPort = "TLS_PORT"
+	// EnvVarTrafficStatusPort Environment variable name for providing the port nginx serves the traffic status on
+	EnvVarTrafficStatusPort = "TRAFFIC_STATUS_PORT"
+	// EnvVarTLSSecret Environment variable name for providing the name of the TLS secrets (kubernetes.io/tls) used to terminate TLS
+	EnvVarTLSSecret = "TLS_SECRET"
+	// EnvVarUpstreamKeepalive Environment variable name for providing the idle keepalive connections cached per upstream
+	EnvVarUpstreamKeepalive = "UPSTREAM_KEEPALIVE"
+	// EnvVarUpstreamKeepaliveRequests Environment variable name for providing the requests served per upstream keepalive connection
+	EnvVarUpstreamKeepaliveRequests = "UPSTREAM_KEEPALIVE_REQUESTS"
+	// EnvVarUpstreamKeepaliveTime Environment variable name for providing the maximum lifetime of an upstream keepalive connection
+	EnvVarUpstreamKeepaliveTime = "UPSTREAM_KEEPALIVE_TIME"
+	// EnvVarUpstreamServerOrder Environment variable name for providing the strategy used to order the servers of an upstream
+	EnvVarUpstreamServerOrder = "UPSTREAM_SERVER_ORDER"
+	// EnvVarWarnOnDuplicateRoutes Environment variable name for warning when a pod's duplicate hosts/paths are collapsed
+	EnvVarWarnOnDuplicateRoutes = "WARN_ON_DUPLICATE_ROUTES"
+	// EnvVarWeightByResources Environment variable name for deriving upstream server weights from the pods' CPU allocation
+	EnvVarWeightByResources = "WEIGHT_BY_RESOURCES"
+	// EnvVarWorkerProcesses Environment variable name for providing the number of nginx worker processes (or auto)
+	EnvVarWorkerProcesses = "WORKER_PROCESSES"
+	// EnvVarWorkerConnections Environment variable name for providing the connections each nginx worker process handles
+	EnvVarWorkerConnections = "WORKER_CONNECTIONS"
+	// ErrMsgTmplInvalidAccessLogFormat is the error message template for an invalid access log format preset
+	ErrMsgTmplInvalidAccessLogFormat = "%s is not one of combined, timing or upstream: %s"
+	// ErrMsgTmplInvalidAccessLogPath is the error message template for an invalid access log path
+	ErrMsgTmplInvalidAccessLogPath = "%s is not an absolute path: %s"
+	// ErrMsgTmplInvalidAnnotationDelimiter is the error message template for an invalid annotation delimiter
+	ErrMsgTmplInvalidAnnotationDelimiter = "%s is not a space, comma or newline: %q"
 	// ErrMsgTmplInvalidAnnotationName is the error message template for an invalid annotation name
 	ErrMsgTmplInvalidAnnotationName = "%s has an invalid annotation name: %s"
 	// ErrMsgTmplInvalidAPIKeySecretLocation is the error message template for invalid API Key Secret location environment variable values
 	ErrMsgTmplInvalidAPIKeySecretLocation = "%s is not in the format of {API_KEY_SECRET_NAME}:{API_KEY_SECRET_DATA_FIELD_NAME}"
+	// ErrMsgTmplInvalidBoolean is the error message template for an invalid boolean
+	ErrMsgTmplInvalidBoolean = "%s is an invalid boolean: %s"
+	// ErrMsgTmplInvalidCharset is the error message template for an invalid charset
+	ErrMsgTmplInvalidCharset = "%s is not a valid charset: %s"
+	// ErrMsgTmplInvalidCount is the error message template for an invalid count
+	ErrMsgTmplInvalidCount = "%s is an invalid count (0 or greater): %s"
+	// ErrMsgTmplInvalidDefaultType is the error message template for an invalid default MIME type
+	ErrMsgTmplInvalidDefaultType = "%s is not a valid MIME type: %s"
+	// ErrMsgTmplInvalidDelay is the error message template for an invalid delay
+	ErrMsgTmplInvalidDelay = "%s is an invalid duration (0 or greater): %s"
+	// ErrMsgTmplInvalidDuration is the error message template for an invalid duration
+	ErrMsgTmplInvalidDuration = "%s is an invalid duration (greater than 0): %s"
+	// ErrMsgTmplInvalidEmptySecretAction is the error message template for an invalid empty secret action
+	ErrMsgTmplInvalidEmptySecretAction = "%s is not one of deny or allow: %s"
+	// ErrMsgTmplInvalidErrorLogLevel is the error message template for an invalid error_log level
+	ErrMsgTmplInvalidErrorLogLevel = "%s is not a valid nginx error_log level (debug, info, notice, warn, error, crit, alert or emerg): %s"
+	// ErrMsgTmplInvalidErrorPage is the error message template for an invalid error page
+	ErrMsgTmplInvalidErrorPage = "%s contains an entry that is not in the format of {STATUS}={URI} (STATUS 400-599, URI a path or an http(s) URL): %s"
+	// ErrMsgTmplInvalidFallbackBackend is the error message template for an invalid fallback backend
+	ErrMsgTmplInvalidFallbackBackend = "%s is not in the format of {HOST}:{PORT}: %s"
+	// ErrMsgTmplInvalidGzipType is the error message template for an invalid gzip MIME type
+	ErrMsgTmplInvalidGzipType = "%s contains an invalid MIME type: %s"
+	// ErrMsgTmplInvalidHeaderName is the error message template for an invalid header name
+	ErrMsgTmplInvalidHeaderName = "%s contains an invalid header name: %s"
+	// ErrMsgTmplInvalidHealthCheckProbes is the error message template for an invalid health check probe preference order
+	ErrMsgTmplInvalidHealthCheckProbes = "%s is not a space delimited list of unique probes (liveness or readiness): %s"
+	// ErrMsgTmplInvalidIncludeFile is the error message template for an invalid include file pattern
+	ErrMsgTmplInvalidIncludeFile = "%s contains a pattern that is not an absolute path: %s"
 	// ErrMsgTmplInvalidLabelSelector is the error message template for an invalid label selector
 	ErrMsgTmplInvalidLabelSelector = "%s has an invalid label selector: %s\n"
+	// ErrMsgTmplInvalidLoadBalanceMethod is the error message template for an invalid load balance method
+	ErrMsgTmplInvalidLoadBalanceMethod = "%s is not one of round_robin, least_conn or ip_hash: %s"
+	// ErrMsgTmplInvalidLogFormat is the error message template for an invalid log format
+	ErrMsgTmplInvalidLogFormat = "%s is not one of text or json: %s"
+	// ErrMsgTmplInvalidLogLevel is the error message template for an invalid log level
+	ErrMsgTmplInvalidLogLevel = "%s is not one of debug, info, warn or error: %s"
+	// ErrMsgTmplInvalidStatus is the error message template for an invalid error status code
+	ErrMsgTmplInvalidStatus = "%s is an invalid error status code (400-599): %s"
+	// ErrMsgTmplInvalidReturnStatus is the error message template for an invalid nginx return status code
+	ErrMsgTmplInvalidReturnStatus = "%s is an invalid status code (200-599, including 444): %s"
+	// ErrMsgTmplInvalidMaxConnections is the error message template for an invalid number of connections
+	ErrMsgTmplInvalidMaxConnections = "%s is an invalid number of connections (0 or greater): %s"
+	// ErrMsgTmplInvalidNotFoundBackend is the error message template for an invalid not found backend
+	ErrMsgTmplInvalidNotFoundBackend = "%s is not in the format of {NAMESPACE}/{NAME}: %s"
+	// ErrMsgTmplInvalidNginxBinary is the error message template for an invalid nginx binary
+	ErrMsgTmplInvalidNginxBinary = "%s is not a command name or path without whitespace or shell characters: %s"
+	// ErrMsgTmplInvalidNginxConfPath is the error message template for an invalid nginx configuration path
+	ErrMsgTmplInvalidNginxConfPath = "%s is not an absolute path without whitespace or shell characters: %s"
 	// ErrMsgTmplInvalidPort is the error message template for an invalid port
 	ErrMsgTmplInvalidPort = "%s is an invalid port: %s\n"
+	// ErrMsgTmplMutuallyExclusive is the error message template for environment variables that cannot both be set
+	ErrMsgTmplMutuallyExclusive = "%s cannot be set along with %s"
+	// ErrMsgTmplPortConflict is the error message template for ports that cannot be the same
+	ErrMsgTmplPortConflict = "%s cannot be the same as %s: %d"
+	// ErrMsgTmplInvalidPreviousAPIKeyField is the error message template for a previous API Key field that is the API Key field
+	ErrMsgTmplInvalidPreviousAPIKeyField = "%s cannot be the same as the API Key secret data field: %s"
+	// ErrMsgTmplInvalidProxyCachePath is the error message template for an invalid proxy cache path
+	ErrMsgTmplInvalidProxyCachePath = "%s is not an absolute path without whitespace: %s"
+	// ErrMsgTmplInvalidSize is the error message template for an invalid nginx size
+	ErrMsgTmplInvalidSize = "%s is an invalid nginx size (greater than 0, Example: 4k): %s"
+	// ErrMsgTmplInvalidRate is the error message template for an invalid nginx rate
+	ErrMsgTmplInvalidRate = "%s is an invalid nginx rate (Example: 10r/s): %s"
+	// ErrMsgTmplInvalidResolver is the error message template for an invalid resolver address
+	ErrMsgTmplInvalidResolver = "%s contains an address that is not in the format of {HOST} or {HOST}:{PORT}: %s"
+	// ErrMsgTmplInvalidRetries is the error message template for an invalid number of retries
+	ErrMsgTmplInvalidRetries = "%s is an invalid number of retries (0 or greater): %s"
+	// ErrMsgTmplInvalidTime is the error message template for an invalid nginx time
+	ErrMsgTmplInvalidTime = "%s is an invalid nginx time (Example: 1h): %s"
+	// ErrMsgTmplInvalidTLSSecret is the error message template for an invalid TLS secret name
+	ErrMsgTmplInvalidTLSSecret = "%s is not a valid secret name different from the API Key secret name: %s"
+	// ErrMsgTmplInvalidUpstreamServerOrder is the error message template for an invalid upstream server ordering strategy
+	ErrMsgTmplInvalidUpstreamServerOrder = "%s is not one of name, ip or insertion-stable: %s"
+	// ErrMsgTmplInvalidWorkerProcesses is the error message template for an invalid number of worker processes
+	ErrMsgTmplInvalidWorkerProcesses = "%s is not auto or a number greater than 0: %s"
+	// ErrMsgTmplInvalidWorkerConnections is the error message template for an invalid number of worker connections
+	ErrMsgTmplInvalidWorkerConnections = "%s is an invalid number of connections (greater than 0): %s"
+	// EmptySecretActionAllow is the EnvVarEmptySecretAction value for skipping the check of an empty secret value (with a warning)
+	EmptySecretActionAllow = "allow"
+	// EmptySecretActionDeny is the EnvVarEmptySecretAction value for rejecting all requests when the secret value is empty
+	EmptySecretActionDeny = "deny"
+	// HealthCheckProbeLiveness is the EnvVarHealthCheckProbes value for the container liveness probe
+	HealthCheckProbeLiveness = "liveness"
+	// HealthCheckProbeReadiness is the EnvVarHealthCheckProbes value for the container readiness probe
+	HealthCheckProbeReadiness = "readiness"
+	// UpstreamServerOrderInsertionStable is the EnvVarUpstreamServerOrder value for ordering servers by pod creation so
+	// that new pods are added after the existing ones
+	UpstreamServerOrderInsertionStable = "insertion-stable"
+	// UpstreamServerOrderIP is the EnvVarUpstreamServerOrder value for ordering servers by their IP address and port
+	UpstreamServerOrderIP = "ip"
+	// UpstreamServerOrderName is the EnvVarUpstreamServerOrder value for ordering servers by pod name (and namespace)
+	UpstreamServerOrderName = "name"
 )
 
+// validAnnotationDelimiters is the set of delimiters allowed in EnvVarAnnotationDelimiter (none are valid in hosts/paths)
+var validAnnotationDelimiters = map[string]bool{
+	" ":  true,
+	",":  true,
+	"\n": true,
+}
+
+// validErrorLogLevels is the set of nginx error_log levels allowed in EnvVarErrorLogLevel
+var validErrorLogLevels = map[string]bool{
+	"alert":  true,
+	"crit":   true,
+	"debug":  true,
+	"emerg":  true,
+	"error":  true,
+	"info":   true,
+	"notice": true,
+	"warn":   true,
+}
+
+func boolFromEnv(name string, defaultValue bool) (bool, error) {
+	valueStr := os.Getenv(name)
+
+	if valueStr == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.ParseBool(valueStr)
+
+	if err != nil {
+		return false, fmt.Errorf(ErrMsgTmplInvalidBoolean, name, valueStr)
+	}
+
+	return value, nil
+}
+
+func countFromEnv(name string, defaultCount int) (int, error) {
+	countStr := os.Getenv(name)
+
+	if countStr == "" {
+		return defaultCount, nil
+	}
+
+	count, err := strconv.Atoi(countStr)
+
+	if err != nil || count < 0 {
+		return 0, fmt.Errorf(ErrMsgTmplInvalidCount, name, countStr)
+	}
+
+	return count, nil
+}
+
+func errorStatusFromEnv(name string, defaultStatus int) (int, error) {
+	statusStr := os.Getenv(name)
+
+	if statusStr == "" {
+		return defaultStatus, nil
+	}
+
+	status, err := strconv.Atoi(statusStr)
+
+	if err != nil || status < 400 || status > 599 {
+		return 0, fmt.Errorf(ErrMsgTmplInvalidStatus, name, statusStr)
+	}
+
+	return status, nil
+}
+
+/*
+returnStatusFromEnv returns the status code an nginx return responds with, any final HTTP status code or the special
+444 that closes the connection without a response
+*/
+func returnStatusFromEnv(name string, defaultStatus int) (int, error) {
+	statusStr := os.Getenv(name)
+
+	if statusStr == "" {
+		return defaultStatus, nil
+	}
+
+	status, err := strconv.Atoi(statusStr)
+
+	if err != nil || status < 200 || status > 599 {
+		return 0, fmt.Errorf(ErrMsgTmplInvalidReturnStatus, name, statusStr)
+	}
+
+	return status, nil
+}
+
 /*
 ConfigFromEnv returns the configuration based on the environment variables and validates the values
 */
 func ConfigFromEnv() (*Config, error) {
 	config := &Config{
-		APIKeyHeader:      os.Getenv(EnvVarAPIKeyHeader),
-		HostsAnnotation:   os.Getenv(EnvVarHostsAnnotation),
-		PathsAnnotation:   os.Getenv(EnvVarPathsAnnotation),
-		ClientMaxBodySize: os.Getenv(EnvClientMaxBodySize),
+		AccessLogFormat:          os.Getenv(EnvVarAccessLogFormat),
+		AccessLogPath:            os.Getenv(EnvVarAccessLogPath),
+		AnnotationDelimiter:      os.Getenv(EnvVarAnnotationDelimiter),
+		APIKeyHeader:             os.Getenv(EnvVarAPIKeyHeader),
+		BasicAuthSecretDataField: os.Getenv(EnvVarBasicAuthSecretDataField),
+		Charset:                  os.Getenv(EnvVarCharset),
+		DefaultType:              os.Getenv(EnvVarDefaultType),
+		HostsAnnotation:          os.Getenv(EnvVarHostsAnnotation),
+		PathsAnnotation:          os.Getenv(EnvVarPathsAnnotation),
+		ClientHeaderBufferSize:   os.Getenv(EnvVarClientHeaderBufferSize),
+		ClientMaxBodySize:        os.Getenv(EnvClientMaxBodySize),
+		EmptySecretAction:        os.Getenv(EnvVarEmptySecretAction),
+		ErrorLogLevel:            os.Getenv(EnvVarErrorLogLevel),
+		FallbackBackend:          os.Getenv(EnvVarFallbackBackend),
+		LoadBalanceMethod:        os.Getenv(EnvVarLoadBalanceMethod),
+		LogFormat:                os.Getenv(EnvVarLogFormat),
+		LogLevel:                 os.Getenv(EnvVarLogLevel),
+		NginxBinary:              os.Getenv(EnvVarNginxBinary),
+		NginxConfPath:            os.Getenv(EnvVarNginxConfPath),
+		NotFoundBackend:          os.Getenv(EnvVarNotFoundBackend),
+		PidPath:                  os.Getenv(EnvVarPidPath),
+		PreviousAPIKeyField:      os.Getenv(EnvVarPreviousAPIKeyField),
+		ProxyCacheMaxSize:        os.Getenv(EnvVarProxyCacheMaxSize),
+		ProxyCachePath:           os.Getenv(EnvVarProxyCachePath),
+		ProxyConnectTimeout:      os.Getenv(EnvVarProxyConnectTimeout),
+		RateLimitRate:            os.Getenv(EnvVarRateLimitRate),
+		ResolverTimeout:          os.Getenv(EnvVarResolverTimeout),
+		TLSSecret:                os.Getenv(EnvVarTLSSecret),
+		UpstreamKeepaliveTime:    os.Getenv(EnvVarUpstreamKeepaliveTime),
+		UpstreamServerOrder:      os.Getenv(EnvVarUpstreamServerOrder),
+		WorkerProcesses:          os.Getenv(EnvVarWorkerProcesses),
 	}
 
 	// Apply defaults
+	if config.AccessLogFormat == "" {
+		config.AccessLogFormat = DefaultAccessLogFormat
+	}
+
+	if config.AccessLogPath == "" {
+		config.AccessLogPath = DefaultAccessLogPath
+	}
+
+	if config.EmptySecretAction == "" {
+		config.EmptySecretAction = DefaultEmptySecretAction
+	}
+
+	if config.ProxyCacheMaxSize == "" {
+		config.ProxyCacheMaxSize = DefaultProxyCacheMaxSize
+	}
+
+	if config.ProxyConnectTimeout == "" {
+		config.ProxyConnectTimeout = DefaultProxyConnectTimeout
+	}
+
+	if config.LoadBalanceMethod == "" {
+		config.LoadBalanceMethod = DefaultLoadBalanceMethod
+	}
+
+	if config.LogFormat == "" {
+		config.LogFormat = DefaultLogFormat
+	}
+
+	if config.LogLevel == "" {
+		config.LogLevel = DefaultLogLevel
+	}
+
+	if config.RateLimitRate == "" {
+		config.RateLimitRate = DefaultRateLimitRate
+	}
+
+	if config.UpstreamServerOrder == "" {
+		config.UpstreamServerOrder = DefaultUpstreamServerOrder
+	}
+
+	if config.AnnotationDelimiter == "" {
+		config.AnnotationDelimiter = DefaultAnnotationDelimiter
+	}
+
 	if config.APIKeyHeader == "" {
 		config.APIKeyHeader = DefaultAPIKeyHeader
 	}
 
+	if config.BasicAuthSecretDataField == "" {
+		config.BasicAuthSecretDataField = DefaultBasicAuthSecretDataField
+	}
+
 	if config.HostsAnnotation == "" {
 		config.HostsAnnotation = DefaultHostsAnnotation
 	}
@@ -99,7 +656,40 @@ func ConfigFromEnv() (*Config, error) {
 		config.ClientMaxBodySize = DefaultClientMaxBodySize
 	}
 
+	if config.ClientHeaderBufferSize == "" {
+		config.ClientHeaderBufferSize = DefaultClientHeaderBufferSize
+	}
+
+	if config.DefaultType == "" {
+		config.DefaultType = DefaultDefaultType
+	}
+
+	if config.PidPath == "" {
+		config.PidPath = DefaultPidPath
+	}
+
+	if config.NginxBinary == "" {
+		config.NginxBinary = DefaultNginxBinary
+	}
+
+	if config.NginxConfPath == "" {
+		config.NginxConfPath = DefaultNginxConfPath
+	}
+
+	if config.ErrorLogLevel == "" {
+		config.ErrorLogLevel = DefaultErrorLogLevel
+	}
+
 	// Validate configuration
+	if config.AccessLogFormat != AccessLogFormatCombined && config.AccessLogFormat != AccessLogFormatTiming &&
+		config.AccessLogFormat != AccessLogFormatUpstream {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidAccessLogFormat, EnvVarAccessLogFormat, config.AccessLogFormat)
+	} else if !path.IsAbs(config.AccessLogPath) || strings.ContainsAny(config.AccessLogPath, " \t;{}'\"") {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidAccessLogPath, EnvVarAccessLogPath, config.AccessLogPath)
+	} else if !validAnnotationDelimiters[config.AnnotationDelimiter] {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidAnnotationDelimiter, EnvVarAnnotationDelimiter, config.AnnotationDelimiter)
+	}
+
 	apiKeySecretLocation := os.Getenv(EnvVarAPIKeySecretLocation)
 	var apiKeySecretLocationParts []string
 
@@ -118,6 +708,10 @@ func ConfigFromEnv() (*Config, error) {
 		}
 	}
 
+	if config.PreviousAPIKeyField != "" && config.PreviousAPIKeyField == config.APIKeySecretDataField {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidPreviousAPIKeyField, EnvVarPreviousAPIKeyField, config.PreviousAPIKeyField)
+	}
+
 	hostErrs := validation.IsQualifiedName(strings.ToLower(config.HostsAnnotation))
 	pathErrs := validation.IsQualifiedName(strings.ToLower(config.PathsAnnotation))
 
@@ -127,6 +721,528 @@ func ConfigFromEnv() (*Config, error) {
 		return nil, fmt.Errorf(ErrMsgTmplInvalidAnnotationName, EnvVarPathsAnnotation, config.PathsAnnotation)
 	}
 
+	// The nginx binary and configuration path are used in shell commands
+	if strings.ContainsAny(config.NginxBinary, " \t\n;&|<>()$`'\"\\*?") {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidNginxBinary, EnvVarNginxBinary, config.NginxBinary)
+	} else if !path.IsAbs(config.NginxConfPath) || strings.ContainsAny(config.NginxConfPath, " \t\n;&|<>()$`'\"\\*?{}") {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidNginxConfPath, EnvVarNginxConfPath, config.NginxConfPath)
+	}
+
+	if config.NotFoundBackend != "" {
+		notFoundBackendParts := strings.Split(config.NotFoundBackend, "/")
+
+		if len(notFoundBackendParts) != 2 || notFoundBackendParts[0] == "" || notFoundBackendParts[1] == "" {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidNotFoundBackend, EnvVarNotFoundBackend, config.NotFoundBackend)
+		}
+	}
+
+	if config.FallbackBackend != "" {
+		fallbackBackendParts := strings.Split(config.FallbackBackend, ":")
+		valid := false
+
+		if len(fallbackBackendParts) == 2 {
+			port, err := strconv.Atoi(fallbackBackendParts[1])
+
+			valid = (hostnameRegex.MatchString(fallbackBackendParts[0]) || ipRegex.MatchString(fallbackBackendParts[0])) && err == nil && utils.IsValidPort(port)
+		}
+
+		if !valid {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidFallbackBackend, EnvVarFallbackBackend, config.FallbackBackend)
+		}
+	}
+
+	for _, address := range strings.Fields(os.Getenv(EnvVarResolver)) {
+		addressParts := strings.Split(address, ":")
+		valid := false
+
+		if len(addressParts) == 1 {
+			valid = hostnameRegex.MatchString(addressParts[0]) || ipRegex.MatchString(addressParts[0])
+		} else if len(addressParts) == 2 {
+			port, err := strconv.Atoi(addressParts[1])
+
+			valid = (hostnameRegex.MatchString(addressParts[0]) || ipRegex.MatchString(addressParts[0])) && err == nil && utils.IsValidPort(port)
+		}
+
+		if !valid {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidResolver, EnvVarResolver, address)
+		}
+
+		config.Resolver = append(config.Resolver, address)
+	}
+
+	if config.ProxyCachePath != "" && (!path.IsAbs(config.ProxyCachePath) || strings.ContainsAny(config.ProxyCachePath, " \t;{}'\"")) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidProxyCachePath, EnvVarProxyCachePath, config.ProxyCachePath)
+	} else if !nginxSizeRegex.MatchString(config.ProxyCacheMaxSize) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidSize, EnvVarProxyCacheMaxSize, config.ProxyCacheMaxSize)
+	}
+
+	if !nginxTimeRegex.MatchString(config.ProxyConnectTimeout) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidTime, EnvVarProxyConnectTimeout, config.ProxyConnectTimeout)
+	}
+
+	if config.ResolverTimeout != "" && !nginxTimeRegex.MatchString(config.ResolverTimeout) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidTime, EnvVarResolverTimeout, config.ResolverTimeout)
+	}
+
+	absoluteRedirect, err := boolFromEnv(EnvVarAbsoluteRedirect, DefaultAbsoluteRedirect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.AbsoluteRedirect = absoluteRedirect
+
+	alwaysAddHeaders, err := boolFromEnv(EnvVarAlwaysAddHeaders, DefaultAlwaysAddHeaders)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.AlwaysAddHeaders = alwaysAddHeaders
+
+	portInRedirect, err := boolFromEnv(EnvVarPortInRedirect, DefaultPortInRedirect)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.PortInRedirect = portInRedirect
+
+	routableLabelBoolean, err := boolFromEnv(EnvVarRoutableLabelBoolean, DefaultRoutableLabelBoolean)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.RoutableLabelBoolean = routableLabelBoolean
+
+	if config.WorkerProcesses != "" && config.WorkerProcesses != "auto" {
+		workerProcesses, err := strconv.Atoi(config.WorkerProcesses)
+
+		if err != nil || workerProcesses < 1 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidWorkerProcesses, EnvVarWorkerProcesses, config.WorkerProcesses)
+		}
+	}
+
+	emptyCacheStatus, err := errorStatusFromEnv(EnvVarEmptyCacheStatus, DefaultEmptyCacheStatus)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EmptyCacheStatus = emptyCacheStatus
+
+	defaultServerReturn, err := returnStatusFromEnv(EnvVarDefaultServerReturn, DefaultDefaultServerReturn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.DefaultServerReturn = defaultServerReturn
+
+	consolidateUpstreams, err := boolFromEnv(EnvVarConsolidateUpstreams, DefaultConsolidateUpstreams)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ConsolidateUpstreams = consolidateUpstreams
+
+	dryRun, err := boolFromEnv(EnvVarDryRun, DefaultDryRun)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.DryRun = dryRun
+
+	config.ErrorPages = make(map[int]string)
+
+	for _, errorPage := range strings.Fields(os.Getenv(EnvVarErrorPages)) {
+		errorPageParts := strings.SplitN(errorPage, "=", 2)
+
+		if len(errorPageParts) != 2 || !errorPageURIRegex.MatchString(errorPageParts[1]) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidErrorPage, EnvVarErrorPages, errorPage)
+		}
+
+		status, err := strconv.Atoi(errorPageParts[0])
+
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidErrorPage, EnvVarErrorPages, errorPage)
+		}
+
+		config.ErrorPages[status] = errorPageParts[1]
+	}
+
+	emptyCacheRetryAfter, err := countFromEnv(EnvVarEmptyCacheRetryAfter, DefaultEmptyCacheRetryAfter)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EmptyCacheRetryAfter = emptyCacheRetryAfter
+
+	if config.EmptySecretAction != EmptySecretActionAllow && config.EmptySecretAction != EmptySecretActionDeny {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidEmptySecretAction, EnvVarEmptySecretAction, config.EmptySecretAction)
+	}
+
+	emptyPathToRoot, err := boolFromEnv(EnvVarEmptyPathToRoot, DefaultEmptyPathToRoot)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EmptyPathToRoot = emptyPathToRoot
+
+	enableAccessLog, err := boolFromEnv(EnvVarEnableAccessLog, DefaultEnableAccessLog)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableAccessLog = enableAccessLog
+
+	enableDebugEndpoints, err := boolFromEnv(EnvVarEnableDebugEndpoints, DefaultEnableDebugEndpoints)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableDebugEndpoints = enableDebugEndpoints
+
+	enableDynamicUpstreams, err := boolFromEnv(EnvVarEnableDynamicUpstreams, DefaultEnableDynamicUpstreams)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableDynamicUpstreams = enableDynamicUpstreams
+
+	enableGzip, err := boolFromEnv(EnvVarEnableGzip, DefaultEnableGzip)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableGzip = enableGzip
+
+	enableRateLimit, err := boolFromEnv(EnvVarEnableRateLimit, DefaultEnableRateLimit)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableRateLimit = enableRateLimit
+
+	if !nginxSizeRegex.MatchString(config.ClientHeaderBufferSize) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidSize, EnvVarClientHeaderBufferSize, config.ClientHeaderBufferSize)
+	}
+
+	if !nginxRateRegex.MatchString(config.RateLimitRate) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidRate, EnvVarRateLimitRate, config.RateLimitRate)
+	}
+
+	rateLimitBurst, err := countFromEnv(EnvVarRateLimitBurst, DefaultRateLimitBurst)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.RateLimitBurst = rateLimitBurst
+
+	gzipTypesStr := os.Getenv(EnvVarGzipTypes)
+
+	if gzipTypesStr == "" {
+		gzipTypesStr = DefaultGzipTypes
+	}
+
+	gzipTypes := strings.Fields(gzipTypesStr)
+
+	for _, gzipType := range gzipTypes {
+		if !gzipTypeRegex.MatchString(gzipType) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidGzipType, EnvVarGzipTypes, gzipType)
+		}
+	}
+
+	config.GzipTypes = strings.Join(gzipTypes, " ")
+
+	if !mimeTypeRegex.MatchString(config.DefaultType) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidDefaultType, EnvVarDefaultType, config.DefaultType)
+	} else if config.Charset != "" && !charsetRegex.MatchString(config.Charset) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidCharset, EnvVarCharset, config.Charset)
+	}
+
+	enableNginxUpstreamCheckModule, err := boolFromEnv(EnvVarEnableNginxUpstreamCheckModule, DefaultEnableNginxUpstreamCheckModule)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableNginxUpstreamCheckModule = enableNginxUpstreamCheckModule
+
+	errorLogToStderr, err := boolFromEnv(EnvVarErrorLogToStderr, DefaultErrorLogToStderr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ErrorLogToStderr = errorLogToStderr
+
+	if !validErrorLogLevels[config.ErrorLogLevel] {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidErrorLogLevel, EnvVarErrorLogLevel, config.ErrorLogLevel)
+	}
+
+	healthCheckProbesStr := os.Getenv(EnvVarHealthCheckProbes)
+
+	if healthCheckProbesStr == "" {
+		healthCheckProbesStr = DefaultHealthCheckProbes
+	}
+
+	for _, probe := range strings.Fields(healthCheckProbesStr) {
+		if (probe != HealthCheckProbeLiveness && probe != HealthCheckProbeReadiness) || containsString(config.HealthCheckProbes, probe) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidHealthCheckProbes, EnvVarHealthCheckProbes, healthCheckProbesStr)
+		}
+
+		config.HealthCheckProbes = append(config.HealthCheckProbes, probe)
+	}
+
+	hideBackendHeadersStr, ok := os.LookupEnv(EnvVarHideBackendHeaders)
+
+	// Only use the default when unset so that an empty value can be used to hide no headers
+	if !ok {
+		hideBackendHeadersStr = DefaultHideBackendHeaders
+	}
+
+	for _, header := range strings.Fields(hideBackendHeadersStr) {
+		if !headerNameRegex.MatchString(header) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidHeaderName, EnvVarHideBackendHeaders, header)
+		}
+
+		config.HideBackendHeaders = append(config.HideBackendHeaders, header)
+	}
+
+	for _, pattern := range strings.Fields(os.Getenv(EnvVarIncludeFiles)) {
+		// Patterns are rendered unquoted so they cannot contain characters that would end the include directive
+		if !path.IsAbs(pattern) || strings.ContainsAny(pattern, ";{}'\"") {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidIncludeFile, EnvVarIncludeFiles, pattern)
+		}
+
+		config.IncludeFiles = append(config.IncludeFiles, pattern)
+	}
+
+	limitConnStatus, err := errorStatusFromEnv(EnvVarLimitConnStatus, DefaultLimitConnStatus)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LimitConnStatus = limitConnStatus
+
+	limitReqStatus, err := errorStatusFromEnv(EnvVarLimitReqStatus, DefaultLimitReqStatus)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.LimitReqStatus = limitReqStatus
+
+	listenIPv6, err := boolFromEnv(EnvVarListenIPv6, DefaultListenIPv6)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ListenIPv6 = listenIPv6
+
+	proxySocketKeepalive, err := boolFromEnv(EnvVarProxySocketKeepalive, DefaultProxySocketKeepalive)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ProxySocketKeepalive = proxySocketKeepalive
+
+	reloadViaSignal, err := boolFromEnv(EnvVarReloadViaSignal, DefaultReloadViaSignal)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ReloadViaSignal = reloadViaSignal
+
+	tcpNodelay, err := boolFromEnv(EnvVarTCPNodelay, DefaultTCPNodelay)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.TCPNodelay = tcpNodelay
+
+	tcpNopush, err := boolFromEnv(EnvVarTCPNopush, DefaultTCPNopush)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.TCPNopush = tcpNopush
+
+	warnOnDuplicateRoutes, err := boolFromEnv(EnvVarWarnOnDuplicateRoutes, DefaultWarnOnDuplicateRoutes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.WarnOnDuplicateRoutes = warnOnDuplicateRoutes
+
+	weightByResources, err := boolFromEnv(EnvVarWeightByResources, DefaultWeightByResources)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.WeightByResources = weightByResources
+
+	startupRetriesStr := os.Getenv(EnvVarStartupRetries)
+
+	if startupRetriesStr == "" {
+		config.StartupRetries = DefaultStartupRetries
+	} else {
+		startupRetries, err := strconv.Atoi(startupRetriesStr)
+
+		if err != nil || startupRetries < 0 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidRetries, EnvVarStartupRetries, startupRetriesStr)
+		}
+
+		config.StartupRetries = startupRetries
+	}
+
+	startupRetryIntervalStr := os.Getenv(EnvVarStartupRetryInterval)
+
+	if startupRetryIntervalStr == "" {
+		config.StartupRetryInterval = DefaultStartupRetryInterval
+	} else {
+		startupRetryInterval, err := time.ParseDuration(startupRetryIntervalStr)
+
+		if err != nil || startupRetryInterval <= 0 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidDuration, EnvVarStartupRetryInterval, startupRetryIntervalStr)
+		}
+
+		config.StartupRetryInterval = startupRetryInterval
+	}
+
+	startupSettleDelayStr := os.Getenv(EnvVarStartupSettleDelay)
+
+	if startupSettleDelayStr == "" {
+		config.StartupSettleDelay = DefaultStartupSettleDelay
+	} else {
+		startupSettleDelay, err := time.ParseDuration(startupSettleDelayStr)
+
+		if err != nil || startupSettleDelay < 0 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidDelay, EnvVarStartupSettleDelay, startupSettleDelayStr)
+		}
+
+		config.StartupSettleDelay = startupSettleDelay
+	}
+
+	shutdownGracePeriodStr := os.Getenv(EnvVarShutdownGracePeriod)
+
+	if shutdownGracePeriodStr == "" {
+		config.ShutdownGracePeriod = DefaultShutdownGracePeriod
+	} else {
+		shutdownGracePeriod, err := time.ParseDuration(shutdownGracePeriodStr)
+
+		if err != nil || shutdownGracePeriod < 0 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidDelay, EnvVarShutdownGracePeriod, shutdownGracePeriodStr)
+		}
+
+		config.ShutdownGracePeriod = shutdownGracePeriod
+	}
+
+	upstreamKeepalive, err := countFromEnv(EnvVarUpstreamKeepalive, DefaultUpstreamKeepalive)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.UpstreamKeepalive = upstreamKeepalive
+
+	upstreamKeepaliveRequests, err := countFromEnv(EnvVarUpstreamKeepaliveRequests, DefaultUpstreamKeepaliveRequests)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.UpstreamKeepaliveRequests = upstreamKeepaliveRequests
+
+	if config.UpstreamKeepaliveTime != "" && !nginxTimeRegex.MatchString(config.UpstreamKeepaliveTime) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidTime, EnvVarUpstreamKeepaliveTime, config.UpstreamKeepaliveTime)
+	}
+
+	if config.UpstreamServerOrder != UpstreamServerOrderInsertionStable && config.UpstreamServerOrder != UpstreamServerOrderIP &&
+		config.UpstreamServerOrder != UpstreamServerOrderName {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidUpstreamServerOrder, EnvVarUpstreamServerOrder, config.UpstreamServerOrder)
+	}
+
+	if config.LoadBalanceMethod != LoadBalanceMethodIPHash && config.LoadBalanceMethod != LoadBalanceMethodLeastConn &&
+		config.LoadBalanceMethod != LoadBalanceMethodRoundRobin {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidLoadBalanceMethod, EnvVarLoadBalanceMethod, config.LoadBalanceMethod)
+	}
+
+	if !logging.IsValidFormat(config.LogFormat) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidLogFormat, EnvVarLogFormat, config.LogFormat)
+	}
+
+	if !logging.IsValidLevel(config.LogLevel) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidLogLevel, EnvVarLogLevel, config.LogLevel)
+	}
+
+	maxConnectionsStr := os.Getenv(EnvVarMaxConnections)
+
+	if maxConnectionsStr == "" {
+		config.MaxConnections = DefaultMaxConnections
+	} else {
+		maxConnections, err := strconv.Atoi(maxConnectionsStr)
+
+		if err != nil || maxConnections < 0 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidMaxConnections, EnvVarMaxConnections, maxConnectionsStr)
+		}
+
+		config.MaxConnections = maxConnections
+	}
+
+	workerConnectionsStr := os.Getenv(EnvVarWorkerConnections)
+
+	if workerConnectionsStr == "" {
+		config.WorkerConnections = DefaultWorkerConnections
+	} else {
+		workerConnections, err := strconv.Atoi(workerConnectionsStr)
+
+		if err != nil || workerConnections < 1 {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidWorkerConnections, EnvVarWorkerConnections, workerConnectionsStr)
+		} else if config.MaxConnections != 0 {
+			// worker_connections is derived from the total number of connections
+			return nil, fmt.Errorf(ErrMsgTmplMutuallyExclusive, EnvVarWorkerConnections, EnvVarMaxConnections)
+		}
+
+		config.WorkerConnections = workerConnections
+	}
+
+	maxLocationsPerHost, err := countFromEnv(EnvVarMaxLocationsPerHost, DefaultMaxLocationsPerHost)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MaxLocationsPerHost = maxLocationsPerHost
+
+	maxReloadsBeforeRestart, err := countFromEnv(EnvVarMaxReloadsBeforeRestart, DefaultMaxReloadsBeforeRestart)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.MaxReloadsBeforeRestart = maxReloadsBeforeRestart
+
 	portStr := os.Getenv(EnvVarPort)
 
 	if portStr == "" {
@@ -141,6 +1257,139 @@ func ConfigFromEnv() (*Config, error) {
 		config.Port = port
 	}
 
+	enableTLSPassthrough, err := boolFromEnv(EnvVarEnableTLSPassthrough, DefaultEnableTLSPassthrough)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableTLSPassthrough = enableTLSPassthrough
+
+	tlsPassthroughPortStr := os.Getenv(EnvVarTLSPassthroughPort)
+
+	if tlsPassthroughPortStr == "" {
+		config.TLSPassthroughPort = DefaultTLSPassthroughPort
+	} else {
+		tlsPassthroughPort, err := strconv.Atoi(tlsPassthroughPortStr)
+
+		if err != nil || !utils.IsValidPort(tlsPassthroughPort) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidPort, EnvVarTLSPassthroughPort, tlsPassthroughPortStr)
+		}
+
+		config.TLSPassthroughPort = tlsPassthroughPort
+	}
+
+	forwardPort, err := boolFromEnv(EnvVarForwardPort, DefaultForwardPort)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.ForwardPort = forwardPort
+
+	forwardedPortStr := os.Getenv(EnvVarForwardedPort)
+
+	if forwardedPortStr == "" {
+		config.ForwardedPort = DefaultForwardedPort
+	} else {
+		forwardedPort, err := strconv.Atoi(forwardedPortStr)
+
+		if err != nil || !utils.IsValidPort(forwardedPort) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidPort, EnvVarForwardedPort, forwardedPortStr)
+		}
+
+		config.ForwardedPort = forwardedPort
+	}
+
+	// The http and stream servers cannot listen on the same port
+	if config.EnableTLSPassthrough && config.TLSPassthroughPort == config.Port {
+		return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTLSPassthroughPort, EnvVarPort, config.Port)
+	}
+
+	if config.TLSSecret != "" && (config.TLSSecret == config.APIKeySecret || len(validation.IsDNS1123Subdomain(config.TLSSecret)) > 0) {
+		return nil, fmt.Errorf(ErrMsgTmplInvalidTLSSecret, EnvVarTLSSecret, config.TLSSecret)
+	}
+
+	tlsPortStr := os.Getenv(EnvVarTLSPort)
+
+	if tlsPortStr == "" {
+		config.TLSPort = DefaultTLSPort
+	} else {
+		tlsPort, err := strconv.Atoi(tlsPortStr)
+
+		if err != nil || !utils.IsValidPort(tlsPort) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidPort, EnvVarTLSPort, tlsPortStr)
+		}
+
+		config.TLSPort = tlsPort
+	}
+
+	// The TLS terminated traffic cannot be served on a port already used by nginx
+	if config.TLSSecret != "" && config.TLSPort == config.Port {
+		return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTLSPort, EnvVarPort, config.Port)
+	} else if config.TLSSecret != "" && config.EnableTLSPassthrough && config.TLSPort == config.TLSPassthroughPort {
+		return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTLSPort, EnvVarTLSPassthroughPort, config.TLSPassthroughPort)
+	}
+
+	readinessPortStr := os.Getenv(EnvVarReadinessPort)
+
+	if readinessPortStr == "" {
+		config.ReadinessPort = DefaultReadinessPort
+	} else {
+		readinessPort, err := strconv.Atoi(readinessPortStr)
+
+		// 0 disables the readiness server
+		if err != nil || (readinessPort != 0 && !utils.IsValidPort(readinessPort)) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidPort, EnvVarReadinessPort, readinessPortStr)
+		}
+
+		config.ReadinessPort = readinessPort
+	}
+
+	// The readiness endpoint cannot listen on a port nginx listens on
+	if config.ReadinessPort == config.Port {
+		return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarReadinessPort, EnvVarPort, config.Port)
+	} else if config.EnableTLSPassthrough && config.ReadinessPort == config.TLSPassthroughPort {
+		return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarReadinessPort, EnvVarTLSPassthroughPort, config.TLSPassthroughPort)
+	} else if config.TLSSecret != "" && config.ReadinessPort == config.TLSPort {
+		return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarReadinessPort, EnvVarTLSPort, config.TLSPort)
+	}
+
+	enableTrafficStatus, err := boolFromEnv(EnvVarEnableTrafficStatus, DefaultEnableTrafficStatus)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config.EnableTrafficStatus = enableTrafficStatus
+
+	trafficStatusPortStr := os.Getenv(EnvVarTrafficStatusPort)
+
+	if trafficStatusPortStr == "" {
+		config.TrafficStatusPort = DefaultTrafficStatusPort
+	} else {
+		trafficStatusPort, err := strconv.Atoi(trafficStatusPortStr)
+
+		if err != nil || !utils.IsValidPort(trafficStatusPort) {
+			return nil, fmt.Errorf(ErrMsgTmplInvalidPort, EnvVarTrafficStatusPort, trafficStatusPortStr)
+		}
+
+		config.TrafficStatusPort = trafficStatusPort
+	}
+
+	// The traffic status server cannot listen on a port used by nginx or the router
+	if config.EnableTrafficStatus {
+		if config.TrafficStatusPort == config.Port {
+			return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTrafficStatusPort, EnvVarPort, config.Port)
+		} else if config.EnableTLSPassthrough && config.TrafficStatusPort == config.TLSPassthroughPort {
+			return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTrafficStatusPort, EnvVarTLSPassthroughPort, config.TLSPassthroughPort)
+		} else if config.TLSSecret != "" && config.TrafficStatusPort == config.TLSPort {
+			return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTrafficStatusPort, EnvVarTLSPort, config.TLSPort)
+		} else if config.TrafficStatusPort == config.ReadinessPort {
+			return nil, fmt.Errorf(ErrMsgTmplPortConflict, EnvVarTrafficStatusPort, EnvVarReadinessPort, config.ReadinessPort)
+		}
+	}
+
 	routableLabelSelector := os.Getenv(EnvVarRoutableLabelSelector)
 
 	if routableLabelSelector == "" {
@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"k8s.io/kubernetes/pkg/labels"
 )
@@ -54,11 +56,89 @@ func resetEnv(t *testing.T) {
 		}
 	}
 
+	unsetEnv(EnvVarAbsoluteRedirect)
+	unsetEnv(EnvVarAccessLogFormat)
+	unsetEnv(EnvVarAccessLogPath)
+	unsetEnv(EnvVarAnnotationDelimiter)
+	unsetEnv(EnvVarAlwaysAddHeaders)
 	unsetEnv(EnvVarAPIKeySecretLocation)
+	unsetEnv(EnvVarBasicAuthSecretDataField)
+	unsetEnv(EnvVarCharset)
+	unsetEnv(EnvVarClientHeaderBufferSize)
+	unsetEnv(EnvVarConsolidateUpstreams)
+	unsetEnv(EnvVarEmptyCacheRetryAfter)
+	unsetEnv(EnvVarEmptyCacheStatus)
+	unsetEnv(EnvVarDefaultServerReturn)
+	unsetEnv(EnvVarDefaultType)
+	unsetEnv(EnvVarDryRun)
+	unsetEnv(EnvVarErrorPages)
+	unsetEnv(EnvVarEmptyPathToRoot)
+	unsetEnv(EnvVarEmptySecretAction)
+	unsetEnv(EnvVarEnableAccessLog)
+	unsetEnv(EnvVarEnableDebugEndpoints)
+	unsetEnv(EnvVarEnableDynamicUpstreams)
+	unsetEnv(EnvVarEnableGzip)
+	unsetEnv(EnvVarEnableNginxUpstreamCheckModule)
+	unsetEnv(EnvVarEnableRateLimit)
+	unsetEnv(EnvVarEnableTLSPassthrough)
+	unsetEnv(EnvVarEnableTrafficStatus)
+	unsetEnv(EnvVarErrorLogLevel)
+	unsetEnv(EnvVarErrorLogToStderr)
+	unsetEnv(EnvVarFallbackBackend)
+	unsetEnv(EnvVarForwardPort)
+	unsetEnv(EnvVarForwardedPort)
+	unsetEnv(EnvVarGzipTypes)
+	unsetEnv(EnvVarHealthCheckProbes)
+	unsetEnv(EnvVarHideBackendHeaders)
 	unsetEnv(EnvVarHostsAnnotation)
+	unsetEnv(EnvVarIncludeFiles)
+	unsetEnv(EnvVarLimitConnStatus)
+	unsetEnv(EnvVarLimitReqStatus)
+	unsetEnv(EnvVarListenIPv6)
+	unsetEnv(EnvVarLoadBalanceMethod)
+	unsetEnv(EnvVarLogFormat)
+	unsetEnv(EnvVarLogLevel)
+	unsetEnv(EnvVarMaxConnections)
+	unsetEnv(EnvVarMaxLocationsPerHost)
+	unsetEnv(EnvVarMaxReloadsBeforeRestart)
+	unsetEnv(EnvVarNginxBinary)
+	unsetEnv(EnvVarNginxConfPath)
+	unsetEnv(EnvVarNotFoundBackend)
 	unsetEnv(EnvVarPathsAnnotation)
+	unsetEnv(EnvVarPidPath)
 	unsetEnv(EnvVarPort)
+	unsetEnv(EnvVarPortInRedirect)
+	unsetEnv(EnvVarPreviousAPIKeyField)
+	unsetEnv(EnvVarProxyCacheMaxSize)
+	unsetEnv(EnvVarProxyCachePath)
+	unsetEnv(EnvVarProxyConnectTimeout)
+	unsetEnv(EnvVarProxySocketKeepalive)
+	unsetEnv(EnvVarRateLimitBurst)
+	unsetEnv(EnvVarRateLimitRate)
+	unsetEnv(EnvVarReadinessPort)
+	unsetEnv(EnvVarReloadViaSignal)
+	unsetEnv(EnvVarResolver)
+	unsetEnv(EnvVarResolverTimeout)
+	unsetEnv(EnvVarRoutableLabelBoolean)
 	unsetEnv(EnvVarRoutableLabelSelector)
+	unsetEnv(EnvVarShutdownGracePeriod)
+	unsetEnv(EnvVarStartupRetries)
+	unsetEnv(EnvVarStartupRetryInterval)
+	unsetEnv(EnvVarStartupSettleDelay)
+	unsetEnv(EnvVarTCPNodelay)
+	unsetEnv(EnvVarTCPNopush)
+	unsetEnv(EnvVarTLSPassthroughPort)
+	unsetEnv(EnvVarTrafficStatusPort)
+	unsetEnv(EnvVarTLSPort)
+	unsetEnv(EnvVarTLSSecret)
+	unsetEnv(EnvVarUpstreamKeepalive)
+	unsetEnv(EnvVarUpstreamKeepaliveRequests)
+	unsetEnv(EnvVarUpstreamKeepaliveTime)
+	unsetEnv(EnvVarUpstreamServerOrder)
+	unsetEnv(EnvVarWarnOnDuplicateRoutes)
+	unsetEnv(EnvVarWeightByResources)
+	unsetEnv(EnvVarWorkerConnections)
+	unsetEnv(EnvVarWorkerProcesses)
 }
 
 func setEnv(t *testing.T, key, value string) {
@@ -74,16 +154,170 @@ func validateConfig(t *testing.T, desc string, expected *Config, actual *Config)
 		return fmt.Sprintf("Expected %s (%s) does not match actual %s (%s): %s\n", field, eValue, field, aValue, desc)
 	}
 
-	if expected.APIKeySecret != actual.APIKeySecret {
+	if expected.AbsoluteRedirect != actual.AbsoluteRedirect {
+		t.Fatalf(makeError("AbsoluteRedirect", strconv.FormatBool(expected.AbsoluteRedirect), strconv.FormatBool(actual.AbsoluteRedirect)))
+	} else if expected.AccessLogFormat != actual.AccessLogFormat {
+		t.Fatalf(makeError("AccessLogFormat", expected.AccessLogFormat, actual.AccessLogFormat))
+	} else if expected.AccessLogPath != actual.AccessLogPath {
+		t.Fatalf(makeError("AccessLogPath", expected.AccessLogPath, actual.AccessLogPath))
+	} else if expected.AnnotationDelimiter != actual.AnnotationDelimiter {
+		t.Fatalf(makeError("AnnotationDelimiter", expected.AnnotationDelimiter, actual.AnnotationDelimiter))
+	} else if expected.AlwaysAddHeaders != actual.AlwaysAddHeaders {
+		t.Fatalf(makeError("AlwaysAddHeaders", strconv.FormatBool(expected.AlwaysAddHeaders), strconv.FormatBool(actual.AlwaysAddHeaders)))
+	} else if expected.APIKeySecret != actual.APIKeySecret {
 		t.Fatalf(makeError("APIKeySecret", expected.APIKeySecret, actual.APIKeySecret))
 	} else if expected.APIKeySecretDataField != actual.APIKeySecretDataField {
 		t.Fatalf(makeError("APIKeySecretDataField", expected.APIKeySecretDataField, actual.APIKeySecretDataField))
+	} else if expected.BasicAuthSecretDataField != actual.BasicAuthSecretDataField {
+		t.Fatalf(makeError("BasicAuthSecretDataField", expected.BasicAuthSecretDataField, actual.BasicAuthSecretDataField))
+	} else if expected.Charset != actual.Charset {
+		t.Fatalf(makeError("Charset", expected.Charset, actual.Charset))
+	} else if expected.ClientHeaderBufferSize != actual.ClientHeaderBufferSize {
+		t.Fatalf(makeError("ClientHeaderBufferSize", expected.ClientHeaderBufferSize, actual.ClientHeaderBufferSize))
+	} else if expected.ConsolidateUpstreams != actual.ConsolidateUpstreams {
+		t.Fatalf(makeError("ConsolidateUpstreams", strconv.FormatBool(expected.ConsolidateUpstreams), strconv.FormatBool(actual.ConsolidateUpstreams)))
+	} else if expected.DefaultServerReturn != actual.DefaultServerReturn {
+		t.Fatalf(makeError("DefaultServerReturn", strconv.Itoa(expected.DefaultServerReturn), strconv.Itoa(actual.DefaultServerReturn)))
+	} else if expected.DefaultType != actual.DefaultType {
+		t.Fatalf(makeError("DefaultType", expected.DefaultType, actual.DefaultType))
+	} else if expected.DryRun != actual.DryRun {
+		t.Fatalf(makeError("DryRun", strconv.FormatBool(expected.DryRun), strconv.FormatBool(actual.DryRun)))
+	} else if fmt.Sprint(expected.ErrorPages) != fmt.Sprint(actual.ErrorPages) {
+		t.Fatalf(makeError("ErrorPages", fmt.Sprint(expected.ErrorPages), fmt.Sprint(actual.ErrorPages)))
+	} else if expected.EmptyCacheRetryAfter != actual.EmptyCacheRetryAfter {
+		t.Fatalf(makeError("EmptyCacheRetryAfter", strconv.Itoa(expected.EmptyCacheRetryAfter), strconv.Itoa(actual.EmptyCacheRetryAfter)))
+	} else if expected.EmptyCacheStatus != actual.EmptyCacheStatus {
+		t.Fatalf(makeError("EmptyCacheStatus", strconv.Itoa(expected.EmptyCacheStatus), strconv.Itoa(actual.EmptyCacheStatus)))
+	} else if expected.EmptySecretAction != actual.EmptySecretAction {
+		t.Fatalf(makeError("EmptySecretAction", expected.EmptySecretAction, actual.EmptySecretAction))
+	} else if expected.EmptyPathToRoot != actual.EmptyPathToRoot {
+		t.Fatalf(makeError("EmptyPathToRoot", strconv.FormatBool(expected.EmptyPathToRoot), strconv.FormatBool(actual.EmptyPathToRoot)))
+	} else if expected.EnableAccessLog != actual.EnableAccessLog {
+		t.Fatalf(makeError("EnableAccessLog", strconv.FormatBool(expected.EnableAccessLog), strconv.FormatBool(actual.EnableAccessLog)))
+	} else if expected.EnableDebugEndpoints != actual.EnableDebugEndpoints {
+		t.Fatalf(makeError("EnableDebugEndpoints", strconv.FormatBool(expected.EnableDebugEndpoints), strconv.FormatBool(actual.EnableDebugEndpoints)))
+	} else if expected.EnableDynamicUpstreams != actual.EnableDynamicUpstreams {
+		t.Fatalf(makeError("EnableDynamicUpstreams", strconv.FormatBool(expected.EnableDynamicUpstreams), strconv.FormatBool(actual.EnableDynamicUpstreams)))
+	} else if expected.EnableGzip != actual.EnableGzip {
+		t.Fatalf(makeError("EnableGzip", strconv.FormatBool(expected.EnableGzip), strconv.FormatBool(actual.EnableGzip)))
+	} else if expected.EnableNginxUpstreamCheckModule != actual.EnableNginxUpstreamCheckModule {
+		t.Fatalf(makeError("EnableNginxUpstreamCheckModule", strconv.FormatBool(expected.EnableNginxUpstreamCheckModule), strconv.FormatBool(actual.EnableNginxUpstreamCheckModule)))
+	} else if expected.EnableRateLimit != actual.EnableRateLimit {
+		t.Fatalf(makeError("EnableRateLimit", strconv.FormatBool(expected.EnableRateLimit), strconv.FormatBool(actual.EnableRateLimit)))
+	} else if expected.EnableTrafficStatus != actual.EnableTrafficStatus {
+		t.Fatalf(makeError("EnableTrafficStatus", strconv.FormatBool(expected.EnableTrafficStatus), strconv.FormatBool(actual.EnableTrafficStatus)))
+	} else if expected.EnableTLSPassthrough != actual.EnableTLSPassthrough {
+		t.Fatalf(makeError("EnableTLSPassthrough", strconv.FormatBool(expected.EnableTLSPassthrough), strconv.FormatBool(actual.EnableTLSPassthrough)))
+	} else if expected.ErrorLogLevel != actual.ErrorLogLevel {
+		t.Fatalf(makeError("ErrorLogLevel", expected.ErrorLogLevel, actual.ErrorLogLevel))
+	} else if expected.ErrorLogToStderr != actual.ErrorLogToStderr {
+		t.Fatalf(makeError("ErrorLogToStderr", strconv.FormatBool(expected.ErrorLogToStderr), strconv.FormatBool(actual.ErrorLogToStderr)))
+	} else if expected.FallbackBackend != actual.FallbackBackend {
+		t.Fatalf(makeError("FallbackBackend", expected.FallbackBackend, actual.FallbackBackend))
+	} else if expected.ForwardPort != actual.ForwardPort {
+		t.Fatalf(makeError("ForwardPort", strconv.FormatBool(expected.ForwardPort), strconv.FormatBool(actual.ForwardPort)))
+	} else if expected.ForwardedPort != actual.ForwardedPort {
+		t.Fatalf(makeError("ForwardedPort", strconv.Itoa(expected.ForwardedPort), strconv.Itoa(actual.ForwardedPort)))
+	} else if expected.GzipTypes != actual.GzipTypes {
+		t.Fatalf(makeError("GzipTypes", expected.GzipTypes, actual.GzipTypes))
+	} else if strings.Join(expected.HealthCheckProbes, " ") != strings.Join(actual.HealthCheckProbes, " ") {
+		t.Fatalf(makeError("HealthCheckProbes", strings.Join(expected.HealthCheckProbes, " "), strings.Join(actual.HealthCheckProbes, " ")))
+	} else if strings.Join(expected.HideBackendHeaders, " ") != strings.Join(actual.HideBackendHeaders, " ") {
+		t.Fatalf(makeError("HideBackendHeaders", strings.Join(expected.HideBackendHeaders, " "), strings.Join(actual.HideBackendHeaders, " ")))
 	} else if expected.HostsAnnotation != actual.HostsAnnotation {
 		t.Fatalf(makeError("HostsAnnotation", expected.HostsAnnotation, actual.HostsAnnotation))
+	} else if strings.Join(expected.IncludeFiles, " ") != strings.Join(actual.IncludeFiles, " ") {
+		t.Fatalf(makeError("IncludeFiles", strings.Join(expected.IncludeFiles, " "), strings.Join(actual.IncludeFiles, " ")))
+	} else if expected.LimitConnStatus != actual.LimitConnStatus {
+		t.Fatalf(makeError("LimitConnStatus", strconv.Itoa(expected.LimitConnStatus), strconv.Itoa(actual.LimitConnStatus)))
+	} else if expected.LimitReqStatus != actual.LimitReqStatus {
+		t.Fatalf(makeError("LimitReqStatus", strconv.Itoa(expected.LimitReqStatus), strconv.Itoa(actual.LimitReqStatus)))
+	} else if expected.ListenIPv6 != actual.ListenIPv6 {
+		t.Fatalf(makeError("ListenIPv6", strconv.FormatBool(expected.ListenIPv6), strconv.FormatBool(actual.ListenIPv6)))
+	} else if expected.LoadBalanceMethod != actual.LoadBalanceMethod {
+		t.Fatalf(makeError("LoadBalanceMethod", expected.LoadBalanceMethod, actual.LoadBalanceMethod))
+	} else if expected.LogFormat != actual.LogFormat {
+		t.Fatalf(makeError("LogFormat", expected.LogFormat, actual.LogFormat))
+	} else if expected.LogLevel != actual.LogLevel {
+		t.Fatalf(makeError("LogLevel", expected.LogLevel, actual.LogLevel))
+	} else if expected.MaxConnections != actual.MaxConnections {
+		t.Fatalf(makeError("MaxConnections", strconv.Itoa(expected.MaxConnections), strconv.Itoa(actual.MaxConnections)))
+	} else if expected.MaxLocationsPerHost != actual.MaxLocationsPerHost {
+		t.Fatalf(makeError("MaxLocationsPerHost", strconv.Itoa(expected.MaxLocationsPerHost), strconv.Itoa(actual.MaxLocationsPerHost)))
+	} else if expected.MaxReloadsBeforeRestart != actual.MaxReloadsBeforeRestart {
+		t.Fatalf(makeError("MaxReloadsBeforeRestart", strconv.Itoa(expected.MaxReloadsBeforeRestart), strconv.Itoa(actual.MaxReloadsBeforeRestart)))
+	} else if expected.NginxBinary != actual.NginxBinary {
+		t.Fatalf(makeError("NginxBinary", expected.NginxBinary, actual.NginxBinary))
+	} else if expected.NginxConfPath != actual.NginxConfPath {
+		t.Fatalf(makeError("NginxConfPath", expected.NginxConfPath, actual.NginxConfPath))
 	} else if expected.PathsAnnotation != actual.PathsAnnotation {
 		t.Fatalf(makeError("PathsAnnotation", expected.PathsAnnotation, actual.PathsAnnotation))
+	} else if expected.PidPath != actual.PidPath {
+		t.Fatalf(makeError("PidPath", expected.PidPath, actual.PidPath))
 	} else if expected.Port != actual.Port {
 		t.Fatalf(makeError("Port", strconv.Itoa(expected.Port), strconv.Itoa(actual.Port)))
+	} else if expected.PortInRedirect != actual.PortInRedirect {
+		t.Fatalf(makeError("PortInRedirect", strconv.FormatBool(expected.PortInRedirect), strconv.FormatBool(actual.PortInRedirect)))
+	} else if expected.PreviousAPIKeyField != actual.PreviousAPIKeyField {
+		t.Fatalf(makeError("PreviousAPIKeyField", expected.PreviousAPIKeyField, actual.PreviousAPIKeyField))
+	} else if expected.ProxyCacheMaxSize != actual.ProxyCacheMaxSize {
+		t.Fatalf(makeError("ProxyCacheMaxSize", expected.ProxyCacheMaxSize, actual.ProxyCacheMaxSize))
+	} else if expected.ProxyCachePath != actual.ProxyCachePath {
+		t.Fatalf(makeError("ProxyCachePath", expected.ProxyCachePath, actual.ProxyCachePath))
+	} else if expected.ProxyConnectTimeout != actual.ProxyConnectTimeout {
+		t.Fatalf(makeError("ProxyConnectTimeout", expected.ProxyConnectTimeout, actual.ProxyConnectTimeout))
+	} else if expected.ProxySocketKeepalive != actual.ProxySocketKeepalive {
+		t.Fatalf(makeError("ProxySocketKeepalive", strconv.FormatBool(expected.ProxySocketKeepalive), strconv.FormatBool(actual.ProxySocketKeepalive)))
+	} else if expected.RateLimitBurst != actual.RateLimitBurst {
+		t.Fatalf(makeError("RateLimitBurst", strconv.Itoa(expected.RateLimitBurst), strconv.Itoa(actual.RateLimitBurst)))
+	} else if expected.RateLimitRate != actual.RateLimitRate {
+		t.Fatalf(makeError("RateLimitRate", expected.RateLimitRate, actual.RateLimitRate))
+	} else if expected.ReadinessPort != actual.ReadinessPort {
+		t.Fatalf(makeError("ReadinessPort", strconv.Itoa(expected.ReadinessPort), strconv.Itoa(actual.ReadinessPort)))
+	} else if expected.ReloadViaSignal != actual.ReloadViaSignal {
+		t.Fatalf(makeError("ReloadViaSignal", strconv.FormatBool(expected.ReloadViaSignal), strconv.FormatBool(actual.ReloadViaSignal)))
+	} else if strings.Join(expected.Resolver, " ") != strings.Join(actual.Resolver, " ") {
+		t.Fatalf(makeError("Resolver", strings.Join(expected.Resolver, " "), strings.Join(actual.Resolver, " ")))
+	} else if expected.ResolverTimeout != actual.ResolverTimeout {
+		t.Fatalf(makeError("ResolverTimeout", expected.ResolverTimeout, actual.ResolverTimeout))
+	} else if expected.RoutableLabelBoolean != actual.RoutableLabelBoolean {
+		t.Fatalf(makeError("RoutableLabelBoolean", strconv.FormatBool(expected.RoutableLabelBoolean), strconv.FormatBool(actual.RoutableLabelBoolean)))
+	} else if expected.ShutdownGracePeriod != actual.ShutdownGracePeriod {
+		t.Fatalf(makeError("ShutdownGracePeriod", expected.ShutdownGracePeriod.String(), actual.ShutdownGracePeriod.String()))
+	} else if expected.StartupRetries != actual.StartupRetries {
+		t.Fatalf(makeError("StartupRetries", strconv.Itoa(expected.StartupRetries), strconv.Itoa(actual.StartupRetries)))
+	} else if expected.StartupRetryInterval != actual.StartupRetryInterval {
+		t.Fatalf(makeError("StartupRetryInterval", expected.StartupRetryInterval.String(), actual.StartupRetryInterval.String()))
+	} else if expected.StartupSettleDelay != actual.StartupSettleDelay {
+		t.Fatalf(makeError("StartupSettleDelay", expected.StartupSettleDelay.String(), actual.StartupSettleDelay.String()))
+	} else if expected.TCPNodelay != actual.TCPNodelay {
+		t.Fatalf(makeError("TCPNodelay", strconv.FormatBool(expected.TCPNodelay), strconv.FormatBool(actual.TCPNodelay)))
+	} else if expected.TCPNopush != actual.TCPNopush {
+		t.Fatalf(makeError("TCPNopush", strconv.FormatBool(expected.TCPNopush), strconv.FormatBool(actual.TCPNopush)))
+	} else if expected.TrafficStatusPort != actual.TrafficStatusPort {
+		t.Fatalf(makeError("TrafficStatusPort", strconv.Itoa(expected.TrafficStatusPort), strconv.Itoa(actual.TrafficStatusPort)))
+	} else if expected.TLSPassthroughPort != actual.TLSPassthroughPort {
+		t.Fatalf(makeError("TLSPassthroughPort", strconv.Itoa(expected.TLSPassthroughPort), strconv.Itoa(actual.TLSPassthroughPort)))
+	} else if expected.TLSPort != actual.TLSPort {
+		t.Fatalf(makeError("TLSPort", strconv.Itoa(expected.TLSPort), strconv.Itoa(actual.TLSPort)))
+	} else if expected.TLSSecret != actual.TLSSecret {
+		t.Fatalf(makeError("TLSSecret", expected.TLSSecret, actual.TLSSecret))
+	} else if expected.UpstreamKeepalive != actual.UpstreamKeepalive {
+		t.Fatalf(makeError("UpstreamKeepalive", strconv.Itoa(expected.UpstreamKeepalive), strconv.Itoa(actual.UpstreamKeepalive)))
+	} else if expected.UpstreamKeepaliveRequests != actual.UpstreamKeepaliveRequests {
+		t.Fatalf(makeError("UpstreamKeepaliveRequests", strconv.Itoa(expected.UpstreamKeepaliveRequests), strconv.Itoa(actual.UpstreamKeepaliveRequests)))
+	} else if expected.UpstreamKeepaliveTime != actual.UpstreamKeepaliveTime {
+		t.Fatalf(makeError("UpstreamKeepaliveTime", expected.UpstreamKeepaliveTime, actual.UpstreamKeepaliveTime))
+	} else if expected.UpstreamServerOrder != actual.UpstreamServerOrder {
+		t.Fatalf(makeError("UpstreamServerOrder", expected.UpstreamServerOrder, actual.UpstreamServerOrder))
+	} else if expected.WarnOnDuplicateRoutes != actual.WarnOnDuplicateRoutes {
+		t.Fatalf(makeError("WarnOnDuplicateRoutes", strconv.FormatBool(expected.WarnOnDuplicateRoutes), strconv.FormatBool(actual.WarnOnDuplicateRoutes)))
+	} else if expected.WeightByResources != actual.WeightByResources {
+		t.Fatalf(makeError("WeightByResources", strconv.FormatBool(expected.WeightByResources), strconv.FormatBool(actual.WeightByResources)))
+	} else if expected.WorkerConnections != actual.WorkerConnections {
+		t.Fatalf(makeError("WorkerConnections", strconv.Itoa(expected.WorkerConnections), strconv.Itoa(actual.WorkerConnections)))
+	} else if expected.WorkerProcesses != actual.WorkerProcesses {
+		t.Fatalf(makeError("WorkerProcesses", expected.WorkerProcesses, actual.WorkerProcesses))
 	} else if expected.RoutableLabelSelector.String() != actual.RoutableLabelSelector.String() {
 		t.Fatalf(makeError("RoutableLabelSelector", expected.RoutableLabelSelector.String(), actual.RoutableLabelSelector.String()))
 	}
@@ -94,12 +328,81 @@ Test for github.com/30x/k8s-router/router/config#ConfigFromEnv using the default
 */
 func TestConfigFromEnvDefaultConfig(t *testing.T) {
 	validateConfig(t, "default configuration", getConfig(t), &Config{
-		APIKeySecret:          DefaultAPIKeySecret,
-		APIKeySecretDataField: DefaultAPIKeySecretDataField,
-		HostsAnnotation:       DefaultHostsAnnotation,
-		PathsAnnotation:       DefaultPathsAnnotation,
-		Port:                  DefaultPort,
-		RoutableLabelSelector: getLabelSelector(t, DefaultRoutableLabelSelector),
+		AbsoluteRedirect:               DefaultAbsoluteRedirect,
+		AccessLogFormat:                DefaultAccessLogFormat,
+		AccessLogPath:                  DefaultAccessLogPath,
+		AnnotationDelimiter:            DefaultAnnotationDelimiter,
+		AlwaysAddHeaders:               DefaultAlwaysAddHeaders,
+		APIKeySecret:                   DefaultAPIKeySecret,
+		APIKeySecretDataField:          DefaultAPIKeySecretDataField,
+		BasicAuthSecretDataField:       DefaultBasicAuthSecretDataField,
+		Charset:                        DefaultCharset,
+		ClientHeaderBufferSize:         DefaultClientHeaderBufferSize,
+		ConsolidateUpstreams:           DefaultConsolidateUpstreams,
+		EmptyCacheRetryAfter:           DefaultEmptyCacheRetryAfter,
+		EmptyCacheStatus:               DefaultEmptyCacheStatus,
+		DefaultServerReturn:            DefaultDefaultServerReturn,
+		DefaultType:                    DefaultDefaultType,
+		DryRun:                         DefaultDryRun,
+		ErrorPages:                     map[int]string{},
+		EmptyPathToRoot:                DefaultEmptyPathToRoot,
+		EmptySecretAction:              DefaultEmptySecretAction,
+		EnableAccessLog:                DefaultEnableAccessLog,
+		EnableDebugEndpoints:           DefaultEnableDebugEndpoints,
+		EnableDynamicUpstreams:         DefaultEnableDynamicUpstreams,
+		EnableGzip:                     DefaultEnableGzip,
+		EnableNginxUpstreamCheckModule: DefaultEnableNginxUpstreamCheckModule,
+		EnableRateLimit:                DefaultEnableRateLimit,
+		EnableTLSPassthrough:           DefaultEnableTLSPassthrough,
+		EnableTrafficStatus:            DefaultEnableTrafficStatus,
+		ErrorLogLevel:                  DefaultErrorLogLevel,
+		ErrorLogToStderr:               DefaultErrorLogToStderr,
+		ForwardPort:                    DefaultForwardPort,
+		ForwardedPort:                  DefaultForwardedPort,
+		GzipTypes:                      DefaultGzipTypes,
+		HealthCheckProbes:              []string{HealthCheckProbeReadiness, HealthCheckProbeLiveness},
+		HideBackendHeaders:             []string{DefaultHideBackendHeaders},
+		HostsAnnotation:                DefaultHostsAnnotation,
+		LimitConnStatus:                DefaultLimitConnStatus,
+		LimitReqStatus:                 DefaultLimitReqStatus,
+		ListenIPv6:                     DefaultListenIPv6,
+		LoadBalanceMethod:              DefaultLoadBalanceMethod,
+		LogFormat:                      DefaultLogFormat,
+		LogLevel:                       DefaultLogLevel,
+		MaxConnections:                 DefaultMaxConnections,
+		MaxLocationsPerHost:            DefaultMaxLocationsPerHost,
+		MaxReloadsBeforeRestart:        DefaultMaxReloadsBeforeRestart,
+		NginxBinary:                    DefaultNginxBinary,
+		NginxConfPath:                  DefaultNginxConfPath,
+		PathsAnnotation:                DefaultPathsAnnotation,
+		PidPath:                        DefaultPidPath,
+		Port:                           DefaultPort,
+		PortInRedirect:                 DefaultPortInRedirect,
+		ProxyCacheMaxSize:              DefaultProxyCacheMaxSize,
+		ProxyCachePath:                 DefaultProxyCachePath,
+		ProxyConnectTimeout:            DefaultProxyConnectTimeout,
+		ProxySocketKeepalive:           DefaultProxySocketKeepalive,
+		RateLimitBurst:                 DefaultRateLimitBurst,
+		RateLimitRate:                  DefaultRateLimitRate,
+		ReadinessPort:                  DefaultReadinessPort,
+		ReloadViaSignal:                DefaultReloadViaSignal,
+		RoutableLabelBoolean:           DefaultRoutableLabelBoolean,
+		RoutableLabelSelector:          getLabelSelector(t, DefaultRoutableLabelSelector),
+		ShutdownGracePeriod:            DefaultShutdownGracePeriod,
+		StartupRetries:                 DefaultStartupRetries,
+		StartupRetryInterval:           DefaultStartupRetryInterval,
+		StartupSettleDelay:             DefaultStartupSettleDelay,
+		TCPNodelay:                     DefaultTCPNodelay,
+		TCPNopush:                      DefaultTCPNopush,
+		TLSPassthroughPort:             DefaultTLSPassthroughPort,
+		TrafficStatusPort:              DefaultTrafficStatusPort,
+		TLSPort:                        DefaultTLSPort,
+		UpstreamKeepalive:              DefaultUpstreamKeepalive,
+		UpstreamKeepaliveRequests:      DefaultUpstreamKeepaliveRequests,
+		UpstreamServerOrder:            DefaultUpstreamServerOrder,
+		WarnOnDuplicateRoutes:          DefaultWarnOnDuplicateRoutes,
+		WeightByResources:              DefaultWeightByResources,
+		WorkerConnections:              DefaultWorkerConnections,
 	})
 }
 
@@ -122,6 +425,26 @@ func TestConfigFromEnvInvalidEnv(t *testing.T) {
 	// Reset the environment variables just in case
 	resetEnv(t)
 
+	// Invalid absolute redirect
+	setEnv(t, EnvVarAbsoluteRedirect, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarAbsoluteRedirect, invalidName))
+
+	// Invalid access log format
+	setEnv(t, EnvVarAccessLogFormat, "main")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidAccessLogFormat, EnvVarAccessLogFormat, "main"))
+
+	// Invalid access log path
+	setEnv(t, EnvVarAccessLogPath, "logs/access.log")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidAccessLogPath, EnvVarAccessLogPath, "logs/access.log"))
+
+	// Invalid annotation delimiter (valid in paths)
+	setEnv(t, EnvVarAnnotationDelimiter, "/")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidAnnotationDelimiter, EnvVarAnnotationDelimiter, "/"))
+
 	// Invalid API Key Secret location
 	setEnv(t, EnvVarAPIKeySecretLocation, "routing")
 
@@ -139,6 +462,250 @@ func TestConfigFromEnvInvalidEnv(t *testing.T) {
 
 	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidAnnotationName, EnvVarPathsAnnotation, invalidName))
 
+	// Invalid empty cache status
+	setEnv(t, EnvVarEmptyCacheStatus, "444")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidStatus, EnvVarEmptyCacheStatus, "444"))
+
+	// Invalid default server return
+	setEnv(t, EnvVarDefaultServerReturn, "199")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidReturnStatus, EnvVarDefaultServerReturn, "199"))
+
+	setEnv(t, EnvVarDefaultServerReturn, "600")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidReturnStatus, EnvVarDefaultServerReturn, "600"))
+
+	// Invalid dry run
+	setEnv(t, EnvVarDryRun, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarDryRun, invalidName))
+
+	// Invalid error pages (missing URI, relative URI and non-error status)
+	setEnv(t, EnvVarErrorPages, "404")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidErrorPage, EnvVarErrorPages, "404"))
+
+	setEnv(t, EnvVarErrorPages, "404=errors/404.html")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidErrorPage, EnvVarErrorPages, "404=errors/404.html"))
+
+	setEnv(t, EnvVarErrorPages, "502=/errors/502.html 302=/errors/302.html")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidErrorPage, EnvVarErrorPages, "302=/errors/302.html"))
+
+	// Invalid empty cache retry after
+	setEnv(t, EnvVarEmptyCacheRetryAfter, "-1")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCount, EnvVarEmptyCacheRetryAfter, "-1"))
+
+	// Invalid empty path to root
+	setEnv(t, EnvVarEmptyPathToRoot, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEmptyPathToRoot, invalidName))
+
+	// Invalid empty secret action
+	setEnv(t, EnvVarEmptySecretAction, "ignore")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidEmptySecretAction, EnvVarEmptySecretAction, "ignore"))
+
+	// Invalid client header buffer size
+	setEnv(t, EnvVarClientHeaderBufferSize, "0k")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidSize, EnvVarClientHeaderBufferSize, "0k"))
+
+	setEnv(t, EnvVarClientHeaderBufferSize, "1g")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidSize, EnvVarClientHeaderBufferSize, "1g"))
+
+	// Invalid consolidate upstreams
+	setEnv(t, EnvVarConsolidateUpstreams, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarConsolidateUpstreams, invalidName))
+
+	// Invalid enable access log
+	setEnv(t, EnvVarEnableAccessLog, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableAccessLog, invalidName))
+
+	// Invalid enable debug endpoints
+	setEnv(t, EnvVarEnableDebugEndpoints, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableDebugEndpoints, invalidName))
+
+	// Invalid enable dynamic upstreams
+	setEnv(t, EnvVarEnableDynamicUpstreams, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableDynamicUpstreams, invalidName))
+
+	// Invalid enable gzip
+	setEnv(t, EnvVarEnableGzip, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableGzip, invalidName))
+
+	// Invalid forward port
+	setEnv(t, EnvVarForwardPort, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarForwardPort, invalidName))
+
+	// Invalid forwarded port
+	setEnv(t, EnvVarForwardedPort, "0")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPort, EnvVarForwardedPort, "0"))
+
+	// Invalid gzip types
+	setEnv(t, EnvVarGzipTypes, "application/json text/html;")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidGzipType, EnvVarGzipTypes, "text/html;"))
+
+	// Invalid default type
+	setEnv(t, EnvVarDefaultType, "text/*")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidDefaultType, EnvVarDefaultType, "text/*"))
+
+	setEnv(t, EnvVarDefaultType, "text/plain; charset=utf-8")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidDefaultType, EnvVarDefaultType, "text/plain; charset=utf-8"))
+
+	// Invalid charset
+	setEnv(t, EnvVarCharset, "utf 8")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCharset, EnvVarCharset, "utf 8"))
+
+	// Invalid enable nginx upstream check module
+	setEnv(t, EnvVarEnableNginxUpstreamCheckModule, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableNginxUpstreamCheckModule, invalidName))
+
+	// Invalid enable rate limit
+	setEnv(t, EnvVarEnableRateLimit, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableRateLimit, invalidName))
+
+	// Invalid rate limit rates
+	for _, rate := range []string{"10", "10r", "10r/h", "0r/s", "r/s", "-1r/s", "10 r/s"} {
+		setEnv(t, EnvVarRateLimitRate, rate)
+
+		validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidRate, EnvVarRateLimitRate, rate))
+	}
+
+	// Invalid rate limit burst
+	setEnv(t, EnvVarRateLimitBurst, "-1")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCount, EnvVarRateLimitBurst, "-1"))
+
+	// Invalid enable TLS passthrough
+	setEnv(t, EnvVarEnableTLSPassthrough, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableTLSPassthrough, invalidName))
+
+	// Invalid error log to stderr
+	setEnv(t, EnvVarErrorLogToStderr, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarErrorLogToStderr, invalidName))
+
+	// Invalid error log level
+	setEnv(t, EnvVarErrorLogLevel, "verbose")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidErrorLogLevel, EnvVarErrorLogLevel, "verbose"))
+
+	// Invalid fallback backend (missing port)
+	setEnv(t, EnvVarFallbackBackend, "maintenance.example.com")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidFallbackBackend, EnvVarFallbackBackend, "maintenance.example.com"))
+
+	// Invalid fallback backend (invalid port)
+	setEnv(t, EnvVarFallbackBackend, "maintenance.example.com:0")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidFallbackBackend, EnvVarFallbackBackend, "maintenance.example.com:0"))
+
+	// Invalid health check probes (unknown probe)
+	setEnv(t, EnvVarHealthCheckProbes, "startup")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidHealthCheckProbes, EnvVarHealthCheckProbes, "startup"))
+
+	// Invalid health check probes (duplicate probe)
+	setEnv(t, EnvVarHealthCheckProbes, "liveness liveness")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidHealthCheckProbes, EnvVarHealthCheckProbes, "liveness liveness"))
+
+	// Invalid hide backend headers
+	setEnv(t, EnvVarHideBackendHeaders, "X-Powered-By X-Bad;Header")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidHeaderName, EnvVarHideBackendHeaders, "X-Bad;Header"))
+
+	// Invalid include files (relative path)
+	setEnv(t, EnvVarIncludeFiles, "/etc/nginx/conf.d/*.conf conf.d/*.conf")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidIncludeFile, EnvVarIncludeFiles, "conf.d/*.conf"))
+
+	// Invalid include files (directive injection)
+	setEnv(t, EnvVarIncludeFiles, "/etc/nginx/extra.conf;")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidIncludeFile, EnvVarIncludeFiles, "/etc/nginx/extra.conf;"))
+
+	// Invalid limit conn status (not a number)
+	setEnv(t, EnvVarLimitConnStatus, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidStatus, EnvVarLimitConnStatus, invalidName))
+
+	// Invalid limit req status (not an error status code)
+	setEnv(t, EnvVarLimitReqStatus, "200")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidStatus, EnvVarLimitReqStatus, "200"))
+
+	// Invalid listen IPv6
+	setEnv(t, EnvVarListenIPv6, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarListenIPv6, invalidName))
+
+	// Invalid load balance method
+	setEnv(t, EnvVarLoadBalanceMethod, "random")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidLoadBalanceMethod, EnvVarLoadBalanceMethod, "random"))
+
+	// Invalid log format
+	setEnv(t, EnvVarLogFormat, "xml")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidLogFormat, EnvVarLogFormat, "xml"))
+
+	// Invalid log level
+	setEnv(t, EnvVarLogLevel, "trace")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidLogLevel, EnvVarLogLevel, "trace"))
+
+	// Invalid max connections
+	setEnv(t, EnvVarMaxConnections, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidMaxConnections, EnvVarMaxConnections, invalidName))
+
+	// Invalid max locations per host
+	setEnv(t, EnvVarMaxLocationsPerHost, "-1")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCount, EnvVarMaxLocationsPerHost, "-1"))
+
+	// Invalid max reloads before restart
+	setEnv(t, EnvVarMaxReloadsBeforeRestart, "-1")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCount, EnvVarMaxReloadsBeforeRestart, "-1"))
+
+	// Invalid nginx binary
+	setEnv(t, EnvVarNginxBinary, "nginx; rm -rf /")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidNginxBinary, EnvVarNginxBinary, "nginx; rm -rf /"))
+
+	// Invalid nginx configuration path (relative)
+	setEnv(t, EnvVarNginxConfPath, "nginx.conf")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidNginxConfPath, EnvVarNginxConfPath, "nginx.conf"))
+
+	// Invalid not found backend
+	invalidBackend := "not-found"
+
+	setEnv(t, EnvVarNotFoundBackend, invalidBackend)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidNotFoundBackend, EnvVarNotFoundBackend, invalidBackend))
+
 	// Invalid port (not a number)
 	setEnv(t, EnvVarPort, invalidName)
 
@@ -151,6 +718,219 @@ func TestConfigFromEnvInvalidEnv(t *testing.T) {
 
 	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPort, EnvVarPort, invalidPort))
 
+	// Invalid TLS passthrough port
+	setEnv(t, EnvVarTLSPassthroughPort, invalidPort)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPort, EnvVarTLSPassthroughPort, invalidPort))
+
+	// Invalid TLS passthrough port (same as the port)
+	setEnv(t, EnvVarEnableRateLimit, "true")
+	setEnv(t, EnvVarEnableTLSPassthrough, "true")
+	setEnv(t, EnvVarTLSPassthroughPort, "80")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarTLSPassthroughPort, EnvVarPort, 80))
+
+	// Invalid TLS secret
+	setEnv(t, EnvVarTLSSecret, "Not_Valid")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidTLSSecret, EnvVarTLSSecret, "Not_Valid"))
+
+	// Invalid TLS secret (same as the API Key secret)
+	setEnv(t, EnvVarTLSSecret, DefaultAPIKeySecret)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidTLSSecret, EnvVarTLSSecret, DefaultAPIKeySecret))
+
+	// Invalid TLS port
+	setEnv(t, EnvVarTLSPort, invalidPort)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPort, EnvVarTLSPort, invalidPort))
+
+	// Invalid TLS port (same as the port)
+	setEnv(t, EnvVarTLSSecret, "routing-tls")
+	setEnv(t, EnvVarTLSPort, "80")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarTLSPort, EnvVarPort, 80))
+
+	// Invalid TLS port (same as the TLS passthrough port)
+	setEnv(t, EnvVarEnableTLSPassthrough, "true")
+	setEnv(t, EnvVarTLSPassthroughPort, "8443")
+	setEnv(t, EnvVarTLSSecret, "routing-tls")
+	setEnv(t, EnvVarTLSPort, "8443")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarTLSPort, EnvVarTLSPassthroughPort, 8443))
+
+	// Invalid readiness port
+	setEnv(t, EnvVarReadinessPort, invalidPort)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPort, EnvVarReadinessPort, invalidPort))
+
+	// Invalid readiness port (same as the port)
+	setEnv(t, EnvVarReadinessPort, "80")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarReadinessPort, EnvVarPort, 80))
+
+	// Invalid readiness port (same as the TLS passthrough port)
+	setEnv(t, EnvVarEnableTLSPassthrough, "true")
+	setEnv(t, EnvVarReadinessPort, "443")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarReadinessPort, EnvVarTLSPassthroughPort, 443))
+
+	// Invalid readiness port (same as the TLS port)
+	setEnv(t, EnvVarTLSSecret, "routing-tls")
+	setEnv(t, EnvVarReadinessPort, "443")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarReadinessPort, EnvVarTLSPort, 443))
+
+	// Invalid enable traffic status
+	setEnv(t, EnvVarEnableTrafficStatus, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarEnableTrafficStatus, invalidName))
+
+	// Invalid traffic status port
+	setEnv(t, EnvVarTrafficStatusPort, invalidPort)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPort, EnvVarTrafficStatusPort, invalidPort))
+
+	// Invalid traffic status port (same as the readiness port)
+	setEnv(t, EnvVarEnableTrafficStatus, "true")
+	setEnv(t, EnvVarReadinessPort, "9000")
+	setEnv(t, EnvVarTrafficStatusPort, "9000")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplPortConflict, EnvVarTrafficStatusPort, EnvVarReadinessPort, 9000))
+
+	// Invalid previous API Key field (same as the API Key field)
+	setEnv(t, EnvVarPreviousAPIKeyField, DefaultAPIKeySecretDataField)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidPreviousAPIKeyField, EnvVarPreviousAPIKeyField, DefaultAPIKeySecretDataField))
+
+	// Invalid proxy cache path (relative)
+	setEnv(t, EnvVarProxyCachePath, "cache/nginx")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidProxyCachePath, EnvVarProxyCachePath, "cache/nginx"))
+
+	// Invalid proxy cache max size
+	setEnv(t, EnvVarProxyCacheMaxSize, "1 gig")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidSize, EnvVarProxyCacheMaxSize, "1 gig"))
+
+	// Invalid proxy connect timeout
+	setEnv(t, EnvVarProxyConnectTimeout, "2 seconds")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidTime, EnvVarProxyConnectTimeout, "2 seconds"))
+
+	// Invalid resolver (invalid port)
+	setEnv(t, EnvVarResolver, "10.96.0.10 kube-dns.kube-system:0")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidResolver, EnvVarResolver, "kube-dns.kube-system:0"))
+
+	// Invalid resolver (invalid host)
+	setEnv(t, EnvVarResolver, "10.96.0.10;")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidResolver, EnvVarResolver, "10.96.0.10;"))
+
+	// Invalid resolver timeout
+	setEnv(t, EnvVarResolverTimeout, "5 seconds")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidTime, EnvVarResolverTimeout, "5 seconds"))
+
+	// Invalid shutdown grace period
+	setEnv(t, EnvVarShutdownGracePeriod, "-1s")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidDelay, EnvVarShutdownGracePeriod, "-1s"))
+
+	// Invalid always add headers
+	setEnv(t, EnvVarAlwaysAddHeaders, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarAlwaysAddHeaders, invalidName))
+
+	// Invalid proxy socket keepalive
+	setEnv(t, EnvVarProxySocketKeepalive, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarProxySocketKeepalive, invalidName))
+
+	// Invalid startup retries
+	setEnv(t, EnvVarStartupRetries, "-1")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidRetries, EnvVarStartupRetries, "-1"))
+
+	// Invalid startup retry interval
+	setEnv(t, EnvVarStartupRetryInterval, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidDuration, EnvVarStartupRetryInterval, invalidName))
+
+	// Invalid startup settle delay
+	setEnv(t, EnvVarStartupSettleDelay, "-1s")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidDelay, EnvVarStartupSettleDelay, "-1s"))
+
+	// Invalid tcp nodelay
+	setEnv(t, EnvVarTCPNodelay, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarTCPNodelay, invalidName))
+
+	// Invalid tcp nopush
+	setEnv(t, EnvVarTCPNopush, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarTCPNopush, invalidName))
+
+	// Invalid reload via signal
+	setEnv(t, EnvVarReloadViaSignal, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarReloadViaSignal, invalidName))
+
+	// Invalid upstream keepalive
+	setEnv(t, EnvVarUpstreamKeepalive, "-1")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCount, EnvVarUpstreamKeepalive, "-1"))
+
+	// Invalid upstream keepalive requests
+	setEnv(t, EnvVarUpstreamKeepaliveRequests, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidCount, EnvVarUpstreamKeepaliveRequests, invalidName))
+
+	// Invalid upstream keepalive time
+	setEnv(t, EnvVarUpstreamKeepaliveTime, "1 hour")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidTime, EnvVarUpstreamKeepaliveTime, "1 hour"))
+
+	// Invalid upstream server order
+	setEnv(t, EnvVarUpstreamServerOrder, "random")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidUpstreamServerOrder, EnvVarUpstreamServerOrder, "random"))
+
+	// Invalid warn on duplicate routes
+	setEnv(t, EnvVarWarnOnDuplicateRoutes, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarWarnOnDuplicateRoutes, invalidName))
+
+	// Invalid weight by resources
+	setEnv(t, EnvVarWeightByResources, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarWeightByResources, invalidName))
+
+	// Invalid worker connections
+	for _, workerConnections := range []string{"0", "-1", "many"} {
+		setEnv(t, EnvVarWorkerConnections, workerConnections)
+
+		validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidWorkerConnections, EnvVarWorkerConnections, workerConnections))
+	}
+
+	// Worker connections along with max connections
+	setEnv(t, EnvVarMaxConnections, "4096")
+	setEnv(t, EnvVarWorkerConnections, "2048")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplMutuallyExclusive, EnvVarWorkerConnections, EnvVarMaxConnections))
+
+	// Invalid worker processes
+	setEnv(t, EnvVarWorkerProcesses, "0")
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidWorkerProcesses, EnvVarWorkerProcesses, "0"))
+
+	// Invalid routable label boolean
+	setEnv(t, EnvVarRoutableLabelBoolean, invalidName)
+
+	validateInvalidConfig(fmt.Sprintf(ErrMsgTmplInvalidBoolean, EnvVarRoutableLabelBoolean, invalidName))
+
 	// Invalid routable label selector
 	setEnv(t, EnvVarRoutableLabelSelector, invalidName)
 
@@ -170,18 +950,250 @@ func TestConfigFromEnvValidConfig(t *testing.T) {
 	secretName := "custom"
 	secretDataField := "another-custom"
 
+	setEnv(t, EnvVarAbsoluteRedirect, "false")
+	setEnv(t, EnvVarAccessLogFormat, AccessLogFormatTiming)
+	setEnv(t, EnvVarAccessLogPath, "/dev/stdout")
+	setEnv(t, EnvVarAlwaysAddHeaders, "false")
+	setEnv(t, EnvVarAnnotationDelimiter, ",")
 	setEnv(t, EnvVarAPIKeySecretLocation, secretName+":"+secretDataField)
+	setEnv(t, EnvVarBasicAuthSecretDataField, "credentials")
+	setEnv(t, EnvVarCharset, "utf-8")
+	setEnv(t, EnvVarClientHeaderBufferSize, "4k")
+	setEnv(t, EnvVarConsolidateUpstreams, "false")
+	setEnv(t, EnvVarEmptyCacheRetryAfter, "30")
+	setEnv(t, EnvVarEmptyCacheStatus, "503")
+	setEnv(t, EnvVarDefaultServerReturn, "200")
+	setEnv(t, EnvVarDefaultType, "text/plain")
+	setEnv(t, EnvVarDryRun, "true")
+	setEnv(t, EnvVarErrorPages, "404=/errors/404.html 502=https://errors.example.com/502.html 503=https://errors.example.com/503.html")
+	setEnv(t, EnvVarEmptyPathToRoot, "true")
+	setEnv(t, EnvVarEmptySecretAction, "allow")
+	setEnv(t, EnvVarEnableDebugEndpoints, "true")
+	setEnv(t, EnvVarEnableDynamicUpstreams, "true")
+	setEnv(t, EnvVarEnableGzip, "true")
+	setEnv(t, EnvVarEnableNginxUpstreamCheckModule, "true")
+	setEnv(t, EnvVarEnableTLSPassthrough, "true")
+	setEnv(t, EnvVarErrorLogLevel, "warn")
+	setEnv(t, EnvVarErrorLogToStderr, "true")
+	setEnv(t, EnvVarFallbackBackend, "maintenance.example.com:8080")
+	setEnv(t, EnvVarForwardPort, "true")
+	setEnv(t, EnvVarForwardedPort, "8443")
+	setEnv(t, EnvVarGzipTypes, "application/json  text/xml")
+	setEnv(t, EnvVarHealthCheckProbes, "liveness")
+	setEnv(t, EnvVarHideBackendHeaders, "X-Powered-By X-AspNet-Version")
 	setEnv(t, EnvVarHostsAnnotation, hostsAnnotation)
+	setEnv(t, EnvVarIncludeFiles, "/etc/nginx/conf.d/*.conf /etc/nginx/geo.conf")
+	setEnv(t, EnvVarLimitConnStatus, "503")
+	setEnv(t, EnvVarLimitReqStatus, "503")
+	setEnv(t, EnvVarListenIPv6, "true")
+	setEnv(t, EnvVarLoadBalanceMethod, "least_conn")
+	setEnv(t, EnvVarLogFormat, "json")
+	setEnv(t, EnvVarLogLevel, "debug")
+	setEnv(t, EnvVarMaxConnections, "4096")
+	setEnv(t, EnvVarMaxLocationsPerHost, "100")
+	setEnv(t, EnvVarMaxReloadsBeforeRestart, "50")
+	setEnv(t, EnvVarNginxBinary, "/usr/local/openresty/nginx/sbin/nginx")
+	setEnv(t, EnvVarNginxConfPath, "/usr/local/openresty/nginx/conf/nginx.conf")
 	setEnv(t, EnvVarPathsAnnotation, pathsAnnotation)
+	setEnv(t, EnvVarPidPath, "/run/nginx.pid")
 	setEnv(t, EnvVarPort, port)
+	setEnv(t, EnvVarPortInRedirect, "false")
+	setEnv(t, EnvVarPreviousAPIKeyField, "api-key-previous")
+	setEnv(t, EnvVarProxyCacheMaxSize, "512m")
+	setEnv(t, EnvVarProxyCachePath, "/var/cache/nginx/router")
+	setEnv(t, EnvVarProxyConnectTimeout, "500ms")
+	setEnv(t, EnvVarProxySocketKeepalive, "true")
+	setEnv(t, EnvVarRateLimitBurst, "50")
+	setEnv(t, EnvVarRateLimitRate, "600r/m")
+	setEnv(t, EnvVarReadinessPort, "8181")
+	setEnv(t, EnvVarReloadViaSignal, "true")
+	setEnv(t, EnvVarResolver, "10.96.0.10 kube-dns.kube-system:5353")
+	setEnv(t, EnvVarResolverTimeout, "5s")
+	setEnv(t, EnvVarRoutableLabelBoolean, "true")
 	setEnv(t, EnvVarRoutableLabelSelector, routableLabelSelector)
+	setEnv(t, EnvVarShutdownGracePeriod, "15s")
+	setEnv(t, EnvVarStartupRetries, "5")
+	setEnv(t, EnvVarStartupRetryInterval, "500ms")
+	setEnv(t, EnvVarStartupSettleDelay, "10s")
+	setEnv(t, EnvVarEnableTrafficStatus, "true")
+	setEnv(t, EnvVarTrafficStatusPort, "9091")
+	setEnv(t, EnvVarTCPNodelay, "false")
+	setEnv(t, EnvVarTCPNopush, "true")
+	setEnv(t, EnvVarTLSPassthroughPort, "8443")
+	setEnv(t, EnvVarTLSPort, "4443")
+	setEnv(t, EnvVarTLSSecret, "routing-tls")
+	setEnv(t, EnvVarUpstreamKeepalive, "32")
+	setEnv(t, EnvVarUpstreamKeepaliveRequests, "10000")
+	setEnv(t, EnvVarUpstreamKeepaliveTime, "1h")
+	setEnv(t, EnvVarUpstreamServerOrder, UpstreamServerOrderInsertionStable)
+	setEnv(t, EnvVarWarnOnDuplicateRoutes, "false")
+	setEnv(t, EnvVarWeightByResources, "true")
+	setEnv(t, EnvVarWorkerProcesses, "auto")
 
 	validateConfig(t, "default configuration", getConfig(t), &Config{
-		APIKeySecret:          secretName,
-		APIKeySecretDataField: secretDataField,
-		HostsAnnotation:       hostsAnnotation,
-		PathsAnnotation:       pathsAnnotation,
-		Port:                  81,
-		RoutableLabelSelector: getLabelSelector(t, routableLabelSelector),
+		AbsoluteRedirect:               false,
+		AccessLogFormat:                AccessLogFormatTiming,
+		AccessLogPath:                  "/dev/stdout",
+		AlwaysAddHeaders:               false,
+		AnnotationDelimiter:            ",",
+		APIKeySecret:                   secretName,
+		APIKeySecretDataField:          secretDataField,
+		BasicAuthSecretDataField:       "credentials",
+		Charset:                        "utf-8",
+		ClientHeaderBufferSize:         "4k",
+		ConsolidateUpstreams:           false,
+		EmptyCacheRetryAfter:           30,
+		EmptyCacheStatus:               503,
+		DefaultServerReturn:            200,
+		DefaultType:                    "text/plain",
+		DryRun:                         true,
+		ErrorPages:                     map[int]string{404: "/errors/404.html", 502: "https://errors.example.com/502.html", 503: "https://errors.example.com/503.html"},
+		EmptyPathToRoot:                true,
+		EmptySecretAction:              EmptySecretActionAllow,
+		EnableAccessLog:                true,
+		EnableDebugEndpoints:           true,
+		EnableDynamicUpstreams:         true,
+		EnableGzip:                     true,
+		EnableNginxUpstreamCheckModule: true,
+		EnableRateLimit:                true,
+		EnableTLSPassthrough:           true,
+		ErrorLogLevel:                  "warn",
+		ErrorLogToStderr:               true,
+		FallbackBackend:                "maintenance.example.com:8080",
+		ForwardPort:                    true,
+		ForwardedPort:                  8443,
+		GzipTypes:                      "application/json text/xml",
+		HealthCheckProbes:              []string{HealthCheckProbeLiveness},
+		HideBackendHeaders:             []string{"X-Powered-By", "X-AspNet-Version"},
+		HostsAnnotation:                hostsAnnotation,
+		IncludeFiles:                   []string{"/etc/nginx/conf.d/*.conf", "/etc/nginx/geo.conf"},
+		LimitConnStatus:                503,
+		LimitReqStatus:                 503,
+		ListenIPv6:                     true,
+		LoadBalanceMethod:              LoadBalanceMethodLeastConn,
+		LogFormat:                      "json",
+		LogLevel:                       "debug",
+		MaxConnections:                 4096,
+		MaxLocationsPerHost:            100,
+		MaxReloadsBeforeRestart:        50,
+		NginxBinary:                    "/usr/local/openresty/nginx/sbin/nginx",
+		NginxConfPath:                  "/usr/local/openresty/nginx/conf/nginx.conf",
+		PathsAnnotation:                pathsAnnotation,
+		PidPath:                        "/run/nginx.pid",
+		Port:                           81,
+		PortInRedirect:                 false,
+		PreviousAPIKeyField:            "api-key-previous",
+		ProxyCacheMaxSize:              "512m",
+		ProxyCachePath:                 "/var/cache/nginx/router",
+		ProxyConnectTimeout:            "500ms",
+		ProxySocketKeepalive:           true,
+		RateLimitBurst:                 50,
+		RateLimitRate:                  "600r/m",
+		ReadinessPort:                  8181,
+		ReloadViaSignal:                true,
+		Resolver:                       []string{"10.96.0.10", "kube-dns.kube-system:5353"},
+		ResolverTimeout:                "5s",
+		RoutableLabelBoolean:           true,
+		RoutableLabelSelector:          getLabelSelector(t, routableLabelSelector),
+		ShutdownGracePeriod:            15 * time.Second,
+		StartupRetries:                 5,
+		StartupRetryInterval:           500 * time.Millisecond,
+		StartupSettleDelay:             10 * time.Second,
+		TCPNodelay:                     false,
+		TCPNopush:                      true,
+		TLSPassthroughPort:             8443,
+		EnableTrafficStatus:            true,
+		TrafficStatusPort:              9091,
+		TLSPort:                        4443,
+		TLSSecret:                      "routing-tls",
+		UpstreamKeepalive:              32,
+		UpstreamKeepaliveRequests:      10000,
+		UpstreamKeepaliveTime:          "1h",
+		UpstreamServerOrder:            UpstreamServerOrderInsertionStable,
+		WarnOnDuplicateRoutes:          false,
+		WeightByResources:              true,
+		WorkerConnections:              DefaultWorkerConnections,
+		WorkerProcesses:                "auto",
 	})
 }
+
+/*
+Test for github.com/30x/k8s-router/router/config#ConfigFromEnv with an empty HIDE_BACKEND_HEADERS
+*/
+func TestConfigFromEnvEmptyHideBackendHeaders(t *testing.T) {
+	resetEnv(t)
+
+	defer resetEnv(t)
+
+	setEnv(t, EnvVarHideBackendHeaders, "")
+
+	if config := getConfig(t); len(config.HideBackendHeaders) != 0 {
+		t.Fatalf("An empty %s should hide no headers: %v", EnvVarHideBackendHeaders, config.HideBackendHeaders)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/config#ConfigFromEnv with WORKER_CONNECTIONS
+*/
+func TestConfigFromEnvWorkerConnections(t *testing.T) {
+	resetEnv(t)
+
+	defer resetEnv(t)
+
+	setEnv(t, EnvVarWorkerConnections, "4096")
+
+	if config := getConfig(t); config.WorkerConnections != 4096 {
+		t.Fatalf("Expected %s to be 4096 but found %d", EnvVarWorkerConnections, config.WorkerConnections)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/config#ConfigFromEnv disabling the readiness server
+*/
+func TestConfigFromEnvDisabledReadinessPort(t *testing.T) {
+	resetEnv(t)
+
+	defer resetEnv(t)
+
+	setEnv(t, EnvVarReadinessPort, "0")
+
+	if config := getConfig(t); config.ReadinessPort != 0 {
+		t.Fatalf("Expected %s to be 0 but found %d", EnvVarReadinessPort, config.ReadinessPort)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/config#ConfigFromEnv enabling the access log
+*/
+func TestConfigFromEnvAccessLog(t *testing.T) {
+	resetEnv(t)
+
+	defer resetEnv(t)
+
+	// The upstream format is used by default
+	setEnv(t, EnvVarEnableAccessLog, "true")
+
+	if config := getConfig(t); !config.EnableAccessLog || config.AccessLogFormat != AccessLogFormatUpstream {
+		t.Fatalf("Expected the access log to be enabled with the %s format but found %t (%s)", AccessLogFormatUpstream,
+			config.EnableAccessLog, config.AccessLogFormat)
+	}
+
+	// Choosing a format does not enable the access log
+	resetEnv(t)
+
+	for _, format := range []string{AccessLogFormatCombined, AccessLogFormatTiming, AccessLogFormatUpstream} {
+		setEnv(t, EnvVarAccessLogFormat, format)
+
+		if config := getConfig(t); config.EnableAccessLog {
+			t.Fatalf("Expected the access log to be disabled for the %s format", format)
+		}
+	}
+
+	// An explicitly disabled access log stays disabled for the timing format
+	setEnv(t, EnvVarEnableAccessLog, "false")
+	setEnv(t, EnvVarAccessLogFormat, AccessLogFormatTiming)
+
+	if config := getConfig(t); config.EnableAccessLog {
+		t.Fatalf("Expected %s=false to disable the access log for the %s format", EnvVarEnableAccessLog, AccessLogFormatTiming)
+	}
+}
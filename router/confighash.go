@@ -0,0 +1,52 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/30x/k8s-router/metrics"
+)
+
+// configHash is the hash of the nginx configuration nginx was last asked to load
+var configHash uint64
+
+/*
+GetConfigHash returns the hash of the nginx configuration nginx was last asked to load (0 until nginx is started)
+*/
+func GetConfigHash() uint64 {
+	return atomic.LoadUint64(&configHash)
+}
+
+/*
+SetConfigHash records the hash of the nginx configuration nginx was asked to load, for the /config-hash endpoint and
+the router_config_info metric
+*/
+func SetConfigHash(hash uint64) {
+	atomic.StoreUint64(&configHash, hash)
+
+	metrics.SetConfigHash(hash)
+}
+
+/*
+ConfigHashHandler serves the /config-hash endpoint, responding with the hexadecimal hash of the nginx configuration
+*/
+func ConfigHashHandler(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "%016x\n", GetConfigHash())
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/*
+Test for github.com/30x/k8s-router/router/confighash#ConfigHashHandler
+*/
+func TestConfigHashHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+
+	defer SetConfigHash(0)
+
+	SetConfigHash(0x1234abcd)
+
+	ConfigHashHandler(recorder, &http.Request{})
+
+	if body := recorder.Body.String(); body != "000000001234abcd\n" {
+		t.Fatalf("Unexpected config hash: %q", body)
+	}
+}
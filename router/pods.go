@@ -17,107 +17,1586 @@ limitations under the License.
 package router
 
 import (
-	"log"
-	"strconv"
+	"fmt"
 	"hash/fnv"
+	"net/url"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+
+	"github.com/30x/k8s-router/logging"
+	"github.com/30x/k8s-router/metrics"
+	"github.com/30x/k8s-router/utils"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/fields"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+const (
+	cacheBypassRegexStr   = "^\\$[A-Za-z_][A-Za-z0-9_]*$"
+	charsetRegexStr       = "^[A-Za-z0-9][A-Za-z0-9\\-_.:]*$"
+	conditionTypeRegexStr = "^([a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$"
+	corsOriginRegexStr    = "^https?://(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]*[a-zA-Z0-9])\\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\\-]*[A-Za-z0-9])(:[0-9]+)?$"
+	errorPageURIRegexStr  = "^(/|https?://)[^\\s;{}'\"]*$"
+	headerNameRegexStr    = "^[A-Za-z0-9][A-Za-z0-9\\-_]*$"
+	hostnameRegexStr      = "^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]*[a-zA-Z0-9])\\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\\-]*[A-Za-z0-9])$"
+	gzipTypeRegexStr      = "^[A-Za-z0-9][A-Za-z0-9!#$&\\-^_.+]*/([A-Za-z0-9][A-Za-z0-9!#$&\\-^_.+]*|\\*)$"
+	ipRegexStr            = "^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])$"
+	mimeTypeRegexStr      = "^[A-Za-z0-9][A-Za-z0-9!#$&\\-^_.+]*/[A-Za-z0-9][A-Za-z0-9!#$&\\-^_.+]*$"
+	nginxRateRegexStr     = "^[1-9][0-9]*r/(s|m)$"
+	nginxSizeRegexStr     = "^[1-9][0-9]*[kKmM]?$"
+	nginxTimeRegexStr     = "^[0-9]+(ms|s|m|h)?$"
+	pathCaptureRegexStr   = "^\\{([A-Za-z_][A-Za-z0-9_]*)\\}$"
+	pathReferenceRegexStr = "\\{([^}]*)\\}"
+	pathSegmentRegexStr   = "^[A-Za-z0-9\\-._~!$&'()*+,;=:@]|%[0-9A-Fa-f]{2}$"
+	portNameRegexStr      = "^[a-z0-9]([a-z0-9\\-]*[a-z0-9])?$"
+	portIndexRegexStr     = "^([0-9]+)\\.([0-9]+)$"
+	rewriteTargetRegexStr = "^/[A-Za-z0-9\\-._~!&()*+,=:@/%]*$"
+	serviceNameRegexStr   = "^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$"
+	unixSocketRegexStr    = "^(/[A-Za-z0-9\\-._~@+]+)+$"
+)
+
+// resourceWeightMilliCPU is the CPU allocation, in millicores, of each unit of weight derived from a pod's resources
+const resourceWeightMilliCPU = 100
+
+const (
+	// AuthRequestAnnotation is the name of the annotation used to authorize requests via an external auth service URL
+	AuthRequestAnnotation = "authRequest"
+	// AuthRequestSigninURLAnnotation is the name of the annotation used to redirect unauthorized requests to a sign in URL
+	AuthRequestSigninURLAnnotation = "authRequestSigninUrl"
+	// AuthModeAnnotation is the name of the annotation used to choose how requests to the pod are authorized
+	AuthModeAnnotation = "authMode"
+	// AuthModeAPIKey is the authorization mode using the API Key header (default)
+	AuthModeAPIKey = "api-key"
+	// AuthModeBasic is the authorization mode using basic auth
+	AuthModeBasic = "basic"
+	// BackendHostAnnotation is the name of the annotation used to proxy to a name-based virtual host of the backend
+	BackendHostAnnotation = "backendHost"
+	// CacheBypassAnnotation is the name of the annotation used to list the variables that bypass and disable the cache
+	CacheBypassAnnotation = "cacheBypass"
+	// CanaryPercentAnnotation is the name of the annotation used to mark a pod as a canary receiving a percentage of traffic
+	CanaryPercentAnnotation = "canaryPercent"
+	// CORSAnnotation is the name of the annotation used to allow cross-origin requests from an origin (or * for any origin)
+	CORSAnnotation = "cors"
+	// CORSMethodsAnnotation is the name of the annotation used to list the methods allowed for cross-origin requests
+	CORSMethodsAnnotation = "corsMethods"
+	// DefaultCORSMethods is the default value for the CORSMethodsAnnotation
+	DefaultCORSMethods = "GET, POST, PUT, PATCH, DELETE, OPTIONS"
+	// GzipAnnotation is the name of the annotation used to enable or disable gzip compression of responses (on/off)
+	GzipAnnotation = "gzip"
+	// HealthCheckPortAnnotation is the name of the annotation used to override the port upstream health checks connect to
+	HealthCheckPortAnnotation = "healthCheckPort"
+	// LoadBalanceMethodAnnotation is the name of the annotation used to choose how an upstream balances requests
+	LoadBalanceMethodAnnotation = "loadBalanceMethod"
+	// LoadBalanceMethodIPHash is the load balance method that pins clients to a pod based on their address
+	LoadBalanceMethodIPHash = "ip_hash"
+	// LoadBalanceMethodLeastConn is the load balance method that sends requests to the pod with the fewest active connections
+	LoadBalanceMethodLeastConn = "least_conn"
+	// LoadBalanceMethodRoundRobin is the load balance method that distributes requests evenly (default)
+	LoadBalanceMethodRoundRobin = "round_robin"
+	// MethodRewritesAnnotation is the name of the annotation used to rewrite request methods ({FROM}:{TO}) before proxying
+	MethodRewritesAnnotation = "methodRewrites"
+	// MirrorPercentageAnnotation is the name of the annotation used to set the percentage of traffic mirrored
+	MirrorPercentageAnnotation = "mirrorPercentage"
+	// MirrorTargetAnnotation is the name of the annotation used to mirror traffic to a shadow backend ({HOST}:{PORT})
+	MirrorTargetAnnotation = "mirrorTarget"
+	// PathTemplateAnnotation is the name of the annotation used to rewrite captured routing paths for the backend
+	PathTemplateAnnotation = "pathTemplate"
+	// ProxyCacheAnnotation is the name of the annotation used to cache the pod's responses in the router_cache zone (on/off)
+	ProxyCacheAnnotation = "proxyCache"
+	// ProxyCacheLockAnnotation is the name of the annotation used to enable proxy_cache_lock (on/off)
+	ProxyCacheLockAnnotation = "proxyCacheLock"
+	// ProxyCacheLockTimeoutAnnotation is the name of the annotation used to set proxy_cache_lock_timeout
+	ProxyCacheLockTimeoutAnnotation = "proxyCacheLockTimeout"
+	// DefaultProxyCacheLockTimeout is the default value for the ProxyCacheLockTimeoutAnnotation (5s)
+	DefaultProxyCacheLockTimeout = "5s"
+	// ProxyCacheUseStaleAnnotation is the name of the annotation used to list the proxy_cache_use_stale conditions
+	ProxyCacheUseStaleAnnotation = "proxyCacheUseStale"
+	// ProxyTimeoutsAnnotation is the name of the annotation used to set the proxy connect/read/send timeouts ({NAME}={TIME})
+	ProxyTimeoutsAnnotation = "proxyTimeouts"
+	// ProxyIgnoreHeadersAnnotation is the name of the annotation used to list the backend response headers nginx should ignore
+	ProxyIgnoreHeadersAnnotation = "proxyIgnoreHeaders"
+	// RequestBufferingAnnotation is the name of the annotation used to enable or disable request body buffering (on/off)
+	RequestBufferingAnnotation = "requestBuffering"
+	// RequireConditionAnnotation is the name of the annotation used to only route the pod while a pod condition is True
+	RequireConditionAnnotation = "requireCondition"
+	// RewritePathsAnnotation is the name of the annotation used to replace the routing path prefix before proxying
+	RewritePathsAnnotation = "rewritePaths"
+	// RoutingServiceAnnotation is the name of the annotation used to route to the endpoints of a service instead of the pod IP
+	RoutingServiceAnnotation = "routingService"
+	// RoutingWeightAnnotation is the name of the annotation used to weight the pod's servers in upstreams
+	RoutingWeightAnnotation = "routingWeight"
+	// SubFilterAnnotation is the name of the annotation used to rewrite response bodies ({FROM} {TO} pairs) via sub_filter
+	SubFilterAnnotation = "subFilter"
+	// StripAuthorizationAnnotation is the name of the annotation used to strip the Authorization header before proxying
+	StripAuthorizationAnnotation = "stripAuthorization"
+	// WebsocketAnnotation is the name of the annotation used to upgrade the connections of the pod's routes to websockets
+	WebsocketAnnotation = "websocket"
+	// WebsocketReadTimeout is the proxy read timeout of websocket routes that do not set their own read timeout
+	WebsocketReadTimeout = "3600s"
+	// TLSPassthroughPortAnnotation is the name of the annotation used to set the container port TLS connections are passed to
+	TLSPassthroughPortAnnotation = "tlsPassthroughPort"
+	// UnixSocketAnnotation is the name of the annotation used to proxy to a unix socket shared with the router (sidecars)
+	UnixSocketAnnotation = "unixSocket"
+)
+
+// validProxyCacheUseStaleConditions is the set of conditions allowed in the ProxyCacheUseStaleAnnotation
+var validProxyCacheUseStaleConditions = map[string]bool{
+	"error":          true,
+	"http_403":       true,
+	"http_404":       true,
+	"http_429":       true,
+	"http_500":       true,
+	"http_502":       true,
+	"http_503":       true,
+	"http_504":       true,
+	"invalid_header": true,
+	"timeout":        true,
+	"updating":       true,
+}
+
+// validProxyIgnoreHeaders maps the lowercased header names nginx allows in proxy_ignore_headers to their canonical form
+var validProxyIgnoreHeaders = map[string]string{
+	"cache-control":      "Cache-Control",
+	"expires":            "Expires",
+	"set-cookie":         "Set-Cookie",
+	"vary":               "Vary",
+	"x-accel-buffering":  "X-Accel-Buffering",
+	"x-accel-charset":    "X-Accel-Charset",
+	"x-accel-expires":    "X-Accel-Expires",
+	"x-accel-limit-rate": "X-Accel-Limit-Rate",
+	"x-accel-redirect":   "X-Accel-Redirect",
+}
+
+// cacheProxyIgnoreHeaders is the set of proxy_ignore_headers headers that only affect caching
+var cacheProxyIgnoreHeaders = map[string]bool{
+	"Cache-Control":   true,
+	"Expires":         true,
+	"Set-Cookie":      true,
+	"Vary":            true,
+	"X-Accel-Expires": true,
+}
+
+// validMethods is the set of HTTP request methods allowed in the MethodRewritesAnnotation
+var validMethods = map[string]bool{
+	"CONNECT": true,
+	"DELETE":  true,
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"PATCH":   true,
+	"POST":    true,
+	"PUT":     true,
+	"TRACE":   true,
+}
+
+type pathPair struct {
+	Path string
+	Port string
+}
+
+/*
+HostKey returns the host, with its port when one was provided, identifying the nginx server block for the route
+*/
+func (i *Incoming) HostKey() string {
+	if i.Port == "" {
+		return i.Host
+	}
+
+	return i.Host + ":" + i.Port
+}
+
+/*
+Copy returns a copy of the cache whose maps can be updated without affecting the original.  The cache entries are
+shared since the cache is updated by replacing entries rather than modifying them.
+*/
+func (cache *Cache) Copy() *Cache {
+	copied := &Cache{
+		Pods:     make(map[string]*PodWithRoutes, len(cache.Pods)),
+		Secrets:  make(map[string]*api.Secret, len(cache.Secrets)),
+		TLSCerts: make(map[string]*api.Secret, len(cache.TLSCerts)),
+	}
+
+	for key, pod := range cache.Pods {
+		copied.Pods[key] = pod
+	}
+
+	for key, secret := range cache.Secrets {
+		copied.Secrets[key] = secret
+	}
+
+	for key, secret := range cache.TLSCerts {
+		copied.TLSCerts[key] = secret
+	}
+
+	return copied
+}
+
+/*
+String implements the Stringer interface
+*/
+func (r *Route) String() string {
+	return r.Incoming.HostKey() + r.Incoming.Path + " -> " + r.Outgoing.IP + ":" + r.Outgoing.Port
+}
+
+var cacheBypassRegex *regexp.Regexp
+var charsetRegex *regexp.Regexp
+var conditionTypeRegex *regexp.Regexp
+var corsOriginRegex *regexp.Regexp
+var errorPageURIRegex *regexp.Regexp
+var headerNameRegex *regexp.Regexp
+var hostnameRegex *regexp.Regexp
+var ipRegex *regexp.Regexp
+var nginxRateRegex *regexp.Regexp
+var nginxSizeRegex *regexp.Regexp
+var nginxTimeRegex *regexp.Regexp
+var gzipTypeRegex *regexp.Regexp
+var mimeTypeRegex *regexp.Regexp
+var pathCaptureRegex *regexp.Regexp
+var pathReferenceRegex *regexp.Regexp
+var pathSegmentRegex *regexp.Regexp
+var portIndexRegex *regexp.Regexp
+var portNameRegex *regexp.Regexp
+var rewriteTargetRegex *regexp.Regexp
+var serviceNameRegex *regexp.Regexp
+var unixSocketRegex *regexp.Regexp
+
+/*
+ServiceEndpointsResolver resolves the endpoint addresses of a service in a namespace for the RoutingServiceAnnotation.
+It is nil until the Kubernetes client is available, in which case pods are routed to by their pod IP.
+*/
+var ServiceEndpointsResolver func(namespace, name string) ([]string, error)
+
+// routeIssueCollectors holds the issues collected for the pods converted by ConvertPodToModelWithIssues (keyed by pod)
+var routeIssueCollectors = make(map[*api.Pod]*[]RouteIssue)
+var routeIssueMutex sync.Mutex
+
+func compileRegex(regexStr string) *regexp.Regexp {
+	compiled, err := regexp.Compile(regexStr)
+
+	if err != nil {
+		logging.Fatalf("Failed to compile regular expression (%s): %v\n", regexStr, err)
+	}
+
+	return compiled
+}
+
+func init() {
+	// Compile all regular expressions
+	cacheBypassRegex = compileRegex(cacheBypassRegexStr)
+	charsetRegex = compileRegex(charsetRegexStr)
+	conditionTypeRegex = compileRegex(conditionTypeRegexStr)
+	corsOriginRegex = compileRegex(corsOriginRegexStr)
+	errorPageURIRegex = compileRegex(errorPageURIRegexStr)
+	headerNameRegex = compileRegex(headerNameRegexStr)
+	gzipTypeRegex = compileRegex(gzipTypeRegexStr)
+	hostnameRegex = compileRegex(hostnameRegexStr)
+	ipRegex = compileRegex(ipRegexStr)
+	mimeTypeRegex = compileRegex(mimeTypeRegexStr)
+	nginxRateRegex = compileRegex(nginxRateRegexStr)
+	nginxSizeRegex = compileRegex(nginxSizeRegexStr)
+	nginxTimeRegex = compileRegex(nginxTimeRegexStr)
+	pathCaptureRegex = compileRegex(pathCaptureRegexStr)
+	pathReferenceRegex = compileRegex(pathReferenceRegexStr)
+	pathSegmentRegex = compileRegex(pathSegmentRegexStr)
+	portIndexRegex = compileRegex(portIndexRegexStr)
+	portNameRegex = compileRegex(portNameRegexStr)
+	rewriteTargetRegex = compileRegex(rewriteTargetRegexStr)
+	serviceNameRegex = compileRegex(serviceNameRegexStr)
+	unixSocketRegex = compileRegex(unixSocketRegexStr)
+}
+
+/*
+collectRouteIssue records the issue when the issues of the pod are being collected
+*/
+func collectRouteIssue(pod *api.Pod, issue RouteIssue) {
+	routeIssueMutex.Lock()
+	defer routeIssueMutex.Unlock()
+
+	if issues, ok := routeIssueCollectors[pod]; ok {
+		*issues = append(*issues, issue)
+	}
+}
+
+/*
+reportNotRoutable logs, and collects, the reason the pod has no routes
+*/
+func reportNotRoutable(pod *api.Pod, messageFormat string, args ...interface{}) {
+	message := fmt.Sprintf(messageFormat, args...)
+
+	logging.Debugf("    Pod (%s) is not routable: %s\n", pod.Name, message)
+
+	collectRouteIssue(pod, RouteIssue{
+		Message:     message,
+		NotRoutable: true,
+	})
+}
+
+/*
+reportRoutingIssue logs, and collects, an annotation value that is ignored or replaced while computing the pod's routes
+*/
+func reportRoutingIssue(pod *api.Pod, messageFormat string, args ...interface{}) {
+	message := fmt.Sprintf(messageFormat, args...)
+
+	logging.Warnf("    Pod (%s) routing issue: %s\n", pod.Name, message)
+
+	collectRouteIssue(pod, RouteIssue{
+		Message: message,
+	})
+}
+
+func isContainerPort(ports []int32, port int32) bool {
+	for _, vPort := range ports {
+		if vPort == port {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+getConditionStatus returns the status of the pod condition with the provided type or an empty status when the pod does
+not have the condition
+*/
+func getConditionStatus(pod *api.Pod, conditionType string) api.ConditionStatus {
+	for _, condition := range pod.Status.Conditions {
+		if string(condition.Type) == conditionType {
+			return condition.Status
+		}
+	}
+
+	return ""
+}
+
+/*
+isPodRoutable returns whether the pod can receive traffic and, when it cannot, the reason why.  Only running pods with
+an IP whose condition named by the RequireConditionAnnotation, if any, is True are routable.
+*/
+func isPodRoutable(pod *api.Pod) (bool, string) {
+	if pod.Status.Phase != api.PodRunning {
+		return false, fmt.Sprintf("Not running (%s)", pod.Status.Phase)
+	} else if pod.Status.PodIP == "" {
+		return false, "Pod does not have an IP"
+	}
+
+	if conditionType, ok := pod.Annotations[RequireConditionAnnotation]; ok {
+		conditionType = strings.TrimSpace(conditionType)
+
+		if !conditionTypeRegex.MatchString(conditionType) {
+			return false, fmt.Sprintf("%s (%s) is not a valid condition type", RequireConditionAnnotation, conditionType)
+		} else if status := getConditionStatus(pod, conditionType); status != api.ConditionTrue {
+			return false, fmt.Sprintf("Condition (%s) is not True (%s)", conditionType, status)
+		}
+	}
+
+	return true, ""
+}
+
+/*
+getPortByName resolves a container port name to the first container port with that name or returns 0 when no container
+port has that name
+*/
+func getPortByName(pod *api.Pod, name string) int {
+	for _, container := range pod.Spec.Containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.Name == name && utils.IsValidPort(int(containerPort.ContainerPort)) {
+				return int(containerPort.ContainerPort)
+			}
+		}
+	}
+
+	return 0
+}
+
+/*
+getPortByIndex resolves a {CONTAINER_INDEX}.{PORT_INDEX} port reference to the referenced container port or returns 0
+when either index is out of range
+*/
+func getPortByIndex(pod *api.Pod, matches []string) int {
+	containerIndex, err := strconv.Atoi(matches[1])
+
+	if err != nil || containerIndex >= len(pod.Spec.Containers) {
+		return 0
+	}
+
+	portIndex, err := strconv.Atoi(matches[2])
+
+	if err != nil || portIndex >= len(pod.Spec.Containers[containerIndex].Ports) {
+		return 0
+	}
+
+	return int(pod.Spec.Containers[containerIndex].Ports[portIndex].ContainerPort)
+}
+
+func containsPathPair(items []*pathPair, item *pathPair) bool {
+	for _, cItem := range items {
+		if cItem.Path == item.Path && cItem.Port == item.Port {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(items []string, item string) bool {
+	for _, cItem := range items {
+		if cItem == item {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+splitAnnotation splits the hosts/paths annotation value using the configured delimiter, ignoring surrounding whitespace
+and empty values
+*/
+func splitAnnotation(config *Config, annotation string) []string {
+	if config.AnnotationDelimiter == "" || config.AnnotationDelimiter == DefaultAnnotationDelimiter {
+		return strings.Fields(annotation)
+	}
+
+	var values []string
+
+	for _, value := range strings.Split(annotation, config.AnnotationDelimiter) {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+/*
+IsNotFoundBackend returns whether the pod is part of the configured not found backend.  The backend name matches either
+the pod name or the pod name prefix generated by its controller (name-xxxxx).
+*/
+func IsNotFoundBackend(config *Config, pod *PodWithRoutes) bool {
+	if config.NotFoundBackend == "" {
+		return false
+	}
+
+	parts := strings.Split(config.NotFoundBackend, "/")
+
+	if len(parts) != 2 || pod.Namespace != parts[0] {
+		return false
+	}
+
+	return pod.Name == parts[1] || strings.HasPrefix(pod.Name, parts[1]+"-")
+}
+
+/*
+GetRoutablePodList returns the routable pods list.  (The Kubernetes API version we build against does not support
+paginated lists, there are no continue tokens, so the returned list is always complete.)
+*/
+func GetRoutablePodList(config *Config, kubeClient *client.Client) (*api.PodList, error) {
+	// Query the initial list of Pods
+	podList, err := kubeClient.Pods(api.NamespaceAll).List(api.ListOptions{
+		FieldSelector: fields.Everything(),
+		LabelSelector: GetRoutableListSelector(config),
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	podList.Items = FilterRoutablePods(config, podList.Items)
+
+	return podList, nil
+}
+
+/*
+FilterRoutablePods returns the pods whose labels are routable (see IsRoutable)
+*/
+func FilterRoutablePods(config *Config, pods []api.Pod) []api.Pod {
+	routablePods := []api.Pod{}
+
+	for _, pod := range pods {
+		if IsRoutable(config, pod.Labels) {
+			routablePods = append(routablePods, pod)
+		}
+	}
+
+	return routablePods
+}
+
+/*
+GetServiceEndpoints returns the addresses of the ready endpoints of the service in the namespace
+*/
+func GetServiceEndpoints(kubeClient *client.Client, namespace, name string) ([]string, error) {
+	endpoints, err := kubeClient.Endpoints(namespace).Get(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return GetEndpointAddresses(endpoints), nil
+}
+
+/*
+GetEndpointAddresses returns the unique addresses of the ready endpoints, in the order they are listed
+*/
+func GetEndpointAddresses(endpoints *api.Endpoints) []string {
+	var addresses []string
+
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if !containsString(addresses, address.IP) {
+				addresses = append(addresses, address.IP)
+			}
+		}
+	}
+
+	return addresses
+}
+
+/*
+ Calculate hash for hosts and paths annotations to compare when pod is modified
+*/
+func calculateAnnotationHash(config *Config, pod *api.Pod) (uint64) {
+	h := fnv.New64()
+	h.Write([]byte(pod.Annotations[config.HostsAnnotation]))
+	h.Write([]byte(pod.Annotations[config.PathsAnnotation]))
+	h.Write([]byte(pod.Annotations[AuthModeAnnotation]))
+	h.Write([]byte(pod.Annotations[AuthRequestAnnotation]))
+	h.Write([]byte(pod.Annotations[AuthRequestSigninURLAnnotation]))
+	h.Write([]byte(pod.Annotations[BackendHostAnnotation]))
+	h.Write([]byte(pod.Annotations[CacheBypassAnnotation]))
+	h.Write([]byte(pod.Annotations[CanaryPercentAnnotation]))
+	h.Write([]byte(pod.Annotations[CORSAnnotation]))
+	h.Write([]byte(pod.Annotations[CORSMethodsAnnotation]))
+	h.Write([]byte(pod.Annotations[GzipAnnotation]))
+	h.Write([]byte(pod.Annotations[HealthCheckPortAnnotation]))
+	h.Write([]byte(pod.Annotations[LoadBalanceMethodAnnotation]))
+	h.Write([]byte(pod.Annotations[RoutingServiceAnnotation]))
+	h.Write([]byte(pod.Annotations[WebsocketAnnotation]))
+	h.Write([]byte(pod.Annotations[MethodRewritesAnnotation]))
+	h.Write([]byte(pod.Annotations[MirrorPercentageAnnotation]))
+	h.Write([]byte(pod.Annotations[MirrorTargetAnnotation]))
+	h.Write([]byte(pod.Annotations[PathTemplateAnnotation]))
+	h.Write([]byte(pod.Annotations[ProxyCacheAnnotation]))
+	h.Write([]byte(pod.Annotations[ProxyCacheLockAnnotation]))
+	h.Write([]byte(pod.Annotations[ProxyCacheLockTimeoutAnnotation]))
+	h.Write([]byte(pod.Annotations[ProxyCacheUseStaleAnnotation]))
+	h.Write([]byte(pod.Annotations[ProxyIgnoreHeadersAnnotation]))
+	h.Write([]byte(pod.Annotations[ProxyTimeoutsAnnotation]))
+	h.Write([]byte(pod.Annotations[RequestBufferingAnnotation]))
+	h.Write([]byte(pod.Annotations[RequireConditionAnnotation]))
+	h.Write([]byte(getConditionStatus(pod, pod.Annotations[RequireConditionAnnotation])))
+	h.Write([]byte(pod.Annotations[RewritePathsAnnotation]))
+	h.Write([]byte(pod.Annotations[RoutingWeightAnnotation]))
+	h.Write([]byte(pod.Annotations[StripAuthorizationAnnotation]))
+	h.Write([]byte(pod.Annotations[SubFilterAnnotation]))
+	h.Write([]byte(pod.Annotations[TLSPassthroughPortAnnotation]))
+	h.Write([]byte(pod.Annotations[UnixSocketAnnotation]))
+	return h.Sum64()
+}
+
+/*
+GetAuthMode returns the authorization mode for the pod's routes
+*/
+func GetAuthMode(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[AuthModeAnnotation]
+
+	if !ok {
+		return AuthModeAPIKey
+	} else if annotation != AuthModeAPIKey && annotation != AuthModeBasic {
+		reportRoutingIssue(pod, "%s (%s) is not one of %s or %s, using %s", AuthModeAnnotation, annotation, AuthModeAPIKey, AuthModeBasic, AuthModeAPIKey)
+
+		return AuthModeAPIKey
+	}
+
+	return annotation
+}
+
+/*
+Returns the annotation value when it is an absolute http(s) URL that can be safely rendered into the nginx configuration
+*/
+func getURLAnnotation(pod *api.Pod, name string) string {
+	annotation, ok := pod.Annotations[name]
+
+	if !ok {
+		return ""
+	}
+
+	parsed, err := url.Parse(annotation)
+
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" || strings.ContainsAny(annotation, " \t;{}'\"") {
+		reportRoutingIssue(pod, "%s (%s) is not a valid http or https URL", name, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
+
+/*
+GetAuthRequest returns the URL of the external auth service used to authorize requests to the pod's routes
+*/
+func GetAuthRequest(pod *api.Pod) string {
+	return getURLAnnotation(pod, AuthRequestAnnotation)
+}
+
+/*
+GetAuthRequestSigninURL returns the URL unauthorized requests to the pod's routes are redirected to
+*/
+func GetAuthRequestSigninURL(pod *api.Pod) string {
+	return getURLAnnotation(pod, AuthRequestSigninURLAnnotation)
+}
+
+/*
+GetBackendHost returns the virtual host name the pod's backend expects as the Host header (and TLS server name) or an
+empty string when the client's Host header should be passed through
+*/
+func GetBackendHost(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[BackendHostAnnotation]
+
+	if !ok {
+		return ""
+	} else if !hostnameRegex.MatchString(annotation) {
+		reportRoutingIssue(pod, "%s (%s) is not a valid hostname", BackendHostAnnotation, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
+
+/*
+GetCanaryPercent returns the percentage (1-99) of traffic the canary pod should receive or 0 if the pod is not a canary
+*/
+func GetCanaryPercent(pod *api.Pod) int {
+	annotation, ok := pod.Annotations[CanaryPercentAnnotation]
+
+	if !ok {
+		return 0
+	}
+
+	percent, err := strconv.Atoi(annotation)
+
+	if err != nil || percent < 1 || percent > 99 {
+		reportRoutingIssue(pod, "%s (%s) is not a valid percentage (1-99)", CanaryPercentAnnotation, annotation)
+
+		return 0
+	}
+
+	return percent
+}
+
+/*
+GetCORSOrigin returns the origin ({SCHEME}://{HOST}[:{PORT}] or * for any origin) allowed to make cross-origin requests
+to the pod's routes or an empty string when the pod does not allow cross-origin requests
+*/
+func GetCORSOrigin(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[CORSAnnotation]
+
+	if !ok {
+		return ""
+	} else if annotation != "*" && !corsOriginRegex.MatchString(annotation) {
+		reportRoutingIssue(pod, "%s (%s) is not * or a valid origin", CORSAnnotation, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
+
+/*
+GetCORSMethods returns the methods (comma delimited) allowed for cross-origin requests to the pod's routes,
+DefaultCORSMethods when the pod does not list valid methods or an empty string when the pod does not allow cross-origin
+requests (an empty origin, as returned by GetCORSOrigin)
+*/
+func GetCORSMethods(pod *api.Pod, origin string) string {
+	if origin == "" {
+		return ""
+	}
+
+	annotation, ok := pod.Annotations[CORSMethodsAnnotation]
+
+	if !ok {
+		return DefaultCORSMethods
+	}
+
+	var methods []string
+
+	for _, method := range strings.FieldsFunc(strings.ToUpper(annotation), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if !validMethods[method] {
+			reportRoutingIssue(pod, "%s value (%s) is not a valid method", CORSMethodsAnnotation, method)
+		} else if !containsString(methods, method) {
+			methods = append(methods, method)
+		}
+	}
+
+	if len(methods) == 0 {
+		return DefaultCORSMethods
+	}
+
+	return strings.Join(methods, ", ")
+}
+
+/*
+Returns the probe value or the Kubernetes default when the value is unset
+*/
+func getProbeValue(value, defaultValue int32) int {
+	if value == 0 {
+		return int(defaultValue)
+	}
+
+	return int(value)
+}
+
+/*
+GetGzip returns whether gzip compression of the pod's responses is turned on or off or an empty string when the
+inherited gzip setting should be used
+*/
+func GetGzip(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[GzipAnnotation]
+
+	if !ok {
+		return ""
+	} else if annotation != "on" && annotation != "off" {
+		reportRoutingIssue(pod, "%s value (%s) is not on/off", GzipAnnotation, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
+
+/*
+GetHealthCheck returns the upstream health check derived from the first HTTP probe found on the pod's containers, using
+the probe preference order in config.HealthCheckProbes
+*/
+func GetHealthCheck(config *Config, pod *api.Pod) *HealthCheck {
+	healthCheckPort := GetHealthCheckPort(pod)
+
+	for _, probeType := range config.HealthCheckProbes {
+		for _, container := range pod.Spec.Containers {
+			probe := container.ReadinessProbe
+
+			if probeType == HealthCheckProbeLiveness {
+				probe = container.LivenessProbe
+			}
+
+			if probe == nil || probe.HTTPGet == nil {
+				continue
+			}
+
+			port := 0
+
+			if probe.HTTPGet.Port.Type == intstr.String {
+				// Resolve the named port using the container ports
+				for _, containerPort := range container.Ports {
+					if containerPort.Name == probe.HTTPGet.Port.StrVal {
+						port = int(containerPort.ContainerPort)
+					}
+				}
+			} else {
+				port = probe.HTTPGet.Port.IntValue()
+			}
+
+			// The health check port annotation takes precedence over the probe's port
+			if healthCheckPort > 0 {
+				port = healthCheckPort
+			}
+
+			path := probe.HTTPGet.Path
+
+			if path == "" {
+				path = "/"
+			}
+
+			if !utils.IsValidPort(port) || strings.ContainsAny(path, " \t\"\\") {
+				reportRoutingIssue(pod, "%s probe (%s:%s) cannot be used as a health check", probeType, probe.HTTPGet.Port.String(), path)
+
+				continue
+			}
+
+			return &HealthCheck{
+				Fall:     getProbeValue(probe.FailureThreshold, 3),
+				Interval: getProbeValue(probe.PeriodSeconds, 10) * 1000,
+				Path:     path,
+				Port:     port,
+				Probe:    probeType,
+				Rise:     getProbeValue(probe.SuccessThreshold, 1),
+				Timeout:  getProbeValue(probe.TimeoutSeconds, 1) * 1000,
+			}
+		}
+	}
+
+	return nil
+}
+
+/*
+GetCacheBypass returns the validated nginx variables that, when not empty or "0", cause the pod's responses to neither
+be served from nor stored in the cache.  No variables are returned when the pod's responses are not cached.
+*/
+func GetCacheBypass(config *Config, pod *api.Pod) []string {
+	var variables []string
+
+	annotation, ok := pod.Annotations[CacheBypassAnnotation]
+
+	if ok && !isProxyCached(config, pod) {
+		reportRoutingIssue(pod, "%s requires the %s annotation to be on", CacheBypassAnnotation, ProxyCacheAnnotation)
+	} else if ok {
+		for _, variable := range strings.Fields(annotation) {
+			if !cacheBypassRegex.MatchString(variable) {
+				reportRoutingIssue(pod, "%s variable (%s) is not a valid nginx variable", CacheBypassAnnotation, variable)
+
+				continue
+			}
+
+			if !containsString(variables, variable) {
+				variables = append(variables, variable)
+			}
+		}
+	}
+
+	return variables
+}
+
+/*
+GetHealthCheckPort returns the validated container port the pod's upstream health checks connect to instead of the
+probe's port or 0 when the probe's port should be used
+*/
+func GetHealthCheckPort(pod *api.Pod) int {
+	annotation, ok := pod.Annotations[HealthCheckPortAnnotation]
+
+	if !ok {
+		return 0
+	}
+
+	var ports []int32
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			ports = append(ports, port.ContainerPort)
+		}
+	}
+
+	port, err := strconv.Atoi(annotation)
+
+	if err != nil || !utils.IsValidPort(port) {
+		reportRoutingIssue(pod, "%s (%s) is not valid, using the probe port", HealthCheckPortAnnotation, annotation)
+
+		return 0
+	} else if !isContainerPort(ports, int32(port)) {
+		reportRoutingIssue(pod, "%s (%s) is not an exposed container port, using the probe port", HealthCheckPortAnnotation, annotation)
+
+		return 0
+	}
+
+	return port
+}
+
+/*
+GetLoadBalanceMethod returns the method used to balance requests across the pods serving the pod's routes
+*/
+func GetLoadBalanceMethod(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[LoadBalanceMethodAnnotation]
+
+	if !ok {
+		return LoadBalanceMethodRoundRobin
+	} else if annotation != LoadBalanceMethodIPHash && annotation != LoadBalanceMethodRoundRobin {
+		reportRoutingIssue(pod, "%s (%s) is not one of %s or %s, using %s", LoadBalanceMethodAnnotation, annotation, LoadBalanceMethodIPHash, LoadBalanceMethodRoundRobin, LoadBalanceMethodRoundRobin)
+
+		return LoadBalanceMethodRoundRobin
+	}
+
+	return annotation
+}
+
+/*
+GetMethodRewrites returns the validated request method rewrites (keyed by the client's method) for the pod's routes
+*/
+func GetMethodRewrites(pod *api.Pod) map[string]string {
+	annotation, ok := pod.Annotations[MethodRewritesAnnotation]
+
+	if !ok {
+		return nil
+	}
+
+	rewrites := make(map[string]string)
+
+	for _, rewrite := range strings.Fields(annotation) {
+		rewriteParts := strings.Split(strings.ToUpper(rewrite), ":")
+
+		if len(rewriteParts) != 2 || !validMethods[rewriteParts[0]] || !validMethods[rewriteParts[1]] {
+			reportRoutingIssue(pod, "%s value (%s) is not a valid {FROM}:{TO} method pair", MethodRewritesAnnotation, rewrite)
+
+			continue
+		} else if rewriteParts[0] == rewriteParts[1] {
+			continue
+		}
+
+		rewrites[rewriteParts[0]] = rewriteParts[1]
+	}
+
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	return rewrites
+}
+
+/*
+GetMirrorTarget returns the validated shadow backend ({HOST}:{PORT}) the pod's traffic should be mirrored to
+*/
+func GetMirrorTarget(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[MirrorTargetAnnotation]
+
+	if !ok {
+		return ""
+	}
+
+	targetParts := strings.Split(annotation, ":")
+
+	if len(targetParts) == 2 {
+		port, err := strconv.Atoi(targetParts[1])
+
+		if (hostnameRegex.MatchString(targetParts[0]) || ipRegex.MatchString(targetParts[0])) && err == nil && utils.IsValidPort(port) {
+			return annotation
+		}
+	}
+
+	reportRoutingIssue(pod, "%s (%s) is not a valid HOST:PORT combination", MirrorTargetAnnotation, annotation)
+
+	return ""
+}
+
+/*
+GetMirrorPercentage returns the percentage (1-100) of the pod's traffic that should be mirrored
+*/
+func GetMirrorPercentage(pod *api.Pod) int {
+	annotation, ok := pod.Annotations[MirrorPercentageAnnotation]
+
+	if !ok {
+		return 100
+	}
+
+	percentage, err := strconv.Atoi(annotation)
+
+	if err != nil || percentage < 1 || percentage > 100 {
+		reportRoutingIssue(pod, "%s (%s) is not a valid percentage (1-100)", MirrorPercentageAnnotation, annotation)
+
+		return 100
+	}
+
+	return percentage
+}
+
+/*
+GetPathCaptureName returns the capture name for a routing path segment in the {name} format and whether the segment
+is a capture
+*/
+func GetPathCaptureName(pathSegment string) (string, bool) {
+	matches := pathCaptureRegex.FindStringSubmatch(pathSegment)
+
+	if matches == nil {
+		return "", false
+	}
+
+	return matches[1], true
+}
+
+/*
+GetPathTemplates returns the validated backend path templates for the pod keyed by routing path.  The annotation is a
+space delimited array of {ROUTING_PATH}={BACKEND_PATH_TEMPLATE} entries where the backend path template can reference
+the {name} captures of the routing path.  (Example: /{version}/api=/api?v={version})  Templates for routing paths with
+captures must reference at least one capture since nginx only allows a static URI in a regex location's proxy_pass.
+*/
+func GetPathTemplates(pod *api.Pod) map[string]string {
+	templates := make(map[string]string)
+
+	annotation, ok := pod.Annotations[PathTemplateAnnotation]
+
+	if !ok {
+		return templates
+	}
+
+	for _, entry := range strings.Fields(annotation) {
+		entryParts := strings.SplitN(entry, "=", 2)
+
+		if len(entryParts) != 2 || !strings.HasPrefix(entryParts[1], "/") {
+			reportRoutingIssue(pod, "%s (%s) is not a valid PATH=TEMPLATE combination", PathTemplateAnnotation, entry)
+
+			continue
+		}
+
+		captures := make(map[string]bool)
+
+		for _, pathSegment := range strings.Split(entryParts[0], "/") {
+			if name, isCapture := GetPathCaptureName(pathSegment); isCapture {
+				captures[name] = true
+			}
+		}
+
+		valid := true
+
+		// Every capture referenced by the template must exist in the routing path
+		for _, reference := range pathReferenceRegex.FindAllStringSubmatch(entryParts[1], -1) {
+			if !captures[reference[1]] {
+				reportRoutingIssue(pod, "%s (%s) references an unknown capture (%s)", PathTemplateAnnotation, entry, reference[1])
+
+				valid = false
+
+				break
+			}
+		}
+
+		if valid && len(captures) > 0 && !pathReferenceRegex.MatchString(entryParts[1]) {
+			reportRoutingIssue(pod, "%s (%s) does not reference a capture of the routing path", PathTemplateAnnotation, entry)
+
+			valid = false
+		}
+
+		if valid {
+			templates[entryParts[0]] = entryParts[1]
+		}
+	}
+
+	return templates
+}
+
+/*
+GetRewritePaths returns the validated path prefix rewrites for the pod keyed by routing path.  The annotation is a space
+delimited array of {ROUTING_PATH}={TARGET} entries where the routing path prefix is replaced by the absolute target path
+before proxying.  (Example: /api/v1=/)
+*/
+func GetRewritePaths(pod *api.Pod) map[string]string {
+	rewrites := make(map[string]string)
+
+	annotation, ok := pod.Annotations[RewritePathsAnnotation]
+
+	if !ok {
+		return rewrites
+	}
+
+	for _, entry := range strings.Fields(annotation) {
+		entryParts := strings.SplitN(entry, "=", 2)
+
+		if len(entryParts) != 2 || !strings.HasPrefix(entryParts[0], "/") {
+			reportRoutingIssue(pod, "%s (%s) is not a valid PATH=TARGET combination", RewritePathsAnnotation, entry)
+
+			continue
+		} else if !rewriteTargetRegex.MatchString(entryParts[1]) {
+			reportRoutingIssue(pod, "%s (%s) target is not an absolute path", RewritePathsAnnotation, entry)
+
+			continue
+		}
+
+		valid := true
+
+		// Routing paths with captures are rewritten using the pathTemplate annotation
+		for _, pathSegment := range strings.Split(entryParts[0], "/") {
+			if _, isCapture := GetPathCaptureName(pathSegment); isCapture {
+				reportRoutingIssue(pod, "%s (%s) routing path has captures, use %s instead", RewritePathsAnnotation, entry, PathTemplateAnnotation)
+
+				valid = false
+
+				break
+			}
+		}
+
+		if valid {
+			rewrites[entryParts[0]] = entryParts[1]
+		}
+	}
+
+	return rewrites
+}
+
+/*
+GetProxyCache returns whether the pod's responses are cached, which requires the proxy cache to be enabled via
+config.ProxyCachePath
+*/
+func GetProxyCache(config *Config, pod *api.Pod) bool {
+	annotation, ok := pod.Annotations[ProxyCacheAnnotation]
+
+	if !ok || annotation == "off" {
+		return false
+	} else if annotation != "on" {
+		reportRoutingIssue(pod, "%s value (%s) is not on/off", ProxyCacheAnnotation, annotation)
+
+		return false
+	} else if config.ProxyCachePath == "" {
+		reportRoutingIssue(pod, "%s is on but the proxy cache is disabled (%s is not set)", ProxyCacheAnnotation, EnvVarProxyCachePath)
+
+		return false
+	}
+
+	return true
+}
+
+/*
+isProxyCached returns whether the pod's responses are cached without reporting the routing issues GetProxyCache reports
+*/
+func isProxyCached(config *Config, pod *api.Pod) bool {
+	return config.ProxyCachePath != "" && pod.Annotations[ProxyCacheAnnotation] == "on"
+}
+
+/*
+GetProxyCacheLockTimeout returns the proxy_cache_lock_timeout for the pod's routes when proxy_cache_lock is enabled or an
+empty string when it is disabled or the pod's responses are not cached
+*/
+func GetProxyCacheLockTimeout(config *Config, pod *api.Pod) string {
+	annotation, ok := pod.Annotations[ProxyCacheLockAnnotation]
+
+	if !ok || annotation == "off" {
+		return ""
+	} else if annotation != "on" {
+		reportRoutingIssue(pod, "%s value (%s) is not on/off", ProxyCacheLockAnnotation, annotation)
+
+		return ""
+	} else if !isProxyCached(config, pod) {
+		reportRoutingIssue(pod, "%s requires the %s annotation to be on", ProxyCacheLockAnnotation, ProxyCacheAnnotation)
+
+		return ""
+	}
+
+	timeout, ok := pod.Annotations[ProxyCacheLockTimeoutAnnotation]
+
+	if !ok {
+		return DefaultProxyCacheLockTimeout
+	} else if !nginxTimeRegex.MatchString(timeout) {
+		reportRoutingIssue(pod, "%s value (%s) is not a valid time, using %s", ProxyCacheLockTimeoutAnnotation, timeout, DefaultProxyCacheLockTimeout)
+
+		return DefaultProxyCacheLockTimeout
+	}
+
+	return timeout
+}
+
+/*
+GetProxyCacheUseStale returns the validated list of conditions in which nginx serves stale cached responses for the pod's
+routes.  No conditions are returned when the pod's responses are not cached.
+*/
+func GetProxyCacheUseStale(config *Config, pod *api.Pod) []string {
+	var conditions []string
+
+	annotation, ok := pod.Annotations[ProxyCacheUseStaleAnnotation]
+
+	if ok && !isProxyCached(config, pod) {
+		reportRoutingIssue(pod, "%s requires the %s annotation to be on", ProxyCacheUseStaleAnnotation, ProxyCacheAnnotation)
+	} else if ok {
+		for _, condition := range strings.Fields(annotation) {
+			condition = strings.ToLower(condition)
+
+			if !validProxyCacheUseStaleConditions[condition] {
+				reportRoutingIssue(pod, "%s condition (%s) is not a valid condition", ProxyCacheUseStaleAnnotation, condition)
+
+				continue
+			}
+
+			// Record the condition (once)
+			if !containsString(conditions, condition) {
+				conditions = append(conditions, condition)
+			}
+		}
+	}
+
+	return conditions
+}
+
+/*
+isValidWildcardHost returns whether the host is a hostname with a leading wildcard (Example: *.example.com)
+*/
+func isValidWildcardHost(host string) bool {
+	return strings.HasPrefix(host, "*.") && hostnameRegex.MatchString(host[2:])
+}
+
+/*
+isValidRegexHost returns whether the host is an anchored nginx regex server name (Example: ~^api\d+\.example\.com$)
+*/
+func isValidRegexHost(host string) bool {
+	// nginx requires quoting server names containing these characters
+	if !strings.HasPrefix(host, "~^") || !strings.HasSuffix(host, "$") || strings.ContainsAny(host, ";{}'\"") {
+		return false
+	}
+
+	_, err := regexp.Compile(host[1:])
+
+	return err == nil
+}
+
+/*
+GetRequestBuffering returns whether buffering of the pod's request bodies is turned on or off or an empty string when the
+inherited request buffering setting should be used
+*/
+func GetRequestBuffering(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[RequestBufferingAnnotation]
+
+	if !ok {
+		return ""
+	} else if annotation != "on" && annotation != "off" {
+		reportRoutingIssue(pod, "%s value (%s) is not on/off", RequestBufferingAnnotation, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
+
+/*
+GetProxyIgnoreHeaders returns the validated list of backend response headers nginx should ignore for the pod's routes.
+The headers that only affect caching are dropped unless the pod's responses are cached.
+*/
+func GetProxyIgnoreHeaders(config *Config, pod *api.Pod) []string {
+	var headers []string
+
+	annotation, ok := pod.Annotations[ProxyIgnoreHeadersAnnotation]
+
+	if ok {
+		cached := isProxyCached(config, pod)
+
+		for _, header := range strings.Fields(annotation) {
+			canonical, valid := validProxyIgnoreHeaders[strings.ToLower(header)]
+
+			if !valid {
+				reportRoutingIssue(pod, "%s header (%s) is not a valid header", ProxyIgnoreHeadersAnnotation, header)
+
+				continue
+			} else if cacheProxyIgnoreHeaders[canonical] && !cached {
+				reportRoutingIssue(pod, "%s header (%s) requires the %s annotation to be on", ProxyIgnoreHeadersAnnotation, header, ProxyCacheAnnotation)
+
+				continue
+			}
+
+			headers = append(headers, canonical)
+		}
+	}
+
+	return headers
+}
+
+/*
+GetProxyTimeouts returns the validated proxy timeouts for the pod's routes or nil when none are set, in which case the
+nginx defaults apply.  The annotation is a space delimited array of {NAME}={TIME} entries where the name is connect, read
+or send.  (Example: read=120s connect=5s send=30s)
+*/
+func GetProxyTimeouts(pod *api.Pod) *Timeouts {
+	annotation, ok := pod.Annotations[ProxyTimeoutsAnnotation]
+
+	if !ok {
+		return nil
+	}
+
+	timeouts := &Timeouts{}
+	found := false
+
+	for _, entry := range strings.Fields(annotation) {
+		entryParts := strings.SplitN(entry, "=", 2)
+
+		if len(entryParts) != 2 || !nginxTimeRegex.MatchString(entryParts[1]) {
+			reportRoutingIssue(pod, "%s (%s) is not a valid NAME=TIME combination", ProxyTimeoutsAnnotation, entry)
+
+			continue
+		}
+
+		switch entryParts[0] {
+		case "connect":
+			timeouts.Connect = entryParts[1]
+		case "read":
+			timeouts.Read = entryParts[1]
+		case "send":
+			timeouts.Send = entryParts[1]
+		default:
+			reportRoutingIssue(pod, "%s timeout (%s) is not one of connect, read or send", ProxyTimeoutsAnnotation, entryParts[0])
+
+			continue
+		}
+
+		found = true
+	}
+
+	if !found {
+		return nil
+	}
+
+	return timeouts
+}
+
+/*
+GetRoutingWeight returns the weight (1 or greater) of the pod's servers in upstreams or 0 when the nginx default weight
+should be used
+*/
+func GetRoutingWeight(pod *api.Pod) int {
+	annotation, ok := pod.Annotations[RoutingWeightAnnotation]
+
+	if !ok {
+		return 0
+	}
+
+	weight, err := strconv.Atoi(annotation)
+
+	if err != nil || weight < 1 {
+		reportRoutingIssue(pod, "%s (%s) is not a valid weight (1 or greater)", RoutingWeightAnnotation, annotation)
+
+		return 0
+	}
+
+	return weight
+}
+
+/*
+GetRoutingService returns the validated name of the service, in the pod's namespace, whose endpoints the pod's routes
+proxy to or an empty string when the pod is proxied to by its pod IP
+*/
+func GetRoutingService(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[RoutingServiceAnnotation]
+
+	if !ok {
+		return ""
+	}
+
+	if !serviceNameRegex.MatchString(annotation) {
+		reportRoutingIssue(pod, "%s (%s) is not a valid service name", RoutingServiceAnnotation, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
+
+/*
+getRouteTargets returns the IPs the pod's routes proxy to: the endpoint addresses of the pod's routing service, when
+it can be resolved, or the pod IP
+*/
+func getRouteTargets(pod *api.Pod) []string {
+	service := GetRoutingService(pod)
 
-	"github.com/30x/k8s-router/utils"
+	if service == "" || ServiceEndpointsResolver == nil {
+		return []string{pod.Status.PodIP}
+	}
 
-	"k8s.io/kubernetes/pkg/api"
-	client "k8s.io/kubernetes/pkg/client/unversioned"
-	"k8s.io/kubernetes/pkg/fields"
-	"k8s.io/kubernetes/pkg/labels"
-	"k8s.io/kubernetes/pkg/watch"
-)
+	addresses, err := ServiceEndpointsResolver(pod.Namespace, service)
 
-const (
-	hostnameRegexStr    = "^(([a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9\\-]*[a-zA-Z0-9])\\.)*([A-Za-z0-9]|[A-Za-z0-9][A-Za-z0-9\\-]*[A-Za-z0-9])$"
-	ipRegexStr          = "^(([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])\\.){3}([0-9]|[1-9][0-9]|1[0-9]{2}|2[0-4][0-9]|25[0-5])$"
-	pathSegmentRegexStr = "^[A-Za-z0-9\\-._~!$&'()*+,;=:@]|%[0-9A-Fa-f]{2}$"
-)
+	if err != nil {
+		reportRoutingIssue(pod, "%s (%s) endpoints could not be resolved, using the pod IP: %v", RoutingServiceAnnotation, service, err)
 
-type pathPair struct {
-	Path string
-	Port string
+		return []string{pod.Status.PodIP}
+	} else if len(addresses) == 0 {
+		reportRoutingIssue(pod, "%s (%s) has no ready endpoints", RoutingServiceAnnotation, service)
+	}
+
+	return addresses
 }
 
 /*
-String implements the Stringer interface
+GetResourceWeight returns the weight (1 or greater) of the pod's servers in upstreams derived from the pod's CPU
+allocation, the total CPU request (or limit, for containers without a request) of its containers in units of
+resourceWeightMilliCPU, or 0 when the pod's containers do not request or limit CPU
 */
-func (r *Route) String() string {
-	return r.Incoming.Host + r.Incoming.Path + " -> " + r.Outgoing.IP + ":" + r.Outgoing.Port
+func GetResourceWeight(pod *api.Pod) int {
+	var milliCPU int64
+
+	for _, container := range pod.Spec.Containers {
+		if cpu, ok := container.Resources.Requests[api.ResourceCPU]; ok {
+			milliCPU += cpu.MilliValue()
+		} else if cpu, ok := container.Resources.Limits[api.ResourceCPU]; ok {
+			milliCPU += cpu.MilliValue()
+		}
+	}
+
+	if milliCPU <= 0 {
+		return 0
+	}
+
+	// Round to the nearest unit, every pod with a CPU allocation has a weight of at least 1
+	weight := int((milliCPU + resourceWeightMilliCPU/2) / resourceWeightMilliCPU)
+
+	if weight < 1 {
+		return 1
+	}
+
+	return weight
 }
 
-var hostnameRegex *regexp.Regexp
-var ipRegex *regexp.Regexp
-var pathSegmentRegex *regexp.Regexp
+/*
+GetStripAuthorization returns whether the Authorization header should be stripped before proxying to the pod
+*/
+func GetStripAuthorization(pod *api.Pod) bool {
+	annotation, ok := pod.Annotations[StripAuthorizationAnnotation]
 
-func compileRegex(regexStr string) *regexp.Regexp {
-	compiled, err := regexp.Compile(regexStr)
+	if !ok {
+		return false
+	}
+
+	strip, err := strconv.ParseBool(annotation)
 
 	if err != nil {
-		log.Fatalf("Failed to compile regular expression (%s): %v\n", regexStr, err)
+		reportRoutingIssue(pod, "%s value (%s) is not a valid boolean", StripAuthorizationAnnotation, annotation)
+
+		return false
 	}
 
-	return compiled
+	return strip
 }
 
-func init() {
-	// Compile all regular expressions
-	hostnameRegex = compileRegex(hostnameRegexStr)
-	ipRegex = compileRegex(ipRegexStr)
-	pathSegmentRegex = compileRegex(pathSegmentRegexStr)
+/*
+GetWebsocket returns whether the connections of the pod's routes are upgraded to websockets
+*/
+func GetWebsocket(pod *api.Pod) bool {
+	annotation, ok := pod.Annotations[WebsocketAnnotation]
+
+	if !ok {
+		return false
+	}
+
+	websocket, err := strconv.ParseBool(annotation)
+
+	if err != nil {
+		reportRoutingIssue(pod, "%s value (%s) is not a valid boolean", WebsocketAnnotation, annotation)
+
+		return false
+	}
+
+	return websocket
 }
 
-func isContainerPort(ports []int32, port int32) bool {
-	for _, vPort := range ports {
-		if vPort == port {
-			return true
+/*
+GetSubFilters returns the validated response body rewrites for the pod's routes
+*/
+func GetSubFilters(pod *api.Pod) []*SubFilter {
+	var subFilters []*SubFilter
+
+	annotation, ok := pod.Annotations[SubFilterAnnotation]
+
+	if ok {
+		values := strings.Fields(annotation)
+
+		if len(values)%2 != 0 {
+			reportRoutingIssue(pod, "%s value (%s) is not a space delimited list of {FROM} {TO} pairs", SubFilterAnnotation, annotation)
+
+			return nil
+		}
+
+		for i := 0; i < len(values); i += 2 {
+			subFilters = append(subFilters, &SubFilter{
+				From: values[i],
+				To:   values[i+1],
+			})
 		}
 	}
-	return false
+
+	return subFilters
 }
 
 /*
-GetRoutablePodList returns the routable pods list.
+GetTLSPassthroughPort returns the validated container port TLS connections for the pod's hosts are passed to, without
+terminating TLS, or an empty string when the pod does not accept TLS passthrough connections
 */
-func GetRoutablePodList(config *Config, kubeClient *client.Client) (*api.PodList, error) {
-	// Query the initial list of Pods
-	podList, err := kubeClient.Pods(api.NamespaceAll).List(api.ListOptions{
-		FieldSelector: fields.Everything(),
-		LabelSelector: config.RoutableLabelSelector,
-	})
+func GetTLSPassthroughPort(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[TLSPassthroughPortAnnotation]
 
-	if err != nil {
-		return nil, err
+	if !ok {
+		return ""
 	}
 
-	return podList, nil
+	var ports []int32
+
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			ports = append(ports, port.ContainerPort)
+		}
+	}
+
+	port, err := strconv.Atoi(annotation)
+
+	if err != nil || !utils.IsValidPort(port) {
+		reportRoutingIssue(pod, "%s (%s) is not valid", TLSPassthroughPortAnnotation, annotation)
+
+		return ""
+	} else if !isContainerPort(ports, int32(port)) {
+		reportRoutingIssue(pod, "%s (%s) is not an exposed container port", TLSPassthroughPortAnnotation, annotation)
+
+		return ""
+	}
+
+	return strconv.Itoa(port)
 }
 
+/*
+GetUnixSocket returns the absolute path of the unix socket the pod's routes are proxied to instead of the pod IP and
+port or an empty string when the pod is proxied to over TCP
+*/
+func GetUnixSocket(pod *api.Pod) string {
+	annotation, ok := pod.Annotations[UnixSocketAnnotation]
+
+	if !ok {
+		return ""
+	} else if !unixSocketRegex.MatchString(annotation) {
+		reportRoutingIssue(pod, "%s (%s) is not a valid absolute socket path", UnixSocketAnnotation, annotation)
+
+		return ""
+	}
+
+	return annotation
+}
 
 /*
- Calculate hash for hosts and paths annotations to compare when pod is modified
+ConvertPodToModelWithIssues converts the pod like ConvertPodToModel and returns the issues found in its annotations
 */
-func calculateAnnotationHash(config *Config, pod *api.Pod) (uint64) {
-	h := fnv.New64()
-	h.Write([]byte(pod.Annotations[config.HostsAnnotation]))
-	h.Write([]byte(pod.Annotations[config.PathsAnnotation]))
-	return h.Sum64()
+func ConvertPodToModelWithIssues(config *Config, pod *api.Pod) (*PodWithRoutes, []RouteIssue) {
+	issues := []RouteIssue{}
+
+	routeIssueMutex.Lock()
+	routeIssueCollectors[pod] = &issues
+	routeIssueMutex.Unlock()
+
+	model := ConvertPodToModel(config, pod)
+
+	routeIssueMutex.Lock()
+	delete(routeIssueCollectors, pod)
+	routeIssueMutex.Unlock()
+
+	return model, issues
 }
 
 /*
  Converts a Kubernetes pod model to our model
 */
 func ConvertPodToModel(config *Config, pod *api.Pod) (*PodWithRoutes) {
+	routable, _ := isPodRoutable(pod)
+	corsOrigin := GetCORSOrigin(pod)
+
 	return &PodWithRoutes{
-		Name: pod.Name,
-		Namespace: pod.Namespace,
-		Status: pod.Status.Phase,
-		AnnotationHash: calculateAnnotationHash(config, pod),
-		Routes: GetRoutes(config, pod),
+		Name:                  pod.Name,
+		Namespace:             pod.Namespace,
+		Created:               pod.CreationTimestamp.Time,
+		Status:                pod.Status.Phase,
+		Routable:              routable,
+		AnnotationHash:        calculateAnnotationHash(config, pod),
+		AuthMode:              GetAuthMode(pod),
+		AuthRequest:           GetAuthRequest(pod),
+		AuthRequestSigninURL:  GetAuthRequestSigninURL(pod),
+		BackendHost:           GetBackendHost(pod),
+		CacheBypass:           GetCacheBypass(config, pod),
+		CanaryPercent:         GetCanaryPercent(pod),
+		CORSMethods:           GetCORSMethods(pod, corsOrigin),
+		CORSOrigin:            corsOrigin,
+		Gzip:                  GetGzip(pod),
+		HealthCheck:           GetHealthCheck(config, pod),
+		LoadBalanceMethod:     GetLoadBalanceMethod(pod),
+		MethodRewrites:        GetMethodRewrites(pod),
+		MirrorPercentage:      GetMirrorPercentage(pod),
+		MirrorTarget:          GetMirrorTarget(pod),
+		ProxyCache:            GetProxyCache(config, pod),
+		ProxyCacheLockTimeout: GetProxyCacheLockTimeout(config, pod),
+		ProxyCacheUseStale:    GetProxyCacheUseStale(config, pod),
+		ProxyIgnoreHeaders:    GetProxyIgnoreHeaders(config, pod),
+		RequestBuffering:      GetRequestBuffering(pod),
+		RoutingService:        pod.Annotations[RoutingServiceAnnotation],
+		StripAuthorization:    GetStripAuthorization(pod),
+		SubFilters:            GetSubFilters(pod),
+		TLSPassthroughPort:    GetTLSPassthroughPort(pod),
+		UnixSocket:            GetUnixSocket(pod),
+		Routes:                GetRoutes(config, pod),
 	}
 }
 
@@ -127,130 +1606,389 @@ GetRoutes returns an array of routes defined within the provided pod
 func GetRoutes(config *Config, pod *api.Pod) []*Route {
 	var routes []*Route
 
-	// Do not process pods that are not running
-	if pod.Status.Phase == api.PodRunning {
-		// Do not process pods without an IP
-		if pod.Status.PodIP != "" {
-			var hosts []string
-			var pathPairs []*pathPair
-			var ports []int32
+	// Do not process pods that are not running, do not have an IP or are gated on a condition that is not True
+	if routable, reason := isPodRoutable(pod); routable {
+		var duplicates []string
+		var hosts []string
+		var pathPairs []*pathPair
+		var ports []int32
+
+		annotation, ok := pod.Annotations[config.HostsAnnotation]
 
-			annotation, ok := pod.Annotations[config.HostsAnnotation]
+		// This pod does not have the hosts annotation set
+		if ok && strings.TrimSpace(annotation) == "" {
+			reportNotRoutable(pod, "Empty '%s' annotation", config.HostsAnnotation)
+		} else if ok {
+			// Process the routing hosts
+			for _, host := range splitAnnotation(config, annotation) {
+				// Regex hosts are used as is since they can contain ':' and case-sensitive escapes, so they cannot have a port
+				if strings.HasPrefix(host, "~") {
+					if !isValidRegexHost(host) {
+						reportRoutingIssue(pod, "%s (%s) is not a valid regex host", config.HostsAnnotation, host)
+					} else if !containsString(hosts, host) {
+						hosts = append(hosts, host)
+					} else if !containsString(duplicates, host) {
+						duplicates = append(duplicates, host)
+					}
 
-			// This pod does not have the hosts annotation set
-			if ok {
-				// Process the routing hosts
-				for _, host := range strings.Split(annotation, " ") {
-					valid := hostnameRegex.MatchString(host)
+					continue
+				}
 
-					if !valid {
-						valid = ipRegex.MatchString(host)
+				// Hostnames are case-insensitive so normalize them to avoid duplicate server blocks
+				host = strings.ToLower(host)
+				hostParts := strings.Split(host, ":")
 
-						if !valid {
-							log.Printf("    Pod (%s) routing issue: %s (%s) is not a valid hostname/ip\n", pod.Name, config.HostsAnnotation, host)
+				// Hosts can have an optional port (host:port) that nginx will listen on for the host
+				if len(hostParts) == 2 {
+					port, err := strconv.Atoi(hostParts[1])
 
-							continue
-						}
+					if err != nil || !utils.IsValidPort(port) {
+						reportRoutingIssue(pod, "%s (%s) has an invalid port", config.HostsAnnotation, host)
+
+						continue
+					}
+
+					// Normalize the port (Example: 080 -> 80)
+					host = hostParts[0] + ":" + strconv.Itoa(port)
+				}
+
+				valid := len(hostParts) <= 2 && (hostnameRegex.MatchString(hostParts[0]) || isValidWildcardHost(hostParts[0]))
+
+				if !valid {
+					valid = len(hostParts) <= 2 && ipRegex.MatchString(hostParts[0])
+
+					if !valid {
+						reportRoutingIssue(pod, "%s (%s) is not a valid hostname/ip", config.HostsAnnotation, host)
+
+						continue
 					}
+				}
 
-					// Record the host
+				// Record the host (once)
+				if !containsString(hosts, host) {
 					hosts = append(hosts, host)
+				} else if !containsString(duplicates, host) {
+					duplicates = append(duplicates, host)
 				}
+			}
 
-				// Do not process the routing paths if there are no valid hosts
-				if len(hosts) > 0 {
-					annotation, ok = pod.Annotations[config.PathsAnnotation]
+			// Do not process the routing paths if there are no valid hosts
+			if len(hosts) > 0 {
+				annotation, ok = pod.Annotations[config.PathsAnnotation]
 
-					// Create a list of valid routing ports
-					for _, container := range pod.Spec.Containers {
-						for _, port := range container.Ports {
-							ports = append(ports, port.ContainerPort)
-						}
+				// Create a list of valid routing ports
+				for _, container := range pod.Spec.Containers {
+					for _, port := range container.Ports {
+						ports = append(ports, port.ContainerPort)
 					}
+				}
+
+				if ok && strings.TrimSpace(annotation) == "" {
+					reportNotRoutable(pod, "Empty '%s' annotation", config.PathsAnnotation)
+				} else if ok {
+					for _, publicPath := range splitAnnotation(config, annotation) {
+						pathParts := strings.Split(publicPath, ":")
+
+						if len(pathParts) == 2 {
+							cPathPair := &pathPair{}
 
-					if ok {
-						for _, publicPath := range strings.Split(annotation, " ") {
-							pathParts := strings.Split(publicPath, ":")
+							// Validate the port
+							port, err := strconv.Atoi(pathParts[0])
 
-							if len(pathParts) == 2 {
-								cPathPair := &pathPair{}
+							// Ports can be referenced by container and port index (Example: 0.1)
+							if matches := portIndexRegex.FindStringSubmatch(pathParts[0]); matches != nil {
+								port = getPortByIndex(pod, matches)
 
-								// Validate the port
-								port, err := strconv.Atoi(pathParts[0])
+								if port == 0 {
+									reportRoutingIssue(pod, "%s port index (%s) is out of range", config.PathsAnnotation, pathParts[0])
+								} else if !utils.IsValidPort(port) {
+									reportRoutingIssue(pod, "%s port index (%s) references an invalid port (%d)", config.PathsAnnotation, pathParts[0], port)
 
-								if err != nil || !utils.IsValidPort(port) {
-									log.Printf("    Pod (%s) routing issue: %s port (%s) is not valid\n", pod.Name, config.PathsAnnotation, pathParts[0])
-								} else if !isContainerPort(ports, int32(port)) {
-									log.Printf("    Pod (%s) routing issue: %s port (%s) is not an exposed container port\n", pod.Name, config.PathsAnnotation, pathParts[0])
+									port = 0
 								} else {
-									cPathPair.Port = pathParts[0]
+									cPathPair.Port = strconv.Itoa(port)
+								}
+							} else if err != nil && portNameRegex.MatchString(pathParts[0]) {
+								// Ports can also be referenced by container port name (Example: http)
+								port = getPortByName(pod, pathParts[0])
+
+								if port == 0 {
+									reportRoutingIssue(pod, "%s port name (%s) does not match a container port", config.PathsAnnotation, pathParts[0])
+								} else {
+									cPathPair.Port = strconv.Itoa(port)
+								}
+							} else if err != nil || !utils.IsValidPort(port) {
+								reportRoutingIssue(pod, "%s port (%s) is not valid", config.PathsAnnotation, pathParts[0])
+							} else if !isContainerPort(ports, int32(port)) {
+								reportRoutingIssue(pod, "%s port (%s) is not an exposed container port", config.PathsAnnotation, pathParts[0])
+							} else {
+								cPathPair.Port = pathParts[0]
+							}
+
+							// Validate the path (when necessary)
+							if port > 0 {
+								if pathParts[1] == "" && config.EmptyPathToRoot {
+									reportRoutingIssue(pod, "%s path for port (%s) is empty, using /", config.PathsAnnotation, pathParts[0])
+
+									pathParts[1] = "/"
+								} else if pathParts[1] == "" {
+									reportRoutingIssue(pod, "%s path for port (%s) is empty", config.PathsAnnotation, pathParts[0])
 								}
 
-								// Validate the path (when necessary)
-								if port > 0 {
-									pathSegments := strings.Split(pathParts[1], "/")
-									valid := true
+								pathSegments := strings.Split(pathParts[1], "/")
+								valid := true
 
-									for i, pathSegment := range pathSegments {
-										// Skip the first and last entry
-										if (i == 0 || i == len(pathSegments)-1) && pathSegment == "" {
-											continue
-										} else if !pathSegmentRegex.MatchString(pathSegment) {
-											log.Printf("    Pod (%s) routing issue: publicPath path (%s) is not valid\n", pod.Name, pathParts[1])
+								for i, pathSegment := range pathSegments {
+									// Skip the first and last entry
+									if (i == 0 || i == len(pathSegments)-1) && pathSegment == "" {
+										continue
+									} else if _, isCapture := GetPathCaptureName(pathSegment); !isCapture && !pathSegmentRegex.MatchString(pathSegment) {
+										reportRoutingIssue(pod, "publicPath path (%s) is not valid", pathParts[1])
 
-											valid = false
+										valid = false
 
-											break
-										}
+										break
 									}
+								}
 
-									if valid {
-										cPathPair.Path = pathParts[1]
-									}
+								if valid {
+									cPathPair.Path = pathParts[1]
 								}
+							}
 
-								if cPathPair.Path != "" && cPathPair.Port != "" {
+							if cPathPair.Path != "" && cPathPair.Port != "" {
+								// Record the path pair (once)
+								if !containsPathPair(pathPairs, cPathPair) {
 									pathPairs = append(pathPairs, cPathPair)
+								} else if duplicate := cPathPair.Port + ":" + cPathPair.Path; !containsString(duplicates, duplicate) {
+									duplicates = append(duplicates, duplicate)
 								}
-							} else {
-								log.Printf("    Pod (%s) routing issue: publicPath (%s) is not a valid PORT:PATH combination\n", pod.Name, annotation)
 							}
+						} else {
+							reportRoutingIssue(pod, "publicPath (%s) is not a valid PORT:PATH combination", annotation)
 						}
-					} else {
-						log.Printf("    Pod (%s) is not routable: Missing '%s' annotation\n", pod.Name, config.PathsAnnotation)
 					}
+				} else {
+					reportNotRoutable(pod, "Missing '%s' annotation", config.PathsAnnotation)
+				}
+			}
+
+			if len(duplicates) > 0 && config.WarnOnDuplicateRoutes {
+				reportRoutingIssue(pod, "duplicate hosts/paths were collapsed into single routes: %s", strings.Join(duplicates, " "))
+			}
+
+			// Turn the hosts and path pairs into routes
+			if hosts != nil && pathPairs != nil {
+				pathTemplates := GetPathTemplates(pod)
+				rewrites := GetRewritePaths(pod)
+				targets := getRouteTargets(pod)
+				timeouts := GetProxyTimeouts(pod)
+				websocket := GetWebsocket(pod)
+				weight := GetRoutingWeight(pod)
+
+				// Keep idle websocket connections open unless the pod sets its own read timeout
+				if websocket && (timeouts == nil || timeouts.Read == "") {
+					websocketTimeouts := &Timeouts{
+						Read: WebsocketReadTimeout,
+					}
+
+					if timeouts != nil {
+						websocketTimeouts.Connect = timeouts.Connect
+						websocketTimeouts.Send = timeouts.Send
+					}
+
+					timeouts = websocketTimeouts
+				}
+
+				// Derive the weight from the pod's CPU allocation when the pod does not set its weight
+				if weight == 0 && config.WeightByResources {
+					weight = GetResourceWeight(pod)
 				}
 
-				// Turn the hosts and path pairs into routes
-				if hosts != nil && pathPairs != nil {
-					for _, host := range hosts {
-						for _, cPathPair := range pathPairs {
+				for _, host := range hosts {
+					hostParts := strings.Split(host, ":")
+
+					// Hosts without a port, including regex hosts, use the default port
+					if strings.HasPrefix(host, "~") {
+						hostParts = []string{host, ""}
+					} else if len(hostParts) == 1 {
+						hostParts = append(hostParts, "")
+					}
+
+					for _, cPathPair := range pathPairs {
+						// Never create a route to an invalid port (nginx rejects servers like 10.244.1.16:0)
+						if port, err := strconv.Atoi(cPathPair.Port); err != nil || !utils.IsValidPort(port) {
+							reportRoutingIssue(pod, "%s port (%s) for path (%s) is not valid, skipping the route", config.PathsAnnotation, cPathPair.Port, cPathPair.Path)
+
+							continue
+						}
+
+						for _, target := range targets {
 							routes = append(routes, &Route{
 								Incoming: &Incoming{
-									Host: host,
-									Path: cPathPair.Path,
+									Host:    hostParts[0],
+									Path:    cPathPair.Path,
+									Port:    hostParts[1],
+									Rewrite: rewrites[cPathPair.Path],
 								},
 								Outgoing: &Outgoing{
-									IP:   pod.Status.PodIP,
-									Port: cPathPair.Port,
+									IP:           target,
+									PathTemplate: pathTemplates[cPathPair.Path],
+									Port:         cPathPair.Port,
+									Timeouts:     timeouts,
+									Websocket:    websocket,
+									Weight:       weight,
 								},
 							})
 						}
 					}
 				}
-			} else {
-				log.Printf("    Pod (%s) is not routable: Missing '%s' annotation\n", pod.Name, config.HostsAnnotation)
 			}
 		} else {
-			log.Printf("    Pod (%s) is not routable: Pod does not have an IP\n", pod.Name)
+			reportNotRoutable(pod, "Missing '%s' annotation", config.HostsAnnotation)
 		}
 	} else {
-		log.Printf("    Pod (%s) is not routable: Not running (%s)\n", pod.Name, pod.Status.Phase)
+		reportNotRoutable(pod, "%s", reason)
 	}
 
 	return routes
 }
 
+/*
+GetPodCacheKey returns the key ({NAMESPACE}/{NAME}) used to cache the pod so that pods with the same name in different
+namespaces do not collide
+*/
+func GetPodCacheKey(pod *api.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+/*
+CountRoutablePods returns the number of cached pods with at least one route
+*/
+func CountRoutablePods(cache map[string]*PodWithRoutes) int {
+	count := 0
+
+	for _, cacheEntry := range cache {
+		if len(cacheEntry.Routes) > 0 {
+			count++
+		}
+	}
+
+	return count
+}
+
+/*
+getLabelSelectorRequirements returns the requirements of the label selector, in the key=value, key in (a,b) or !key
+formats
+*/
+func getLabelSelectorRequirements(selector labels.Selector) []string {
+	requirements := []string{}
+	selectorStr := selector.String()
+	depth := 0
+	start := 0
+
+	// Commas separate requirements except within the values of set based requirements
+	for i, char := range selectorStr {
+		switch char {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				requirements = append(requirements, strings.TrimSpace(selectorStr[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	if requirement := strings.TrimSpace(selectorStr[start:]); requirement != "" {
+		requirements = append(requirements, requirement)
+	}
+
+	return requirements
+}
+
+/*
+getLabelRequirementKey returns the label key of the label selector requirement
+*/
+func getLabelRequirementKey(requirement string) string {
+	requirement = strings.TrimSpace(strings.TrimPrefix(requirement, "!"))
+
+	if end := strings.IndexAny(requirement, " !=<>"); end != -1 {
+		return requirement[:end]
+	}
+
+	return requirement
+}
+
+/*
+GetRoutableListSelector returns the label selector used to list and watch routable objects.  When the routable label
+values are treated as booleans, the routable label selector's equality requirements are replaced by requirements on the
+existence of their label so that pods with any truthy value are listed, and then filtered using IsRoutable.
+*/
+func GetRoutableListSelector(config *Config) labels.Selector {
+	if !config.RoutableLabelBoolean {
+		return config.RoutableLabelSelector
+	}
+
+	listRequirements := []string{}
+
+	for _, requirement := range getLabelSelectorRequirements(config.RoutableLabelSelector) {
+		key := getLabelRequirementKey(requirement)
+		operator := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(requirement, "!"), key))
+
+		switch {
+		case strings.HasPrefix(requirement, "!"):
+			// Requiring a label not to exist does not depend on its value
+			listRequirements = append(listRequirements, requirement)
+		case operator == "", strings.HasPrefix(operator, "="), strings.HasPrefix(operator, "in "):
+			listRequirements = append(listRequirements, key)
+		}
+
+		// Other requirements (!=, notin, < and >) are left to IsRoutable since normalized values can satisfy them
+	}
+
+	selector, err := labels.Parse(strings.Join(listRequirements, ","))
+
+	if err != nil {
+		return labels.Everything()
+	}
+
+	return selector
+}
+
+/*
+IsRoutable returns whether or not the labels match the routable label selector.  When the routable label values are
+treated as booleans, truthy values (true, 1 or yes) of the labels used by the selector are matched as "true".
+*/
+func IsRoutable(config *Config, podLabels map[string]string) bool {
+	if config.RoutableLabelSelector.Matches(labels.Set(podLabels)) {
+		return true
+	} else if !config.RoutableLabelBoolean {
+		return false
+	}
+
+	normalizedLabels := labels.Set{}
+
+	for name, value := range podLabels {
+		normalizedLabels[name] = value
+	}
+
+	for _, requirement := range getLabelSelectorRequirements(config.RoutableLabelSelector) {
+		key := getLabelRequirementKey(requirement)
+
+		if value, ok := normalizedLabels[key]; ok {
+			switch strings.ToLower(value) {
+			case "true", "1", "yes":
+				normalizedLabels[key] = "true"
+			}
+		}
+	}
+
+	return config.RoutableLabelSelector.Matches(normalizedLabels)
+}
+
 /*
 UpdatePodCacheForEvents updates the cache based on the pod events and returns if the changes warrant an nginx restart.
 */
@@ -259,55 +1997,124 @@ func UpdatePodCacheForEvents(config *Config, cache map[string]*PodWithRoutes, ev
 
 	for _, event := range events {
 		pod := event.Object.(*api.Pod)
+		cacheKey := GetPodCacheKey(pod)
 
-		log.Printf("  Pod (%s) event: %s\n", pod.Name, event.Type)
+		logging.Infof("  Pod (%s) event: %s\n", pod.Name, event.Type)
+
+		metrics.RecordPodEvent(string(event.Type))
 
 		// Process the event
 		switch event.Type {
 		case watch.Added:
+			// Pods are watched by the existence of the routable labels when their values are treated as booleans
+			if !IsRoutable(config, pod.Labels) {
+				logging.Debugf("    Pod does not match the routable label selector\n")
+
+				continue
+			}
+
 			// This event is likely never going to be handled in the real world because most pod add events happen prior to
 			// pod being routable but it's here just in case.
-			cache[pod.Name] = ConvertPodToModel(config, pod)
+			cache[cacheKey] = ConvertPodToModel(config, pod)
 
-			needsRestart = len(cache[pod.Name].Routes) > 0
+			needsRestart = len(cache[cacheKey].Routes) > 0
 
 		case watch.Deleted:
 			needsRestart = true
-			delete(cache, pod.Name)
+			delete(cache, cacheKey)
 
 		case watch.Modified:
-			podLabels := labels.Set(pod.Labels)
-
 			// Check if the pod still has the routable label
-			if config.RoutableLabelSelector.Matches(podLabels) {
-				cached, ok := cache[pod.Name]
+			if IsRoutable(config, pod.Labels) {
+				cached, ok := cache[cacheKey]
+
+				routable, _ := isPodRoutable(pod)
 
-				// If anything routing related changes, trigger a server restart
-				if !ok || calculateAnnotationHash(config, pod) != cached.AnnotationHash || pod.Status.Phase != cached.Status {
+				// If anything routing related changes, including the pod becoming (un)routable without a phase change (like a
+				// running pod being assigned its IP), trigger a server restart
+				if !ok || calculateAnnotationHash(config, pod) != cached.AnnotationHash || pod.Status.Phase != cached.Status ||
+					routable != cached.Routable {
 					needsRestart = true
 				}
 				
 				// Add/Update the cache entry
-				cache[pod.Name] = ConvertPodToModel(config, pod)
+				cache[cacheKey] = ConvertPodToModel(config, pod)
 			} else {
-				log.Println("    Pod is no longer routable")
+				logging.Infof("    Pod is no longer routable\n")
 
 				// Pod no longer matches the routable label selector so we need to remove it from the cache
 				needsRestart = true
-				delete(cache, pod.Name)
+				delete(cache, cacheKey)
 			}
 		}
 
-		cacheEntry, ok := cache[pod.Name]
+		cacheEntry, ok := cache[cacheKey]
 
 		if ok {
 			if len(cacheEntry.Routes) > 0 {
-				log.Println("    Pod is routable")
+				logging.Debugf("    Pod is routable\n")
 			} else {
-				log.Println("    Pod is not routable")
+				logging.Debugf("    Pod is not routable\n")
+			}
+		}
+	}
+
+	metrics.SetRoutablePods(CountRoutablePods(cache))
+
+	return needsRestart
+}
+
+/*
+IsRoutingServiceEndpoints returns whether any cached pod is routed to the endpoints of the service by its
+RoutingServiceAnnotation
+*/
+func IsRoutingServiceEndpoints(cache map[string]*PodWithRoutes, endpoints *api.Endpoints) bool {
+	for _, cacheEntry := range cache {
+		if cacheEntry.Namespace == endpoints.Namespace && cacheEntry.RoutingService == endpoints.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+UpdateRoutingServiceCacheForEvents updates the routes of the cached pods routed to the services of the endpoints events,
+converting the current pod returned by getPod, and returns if the changes warrant an nginx restart.
+*/
+func UpdateRoutingServiceCacheForEvents(config *Config, cache map[string]*PodWithRoutes, events []watch.Event, getPod func(namespace, name string) (*api.Pod, error)) bool {
+	needsRestart := false
+
+	for _, event := range events {
+		endpoints := event.Object.(*api.Endpoints)
+
+		logging.Infof("  Endpoints (%s) event: %s\n", endpoints.Name, event.Type)
+
+		for cacheKey, cached := range cache {
+			if cached.Namespace != endpoints.Namespace || cached.RoutingService != endpoints.Name {
+				continue
+			}
+
+			pod, err := getPod(cached.Namespace, cached.Name)
+
+			if err != nil {
+				logging.Warnf("Failed to get the pod (%s) routed to the endpoints of %s: %v", cached.Name, endpoints.Name, err)
+
+				continue
+			}
+
+			// Converting the pod resolves the current endpoints of its routing service
+			cache[cacheKey] = ConvertPodToModel(config, pod)
+
+			if !reflect.DeepEqual(cached.Routes, cache[cacheKey].Routes) {
+				logging.Infof("    Pod (%s) routes changed\n", cached.Name)
+
+				needsRestart = true
 			}
 		}
 	}
 
+	metrics.SetRoutablePods(CountRoutablePods(cache))
+
 	return needsRestart
 }
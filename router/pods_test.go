@@ -17,14 +17,20 @@ limitations under the License.
 package router
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"strings"
 	"testing"
 
 	"github.com/30x/k8s-router/kubernetes"
+	"github.com/30x/k8s-router/logging"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/resource"
 	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/intstr"
 	"k8s.io/kubernetes/pkg/watch"
 )
 
@@ -39,7 +45,49 @@ func init() {
 
 	config = envConfig
 
-	log.SetOutput(ioutil.Discard)
+	logging.SetOutput(ioutil.Discard)
+}
+
+/*
+getRoutablePod returns a running pod (testing/testing), with the routable label, that listens on port 3000 and is
+routed to test.github.com/, with the annotations added to its routing annotations (replacing them when they have the same
+name)
+*/
+func getRoutablePod(annotations map[string]string) *api.Pod {
+	podAnnotations := map[string]string{
+		"routingHosts": "test.github.com",
+		"routingPaths": "3000:/",
+	}
+
+	for name, value := range annotations {
+		podAnnotations[name] = value
+	}
+
+	return &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: podAnnotations,
+			Labels: map[string]string{
+				"routable": "true",
+			},
+			Name:      "testing",
+			Namespace: "testing",
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}
 }
 
 func validateRoutes(t *testing.T, desc string, expected, actual []*Route) {
@@ -66,6 +114,7 @@ func validateRoutes(t *testing.T, desc string, expected, actual []*Route) {
 		for _, cRoute := range items {
 			if item.Incoming.Host == cRoute.Incoming.Host &&
 				item.Incoming.Path == cRoute.Incoming.Path &&
+				item.Incoming.Port == cRoute.Incoming.Port &&
 				item.Outgoing.IP == cRoute.Outgoing.IP &&
 				item.Outgoing.Port == cRoute.Outgoing.Port {
 				route = cRoute
@@ -527,7 +576,7 @@ func TestUpdatePodCacheForEvents(t *testing.T) {
 
 	if needsRestart {
 		t.Fatal("Server should not need a restart")
-	} else if _, ok := cache[podName]; !ok {
+	} else if _, ok := cache[GetPodCacheKey(unroutablePod)]; !ok {
 		t.Fatal("Cache should reflect the added pod")
 	}
 
@@ -619,3 +668,2150 @@ func TestUpdatePodCacheForEvents(t *testing.T) {
 		t.Fatal("Cache should reflect the deleted pod")
 	}
 }
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetProxyIgnoreHeaders
+*/
+func TestGetProxyIgnoreHeaders(t *testing.T) {
+	cacheConfig := *config
+
+	cacheConfig.ProxyCachePath = "/var/cache/nginx/router"
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				ProxyCacheAnnotation:         "on",
+				ProxyIgnoreHeadersAnnotation: "cache-control X-Not-Allowed Expires x-accel-buffering",
+			},
+		},
+	}
+
+	headers := GetProxyIgnoreHeaders(&cacheConfig, pod)
+
+	if len(headers) != 3 {
+		t.Fatalf("Expected 3 headers but found %d", len(headers))
+	} else if headers[0] != "Cache-Control" || headers[1] != "Expires" || headers[2] != "X-Accel-Buffering" {
+		t.Fatalf("Unexpected headers: %v", headers)
+	}
+
+	// The caching headers are dropped when the proxy cache is disabled
+	headers = GetProxyIgnoreHeaders(config, pod)
+
+	if len(headers) != 1 || headers[0] != "X-Accel-Buffering" {
+		t.Fatalf("Expected only the X-Accel-Buffering header but found: %v", headers)
+	}
+
+	// The caching headers are dropped when the pod's responses are not cached
+	delete(pod.Annotations, ProxyCacheAnnotation)
+
+	headers = GetProxyIgnoreHeaders(&cacheConfig, pod)
+
+	if len(headers) != 1 || headers[0] != "X-Accel-Buffering" {
+		t.Fatalf("Expected only the X-Accel-Buffering header but found: %v", headers)
+	}
+
+	if len(GetProxyIgnoreHeaders(&cacheConfig, &api.Pod{})) != 0 {
+		t.Fatal("Pods without the annotation should not have any headers")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetProxyCache
+*/
+func TestGetProxyCache(t *testing.T) {
+	cacheConfig := *config
+
+	cacheConfig.ProxyCachePath = "/var/cache/nginx/router"
+
+	if !GetProxyCache(&cacheConfig, getRoutablePod(map[string]string{ProxyCacheAnnotation: "on"})) {
+		t.Fatal("Pod responses should be cached when the annotation is on")
+	} else if GetProxyCache(&cacheConfig, getRoutablePod(map[string]string{ProxyCacheAnnotation: "off"})) {
+		t.Fatal("Pod responses should not be cached when the annotation is off")
+	} else if GetProxyCache(&cacheConfig, getRoutablePod(map[string]string{ProxyCacheAnnotation: "yes"})) {
+		t.Fatal("Pod responses should not be cached when the annotation is invalid")
+	} else if GetProxyCache(&cacheConfig, &api.Pod{}) {
+		t.Fatal("Pod responses should not be cached without the annotation")
+	}
+
+	_, issues := ConvertPodToModelWithIssues(config, getRoutablePod(map[string]string{ProxyCacheAnnotation: "on"}))
+	reported := false
+
+	for _, issue := range issues {
+		if !issue.NotRoutable && strings.Contains(issue.Message, EnvVarProxyCachePath) {
+			reported = true
+		}
+	}
+
+	if !reported {
+		t.Fatalf("Expected a routing issue about %s but found: %v", EnvVarProxyCachePath, issues)
+	} else if GetProxyCache(config, getRoutablePod(map[string]string{ProxyCacheAnnotation: "on"})) {
+		t.Fatal("Pod responses should not be cached when the proxy cache is disabled")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetProxyCacheUseStale
+*/
+func TestGetProxyCacheUseStale(t *testing.T) {
+	cacheConfig := *config
+
+	cacheConfig.ProxyCachePath = "/var/cache/nginx/router"
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				ProxyCacheAnnotation:         "on",
+				ProxyCacheUseStaleAnnotation: "error TIMEOUT http_418 updating error",
+			},
+		},
+	}
+
+	conditions := GetProxyCacheUseStale(&cacheConfig, pod)
+
+	if len(conditions) != 3 {
+		t.Fatalf("Expected 3 conditions but found %d", len(conditions))
+	} else if conditions[0] != "error" || conditions[1] != "timeout" || conditions[2] != "updating" {
+		t.Fatalf("Unexpected conditions: %v", conditions)
+	}
+
+	if len(GetProxyCacheUseStale(config, pod)) != 0 {
+		t.Fatal("Pods should not have any conditions when the proxy cache is disabled")
+	}
+
+	delete(pod.Annotations, ProxyCacheAnnotation)
+
+	if len(GetProxyCacheUseStale(&cacheConfig, pod)) != 0 {
+		t.Fatal("Pods whose responses are not cached should not have any conditions")
+	} else if len(GetProxyCacheUseStale(&cacheConfig, &api.Pod{})) != 0 {
+		t.Fatal("Pods without the annotation should not have any conditions")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes where the routingHosts annotation has mixed-case hosts
+*/
+func TestGetRoutesMixedCaseHosts(t *testing.T) {
+	validateRoutes(t, "mixed-case hosts", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "Test.GitHub.com test.github.com",
+				"routingPaths": "3000:/",
+			},
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes where the pod has wildcard, regex and invalid hosts
+*/
+func TestGetRoutesWildcardAndRegexHosts(t *testing.T) {
+	validateRoutes(t, "wildcard and regex hosts", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "*.example.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "*.example.com",
+				Path: "/",
+				Port: "8080",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "~^(?:API|api)\\d+\\.example\\.com$",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				// Invalid hosts: a trailing dot, a wildcard without a hostname, a wildcard not at the start, an unanchored
+				// regex, an invalid regex and a regex nginx would need quoted
+				"routingHosts": "test. *. test.*.example.com *.example.com *.example.com:8080 ~api\\.example\\.com " +
+					"~^(?:API|api)\\d+\\.example\\.com$ ~^api(\\.example\\.com$ ~^api{2}\\.example\\.com$",
+				"routingPaths": "3000:/",
+			},
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes where the pod has an empty routingPaths path
+*/
+func TestGetRoutesEmptyPublicPathsPath(t *testing.T) {
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "test.github.com",
+				"routingPaths": "3000: 3000:/nodejs",
+			},
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}
+	nodejsRoute := &Route{
+		Incoming: &Incoming{
+			Host: "test.github.com",
+			Path: "/nodejs",
+		},
+		Outgoing: &Outgoing{
+			IP:   "10.244.1.17",
+			Port: "3000",
+		},
+	}
+
+	// Dropped
+	validateRoutes(t, "pod has an empty routingPaths path (dropped)", []*Route{nodejsRoute}, GetRoutes(config, pod))
+
+	// Defaulted to /
+	rootConfig := *config
+
+	rootConfig.EmptyPathToRoot = true
+
+	validateRoutes(t, "pod has an empty routingPaths path (defaulted to /)", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+		nodejsRoute,
+	}, GetRoutes(&rootConfig, pod))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes where the pod has hosts with ports
+*/
+func TestGetRoutesHostsWithPorts(t *testing.T) {
+	validateRoutes(t, "hosts with ports", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+				Port: "8080",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "192.168.0.1",
+				Path: "/",
+				Port: "8081",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com test.github.com:8080 Test.GitHub.com:08080 192.168.0.1:8081",
+	})))
+
+	// Invalid ports
+	validateRoutes(t, "hosts with invalid ports", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingHosts": "test.github.com test.github.com:abc test.github.com:0 test.github.com:65536 " +
+			"test.github.com: test.github.com:80:80",
+	})))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with duplicate hosts and paths
+*/
+func TestGetRoutesDuplicateRoutes(t *testing.T) {
+	var output bytes.Buffer
+
+	logging.SetOutput(&output)
+
+	defer func() {
+		config.WarnOnDuplicateRoutes = DefaultWarnOnDuplicateRoutes
+
+		logging.SetOutput(ioutil.Discard)
+	}()
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "a.com a.com b.com A.com",
+				"routingPaths": "3000:/ 3000:/ 3000:/api",
+			},
+			Name: "duplicates",
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}
+	expected := []*Route{}
+
+	for _, host := range []string{"a.com", "b.com"} {
+		for _, path := range []string{"/", "/api"} {
+			expected = append(expected, &Route{
+				Incoming: &Incoming{
+					Host: host,
+					Path: path,
+				},
+				Outgoing: &Outgoing{
+					IP:   "10.244.1.17",
+					Port: "3000",
+				},
+			})
+		}
+	}
+
+	warning := "Pod (duplicates) routing issue: duplicate hosts/paths were collapsed into single routes: a.com 3000:/\n"
+
+	validateRoutes(t, "duplicate hosts and paths", expected, GetRoutes(config, pod))
+
+	if count := strings.Count(output.String(), warning); count != 1 {
+		t.Fatalf("Expected a single duplicate routes warning but found %d:\n%s", count, output.String())
+	}
+
+	// Duplicates are still collapsed without the warning
+	config.WarnOnDuplicateRoutes = false
+
+	output.Reset()
+
+	validateRoutes(t, "duplicate hosts and paths (no warning)", expected, GetRoutes(config, pod))
+
+	if strings.Contains(output.String(), "duplicate hosts/paths") {
+		t.Fatalf("Expected no duplicate routes warning but found:\n%s", output.String())
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes and
+github.com/30x/k8s-router/router/pods#UpdatePodCacheForEvents suppressing messages below the log level
+*/
+func TestGetRoutesLogLevel(t *testing.T) {
+	var output bytes.Buffer
+
+	logging.SetOutput(&output)
+
+	defer func() {
+		logging.Configure(DefaultLogLevel, DefaultLogFormat)
+		logging.SetOutput(ioutil.Discard)
+	}()
+
+	notRunningPod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Name: "pending",
+		},
+		Status: api.PodStatus{
+			Phase: api.PodPending,
+		},
+	}
+	invalidPathsPod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "test.github.com",
+				"routingPaths": "-1:/",
+			},
+			Name: "invalid",
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}
+	notRoutable := "Pod (pending) is not routable: Not running (Pending)"
+	routingIssue := "Pod (invalid) routing issue: routingPaths port (-1) is not valid"
+
+	// The debug messages are suppressed at the warn level
+	logging.Configure(logging.LevelWarn, logging.FormatText)
+
+	GetRoutes(config, notRunningPod)
+	GetRoutes(config, invalidPathsPod)
+	UpdatePodCacheForEvents(config, map[string]*PodWithRoutes{}, []watch.Event{
+		watch.Event{
+			Type:   watch.Added,
+			Object: notRunningPod,
+		},
+	})
+
+	if strings.Contains(output.String(), notRoutable) || strings.Contains(output.String(), "event: ADDED") {
+		t.Fatalf("Expected the debug and info messages to be suppressed but found:\n%s", output.String())
+	} else if !strings.Contains(output.String(), routingIssue) {
+		t.Fatalf("Expected the routing issue warning but found:\n%s", output.String())
+	}
+
+	// Everything is written at the debug level
+	logging.Configure(logging.LevelDebug, logging.FormatJSON)
+
+	output.Reset()
+
+	GetRoutes(config, notRunningPod)
+
+	if !strings.Contains(output.String(), `"level":"debug","msg":"`+notRoutable+`"`) {
+		t.Fatalf("Expected the not routable debug message but found:\n%s", output.String())
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#ConvertPodToModelWithIssues
+*/
+func TestConvertPodToModelWithIssues(t *testing.T) {
+	model, issues := ConvertPodToModelWithIssues(config, getRoutablePod(map[string]string{
+		"routingHosts":       "test.github.com bad_host",
+		"stripAuthorization": "maybe",
+	}))
+	expected := []RouteIssue{
+		RouteIssue{Message: "stripAuthorization value (maybe) is not a valid boolean"},
+		RouteIssue{Message: "routingHosts (bad_host) is not a valid hostname/ip"},
+	}
+
+	if len(model.Routes) != 1 {
+		t.Fatalf("Expected 1 route but found %d", len(model.Routes))
+	} else if fmt.Sprint(issues) != fmt.Sprint(expected) {
+		t.Fatalf("Unexpected issues: %v", issues)
+	}
+
+	// Only the issues of the converted pod are collected, and only while it is converted
+	pod := getRoutablePod(map[string]string{
+		"routingHosts":       "",
+		"stripAuthorization": "maybe",
+	})
+	collected := []RouteIssue{}
+
+	routeIssueCollectors[pod] = &collected
+
+	ConvertPodToModel(config, getRoutablePod(map[string]string{
+		"routingHosts":       "bad_host",
+		"stripAuthorization": "maybe",
+	}))
+
+	delete(routeIssueCollectors, pod)
+
+	if len(collected) != 0 {
+		t.Fatalf("Expected the issues of other pods not to be collected but found: %v", collected)
+	} else if len(routeIssueCollectors) != 0 {
+		t.Fatal("Expected the collectors to be removed once the pods are converted")
+	}
+
+	if _, issues = ConvertPodToModelWithIssues(config, pod); fmt.Sprint(issues) != fmt.Sprint([]RouteIssue{
+		RouteIssue{Message: "stripAuthorization value (maybe) is not a valid boolean"},
+		RouteIssue{Message: "Empty 'routingHosts' annotation", NotRoutable: true},
+	}) {
+		t.Fatalf("Unexpected issues: %v", issues)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with paths referencing ports by container and port index
+*/
+func TestGetRoutesPortIndex(t *testing.T) {
+	getPod := func(paths string) *api.Pod {
+		pod := getRoutablePod(map[string]string{
+			"routingPaths": paths,
+		})
+
+		pod.Spec.Containers = []api.Container{
+			api.Container{
+				Ports: []api.ContainerPort{
+					api.ContainerPort{
+						ContainerPort: int32(3000),
+					},
+					api.ContainerPort{
+						ContainerPort: int32(3001),
+					},
+				},
+			},
+			api.Container{
+				Ports: []api.ContainerPort{
+					api.ContainerPort{
+						ContainerPort: int32(8080),
+					},
+				},
+			},
+		}
+
+		return pod
+	}
+
+	validateRoutes(t, "paths with port indexes", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/admin",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3001",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/java",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "8080",
+			},
+		},
+	}, GetRoutes(config, getPod("0.1:/admin 1.0:/java")))
+
+	// Out of range indexes
+	validateRoutes(t, "paths with out of range port indexes", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getPod("0.0:/ 0.2:/a 1.1:/b 2.0:/c 99999999999999999999.0:/d 0.1.0:/e")))
+
+	// Indexes referencing invalid container ports (port 0 must never be routed to)
+	invalidPortsPod := getPod("0.0:/ 0.1:/a 1.0:/b")
+
+	invalidPortsPod.Spec.Containers[0].Ports[1].ContainerPort = int32(0)
+	invalidPortsPod.Spec.Containers[1].Ports[0].ContainerPort = int32(70000)
+
+	validateRoutes(t, "paths with port indexes referencing invalid ports", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, invalidPortsPod))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with paths referencing ports by container port name
+*/
+func TestGetRoutesPortName(t *testing.T) {
+	getPod := func(paths string) *api.Pod {
+		pod := getRoutablePod(map[string]string{
+			"routingPaths": paths,
+		})
+
+		pod.Spec.Containers = []api.Container{
+			api.Container{
+				Ports: []api.ContainerPort{
+					api.ContainerPort{
+						ContainerPort: int32(3000),
+						Name:          "http",
+					},
+				},
+			},
+			api.Container{
+				Ports: []api.ContainerPort{
+					api.ContainerPort{
+						ContainerPort: int32(9090),
+						Name:          "grpc",
+					},
+				},
+			},
+		}
+
+		return pod
+	}
+
+	validateRoutes(t, "paths with port names", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/grpc",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "9090",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/numeric",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getPod("http:/ grpc:/grpc 3000:/numeric")))
+
+	// Nonexistent and invalid port names
+	validateRoutes(t, "paths with nonexistent port names", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getPod("http:/ metrics:/metrics HTTP:/upper -http:/dash")))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with the requireCondition annotation
+*/
+func TestGetRoutesRequireCondition(t *testing.T) {
+	getPod := func(conditionType string, status api.ConditionStatus) *api.Pod {
+		pod := getRoutablePod(map[string]string{
+			"requireCondition": conditionType,
+		})
+
+		pod.Status.Conditions = []api.PodCondition{
+			api.PodCondition{
+				Type:   api.PodReady,
+				Status: api.ConditionTrue,
+			},
+			api.PodCondition{
+				Type:   api.PodConditionType("app-ready"),
+				Status: status,
+			},
+		}
+
+		return pod
+	}
+	expected := []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}
+
+	validateRoutes(t, "custom condition true", expected, GetRoutes(config, getPod("app-ready", api.ConditionTrue)))
+	validateRoutes(t, "custom condition false", []*Route{}, GetRoutes(config, getPod("app-ready", api.ConditionFalse)))
+	validateRoutes(t, "custom condition unknown", []*Route{}, GetRoutes(config, getPod("app-ready", api.ConditionUnknown)))
+	validateRoutes(t, "missing custom condition", []*Route{}, GetRoutes(config, getPod("example.com/app-ready", api.ConditionTrue)))
+	validateRoutes(t, "invalid condition type", []*Route{}, GetRoutes(config, getPod("app ready", api.ConditionTrue)))
+
+	// Changing the status of the condition changes the hash so the router is restarted
+	if calculateAnnotationHash(config, getPod("app-ready", api.ConditionTrue)) == calculateAnnotationHash(config, getPod("app-ready", api.ConditionFalse)) {
+		t.Fatal("Expected the annotation hash to change when the required condition changes")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#isPodRoutable
+*/
+func TestIsPodRoutable(t *testing.T) {
+	getPod := func(phase api.PodPhase, ip string, ready api.ConditionStatus) *api.Pod {
+		pod := getRoutablePod(map[string]string{
+			"requireCondition": "Ready",
+		})
+
+		pod.Status = api.PodStatus{
+			Conditions: []api.PodCondition{
+				api.PodCondition{
+					Type:   api.PodReady,
+					Status: ready,
+				},
+			},
+			Phase: phase,
+			PodIP: ip,
+		}
+
+		return pod
+	}
+	ungatedPod := getPod(api.PodRunning, "10.244.1.17", api.ConditionFalse)
+
+	delete(ungatedPod.Annotations, "requireCondition")
+
+	tests := []struct {
+		desc     string
+		pod      *api.Pod
+		routable bool
+		reason   string
+	}{
+		{"running with an IP and ready", getPod(api.PodRunning, "10.244.1.17", api.ConditionTrue), true, ""},
+		{"running with an IP and not ready", getPod(api.PodRunning, "10.244.1.17", api.ConditionFalse), false, "Condition (Ready) is not True (False)"},
+		{"running with an IP without a required condition", ungatedPod, true, ""},
+		{"running without an IP and ready", getPod(api.PodRunning, "", api.ConditionTrue), false, "Pod does not have an IP"},
+		{"pending with an IP and ready", getPod(api.PodPending, "10.244.1.17", api.ConditionTrue), false, "Not running (Pending)"},
+		{"pending without an IP", getPod(api.PodPending, "", api.ConditionFalse), false, "Not running (Pending)"},
+		{"succeeded with an IP", getPod(api.PodSucceeded, "10.244.1.17", api.ConditionFalse), false, "Not running (Succeeded)"},
+		{"failed with an IP", getPod(api.PodFailed, "10.244.1.17", api.ConditionFalse), false, "Not running (Failed)"},
+		{"unknown with an IP and ready", getPod(api.PodUnknown, "10.244.1.17", api.ConditionTrue), false, "Not running (Unknown)"},
+	}
+
+	for _, test := range tests {
+		routable, reason := isPodRoutable(test.pod)
+
+		if routable != test.routable || reason != test.reason {
+			t.Fatalf("Expected %s to be routable (%t: %q) but was (%t: %q)", test.desc, test.routable, test.reason, routable, reason)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#UpdatePodCacheForEvents when a running pod is assigned its IP
+*/
+func TestUpdatePodCacheForEventsPodIPAssigned(t *testing.T) {
+	cache := map[string]*PodWithRoutes{}
+	makePod := func(ip string) *api.Pod {
+		pod := getRoutablePod(nil)
+
+		pod.Status.PodIP = ip
+
+		return pod
+	}
+
+	UpdatePodCacheForEvents(config, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Added,
+			Object: makePod(""),
+		},
+	})
+
+	// The phase and annotations do not change but the pod becomes routable
+	needsRestart := UpdatePodCacheForEvents(config, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Modified,
+			Object: makePod("10.244.1.17"),
+		},
+	})
+
+	if !needsRestart {
+		t.Fatal("Server should need a restart")
+	} else if cached := cache[GetPodCacheKey(makePod(""))]; !cached.Routable || len(cached.Routes) != 1 {
+		t.Fatal("Cache should reflect the routable pod")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with the routingService annotation
+*/
+func TestGetRoutesRoutingService(t *testing.T) {
+	services := map[string][]string{
+		"testing/empty":   []string{},
+		"testing/service": []string{"10.244.1.20", "10.244.2.21"},
+	}
+
+	ServiceEndpointsResolver = func(namespace, name string) ([]string, error) {
+		return services[namespace+"/"+name], nil
+	}
+
+	defer func() {
+		ServiceEndpointsResolver = nil
+	}()
+
+	// Service with multiple endpoint addresses
+	validateRoutes(t, "service with multiple endpoint addresses", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.20",
+				Port: "3000",
+			},
+		},
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.2.21",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getRoutablePod(map[string]string{RoutingServiceAnnotation: "service"})))
+
+	// Service with no endpoint addresses
+	validateRoutes(t, "service without endpoint addresses", []*Route{}, GetRoutes(config, getRoutablePod(map[string]string{
+		RoutingServiceAnnotation: "empty",
+	})))
+
+	// Invalid service name uses the pod IP
+	validateRoutes(t, "invalid service name", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getRoutablePod(map[string]string{RoutingServiceAnnotation: "Not_A_Service"})))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#UpdateRoutingServiceCacheForEvents
+*/
+func TestUpdateRoutingServiceCacheForEvents(t *testing.T) {
+	addresses := []string{"10.244.1.20"}
+
+	ServiceEndpointsResolver = func(namespace, name string) ([]string, error) {
+		return addresses, nil
+	}
+
+	defer func() {
+		ServiceEndpointsResolver = nil
+	}()
+
+	pod := getRoutablePod(map[string]string{
+		RoutingServiceAnnotation: "service",
+	})
+	cache := map[string]*PodWithRoutes{
+		GetPodCacheKey(pod): ConvertPodToModel(config, pod),
+	}
+	getPod := func(namespace, name string) (*api.Pod, error) {
+		return pod, nil
+	}
+	makeEvent := func(namespace, name string) []watch.Event {
+		return []watch.Event{
+			watch.Event{
+				Type: watch.Modified,
+				Object: &api.Endpoints{
+					ObjectMeta: api.ObjectMeta{
+						Name:      name,
+						Namespace: namespace,
+					},
+				},
+			},
+		}
+	}
+
+	if !IsRoutingServiceEndpoints(cache, makeEvent("testing", "service")[0].Object.(*api.Endpoints)) {
+		t.Fatal("The pod should be routed to the service's endpoints")
+	} else if IsRoutingServiceEndpoints(cache, makeEvent("other", "service")[0].Object.(*api.Endpoints)) {
+		t.Fatal("The pod should not be routed to the endpoints of a service in another namespace")
+	}
+
+	// Unchanged endpoints
+	if UpdateRoutingServiceCacheForEvents(config, cache, makeEvent("testing", "service"), getPod) {
+		t.Fatal("Server should not need a restart when the endpoint addresses are unchanged")
+	}
+
+	// Endpoints of an unrelated service
+	addresses = []string{"10.244.2.21"}
+
+	if UpdateRoutingServiceCacheForEvents(config, cache, makeEvent("testing", "other"), getPod) {
+		t.Fatal("Server should not need a restart for the endpoints of an unrelated service")
+	} else if cache[GetPodCacheKey(pod)].Routes[0].Outgoing.IP != "10.244.1.20" {
+		t.Fatal("The routes should not be updated for the endpoints of an unrelated service")
+	}
+
+	// Changed endpoints
+	if !UpdateRoutingServiceCacheForEvents(config, cache, makeEvent("testing", "service"), getPod) {
+		t.Fatal("Server should need a restart when the endpoint addresses change")
+	}
+
+	validateRoutes(t, "changed endpoint addresses", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.2.21",
+				Port: "3000",
+			},
+		},
+	}, cache[GetPodCacheKey(pod)].Routes)
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetEndpointAddresses
+*/
+func TestGetEndpointAddresses(t *testing.T) {
+	addresses := GetEndpointAddresses(&api.Endpoints{
+		Subsets: []api.EndpointSubset{
+			api.EndpointSubset{
+				Addresses: []api.EndpointAddress{
+					api.EndpointAddress{
+						IP: "10.244.1.20",
+					},
+					api.EndpointAddress{
+						IP: "10.244.2.21",
+					},
+				},
+				NotReadyAddresses: []api.EndpointAddress{
+					api.EndpointAddress{
+						IP: "10.244.3.22",
+					},
+				},
+			},
+			api.EndpointSubset{
+				Addresses: []api.EndpointAddress{
+					api.EndpointAddress{
+						IP: "10.244.1.20",
+					},
+				},
+			},
+		},
+	})
+
+	if strings.Join(addresses, " ") != "10.244.1.20 10.244.2.21" {
+		t.Fatalf("Expected the unique ready endpoint addresses but found: %v", addresses)
+	}
+
+	if addresses := GetEndpointAddresses(&api.Endpoints{}); len(addresses) != 0 {
+		t.Fatalf("Expected no endpoint addresses but found: %v", addresses)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with the proxyTimeouts annotation
+*/
+func TestGetRoutesProxyTimeouts(t *testing.T) {
+	getTimeouts := func(annotation *string) *Timeouts {
+		annotations := map[string]string{
+			"routingHosts": "test.github.com",
+			"routingPaths": "3000:/",
+		}
+
+		if annotation != nil {
+			annotations[ProxyTimeoutsAnnotation] = *annotation
+		}
+
+		routes := GetRoutes(config, &api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Annotations: annotations,
+			},
+			Spec: api.PodSpec{
+				Containers: []api.Container{
+					api.Container{
+						Ports: []api.ContainerPort{
+							api.ContainerPort{
+								ContainerPort: int32(3000),
+							},
+						},
+					},
+				},
+			},
+			Status: api.PodStatus{
+				Phase: api.PodRunning,
+				PodIP: "10.244.1.17",
+			},
+		})
+
+		if len(routes) != 1 {
+			t.Fatalf("Expected 1 route but found %d", len(routes))
+		}
+
+		return routes[0].Outgoing.Timeouts
+	}
+	makeAnnotation := func(value string) *string {
+		return &value
+	}
+
+	// No annotation
+	if timeouts := getTimeouts(nil); timeouts != nil {
+		t.Fatalf("Routes without the annotation should use the nginx defaults: %v", timeouts)
+	}
+
+	// Full specification
+	if timeouts := getTimeouts(makeAnnotation("read=120s connect=5s send=30s")); timeouts == nil ||
+		*timeouts != (Timeouts{Connect: "5s", Read: "120s", Send: "30s"}) {
+		t.Fatalf("Unexpected timeouts: %v", timeouts)
+	}
+
+	// Partial specification
+	if timeouts := getTimeouts(makeAnnotation("read=2m")); timeouts == nil || *timeouts != (Timeouts{Read: "2m"}) {
+		t.Fatalf("Unexpected timeouts: %v", timeouts)
+	}
+
+	// Parse errors only drop the invalid entries
+	if timeouts := getTimeouts(makeAnnotation("read=forever connect=5s send write=30s idle=10s")); timeouts == nil ||
+		*timeouts != (Timeouts{Connect: "5s"}) {
+		t.Fatalf("Unexpected timeouts: %v", timeouts)
+	}
+
+	// Only parse errors
+	if timeouts := getTimeouts(makeAnnotation("read=-1s connect=")); timeouts != nil {
+		t.Fatalf("Routes without valid timeouts should use the nginx defaults: %v", timeouts)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes with a custom annotation delimiter
+*/
+func TestGetRoutesAnnotationDelimiter(t *testing.T) {
+	makeRoute := func(host, path string) *Route {
+		return &Route{
+			Incoming: &Incoming{
+				Host: host,
+				Path: path,
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		}
+	}
+	expected := []*Route{
+		makeRoute("test.github.com", "/a"),
+		makeRoute("test.github.com", "/b"),
+		makeRoute("192.168.0.1", "/a"),
+		makeRoute("192.168.0.1", "/b"),
+	}
+	delimitedConfig := *config
+
+	// Comma delimited
+	delimitedConfig.AnnotationDelimiter = ","
+
+	validateRoutes(t, "comma delimited annotations", expected,
+		GetRoutes(&delimitedConfig, getRoutablePod(map[string]string{
+			"routingHosts": "test.github.com, 192.168.0.1",
+			"routingPaths": "3000:/a,3000:/b,",
+		})))
+
+	// Newline delimited
+	delimitedConfig.AnnotationDelimiter = "\n"
+
+	validateRoutes(t, "newline delimited annotations", expected,
+		GetRoutes(&delimitedConfig, getRoutablePod(map[string]string{
+			"routingHosts": "test.github.com\n192.168.0.1\n",
+			"routingPaths": "3000:/a\n\n  3000:/b",
+		})))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetCacheBypass
+*/
+func TestGetCacheBypass(t *testing.T) {
+	cacheConfig := *config
+
+	cacheConfig.ProxyCachePath = "/var/cache/nginx/router"
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				CacheBypassAnnotation: "$cookie_session cookie_user $http_authorization $cookie_session $arg_nocache;",
+				ProxyCacheAnnotation:  "on",
+			},
+		},
+	}
+
+	variables := GetCacheBypass(&cacheConfig, pod)
+
+	if len(variables) != 2 {
+		t.Fatalf("Expected 2 variables but found %d", len(variables))
+	} else if variables[0] != "$cookie_session" || variables[1] != "$http_authorization" {
+		t.Fatalf("Unexpected variables: %v", variables)
+	}
+
+	if len(GetCacheBypass(config, pod)) != 0 {
+		t.Fatal("Pods should not have any variables when the proxy cache is disabled")
+	}
+
+	delete(pod.Annotations, ProxyCacheAnnotation)
+
+	if len(GetCacheBypass(&cacheConfig, pod)) != 0 {
+		t.Fatal("Pods whose responses are not cached should not have any variables")
+	} else if len(GetCacheBypass(&cacheConfig, &api.Pod{})) != 0 {
+		t.Fatal("Pods without the annotation should not have any variables")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetTLSPassthroughPort
+*/
+func TestGetTLSPassthroughPort(t *testing.T) {
+	if port := GetTLSPassthroughPort(getRoutablePod(nil)); port != "" {
+		t.Fatalf("Pods without the annotation should not have a TLS passthrough port: %s", port)
+	}
+
+	if port := GetTLSPassthroughPort(getRoutablePod(map[string]string{
+		TLSPassthroughPortAnnotation: "03000",
+	})); port != "3000" {
+		t.Fatalf("Expected TLS passthrough port 3000 but found %s", port)
+	}
+
+	for _, annotation := range []string{"abc", "0", "65536", "9443"} {
+		if port := GetTLSPassthroughPort(getRoutablePod(map[string]string{
+			TLSPassthroughPortAnnotation: annotation,
+		})); port != "" {
+			t.Fatalf("Invalid TLS passthrough port (%s) should be ignored: %s", annotation, port)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetGzip
+*/
+func TestGetGzip(t *testing.T) {
+	getGzip := func(annotations map[string]string) string {
+		return GetGzip(&api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Annotations: annotations,
+			},
+		})
+	}
+
+	if gzip := getGzip(nil); gzip != "" {
+		t.Fatalf("Pods without the annotation should use the inherited gzip setting: %s", gzip)
+	} else if gzip = getGzip(map[string]string{GzipAnnotation: "off"}); gzip != "off" {
+		t.Fatalf("Expected gzip off but found %s", gzip)
+	} else if gzip = getGzip(map[string]string{GzipAnnotation: "on"}); gzip != "on" {
+		t.Fatalf("Expected gzip on but found %s", gzip)
+	} else if gzip = getGzip(map[string]string{GzipAnnotation: "false"}); gzip != "" {
+		t.Fatalf("Invalid gzip values should use the inherited gzip setting: %s", gzip)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRequestBuffering
+*/
+func TestGetRequestBuffering(t *testing.T) {
+	getRequestBuffering := func(annotations map[string]string) string {
+		return GetRequestBuffering(&api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Annotations: annotations,
+			},
+		})
+	}
+
+	if buffering := getRequestBuffering(nil); buffering != "" {
+		t.Fatalf("Pods without the annotation should use the inherited request buffering setting: %s", buffering)
+	} else if buffering = getRequestBuffering(map[string]string{RequestBufferingAnnotation: "off"}); buffering != "off" {
+		t.Fatalf("Expected request buffering off but found %s", buffering)
+	} else if buffering = getRequestBuffering(map[string]string{RequestBufferingAnnotation: "on"}); buffering != "on" {
+		t.Fatalf("Expected request buffering on but found %s", buffering)
+	} else if buffering = getRequestBuffering(map[string]string{RequestBufferingAnnotation: "false"}); buffering != "" {
+		t.Fatalf("Invalid request buffering values should use the inherited request buffering setting: %s", buffering)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetBackendHost
+*/
+func TestGetBackendHost(t *testing.T) {
+	getBackendHost := func(annotations map[string]string) string {
+		return GetBackendHost(&api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Annotations: annotations,
+			},
+		})
+	}
+
+	if backendHost := getBackendHost(nil); backendHost != "" {
+		t.Fatalf("Pods without the annotation should pass the client's Host header through: %s", backendHost)
+	} else if backendHost = getBackendHost(map[string]string{BackendHostAnnotation: "api.internal"}); backendHost != "api.internal" {
+		t.Fatalf("Expected api.internal but found %s", backendHost)
+	}
+
+	for _, invalid := range []string{"", "api.internal:8080", "api.internal;", "-api.internal", "api internal"} {
+		if backendHost := getBackendHost(map[string]string{BackendHostAnnotation: invalid}); backendHost != "" {
+			t.Fatalf("Invalid backend host (%q) should be ignored: %s", invalid, backendHost)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetUnixSocket
+*/
+func TestGetUnixSocket(t *testing.T) {
+	getUnixSocket := func(annotations map[string]string) string {
+		return GetUnixSocket(&api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Annotations: annotations,
+			},
+		})
+	}
+
+	if unixSocket := getUnixSocket(nil); unixSocket != "" {
+		t.Fatalf("Pods without the annotation should be proxied to over TCP: %s", unixSocket)
+	} else if unixSocket = getUnixSocket(map[string]string{UnixSocketAnnotation: "/var/run/app/app.sock"}); unixSocket != "/var/run/app/app.sock" {
+		t.Fatalf("Expected /var/run/app/app.sock but found %s", unixSocket)
+	}
+
+	for _, invalid := range []string{"", "/", "app.sock", "/var/run//app.sock", "/var/run/app.sock;", "/var/run/app sock", "/var/run/app.sock:/api"} {
+		if unixSocket := getUnixSocket(map[string]string{UnixSocketAnnotation: invalid}); unixSocket != "" {
+			t.Fatalf("Invalid unix socket (%q) should be ignored: %s", invalid, unixSocket)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutingWeight
+*/
+func TestGetRoutingWeight(t *testing.T) {
+	getRoutingWeight := func(annotations map[string]string) int {
+		return GetRoutingWeight(&api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Annotations: annotations,
+			},
+		})
+	}
+
+	if weight := getRoutingWeight(nil); weight != 0 {
+		t.Fatalf("Pods without the annotation should use the nginx default weight: %d", weight)
+	} else if weight = getRoutingWeight(map[string]string{RoutingWeightAnnotation: "3"}); weight != 3 {
+		t.Fatalf("Expected a weight of 3 but found %d", weight)
+	}
+
+	for _, invalid := range []string{"", "0", "-1", "1.5", "heavy"} {
+		if weight := getRoutingWeight(map[string]string{RoutingWeightAnnotation: invalid}); weight != 0 {
+			t.Fatalf("Invalid weight (%q) should use the nginx default weight: %d", invalid, weight)
+		}
+	}
+
+	// The weight is recorded on the outgoing route
+	routes := GetRoutes(config, &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts":          "test.github.com",
+				"routingPaths":          "3000:/",
+				RoutingWeightAnnotation: "5",
+			},
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	})
+
+	if len(routes) != 1 {
+		t.Fatalf("Expected 1 route but found %d", len(routes))
+	} else if routes[0].Outgoing.Weight != 5 {
+		t.Fatalf("Expected the route to have a weight of 5 but found %d", routes[0].Outgoing.Weight)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetResourceWeight
+*/
+func TestGetResourceWeight(t *testing.T) {
+	makeContainer := func(resourceType, cpu string) api.Container {
+		container := api.Container{
+			Ports: []api.ContainerPort{
+				api.ContainerPort{
+					ContainerPort: int32(3000),
+				},
+			},
+		}
+
+		if cpu != "" {
+			resources := api.ResourceList{
+				api.ResourceCPU: resource.MustParse(cpu),
+			}
+
+			if resourceType == "limits" {
+				container.Resources.Limits = resources
+			} else {
+				container.Resources.Requests = resources
+			}
+		}
+
+		return container
+	}
+	makePod := func(annotations map[string]string, containers ...api.Container) *api.Pod {
+		pod := getRoutablePod(annotations)
+
+		pod.Spec.Containers = containers
+
+		return pod
+	}
+
+	for desc, test := range map[string]struct {
+		pod      *api.Pod
+		expected int
+	}{
+		"no resources":         {makePod(nil, makeContainer("", "")), 0},
+		"requests":             {makePod(nil, makeContainer("requests", "2")), 20},
+		"limits":               {makePod(nil, makeContainer("limits", "500m")), 5},
+		"rounded requests":     {makePod(nil, makeContainer("requests", "250m")), 3},
+		"minimum weight":       {makePod(nil, makeContainer("requests", "10m")), 1},
+		"multiple containers":  {makePod(nil, makeContainer("requests", "1"), makeContainer("limits", "500m")), 15},
+		"partially unassigned": {makePod(nil, makeContainer("requests", "300m"), makeContainer("", "")), 3},
+	} {
+		if weight := GetResourceWeight(test.pod); weight != test.expected {
+			t.Fatalf("Expected a weight of %d but found %d: %s", test.expected, weight, desc)
+		}
+	}
+
+	// The weight is only derived from the resources when enabled
+	pod := makePod(nil, makeContainer("requests", "2"))
+
+	if routes := GetRoutes(config, pod); len(routes) != 1 || routes[0].Outgoing.Weight != 0 {
+		t.Fatal("The weight should not be derived from the resources when disabled")
+	}
+
+	config.WeightByResources = true
+
+	defer func() {
+		config.WeightByResources = DefaultWeightByResources
+	}()
+
+	if routes := GetRoutes(config, pod); len(routes) != 1 || routes[0].Outgoing.Weight != 20 {
+		t.Fatal("Expected the route to have the weight derived from the resources")
+	}
+
+	// The routingWeight annotation takes precedence
+	pod = makePod(map[string]string{RoutingWeightAnnotation: "5"}, makeContainer("requests", "2"))
+
+	if routes := GetRoutes(config, pod); len(routes) != 1 || routes[0].Outgoing.Weight != 5 {
+		t.Fatal("Expected the route to have the weight of the routingWeight annotation")
+	}
+
+	// Pods without resources use the nginx default weight
+	pod = makePod(nil, makeContainer("", ""))
+
+	if routes := GetRoutes(config, pod); len(routes) != 1 || routes[0].Outgoing.Weight != 0 {
+		t.Fatal("Pods without resources should use the nginx default weight")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetStripAuthorization
+*/
+func TestGetStripAuthorization(t *testing.T) {
+	if !GetStripAuthorization(getRoutablePod(map[string]string{StripAuthorizationAnnotation: "true"})) {
+		t.Fatal("Authorization header should be stripped")
+	} else if GetStripAuthorization(getRoutablePod(map[string]string{StripAuthorizationAnnotation: "false"})) {
+		t.Fatal("Authorization header should not be stripped")
+	} else if GetStripAuthorization(getRoutablePod(map[string]string{StripAuthorizationAnnotation: "not-a-boolean"})) {
+		t.Fatal("Authorization header should not be stripped for invalid values")
+	} else if GetStripAuthorization(&api.Pod{}) {
+		t.Fatal("Authorization header should not be stripped without the annotation")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetMirrorTarget and github.com/30x/k8s-router/router/pods#GetMirrorPercentage
+*/
+func TestGetMirror(t *testing.T) {
+	makePod := func(target, percentage string) *api.Pod {
+		return getRoutablePod(map[string]string{
+			MirrorPercentageAnnotation: percentage,
+			MirrorTargetAnnotation:     target,
+		})
+	}
+
+	if GetMirrorTarget(makePod("10.244.1.20:8080", "10")) != "10.244.1.20:8080" {
+		t.Fatal("Mirror target should be valid")
+	} else if GetMirrorTarget(makePod("10.244.1.20", "10")) != "" {
+		t.Fatal("Mirror target without a port should be invalid")
+	} else if GetMirrorTarget(makePod("shadow.:8080", "10")) != "" {
+		t.Fatal("Mirror target with an invalid host should be invalid")
+	} else if GetMirrorTarget(makePod("shadow:77777", "10")) != "" {
+		t.Fatal("Mirror target with an invalid port should be invalid")
+	} else if GetMirrorTarget(&api.Pod{}) != "" {
+		t.Fatal("Pods without the annotation should not have a mirror target")
+	}
+
+	if GetMirrorPercentage(makePod("shadow:8080", "10")) != 10 {
+		t.Fatal("Mirror percentage should be 10")
+	} else if GetMirrorPercentage(makePod("shadow:8080", "0")) != 100 {
+		t.Fatal("Invalid mirror percentages should default to 100")
+	} else if GetMirrorPercentage(&api.Pod{}) != 100 {
+		t.Fatal("Mirror percentage should default to 100")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutes where the routing annotations are empty or whitespace-only
+*/
+func TestGetRoutesEmptyAnnotations(t *testing.T) {
+	validateRoutes(t, "empty routingHosts", []*Route{}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingHosts": "",
+	})))
+	validateRoutes(t, "whitespace-only routingHosts", []*Route{}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingHosts": "   ",
+	})))
+	validateRoutes(t, "empty routingPaths", []*Route{}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingPaths": "",
+	})))
+	validateRoutes(t, "whitespace-only routingPaths", []*Route{}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingPaths": "  ",
+	})))
+
+	// Extra whitespace between entries should not produce invalid entries
+	validateRoutes(t, "extra whitespace", []*Route{
+		&Route{
+			Incoming: &Incoming{
+				Host: "test.github.com",
+				Path: "/",
+			},
+			Outgoing: &Outgoing{
+				IP:   "10.244.1.17",
+				Port: "3000",
+			},
+		},
+	}, GetRoutes(config, getRoutablePod(map[string]string{
+		"routingHosts": " test.github.com  ",
+		"routingPaths": "  3000:/ ",
+	})))
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetPathTemplates
+*/
+func TestGetPathTemplates(t *testing.T) {
+	templates := GetPathTemplates(&api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				PathTemplateAnnotation: "/{version}/api=/api?v={version} /{id}=/users/{name} /nodejs=nodejs /invalid " +
+					"/{id}/static=/static /docs=/v2/docs",
+			},
+		},
+	})
+
+	if len(templates) != 2 {
+		t.Fatalf("Expected 2 path templates but found %d", len(templates))
+	} else if templates["/{version}/api"] != "/api?v={version}" {
+		t.Fatalf("Unexpected path template: %s", templates["/{version}/api"])
+	} else if templates["/docs"] != "/v2/docs" {
+		t.Fatalf("Unexpected path template: %s", templates["/docs"])
+	} else if _, ok := templates["/{id}/static"]; ok {
+		t.Fatal("Templates for routing paths with captures should reference a capture")
+	}
+
+	// Routes should carry the backend path template
+	routes := GetRoutes(config, &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts":         "test.github.com",
+				"routingPaths":         "3000:/{version}/api",
+				PathTemplateAnnotation: "/{version}/api=/api?v={version}",
+			},
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(3000),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	})
+
+	if len(routes) != 1 {
+		t.Fatalf("Expected 1 route but found %d", len(routes))
+	} else if routes[0].Outgoing.PathTemplate != "/api?v={version}" {
+		t.Fatalf("Unexpected route path template: %s", routes[0].Outgoing.PathTemplate)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRewritePaths
+*/
+func TestGetRewritePaths(t *testing.T) {
+	rewrites := GetRewritePaths(&api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				RewritePathsAnnotation: "/api/v1=/ /web=web /{version}/api=/api /legacy=/v2;rm /invalid api=/",
+			},
+		},
+	})
+
+	if len(rewrites) != 1 {
+		t.Fatalf("Expected 1 rewrite but found %d", len(rewrites))
+	} else if rewrites["/api/v1"] != "/" {
+		t.Fatalf("Unexpected rewrite: %s", rewrites["/api/v1"])
+	}
+
+	// Routes should carry the rewrite
+	routes := GetRoutes(config, getRoutablePod(map[string]string{
+		"routingPaths":         "3000:/api/v1",
+		RewritePathsAnnotation: "/api/v1=/",
+	}))
+
+	if len(routes) != 1 {
+		t.Fatalf("Expected 1 route but found %d", len(routes))
+	} else if routes[0].Incoming.Rewrite != "/" {
+		t.Fatalf("Unexpected route rewrite: %s", routes[0].Incoming.Rewrite)
+	}
+
+	// Routes without a rewrite should proxy the path as is
+	routes = GetRoutes(config, getRoutablePod(map[string]string{
+		"routingPaths": "3000:/api/v1",
+	}))
+
+	if len(routes) != 1 {
+		t.Fatalf("Expected 1 route but found %d", len(routes))
+	} else if routes[0].Incoming.Rewrite != "" {
+		t.Fatalf("Unexpected route rewrite: %s", routes[0].Incoming.Rewrite)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetProxyCacheLockTimeout
+*/
+func TestGetProxyCacheLockTimeout(t *testing.T) {
+	cacheConfig := *config
+
+	cacheConfig.ProxyCachePath = "/var/cache/nginx/router"
+
+	if GetProxyCacheLockTimeout(&cacheConfig, getRoutablePod(map[string]string{
+		ProxyCacheAnnotation:     "on",
+		ProxyCacheLockAnnotation: "on",
+	})) != DefaultProxyCacheLockTimeout {
+		t.Fatal("Cache lock timeout should default to " + DefaultProxyCacheLockTimeout)
+	} else if GetProxyCacheLockTimeout(&cacheConfig, getRoutablePod(map[string]string{
+		ProxyCacheAnnotation:            "on",
+		ProxyCacheLockAnnotation:        "on",
+		ProxyCacheLockTimeoutAnnotation: "500ms",
+	})) != "500ms" {
+		t.Fatal("Cache lock timeout should be 500ms")
+	} else if GetProxyCacheLockTimeout(&cacheConfig, getRoutablePod(map[string]string{
+		ProxyCacheAnnotation:            "on",
+		ProxyCacheLockAnnotation:        "on",
+		ProxyCacheLockTimeoutAnnotation: "five seconds",
+	})) != DefaultProxyCacheLockTimeout {
+		t.Fatal("Invalid cache lock timeouts should use the default")
+	} else if GetProxyCacheLockTimeout(&cacheConfig, getRoutablePod(map[string]string{
+		ProxyCacheAnnotation:     "on",
+		ProxyCacheLockAnnotation: "off",
+	})) != "" {
+		t.Fatal("Cache lock should be disabled")
+	} else if GetProxyCacheLockTimeout(&cacheConfig, getRoutablePod(map[string]string{
+		ProxyCacheAnnotation:     "on",
+		ProxyCacheLockAnnotation: "yes",
+	})) != "" {
+		t.Fatal("Cache lock should be disabled for invalid values")
+	} else if GetProxyCacheLockTimeout(config, getRoutablePod(map[string]string{
+		ProxyCacheAnnotation:     "on",
+		ProxyCacheLockAnnotation: "on",
+	})) != "" {
+		t.Fatal("Cache lock should be disabled when the proxy cache is disabled")
+	} else if GetProxyCacheLockTimeout(&cacheConfig, getRoutablePod(map[string]string{
+		ProxyCacheLockAnnotation: "on",
+	})) != "" {
+		t.Fatal("Cache lock should be disabled when the pod's responses are not cached")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetCanaryPercent
+*/
+func TestGetCanaryPercent(t *testing.T) {
+	if GetCanaryPercent(getRoutablePod(map[string]string{CanaryPercentAnnotation: "10"})) != 10 {
+		t.Fatal("Canary percent should be 10")
+	} else if GetCanaryPercent(getRoutablePod(map[string]string{CanaryPercentAnnotation: "100"})) != 0 {
+		t.Fatal("Canary percent of 100 should be invalid")
+	} else if GetCanaryPercent(getRoutablePod(map[string]string{CanaryPercentAnnotation: "ten"})) != 0 {
+		t.Fatal("Canary percent should be a number")
+	} else if GetCanaryPercent(&api.Pod{}) != 0 {
+		t.Fatal("Pods without the annotation should not be canaries")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetCORSOrigin and GetCORSMethods
+*/
+func TestGetCORS(t *testing.T) {
+	if origin := GetCORSOrigin(getRoutablePod(nil)); origin != "" {
+		t.Fatalf("Pods without the annotation should not allow cross-origin requests: %s", origin)
+	} else if methods := GetCORSMethods(getRoutablePod(map[string]string{
+		CORSMethodsAnnotation: "GET",
+	}), ""); methods != "" {
+		t.Fatalf("Pods without the cors annotation should not have CORS methods: %s", methods)
+	}
+
+	for _, origin := range []string{"*", "https://app.example.com", "http://localhost:8080"} {
+		if actual := GetCORSOrigin(getRoutablePod(map[string]string{CORSAnnotation: origin})); actual != origin {
+			t.Fatalf("Expected the origin (%s) but found %s", origin, actual)
+		}
+	}
+
+	for _, invalid := range []string{"", "app.example.com", "https://app.example.com/", "https://*.example.com", "https://app.example.com\"; return 200"} {
+		if origin := GetCORSOrigin(getRoutablePod(map[string]string{CORSAnnotation: invalid})); origin != "" {
+			t.Fatalf("Invalid origin (%q) should be ignored: %s", invalid, origin)
+		}
+	}
+
+	if methods := GetCORSMethods(getRoutablePod(map[string]string{
+		CORSAnnotation: "*",
+	}), "*"); methods != DefaultCORSMethods {
+		t.Fatalf("Expected the default CORS methods but found: %s", methods)
+	} else if methods = GetCORSMethods(getRoutablePod(map[string]string{
+		CORSAnnotation:        "*",
+		CORSMethodsAnnotation: "get, post FETCH get",
+	}), "*"); methods != "GET, POST" {
+		t.Fatalf("Expected the valid CORS methods (GET, POST) but found: %s", methods)
+	} else if methods = GetCORSMethods(getRoutablePod(map[string]string{
+		CORSAnnotation:        "*",
+		CORSMethodsAnnotation: "FETCH",
+	}), "*"); methods != DefaultCORSMethods {
+		t.Fatalf("Expected the default CORS methods without valid methods but found: %s", methods)
+	}
+
+	// An invalid origin is only reported once when converting the pod
+	model, issues := ConvertPodToModelWithIssues(config, getRoutablePod(map[string]string{
+		CORSAnnotation:        "app.example.com",
+		CORSMethodsAnnotation: "GET",
+	}))
+
+	if model.CORSOrigin != "" || model.CORSMethods != "" {
+		t.Fatalf("Pods with an invalid origin should not allow cross-origin requests: %s (%s)", model.CORSOrigin,
+			model.CORSMethods)
+	} else if len(issues) != 1 {
+		t.Fatalf("Expected the invalid origin to be reported once but found %d issues: %v", len(issues), issues)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetAuthMode
+*/
+func TestGetAuthMode(t *testing.T) {
+	if mode := GetAuthMode(&api.Pod{}); mode != AuthModeAPIKey {
+		t.Fatalf("Auth mode should default to %s but was %s", AuthModeAPIKey, mode)
+	} else if mode := GetAuthMode(getRoutablePod(map[string]string{
+		AuthModeAnnotation: AuthModeBasic,
+	})); mode != AuthModeBasic {
+		t.Fatalf("Auth mode should be %s but was %s", AuthModeBasic, mode)
+	} else if mode := GetAuthMode(getRoutablePod(map[string]string{
+		AuthModeAnnotation: "digest",
+	})); mode != AuthModeAPIKey {
+		t.Fatalf("Auth mode should fall back to %s for invalid values but was %s", AuthModeAPIKey, mode)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetMethodRewrites
+*/
+func TestGetMethodRewrites(t *testing.T) {
+	rewrites := GetMethodRewrites(getRoutablePod(map[string]string{MethodRewritesAnnotation: "head:GET OPTIONS:GET"}))
+
+	if len(rewrites) != 2 || rewrites["HEAD"] != "GET" || rewrites["OPTIONS"] != "GET" {
+		t.Fatalf("Unexpected method rewrites: %v", rewrites)
+	} else if rewrites = GetMethodRewrites(getRoutablePod(map[string]string{
+		MethodRewritesAnnotation: "HEAD:FETCH GET PURGE:GET HEAD:HEAD",
+	})); rewrites != nil {
+		t.Fatalf("Invalid method rewrites should be ignored: %v", rewrites)
+	} else if rewrites = GetMethodRewrites(&api.Pod{}); rewrites != nil {
+		t.Fatalf("There should be no method rewrites without the annotation: %v", rewrites)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetSubFilters
+*/
+func TestGetSubFilters(t *testing.T) {
+	subFilters := GetSubFilters(getRoutablePod(map[string]string{
+		SubFilterAnnotation: "http://legacy.internal/ https://test.github.com/ 'old' 'new'",
+	}))
+
+	if len(subFilters) != 2 {
+		t.Fatalf("Expected 2 sub filters but found %d", len(subFilters))
+	} else if subFilters[0].From != "http://legacy.internal/" || subFilters[0].To != "https://test.github.com/" {
+		t.Fatalf("Unexpected sub filter: %s -> %s", subFilters[0].From, subFilters[0].To)
+	} else if subFilters[1].From != "'old'" || subFilters[1].To != "'new'" {
+		t.Fatalf("Unexpected sub filter: %s -> %s", subFilters[1].From, subFilters[1].To)
+	} else if subFilters = GetSubFilters(getRoutablePod(map[string]string{
+		SubFilterAnnotation: "http://legacy.internal/",
+	})); subFilters != nil {
+		t.Fatal("Sub filters without a replacement should be ignored")
+	} else if subFilters = GetSubFilters(&api.Pod{}); subFilters != nil {
+		t.Fatal("There should be no sub filters without the annotation")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#UpdatePodCacheForEvents with pods of the same name in different namespaces
+*/
+func TestUpdatePodCacheForEventsSamePodNameDifferentNamespaces(t *testing.T) {
+	cache := map[string]*PodWithRoutes{}
+	pod1 := getRoutablePod(nil)
+	pod2 := getRoutablePod(nil)
+
+	pod1.Namespace = "namespace1"
+	pod1.Status.PodIP = "10.244.1.16"
+	pod2.Namespace = "namespace2"
+
+	UpdatePodCacheForEvents(config, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Added,
+			Object: pod1,
+		},
+		watch.Event{
+			Type:   watch.Added,
+			Object: pod2,
+		},
+	})
+
+	if len(cache) != 2 {
+		t.Fatalf("Cache should have 2 pods but has %d", len(cache))
+	} else if cache[GetPodCacheKey(pod1)].Namespace != "namespace1" || cache[GetPodCacheKey(pod2)].Namespace != "namespace2" {
+		t.Fatal("Cache should key the pods by namespace and name")
+	}
+
+	// Deleting one pod should not remove the other
+	UpdatePodCacheForEvents(config, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Deleted,
+			Object: pod1,
+		},
+	})
+
+	if _, ok := cache[GetPodCacheKey(pod2)]; !ok || len(cache) != 1 {
+		t.Fatal("Cache should only have removed the deleted pod")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#IsRoutable
+*/
+func TestIsRoutable(t *testing.T) {
+	booleanConfig := *config
+
+	booleanConfig.RoutableLabelBoolean = true
+
+	for value, expected := range map[string]bool{
+		"true":  true,
+		"True":  true,
+		"TRUE":  true,
+		"1":     true,
+		"yes":   true,
+		"Yes":   true,
+		"false": false,
+		"0":     false,
+		"no":    false,
+		"":      false,
+		"y":     false,
+	} {
+		podLabels := map[string]string{
+			"routable": value,
+		}
+
+		if actual := IsRoutable(&booleanConfig, podLabels); actual != expected {
+			t.Fatalf("Expected routable label (%s) to be routable (%t) but was (%t)", value, expected, actual)
+		}
+
+		if actual := IsRoutable(config, podLabels); actual != (value == "true") {
+			t.Fatalf("Expected routable label (%s) to be routable (%t) without boolean labels but was (%t)", value,
+				value == "true", actual)
+		}
+	}
+
+	if IsRoutable(&booleanConfig, map[string]string{}) {
+		t.Fatal("Pods without the routable label should not be routable")
+	}
+
+	// Modifying a pod to use a truthy value should not remove it from the cache
+	cache := map[string]*PodWithRoutes{}
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "test.github.com",
+				"routingPaths": "80:/",
+			},
+			Labels: map[string]string{
+				"routable": "true",
+			},
+			Name: "test-pod",
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.17",
+		},
+	}
+
+	UpdatePodCacheForEvents(&booleanConfig, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Added,
+			Object: pod,
+		},
+	})
+
+	modifiedPod := *pod
+
+	modifiedPod.Labels = map[string]string{
+		"routable": "yes",
+	}
+
+	UpdatePodCacheForEvents(&booleanConfig, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Modified,
+			Object: &modifiedPod,
+		},
+	})
+
+	if _, ok := cache[GetPodCacheKey(pod)]; !ok {
+		t.Fatal("Cache should still have the pod with a truthy routable label")
+	}
+
+	UpdatePodCacheForEvents(config, cache, []watch.Event{
+		watch.Event{
+			Type:   watch.Modified,
+			Object: &modifiedPod,
+		},
+	})
+
+	if len(cache) > 0 {
+		t.Fatal("Cache should not have the pod when the routable label is not treated as a boolean")
+	}
+
+	// Pods watched by the existence of the routable label are only added when routable
+	for value, expected := range map[string]bool{
+		"yes": true,
+		"no":  false,
+	} {
+		addedPod := *pod
+
+		addedPod.Labels = map[string]string{
+			"routable": value,
+		}
+
+		cache = map[string]*PodWithRoutes{}
+
+		UpdatePodCacheForEvents(&booleanConfig, cache, []watch.Event{
+			watch.Event{
+				Type:   watch.Added,
+				Object: &addedPod,
+			},
+		})
+
+		if _, ok := cache[GetPodCacheKey(pod)]; ok != expected {
+			t.Fatalf("Expected added pod with routable label (%s) to be cached (%t) but was (%t)", value, expected, ok)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetRoutableListSelector
+*/
+func TestGetRoutableListSelector(t *testing.T) {
+	if selector := GetRoutableListSelector(config); selector.String() != config.RoutableLabelSelector.String() {
+		t.Fatalf("Expected the routable label selector without boolean labels but found: %s", selector)
+	}
+
+	selector, err := labels.Parse("routable=true,tier in (api,web),!legacy,owner!=ops")
+
+	if err != nil {
+		t.Fatalf("Unable to parse label selector: %v", err)
+	}
+
+	booleanConfig := *config
+
+	booleanConfig.RoutableLabelBoolean = true
+	booleanConfig.RoutableLabelSelector = selector
+
+	listSelector := GetRoutableListSelector(&booleanConfig)
+
+	for _, podLabels := range []labels.Set{
+		labels.Set{"routable": "yes", "tier": "api"},
+		labels.Set{"routable": "1", "tier": "db"},
+		labels.Set{"routable": "true", "tier": "web", "owner": "ops"},
+	} {
+		if !listSelector.Matches(podLabels) {
+			t.Fatalf("Expected pods labeled (%s) to be listed using %s", podLabels, listSelector)
+		}
+	}
+
+	for _, podLabels := range []labels.Set{
+		labels.Set{"tier": "api"},
+		labels.Set{"routable": "yes"},
+		labels.Set{"routable": "yes", "tier": "api", "legacy": "true"},
+	} {
+		if listSelector.Matches(podLabels) {
+			t.Fatalf("Expected pods labeled (%s) not to be listed using %s", podLabels, listSelector)
+		}
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#FilterRoutablePods
+*/
+func TestFilterRoutablePods(t *testing.T) {
+	booleanConfig := *config
+
+	booleanConfig.RoutableLabelBoolean = true
+
+	pods := []api.Pod{}
+
+	for _, value := range []string{"true", "yes", "1", "no", "false"} {
+		pods = append(pods, api.Pod{
+			ObjectMeta: api.ObjectMeta{
+				Labels: map[string]string{
+					"routable": value,
+				},
+				Name: value,
+			},
+		})
+	}
+
+	if routablePods := FilterRoutablePods(&booleanConfig, pods); len(routablePods) != 3 {
+		t.Fatalf("Expected 3 routable pods but found %d", len(routablePods))
+	} else if routablePods[0].Name != "true" || routablePods[1].Name != "yes" || routablePods[2].Name != "1" {
+		t.Fatalf("Unexpected routable pods: %s, %s and %s", routablePods[0].Name, routablePods[1].Name, routablePods[2].Name)
+	}
+
+	if routablePods := FilterRoutablePods(config, pods); len(routablePods) != 1 || routablePods[0].Name != "true" {
+		t.Fatalf("Expected only the pod labeled true to be routable without boolean labels but found %d", len(routablePods))
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetLoadBalanceMethod
+*/
+func TestGetLoadBalanceMethod(t *testing.T) {
+	if method := GetLoadBalanceMethod(&api.Pod{}); method != LoadBalanceMethodRoundRobin {
+		t.Fatalf("Load balance method should default to %s but was %s", LoadBalanceMethodRoundRobin, method)
+	} else if method := GetLoadBalanceMethod(getRoutablePod(map[string]string{
+		LoadBalanceMethodAnnotation: LoadBalanceMethodIPHash,
+	})); method != LoadBalanceMethodIPHash {
+		t.Fatalf("Load balance method should be %s but was %s", LoadBalanceMethodIPHash, method)
+	} else if method := GetLoadBalanceMethod(getRoutablePod(map[string]string{
+		LoadBalanceMethodAnnotation: "hash",
+	})); method != LoadBalanceMethodRoundRobin {
+		t.Fatalf("Load balance method should fall back to %s for invalid values but was %s", LoadBalanceMethodRoundRobin, method)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetAuthRequest and github.com/30x/k8s-router/router/pods#GetAuthRequestSigninURL
+*/
+func TestGetAuthRequest(t *testing.T) {
+	makePod := func(authRequest, signinURL string) *api.Pod {
+		return getRoutablePod(map[string]string{
+			AuthRequestAnnotation:          authRequest,
+			AuthRequestSigninURLAnnotation: signinURL,
+		})
+	}
+
+	pod := makePod("http://auth.auth-system.svc.cluster.local/verify", "https://login.github.com/signin")
+
+	if GetAuthRequest(pod) != "http://auth.auth-system.svc.cluster.local/verify" {
+		t.Fatal("Auth request URL should be valid")
+	} else if GetAuthRequestSigninURL(pod) != "https://login.github.com/signin" {
+		t.Fatal("Auth request sign in URL should be valid")
+	}
+
+	for _, invalid := range []string{"/verify", "ftp://auth/verify", "http:///verify", "http://auth/verify;return 200", "http://auth/{verify}"} {
+		if GetAuthRequest(makePod(invalid, invalid)) != "" || GetAuthRequestSigninURL(makePod(invalid, invalid)) != "" {
+			t.Fatalf("URL (%s) should be invalid", invalid)
+		}
+	}
+
+	if GetAuthRequest(&api.Pod{}) != "" || GetAuthRequestSigninURL(&api.Pod{}) != "" {
+		t.Fatal("There should be no auth request URLs without the annotations")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/pods#GetHealthCheck
+*/
+func TestGetHealthCheck(t *testing.T) {
+	makePod := func(readinessProbe, livenessProbe *api.Probe) *api.Pod {
+		pod := getRoutablePod(nil)
+
+		pod.Spec.Containers[0].LivenessProbe = livenessProbe
+		pod.Spec.Containers[0].Ports[0].Name = "http"
+		pod.Spec.Containers[0].ReadinessProbe = readinessProbe
+
+		return pod
+	}
+	makeProbe := func(path string, port intstr.IntOrString) *api.Probe {
+		return &api.Probe{
+			Handler: api.Handler{
+				HTTPGet: &api.HTTPGetAction{
+					Path: path,
+					Port: port,
+				},
+			},
+			PeriodSeconds: 5,
+		}
+	}
+	readinessProbe := makeProbe("/ready", intstr.FromInt(8080))
+	livenessProbe := makeProbe("/alive", intstr.FromString("http"))
+
+	// Readiness is preferred
+	healthCheck := GetHealthCheck(config, makePod(readinessProbe, livenessProbe))
+
+	if healthCheck == nil || healthCheck.Probe != HealthCheckProbeReadiness || healthCheck.Path != "/ready" {
+		t.Fatalf("Health check should be derived from the readiness probe: %v", healthCheck)
+	}
+
+	// Only a liveness probe (named port)
+	healthCheck = GetHealthCheck(config, makePod(nil, livenessProbe))
+
+	if healthCheck == nil || healthCheck.Probe != HealthCheckProbeLiveness || healthCheck.Path != "/alive" || healthCheck.Port != 3000 {
+		t.Fatalf("Health check should be derived from the liveness probe: %v", healthCheck)
+	} else if healthCheck.Interval != 5000 || healthCheck.Timeout != 1000 || healthCheck.Rise != 1 || healthCheck.Fall != 3 {
+		t.Fatalf("Health check should use the probe period and the Kubernetes probe defaults: %v", healthCheck)
+	}
+
+	// Custom preference order
+	livenessConfig := *config
+
+	livenessConfig.HealthCheckProbes = []string{HealthCheckProbeLiveness}
+
+	if healthCheck = GetHealthCheck(&livenessConfig, makePod(readinessProbe, livenessProbe)); healthCheck == nil || healthCheck.Probe != HealthCheckProbeLiveness {
+		t.Fatalf("Health check should be derived from the liveness probe: %v", healthCheck)
+	} else if healthCheck = GetHealthCheck(&livenessConfig, makePod(readinessProbe, nil)); healthCheck != nil {
+		t.Fatalf("Health check should not be derived from probes missing from the preference order: %v", healthCheck)
+	}
+
+	// Health check port annotation
+	healthCheckPod := makePod(readinessProbe, nil)
+
+	healthCheckPod.Spec.Containers[0].Ports = append(healthCheckPod.Spec.Containers[0].Ports, api.ContainerPort{
+		ContainerPort: int32(9090),
+	})
+	healthCheckPod.Annotations = map[string]string{
+		HealthCheckPortAnnotation: "9090",
+	}
+
+	if healthCheck = GetHealthCheck(config, healthCheckPod); healthCheck == nil || healthCheck.Port != 9090 || healthCheck.Path != "/ready" {
+		t.Fatalf("Health check should use the healthCheckPort port: %v", healthCheck)
+	}
+
+	// Invalid health check port annotations fall back to the probe port
+	for _, annotation := range []string{"abc", "0", "65536", "9091"} {
+		healthCheckPod.Annotations[HealthCheckPortAnnotation] = annotation
+
+		if healthCheck = GetHealthCheck(config, healthCheckPod); healthCheck == nil || healthCheck.Port != 8080 {
+			t.Fatalf("Health check should use the probe port for an invalid healthCheckPort (%s): %v", annotation, healthCheck)
+		}
+	}
+
+	// Unresolvable named port and no probes
+	if healthCheck = GetHealthCheck(config, makePod(nil, makeProbe("/alive", intstr.FromString("missing")))); healthCheck != nil {
+		t.Fatalf("Health check should not be derived from a probe with an unknown port: %v", healthCheck)
+	} else if healthCheck = GetHealthCheck(config, makePod(nil, nil)); healthCheck != nil {
+		t.Fatalf("Health check should not be derived without probes: %v", healthCheck)
+	}
+}
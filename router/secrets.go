@@ -17,44 +17,148 @@ limitations under the License.
 package router
 
 import (
-	"log"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/30x/k8s-router/logging"
+	"github.com/30x/k8s-router/metrics"
 
 	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/watch"
 )
 
-func ConvertSecretToModel(config *Config, secret *api.Secret) ([]byte) {
-	apikey, _ := secret.Data[config.APIKeySecretDataField]
-	return apikey
+const (
+	// APIKeyHeaderSecretDataField is the secret data field name storing the header name used to identify the API Key of
+	// the namespace (overrides the API Key header)
+	APIKeyHeaderSecretDataField = "header-name"
+)
+
+/*
+ConvertSecretToModel converts a Kubernetes secret to our model.  The full secret is retained so that each route can use
+the secret data field its authorization mode requires.
+*/
+func ConvertSecretToModel(config *Config, secret *api.Secret) *api.Secret {
+	return secret
+}
+
+func isUsableSecret(config *Config, secret *api.Secret) bool {
+	if _, ok := secret.Data[config.APIKeySecretDataField]; ok {
+		return true
+	}
+
+	_, ok := secret.Data[config.BasicAuthSecretDataField]
+
+	return ok
 }
+
 /*
-GetRouterSecretList returns the router secrets.
+secretDataFields returns the secret data fields the routes use, including the previous API Key field when configured
 */
-func GetRouterSecretList(config *Config, kubeClient *client.Client) (*api.SecretList, error) {
-	// Query all secrets
-	secretList, err := kubeClient.Secrets(api.NamespaceAll).List(api.ListOptions{})
+func secretDataFields(config *Config) []string {
+	fields := []string{config.APIKeySecretDataField, config.BasicAuthSecretDataField, APIKeyHeaderSecretDataField}
 
-	if err != nil {
-		return nil, err
+	if config.PreviousAPIKeyField != "" {
+		fields = append(fields, config.PreviousAPIKeyField)
 	}
 
-	// Filter out the secrets that are not router API Key secrets or that do not have the proper secret key
+	return fields
+}
+
+func secretDataChanged(config *Config, secret, cached *api.Secret) bool {
+	for _, field := range secretDataFields(config) {
+		value, ok := secret.Data[field]
+		cachedValue, cachedOk := cached.Data[field]
+
+		if ok != cachedOk || !bytes.Equal(value, cachedValue) {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+GetAPIKeyHeader returns the header name used to identify the API Key of the secret's namespace: the header name stored in
+the secret, when valid, or the API Key header
+*/
+func GetAPIKeyHeader(config *Config, secret *api.Secret) string {
+	value, ok := secret.Data[APIKeyHeaderSecretDataField]
+
+	if !ok {
+		return config.APIKeyHeader
+	}
+
+	header := strings.TrimSpace(string(value))
+
+	if !headerNameRegex.MatchString(header) {
+		logging.Warnf("    Router secret for namespace (%s) issue: %s (%s) is not a valid header name, using %s\n", secret.Namespace, APIKeyHeaderSecretDataField, header, config.APIKeyHeader)
+
+		return config.APIKeyHeader
+	}
+
+	return header
+}
+
+/*
+preferSecret returns whether the secret should be kept instead of the other secret matching the router secret name in the
+same namespace: a secret with an API Key value wins, otherwise the lowest UID wins so the choice never depends on the
+list order
+*/
+func preferSecret(config *Config, secret, other *api.Secret) bool {
+	_, hasAPIKey := secret.Data[config.APIKeySecretDataField]
+	_, otherHasAPIKey := other.Data[config.APIKeySecretDataField]
+
+	if hasAPIKey != otherHasAPIKey {
+		return hasAPIKey
+	}
+
+	return secret.UID < other.UID
+}
+
+/*
+filterRouterSecrets returns the usable router secrets, at most one per namespace
+*/
+func filterRouterSecrets(config *Config, secrets []api.Secret) []api.Secret {
 	var filtered []api.Secret
+	namespaceIndexes := make(map[string]int)
 
-	for _, secret := range secretList.Items {
+	for _, secret := range secrets {
 		if secret.Name == config.APIKeySecret {
-			_, ok := secret.Data[config.APIKeySecretDataField]
+			if !isUsableSecret(config, &secret) {
+				logging.Warnf("    Router secret for namespace (%s) is not usable: Missing '%s' and '%s' keys\n", secret.Namespace, config.APIKeySecretDataField, config.BasicAuthSecretDataField)
+			} else if i, ok := namespaceIndexes[secret.Namespace]; ok {
+				// The secrets are cached by namespace so only one secret per namespace can be kept
+				logging.Warnf("    Router secret for namespace (%s) issue: multiple secrets match the %s name\n", secret.Namespace, config.APIKeySecret)
 
-			if ok {
-				filtered = append(filtered, secret)
+				if preferSecret(config, &secret, &filtered[i]) {
+					filtered[i] = secret
+				}
 			} else {
-				log.Printf("    Router secret for namespace (%s) is not usable: Missing '%s' key\n", secret.Namespace, config.APIKeySecretDataField)
+				namespaceIndexes[secret.Namespace] = len(filtered)
+				filtered = append(filtered, secret)
 			}
 		}
 	}
 
-	secretList.Items = filtered
+	return filtered
+}
+
+/*
+GetRouterSecretList returns the router secrets.  (Like GetRoutablePodList, the returned list is always complete.)
+*/
+func GetRouterSecretList(config *Config, kubeClient *client.Client) (*api.SecretList, error) {
+	// Query all secrets
+	secretList, err := kubeClient.Secrets(api.NamespaceAll).List(api.ListOptions{})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter out the secrets that are not router API Key secrets or that do not have the proper secret key
+	secretList.Items = filterRouterSecrets(config, secretList.Items)
 
 	return secretList, nil
 }
@@ -62,14 +166,25 @@ func GetRouterSecretList(config *Config, kubeClient *client.Client) (*api.Secret
 /*
 UpdateSecretCacheForEvents updates the cache based on the secret events and returns if the changes warrant an nginx restart.
 */
-func UpdateSecretCacheForEvents(config *Config, cache map[string][]byte, events []watch.Event) bool {
+func UpdateSecretCacheForEvents(config *Config, cache map[string]*api.Secret, events []watch.Event) bool {
 	needsRestart := false
 
 	for _, event := range events {
 		secret := event.Object.(*api.Secret)
 		namespace := secret.Namespace
 
-		log.Printf("  Secret (%s in %s namespace) event: %s\n", secret.Name, secret.Namespace, event.Type)
+		logging.Infof("  Secret (%s in %s namespace) event: %s\n", secret.Name, secret.Namespace, event.Type)
+
+		metrics.RecordSecretEvent(string(event.Type))
+
+		// Another secret matching the router secret name is cached for the namespace so only replace it when preferred
+		if cached, ok := cache[namespace]; ok && cached.UID != secret.UID {
+			logging.Warnf("    Router secret for namespace (%s) issue: multiple secrets match the %s name\n", namespace, config.APIKeySecret)
+
+			if event.Type == watch.Deleted || !preferSecret(config, secret, cached) {
+				continue
+			}
+		}
 
 		// Process the event
 		switch event.Type {
@@ -82,39 +197,138 @@ func UpdateSecretCacheForEvents(config *Config, cache map[string][]byte, events
 			needsRestart = true
 
 		case watch.Modified:
-			cachedAPIKey, ok := cache[namespace]
-			apiKey := ConvertSecretToModel(config, secret)
+			cached, ok := cache[namespace]
 
-			if ok {
+			if ok && secretDataChanged(config, secret, cached) {
+				needsRestart = true
+			}
 
-				if (apiKey == nil && cachedAPIKey != nil) || (apiKey != nil && cachedAPIKey == nil) {
-					needsRestart = true
-				} else if apiKey != nil && cachedAPIKey != nil && len(apiKey) != len(cachedAPIKey) {
-					needsRestart = true
-				} else {
-					for i := range apiKey {
-						if apiKey[i] != cachedAPIKey[i] {
-							needsRestart = true
+			cache[namespace] = ConvertSecretToModel(config, secret)
+		}
 
-							break
-						}
-					}
+		if _, ok := cache[namespace]; ok {
+			for _, field := range secretDataFields(config) {
+				if _, ok := secret.Data[field]; ok {
+					logging.Infof("    Secret has an %s value: yes\n", field)
+				} else {
+					logging.Infof("    Secret has an %s value: no\n", field)
 				}
 			}
+		}
+	}
+
+	return needsRestart
+}
+
+/*
+IsTLSSecret returns whether the secret is one of the TLS secrets used to terminate TLS
+*/
+func IsTLSSecret(config *Config, secret *api.Secret) bool {
+	return config.TLSSecret != "" && secret.Name == config.TLSSecret
+}
+
+func isUsableTLSSecret(secret *api.Secret) bool {
+	if secret.Type != api.SecretTypeTLS {
+		return false
+	}
+
+	_, certOk := secret.Data[api.TLSCertKey]
+	_, keyOk := secret.Data[api.TLSPrivateKeyKey]
+
+	return certOk && keyOk
+}
 
-			cache[namespace] = apiKey
+func tlsSecretDataChanged(secret, cached *api.Secret) bool {
+	for _, field := range []string{api.TLSCertKey, api.TLSPrivateKeyKey} {
+		if !bytes.Equal(secret.Data[field], cached.Data[field]) {
+			return true
 		}
+	}
 
-		if _, ok := cache[namespace]; ok {
-			apiKey := ConvertSecretToModel(config, secret)
+	return false
+}
+
+/*
+GetTLSSecretList returns the usable TLS secrets.  (Like GetRouterSecretList, the returned list is always complete.)
+*/
+func GetTLSSecretList(config *Config, kubeClient *client.Client) (*api.SecretList, error) {
+	// Query all secrets
+	secretList, err := kubeClient.Secrets(api.NamespaceAll).List(api.ListOptions{})
 
-			if apiKey == nil {
-				log.Printf("    Secret has an %s value: no\n", config.APIKeySecretDataField)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filter out the secrets that are not TLS secrets or that do not have the certificate and key
+	var filtered []api.Secret
+
+	for _, secret := range secretList.Items {
+		if IsTLSSecret(config, &secret) {
+			if isUsableTLSSecret(&secret) {
+				filtered = append(filtered, secret)
 			} else {
-				log.Printf("    Secret has an %s value: yes\n", config.APIKeySecretDataField)
+				logging.Warnf("    TLS secret for namespace (%s) is not usable: Not a %s secret with '%s' and '%s' keys\n", secret.Namespace, api.SecretTypeTLS, api.TLSCertKey, api.TLSPrivateKeyKey)
 			}
 		}
 	}
 
+	secretList.Items = filtered
+
+	return secretList, nil
+}
+
+/*
+TLSSecretMatchesHost returns whether the certificate of the TLS secret is valid for the host, using the certificate's
+subject alternative names (Wildcard names match a single label)
+*/
+func TLSSecretMatchesHost(secret *api.Secret, host string) bool {
+	block, _ := pem.Decode(secret.Data[api.TLSCertKey])
+
+	if block == nil {
+		return false
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+
+	if err != nil {
+		logging.Warnf("    TLS secret for namespace (%s) has an invalid certificate: %v\n", secret.Namespace, err)
+
+		return false
+	}
+
+	return cert.VerifyHostname(host) == nil
+}
+
+/*
+UpdateTLSCertCacheForEvents updates the TLS certificate cache based on the secret events and returns if the changes
+warrant an nginx restart.
+*/
+func UpdateTLSCertCacheForEvents(config *Config, cache map[string]*api.Secret, events []watch.Event) bool {
+	needsRestart := false
+
+	for _, event := range events {
+		secret := event.Object.(*api.Secret)
+		namespace := secret.Namespace
+		cached, ok := cache[namespace]
+
+		logging.Infof("  TLS secret (%s in %s namespace) event: %s\n", secret.Name, secret.Namespace, event.Type)
+
+		// Unusable secrets are treated as deleted so that nginx never references a missing certificate
+		if event.Type == watch.Deleted || !isUsableTLSSecret(secret) {
+			if ok {
+				delete(cache, namespace)
+				needsRestart = true
+			}
+
+			continue
+		}
+
+		if !ok || tlsSecretDataChanged(secret, cached) {
+			needsRestart = true
+		}
+
+		cache[namespace] = secret
+	}
+
 	return needsRestart
 }
@@ -17,20 +17,30 @@ limitations under the License.
 package router
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"io/ioutil"
-	"log"
+	"math/big"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/30x/k8s-router/kubernetes"
+	"github.com/30x/k8s-router/logging"
 
 	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/types"
 	"k8s.io/kubernetes/pkg/watch"
 )
 
 // config is set in pods_test.go
 
 func init() {
-	log.SetOutput(ioutil.Discard)
+	logging.SetOutput(ioutil.Discard)
 }
 
 /*
@@ -56,13 +66,82 @@ func TestGetRouterSecretList(t *testing.T) {
 	}
 }
 
+/*
+Test for github.com/30x/k8s-router/router/secrets#filterRouterSecrets with multiple secrets matching the router secret
+name in the same namespace
+*/
+func TestFilterRouterSecretsAmbiguous(t *testing.T) {
+	getSecret := func(namespace, uid, field string) api.Secret {
+		return api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:      config.APIKeySecret,
+				Namespace: namespace,
+				UID:       types.UID(uid),
+			},
+			Data: map[string][]byte{
+				field: []byte("secret"),
+			},
+		}
+	}
+	basicAuthSecret := getSecret("a", "1", config.BasicAuthSecretDataField)
+	apiKeySecret := getSecret("a", "2", config.APIKeySecretDataField)
+	otherAPIKeySecret := getSecret("a", "3", config.APIKeySecretDataField)
+	otherNamespaceSecret := getSecret("b", "4", config.APIKeySecretDataField)
+
+	// The secret with an API Key value is kept regardless of the list order
+	for _, secrets := range [][]api.Secret{
+		[]api.Secret{basicAuthSecret, apiKeySecret, otherAPIKeySecret, otherNamespaceSecret},
+		[]api.Secret{otherNamespaceSecret, otherAPIKeySecret, apiKeySecret, basicAuthSecret},
+	} {
+		filtered := filterRouterSecrets(config, secrets)
+
+		if len(filtered) != 2 {
+			t.Fatalf("Expected one secret per namespace but found %d", len(filtered))
+		}
+
+		for _, secret := range filtered {
+			if secret.Namespace == "a" && secret.UID != apiKeySecret.UID {
+				t.Fatalf("Expected the secret with an API Key value and the lowest UID (%s) but found %s", apiKeySecret.UID, secret.UID)
+			} else if secret.Namespace == "b" && secret.UID != otherNamespaceSecret.UID {
+				t.Fatalf("Expected the only secret of namespace b (%s) but found %s", otherNamespaceSecret.UID, secret.UID)
+			}
+		}
+	}
+
+	// Events for another matching secret do not replace the preferred cached secret
+	cache := map[string]*api.Secret{
+		"a": &apiKeySecret,
+	}
+
+	for _, event := range []watch.Event{
+		watch.Event{
+			Type:   watch.Added,
+			Object: &basicAuthSecret,
+		},
+		watch.Event{
+			Type:   watch.Modified,
+			Object: &otherAPIKeySecret,
+		},
+		watch.Event{
+			Type:   watch.Deleted,
+			Object: &otherAPIKeySecret,
+		},
+	} {
+		if UpdateSecretCacheForEvents(config, cache, []watch.Event{event}) {
+			t.Fatalf("The %s event for a secret that is not preferred should not require a restart", event.Type)
+		} else if cached, ok := cache["a"]; !ok || cached.UID != apiKeySecret.UID {
+			t.Fatalf("The %s event for a secret that is not preferred should not change the cache", event.Type)
+		}
+	}
+}
+
 /*
 Test for github.com/30x/k8s-router/router/secrets#UpdateSecretCacheForEvents
 */
 func TestUpdateSecretCacheForEvents(t *testing.T) {
 	apiKeyStr := "API-Key"
 	apiKey := []byte(apiKeyStr)
-	cache := make(map[string][]byte)
+	cache := make(map[string]*api.Secret)
 	namespace := "my-namespace"
 
 	addedSecret := &api.Secret{
@@ -132,7 +211,7 @@ func TestUpdateSecretCacheForEvents(t *testing.T) {
 		t.Fatal("Server should require a restart")
 	}
 
-	if apiKeyStr == string(cache[namespace][:]) {
+	if apiKeyStr == string(cache[namespace].Data["api-key"]) {
 		t.Fatal("Cache should have the updated secret")
 	}
 
@@ -150,3 +229,234 @@ func TestUpdateSecretCacheForEvents(t *testing.T) {
 		t.Fatal("Cache should not have the deleted secret")
 	}
 }
+
+/*
+Test for github.com/30x/k8s-router/router/secrets#UpdateSecretCacheForEvents with a previous API Key field
+*/
+func TestUpdateSecretCacheForEventsPreviousAPIKey(t *testing.T) {
+	defer func() {
+		config.PreviousAPIKeyField = ""
+	}()
+
+	cache := make(map[string]*api.Secret)
+	getSecret := func(previousAPIKey string) *api.Secret {
+		return &api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:      config.APIKeySecret,
+				Namespace: "my-namespace",
+			},
+			Data: map[string][]byte{
+				"api-key":          []byte("API-Key"),
+				"api-key-previous": []byte(previousAPIKey),
+			},
+		}
+	}
+	modify := func(secret *api.Secret) bool {
+		return UpdateSecretCacheForEvents(config, cache, []watch.Event{
+			watch.Event{
+				Type:   watch.Modified,
+				Object: secret,
+			},
+		})
+	}
+
+	cache["my-namespace"] = getSecret("Old-API-Key")
+
+	// The previous API Key is ignored unless configured
+	if modify(getSecret("Older-API-Key")) {
+		t.Fatal("Server should not require a restart")
+	}
+
+	config.PreviousAPIKeyField = "api-key-previous"
+
+	if !modify(getSecret("Old-API-Key")) {
+		t.Fatal("Server should require a restart")
+	} else if modify(getSecret("Old-API-Key")) {
+		t.Fatal("Server should not require a restart")
+	}
+}
+
+/*
+makeTLSSecret returns a TLS secret with a self-signed certificate for the provided hosts
+*/
+func makeTLSSecret(t *testing.T, namespace string, hosts ...string) *api.Secret {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("Unable to generate the private key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		NotAfter:     time.Now().Add(time.Hour),
+		NotBefore:    time.Now(),
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "k8s-router",
+		},
+	}
+
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("Unable to create the certificate: %v", err)
+	}
+
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+
+	if err != nil {
+		t.Fatalf("Unable to marshal the private key: %v", err)
+	}
+
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "routing-tls",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			api.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}),
+			api.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes}),
+		},
+		Type: api.SecretTypeTLS,
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/secrets#TLSSecretMatchesHost
+*/
+func TestTLSSecretMatchesHost(t *testing.T) {
+	secret := makeTLSSecret(t, "my-namespace", "test.github.com", "*.example.com", "192.168.0.1")
+
+	for _, host := range []string{"test.github.com", "a.example.com", "192.168.0.1"} {
+		if !TLSSecretMatchesHost(secret, host) {
+			t.Fatalf("The certificate should match %s", host)
+		}
+	}
+
+	for _, host := range []string{"github.com", "example.com", "a.b.example.com", "192.168.0.2"} {
+		if TLSSecretMatchesHost(secret, host) {
+			t.Fatalf("The certificate should not match %s", host)
+		}
+	}
+
+	if TLSSecretMatchesHost(&api.Secret{}, "test.github.com") {
+		t.Fatal("Secrets without a certificate should not match any host")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/secrets#UpdateTLSCertCacheForEvents
+*/
+func TestUpdateTLSCertCacheForEvents(t *testing.T) {
+	cache := make(map[string]*api.Secret)
+	namespace := "my-namespace"
+	addedSecret := makeTLSSecret(t, namespace, "test.github.com")
+	modifiedSecret := makeTLSSecret(t, namespace, "test.github.com")
+	unusableSecret := makeTLSSecret(t, namespace, "test.github.com")
+
+	unusableSecret.Type = api.SecretTypeOpaque
+
+	updateCache := func(eventType watch.EventType, secret *api.Secret) bool {
+		return UpdateTLSCertCacheForEvents(config, cache, []watch.Event{
+			watch.Event{
+				Type:   eventType,
+				Object: secret,
+			},
+		})
+	}
+
+	// Test add event
+	if !updateCache(watch.Added, addedSecret) {
+		t.Fatal("Server should require a restart")
+	} else if cache[namespace] != addedSecret {
+		t.Fatal("Cache should reflect the added secret")
+	}
+
+	// Test modify event with an unchanged certificate
+	if updateCache(watch.Modified, addedSecret) {
+		t.Fatal("Server should not require a restart")
+	}
+
+	// Test modify event with a changed certificate
+	if !updateCache(watch.Modified, modifiedSecret) {
+		t.Fatal("Server should require a restart")
+	} else if cache[namespace] != modifiedSecret {
+		t.Fatal("Cache should have the updated secret")
+	}
+
+	// Test modify event making the secret unusable
+	if !updateCache(watch.Modified, unusableSecret) {
+		t.Fatal("Server should require a restart")
+	} else if _, ok := cache[namespace]; ok {
+		t.Fatal("Cache should not have the unusable secret")
+	}
+
+	// Test delete event
+	updateCache(watch.Added, addedSecret)
+
+	if !updateCache(watch.Deleted, addedSecret) {
+		t.Fatal("Server should require a restart")
+	} else if _, ok := cache[namespace]; ok {
+		t.Fatal("Cache should not have the deleted secret")
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/secrets#GetAPIKeyHeader
+*/
+func TestGetAPIKeyHeader(t *testing.T) {
+	makeSecret := func(data map[string][]byte) *api.Secret {
+		return &api.Secret{
+			ObjectMeta: api.ObjectMeta{
+				Name:      config.APIKeySecret,
+				Namespace: "my-namespace",
+			},
+			Data: data,
+		}
+	}
+
+	// No header name uses the API Key header
+	if header := GetAPIKeyHeader(config, makeSecret(map[string][]byte{})); header != config.APIKeyHeader {
+		t.Fatalf("Expected %s but found %s", config.APIKeyHeader, header)
+	}
+
+	// Valid header name
+	if header := GetAPIKeyHeader(config, makeSecret(map[string][]byte{
+		APIKeyHeaderSecretDataField: []byte("X-Tenant-Key\n"),
+	})); header != "X-Tenant-Key" {
+		t.Fatalf("Expected X-Tenant-Key but found %s", header)
+	}
+
+	// Invalid header name uses the API Key header
+	if header := GetAPIKeyHeader(config, makeSecret(map[string][]byte{
+		APIKeyHeaderSecretDataField: []byte("X-Tenant;Key"),
+	})); header != config.APIKeyHeader {
+		t.Fatalf("Expected %s but found %s", config.APIKeyHeader, header)
+	}
+
+	// Changing the header name requires a restart
+	cache := map[string]*api.Secret{
+		"my-namespace": makeSecret(map[string][]byte{
+			"api-key": []byte("API-Key"),
+		}),
+	}
+
+	if !UpdateSecretCacheForEvents(config, cache, []watch.Event{
+		watch.Event{
+			Type: watch.Modified,
+			Object: makeSecret(map[string][]byte{
+				"api-key":                   []byte("API-Key"),
+				APIKeyHeaderSecretDataField: []byte("X-Tenant-Key"),
+			}),
+		},
+	}) {
+		t.Fatal("Server should require a restart when the header name changes")
+	}
+}
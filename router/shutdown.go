@@ -0,0 +1,117 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/30x/k8s-router/logging"
+	"github.com/30x/k8s-router/metrics"
+)
+
+// draining is set to 1 once the shutdown sequence has started so that the readiness endpoint fails
+var draining int32
+
+// shutdownSleep is used to wait for the shutdown grace period (Replaced in tests)
+var shutdownSleep = time.Sleep
+
+/*
+IsReady returns whether the router should receive traffic (false once the shutdown sequence has started)
+*/
+func IsReady() bool {
+	return atomic.LoadInt32(&draining) == 0
+}
+
+/*
+ReadinessHandler returns the handler of the /ready endpoint, responding with a 200 once nginx has been successfully
+configured and a 503 before then or once the router is draining
+*/
+func ReadinessHandler(state *ControllerState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !IsReady() {
+			state.writeStatus(w, false, "draining")
+		} else if state.IsReady() {
+			state.writeStatus(w, true, "ready")
+		} else {
+			state.writeStatus(w, false, "waiting for the first successful reload")
+		}
+	}
+}
+
+/*
+newReadinessMux returns the mux serving the /config-hash, /healthz, /metrics and /ready (also served as /readyz)
+endpoints, and the /validate endpoint when the debug endpoints are enabled
+*/
+func newReadinessMux(config *Config, state *ControllerState) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/config-hash", ConfigHashHandler)
+	mux.HandleFunc("/healthz", state.HealthzHandler)
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/ready", ReadinessHandler(state))
+	mux.HandleFunc("/readyz", ReadinessHandler(state))
+
+	if config.EnableDebugEndpoints {
+		mux.HandleFunc("/validate", ValidateHandler(config))
+	}
+
+	return mux
+}
+
+/*
+StartReadinessServer serves the /config-hash, /healthz, /metrics, /ready and /readyz endpoints, and the /validate
+endpoint when the debug endpoints are enabled, on config.ReadinessPort in the background, unless it is 0
+*/
+func StartReadinessServer(config *Config, state *ControllerState) {
+	if config.ReadinessPort == 0 {
+		return
+	}
+
+	mux := newReadinessMux(config, state)
+
+	go func() {
+		if err := http.ListenAndServe(":"+strconv.Itoa(config.ReadinessPort), mux); err != nil {
+			logging.Fatalf("Failed to serve the readiness endpoint: %v.", err)
+		}
+	}()
+}
+
+/*
+Shutdown drains the router before it exits: no new events are accepted, the readiness endpoint starts failing so the
+load balancer stops sending traffic, the router waits config.ShutdownGracePeriod for in-flight connections and then nginx
+is asked to quit gracefully.  The caller is expected to exit once this returns.
+*/
+func Shutdown(config *Config, stopEvents, quitServer func()) {
+	logging.Infof("Shutting down the Kubernetes Router\n")
+
+	stopEvents()
+
+	atomic.StoreInt32(&draining, 1)
+
+	if config.ShutdownGracePeriod > 0 {
+		logging.Infof("  Waiting %s for connections to drain", config.ShutdownGracePeriod)
+
+		shutdownSleep(config.ShutdownGracePeriod)
+	}
+
+	logging.Infof("  Stopping nginx\n")
+
+	quitServer()
+}
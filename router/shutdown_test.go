@@ -0,0 +1,119 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runShutdown(t *testing.T, gracePeriod time.Duration) []string {
+	var steps []string
+
+	shutdownSleep = func(d time.Duration) {
+		if IsReady() {
+			t.Fatal("The router should not be ready while connections drain")
+		} else if d != gracePeriod {
+			t.Fatalf("Expected a %s grace period but found %s", gracePeriod, d)
+		}
+
+		steps = append(steps, "drain")
+	}
+
+	defer func() {
+		shutdownSleep = time.Sleep
+		atomic.StoreInt32(&draining, 0)
+	}()
+
+	Shutdown(&Config{
+		ShutdownGracePeriod: gracePeriod,
+	}, func() {
+		if !IsReady() {
+			t.Fatal("The router should still be ready when the events are stopped")
+		}
+
+		steps = append(steps, "stop events")
+	}, func() {
+		if IsReady() {
+			t.Fatal("The router should not be ready when nginx quits")
+		}
+
+		steps = append(steps, "quit nginx")
+	})
+
+	return steps
+}
+
+/*
+Test for github.com/30x/k8s-router/router/shutdown#Shutdown
+*/
+func TestShutdown(t *testing.T) {
+	steps := runShutdown(t, 10*time.Second)
+	expected := []string{"stop events", "drain", "quit nginx"}
+
+	if !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("Expected shutdown steps %v but found %v", expected, steps)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/shutdown#Shutdown without a grace period
+*/
+func TestShutdownWithoutGracePeriod(t *testing.T) {
+	steps := runShutdown(t, 0)
+	expected := []string{"stop events", "quit nginx"}
+
+	if !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("Expected shutdown steps %v but found %v", expected, steps)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/shutdown#ReadinessHandler
+*/
+func TestReadinessHandler(t *testing.T) {
+	state := &ControllerState{}
+	getStatus := func() int {
+		recorder := httptest.NewRecorder()
+
+		ReadinessHandler(state)(recorder, &http.Request{})
+
+		return recorder.Code
+	}
+
+	defer atomic.StoreInt32(&draining, 0)
+
+	if status := getStatus(); status != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a %d before the first reload but found %d", http.StatusServiceUnavailable, status)
+	}
+
+	state.RecordReload(nil)
+
+	if status := getStatus(); status != http.StatusOK {
+		t.Fatalf("Expected a %d while ready but found %d", http.StatusOK, status)
+	}
+
+	atomic.StoreInt32(&draining, 1)
+
+	if status := getStatus(); status != http.StatusServiceUnavailable {
+		t.Fatalf("Expected a %d while draining but found %d", http.StatusServiceUnavailable, status)
+	}
+}
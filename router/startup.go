@@ -0,0 +1,96 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"time"
+
+	"github.com/30x/k8s-router/logging"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// startupSleep is used to wait between startup retries (Replaced in tests)
+var startupSleep = time.Sleep
+
+/*
+RetryOnStartup calls the provided function until it succeeds, retrying up to config.StartupRetries times with the
+interval between retries starting at config.StartupRetryInterval and doubling after each failure.  The error of the
+last attempt is returned when all retries fail.
+*/
+func RetryOnStartup(config *Config, desc string, fn func() error) error {
+	interval := config.StartupRetryInterval
+	err := fn()
+
+	for retry := 1; err != nil && retry <= config.StartupRetries; retry++ {
+		logging.Warnf("  Failed to %s (retry %d of %d in %s): %v\n", desc, retry, config.StartupRetries, interval, err)
+
+		startupSleep(interval)
+
+		interval *= 2
+		err = fn()
+	}
+
+	return err
+}
+
+/*
+SettleCache applies the pod and secret events received within config.StartupSettleDelay to the cache so that the
+initial nginx configuration includes the pods discovered while the cluster is still starting
+*/
+func SettleCache(config *Config, cache *Cache, podWatcher, secretWatcher watch.Interface) {
+	var podEvents []watch.Event
+	var secretEvents []watch.Event
+	var tlsCertEvents []watch.Event
+
+	podChan := podWatcher.ResultChan()
+	secretChan := secretWatcher.ResultChan()
+	settled := time.After(config.StartupSettleDelay)
+
+	for podChan != nil || secretChan != nil {
+		select {
+		case event, ok := <-podChan:
+			if !ok {
+				// Leave handling the closed watcher to the caller
+				podChan = nil
+			} else {
+				podEvents = append(podEvents, event)
+			}
+
+		case event, ok := <-secretChan:
+			if !ok {
+				// Leave handling the closed watcher to the caller
+				secretChan = nil
+			} else if secret := event.Object.(*api.Secret); secret.Name == config.APIKeySecret {
+				secretEvents = append(secretEvents, event)
+			} else if IsTLSSecret(config, secret) {
+				tlsCertEvents = append(tlsCertEvents, event)
+			}
+
+		case <-settled:
+			podChan = nil
+			secretChan = nil
+		}
+	}
+
+	logging.Infof("  Events found while settling: %d pod, %d secret, %d TLS secret", len(podEvents), len(secretEvents), len(tlsCertEvents))
+
+	UpdatePodCacheForEvents(config, cache.Pods, podEvents)
+	UpdateSecretCacheForEvents(config, cache.Secrets, secretEvents)
+	UpdateTLSCertCacheForEvents(config, cache.TLSCerts, tlsCertEvents)
+}
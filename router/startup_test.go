@@ -0,0 +1,193 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+/*
+fakeClient is a client whose pod list fails a configured number of times before succeeding
+*/
+type fakeClient struct {
+	calls    int
+	failures int
+}
+
+func (c *fakeClient) List() (*api.PodList, error) {
+	c.calls++
+
+	if c.calls <= c.failures {
+		return nil, errors.New("the server is currently unable to handle the request")
+	}
+
+	return &api.PodList{}, nil
+}
+
+func retryList(retries, failures int) (*fakeClient, []time.Duration, error) {
+	var sleeps []time.Duration
+
+	startupSleep = func(d time.Duration) {
+		sleeps = append(sleeps, d)
+	}
+
+	defer func() {
+		startupSleep = time.Sleep
+	}()
+
+	kubeClient := &fakeClient{failures: failures}
+	err := RetryOnStartup(&Config{
+		StartupRetries:       retries,
+		StartupRetryInterval: time.Second,
+	}, "query the initial list of pods", func() error {
+		_, err := kubeClient.List()
+
+		return err
+	})
+
+	return kubeClient, sleeps, err
+}
+
+/*
+Test for github.com/30x/k8s-router/router/startup#RetryOnStartup when the client recovers before the retries run out
+*/
+func TestRetryOnStartupRecovers(t *testing.T) {
+	kubeClient, sleeps, err := retryList(3, 2)
+
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	} else if kubeClient.calls != 3 {
+		t.Fatalf("Expected 3 calls but there were %d", kubeClient.calls)
+	} else if len(sleeps) != 2 || sleeps[0] != time.Second || sleeps[1] != 2*time.Second {
+		t.Fatalf("Expected to back off for 1s and 2s but slept for %v", sleeps)
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/startup#RetryOnStartup when the client never recovers
+*/
+func TestRetryOnStartupGivesUp(t *testing.T) {
+	kubeClient, sleeps, err := retryList(2, 5)
+
+	if err == nil {
+		t.Fatal("Expected an error once the retries ran out")
+	} else if kubeClient.calls != 3 {
+		t.Fatalf("Expected 3 calls but there were %d", kubeClient.calls)
+	} else if len(sleeps) != 2 {
+		t.Fatalf("Expected 2 retries but there were %d", len(sleeps))
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/startup#RetryOnStartup without retries (fail fast)
+*/
+func TestRetryOnStartupFailFast(t *testing.T) {
+	kubeClient, sleeps, err := retryList(0, 1)
+
+	if err == nil {
+		t.Fatal("Expected an error without retries")
+	} else if kubeClient.calls != 1 {
+		t.Fatalf("Expected 1 call but there were %d", kubeClient.calls)
+	} else if len(sleeps) != 0 {
+		t.Fatalf("Expected no retries but there were %d", len(sleeps))
+	}
+}
+
+/*
+Test for github.com/30x/k8s-router/router/startup#SettleCache
+*/
+func TestSettleCache(t *testing.T) {
+	cache := &Cache{
+		Pods:     make(map[string]*PodWithRoutes),
+		Secrets:  make(map[string]*api.Secret),
+		TLSCerts: make(map[string]*api.Secret),
+	}
+	podWatcher := watch.NewFake()
+	secretWatcher := watch.NewFake()
+	settleConfig := *config
+
+	settleConfig.StartupSettleDelay = 100 * time.Millisecond
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: map[string]string{
+				"routingHosts": "test.github.com",
+				"routingPaths": "80:/",
+			},
+			Labels: map[string]string{
+				"routable": "true",
+			},
+			Name:      "late-pod",
+			Namespace: "testing",
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: []api.ContainerPort{
+						api.ContainerPort{
+							ContainerPort: int32(80),
+						},
+					},
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: "10.244.1.16",
+		},
+	}
+	secret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      config.APIKeySecret,
+			Namespace: "testing",
+		},
+		Data: map[string][]byte{
+			"api-key": []byte("API-Key"),
+		},
+	}
+	ignoredSecret := &api.Secret{
+		ObjectMeta: api.ObjectMeta{
+			Name:      "not-the-router-secret",
+			Namespace: "other",
+		},
+		Data: map[string][]byte{
+			"api-key": []byte("API-Key"),
+		},
+	}
+
+	// Send the events during the settle window (The fake watchers block until the events are read)
+	go func() {
+		podWatcher.Add(pod)
+		secretWatcher.Add(secret)
+		secretWatcher.Add(ignoredSecret)
+	}()
+
+	SettleCache(&settleConfig, cache, podWatcher, secretWatcher)
+
+	if cacheEntry, ok := cache.Pods[GetPodCacheKey(pod)]; !ok || len(cacheEntry.Routes) != 1 {
+		t.Fatal("Cache should include the routable pod added while settling")
+	} else if _, ok := cache.Secrets["testing"]; !ok {
+		t.Fatal("Cache should include the router secret added while settling")
+	} else if _, ok := cache.Secrets["other"]; ok {
+		t.Fatal("Cache should not include secrets that are not router secrets")
+	}
+}
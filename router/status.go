@@ -0,0 +1,132 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ControllerState tracks the health of the controller's watch loop.  It is safe for concurrent use so that the watch loop
+can update it while the status endpoints read it.
+*/
+type ControllerState struct {
+	cacheBuilt bool
+	lastError  string
+	lastReload time.Time
+	mutex      sync.RWMutex
+}
+
+/*
+SetCacheBuilt records that the initial cache has been built from the cluster
+*/
+func (state *ControllerState) SetCacheBuilt() {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	state.cacheBuilt = true
+}
+
+/*
+RecordReload records the outcome of applying a configuration to nginx, updating the last successful reload time on
+success and the last error on failure
+*/
+func (state *ControllerState) RecordReload(err error) {
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if err != nil {
+		state.lastError = err.Error()
+	} else {
+		state.lastReload = time.Now()
+	}
+}
+
+/*
+IsHealthy returns whether the initial cache has been built
+*/
+func (state *ControllerState) IsHealthy() bool {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	return state.cacheBuilt
+}
+
+/*
+IsReady returns whether nginx has been successfully configured at least once
+*/
+func (state *ControllerState) IsReady() bool {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	return !state.lastReload.IsZero()
+}
+
+/*
+LastReload returns the time of the last successful reload (the zero time until nginx is first configured)
+*/
+func (state *ControllerState) LastReload() time.Time {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	return state.lastReload
+}
+
+/*
+LastError returns the error of the last failed reload (empty when no reload has failed)
+*/
+func (state *ControllerState) LastError() string {
+	state.mutex.RLock()
+	defer state.mutex.RUnlock()
+
+	return state.lastError
+}
+
+/*
+writeStatus writes the controller state, responding with a 200 when ok and a 503 otherwise
+*/
+func (state *ControllerState) writeStatus(w http.ResponseWriter, ok bool, status string) {
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	fmt.Fprintln(w, status)
+
+	if lastReload := state.LastReload(); !lastReload.IsZero() {
+		fmt.Fprintf(w, "last reload: %s\n", lastReload.Format(time.RFC3339))
+	}
+
+	if lastError := state.LastError(); lastError != "" {
+		fmt.Fprintf(w, "last error: %s\n", lastError)
+	}
+}
+
+/*
+HealthzHandler serves the /healthz endpoint, responding with a 200 once the initial cache has been built
+*/
+func (state *ControllerState) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	if state.IsHealthy() {
+		state.writeStatus(w, true, "ok")
+	} else {
+		state.writeStatus(w, false, "building the initial cache")
+	}
+}
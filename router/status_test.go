@@ -0,0 +1,88 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/*
+Test for github.com/30x/k8s-router/router/status#ControllerState served by the readiness server
+*/
+func TestStatusEndpoints(t *testing.T) {
+	state := &ControllerState{}
+	server := httptest.NewServer(newReadinessMux(&Config{}, state))
+
+	defer server.Close()
+
+	get := func(path string) (int, string) {
+		res, err := http.Get(server.URL + path)
+
+		if err != nil {
+			t.Fatalf("Failed to request %s: %v", path, err)
+		}
+
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+
+		if err != nil {
+			t.Fatalf("Failed to read the %s response: %v", path, err)
+		}
+
+		return res.StatusCode, string(body)
+	}
+	validateStatus := func(path string, expectedCode int, expectedBody string) {
+		if code, body := get(path); code != expectedCode {
+			t.Fatalf("Expected %s to respond with a %d but found %d", path, expectedCode, code)
+		} else if !strings.Contains(body, expectedBody) {
+			t.Fatalf("Expected the %s response to contain %q but found:\n%s", path, expectedBody, body)
+		}
+	}
+
+	// Before the initial cache is built
+	validateStatus("/healthz", http.StatusServiceUnavailable, "building the initial cache")
+	validateStatus("/ready", http.StatusServiceUnavailable, "waiting for the first successful reload")
+	validateStatus("/readyz", http.StatusServiceUnavailable, "waiting for the first successful reload")
+
+	// The initial cache is built but the first reload failed
+	state.SetCacheBuilt()
+	state.RecordReload(errors.New("nginx: [emerg] invalid configuration"))
+
+	validateStatus("/healthz", http.StatusOK, "ok")
+	validateStatus("/ready", http.StatusServiceUnavailable, "last error: nginx: [emerg] invalid configuration")
+
+	// The reload succeeded
+	state.RecordReload(nil)
+
+	validateStatus("/ready", http.StatusOK, "ready\nlast reload: ")
+	validateStatus("/readyz", http.StatusOK, "ready\nlast reload: ")
+
+	if state.LastReload().IsZero() {
+		t.Fatal("The last successful reload time should be recorded")
+	}
+
+	// A later failure keeps the router ready but is reported
+	state.RecordReload(errors.New("reload failed"))
+
+	validateStatus("/ready", http.StatusOK, "last error: reload failed")
+}
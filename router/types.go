@@ -17,6 +17,8 @@ limitations under the License.
 package router
 
 import (
+	"time"
+
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/labels"
 )
@@ -25,30 +27,207 @@ import (
 Cache is the structure containing the router API Keys and the routable pods cache
 */
 type Cache struct {
-	Pods    map[string]*PodWithRoutes
-	Secrets map[string][]byte
+	Pods     map[string]*PodWithRoutes
+	Secrets  map[string]*api.Secret
+	TLSCerts map[string]*api.Secret
 }
 
 /*
 Config is the structure containing the configuration
 */
 type Config struct {
+	// Whether redirects generated by nginx use absolute URLs (absolute_redirect)
+	AbsoluteRedirect bool
+	// The access log format preset (combined, timing or upstream)
+	AccessLogFormat string
+	// The access log path used with the access log format
+	AccessLogPath string
+	// The delimiter used to split the hosts and paths annotations (space, comma or newline)
+	AnnotationDelimiter string
+	// Whether generated add_header directives use the 'always' flag so headers are added to error responses
+	AlwaysAddHeaders bool
 	// The header name used to identify the API Key
 	APIKeyHeader string
 	// The secret name used to store the API Key for the namespace
 	APIKeySecret string
 	// The secret data field name to store the API Key for the namespace
 	APIKeySecretDataField string
+	// The secret data field name to store the basic auth credentials ({USER}:{PASSWORD}) for the namespace
+	BasicAuthSecretDataField string
+	// The status code the default server returns for requests to unknown hosts (444 closes the connection)
+	DefaultServerReturn int
+	// The MIME type of responses without a Content-Type (default_type)
+	DefaultType string
+	// Whether to print the nginx configuration of the cluster and exit without starting nginx
+	DryRun bool
+	// The error pages (status code to URI) nginx serves in place of its own error responses
+	ErrorPages map[int]string
+	// The level of the nginx error_log written to stderr
+	ErrorLogLevel string
+	// Whether the nginx error_log is written to stderr
+	ErrorLogToStderr bool
+	// The Retry-After seconds returned with EmptyCacheStatus (0 to not return a Retry-After header)
+	EmptyCacheRetryAfter int
+	// The status code the default server returns when there are no routable pods (0 to close the connection)
+	EmptyCacheStatus int
+	// How routes are secured when their router secret value is empty (deny rejects all requests, allow skips the check)
+	EmptySecretAction string
+	// Whether paths annotation entries with an empty path ({PORT}:) route / instead of being dropped
+	EmptyPathToRoot bool
+	// Whether nginx writes the access log to AccessLogPath with AccessLogFormat
+	EnableAccessLog bool
+	// Whether the debug endpoints (/validate) are served on the readiness port
+	EnableDebugEndpoints bool
+	// Whether upstream server membership changes are applied via ngx_dynamic_upstream instead of reloading nginx
+	EnableDynamicUpstreams bool
+	// Whether responses are gzip compressed
+	EnableGzip bool
+	// Whether upstream health checks are generated for nginx_upstream_check_module
+	EnableNginxUpstreamCheckModule bool
+	// Whether the request rate of each client address is limited (limit_req)
+	EnableRateLimit bool
+	// Whether nginx counts the requests and bytes of each host and upstream and serves them on TrafficStatusPort (nginx-module-vts)
+	EnableTrafficStatus bool
+	// Whether TLS connections are routed to the pods by their SNI server name without terminating TLS (stream module)
+	EnableTLSPassthrough bool
+	// The backend ({HOST}:{PORT}) added to every upstream as a backup server (empty to not add a backup server)
+	FallbackBackend string
+	// Whether the X-Forwarded-Port header is sent to the pods
+	ForwardPort bool
+	// The fixed X-Forwarded-Port value, such as the port of a port-mapping load balancer (0 for the port nginx received the request on)
+	ForwardedPort int
+	// The space delimited MIME types compressed when gzip is enabled
+	GzipTypes string
+	// The preference order of the container probes (liveness or readiness) upstream health checks are derived from
+	HealthCheckProbes []string
+	// The backend response headers hidden from clients
+	HideBackendHeaders []string
 	// The name of the annotation used to find hosts to route
 	HostsAnnotation string
+	// The nginx config files (glob patterns) included in the http block
+	IncludeFiles []string
+	// The status code returned when a request is rejected by a connection limit
+	LimitConnStatus int
+	// The status code returned when a request is rejected by a rate limit
+	LimitReqStatus int
+	// Whether every nginx server also listens on IPv6 ([::]) in addition to IPv4
+	ListenIPv6 bool
+	// The cluster-wide method upstreams balance requests with (round_robin, least_conn or ip_hash)
+	LoadBalanceMethod string
+	// The format of the router logs (text or json)
+	LogFormat string
+	// The level below which router log messages are suppressed (debug, info, warn or error)
+	LogLevel string
+	// The total number of connections across all nginx workers used to derive worker_connections (0 to use WorkerConnections)
+	MaxConnections int
+	// The maximum number of locations per host, additional locations are dropped (0 for unlimited)
+	MaxLocationsPerHost int
+	// The number of nginx reloads after which nginx is fully restarted to clean up lingering workers (0 to never restart)
+	MaxReloadsBeforeRestart int
 	// The name of the annotation used to find paths to route
 	PathsAnnotation string
+	// The path to the nginx master PID file
+	PidPath string
+	// The nginx binary used to start, test and reload nginx (a command name found on the PATH or a path)
+	NginxBinary string
+	// The path the nginx configuration is written to
+	NginxConfPath string
 	// The port that nginx will listen on
 	Port int
+	// Whether absolute redirects generated by nginx include the port (port_in_redirect)
+	PortInRedirect bool
+	// The secret data field name of the API Key being rotated out, accepted alongside the API Key (empty to disable)
+	PreviousAPIKeyField string
+	// The maximum size of the proxy cache, as an nginx size (Example: 1g)
+	ProxyCacheMaxSize string
+	// The directory cached responses are stored in (empty to disable the proxy cache)
+	ProxyCachePath string
+	// How long nginx waits to connect to a pod before trying the next pod
+	ProxyConnectTimeout string
+	// Whether TCP keepalive is enabled on upstream connections
+	ProxySocketKeepalive bool
+	// The number of requests a client can make in excess of RateLimitRate before being rejected
+	RateLimitBurst int
+	// The request rate limit of each client address, as an nginx rate (Example: 10r/s)
+	RateLimitRate string
+	// The port the router serves its /ready endpoint on (0 to disable the readiness endpoint)
+	ReadinessPort int
+	// The DNS servers nginx uses to resolve hostnames at request time (empty to not configure a resolver)
+	Resolver []string
+	// How long nginx waits on the resolver before failing resolution (empty to use the nginx default of 30s)
+	ResolverTimeout string
+	// Whether nginx is reloaded (and stopped) by signaling the PID in the PID file instead of using "nginx -s"
+	ReloadViaSignal bool
+	// Whether truthy label values (true, 1 or yes) satisfy routable label selector requirements on "true"
+	RoutableLabelBoolean bool
 	// The label selector used to identify routable objects
 	RoutableLabelSelector labels.Selector
+	// How long the router waits for connections to drain after failing its readiness endpoint before nginx quits
+	ShutdownGracePeriod time.Duration
+	// The number of times the initial cluster query is retried before giving up
+	StartupRetries int
+	// The interval before the first startup retry (doubled after each failed retry)
+	StartupRetryInterval time.Duration
+	// How long events are collected after the initial cluster query before the first reload (0 to reload immediately)
+	StartupSettleDelay time.Duration
+	// Whether tcp_nodelay is enabled
+	TCPNodelay bool
+	// Whether tcp_nopush is enabled
+	TCPNopush bool
+	// The port nginx listens on for TLS passthrough connections
+	TLSPassthroughPort int
+	// The port nginx listens on for TLS terminated traffic
+	TLSPort int
+	// The port nginx serves the traffic status on when EnableTrafficStatus is enabled
+	TrafficStatusPort int
+	// The name of the TLS secrets (kubernetes.io/tls) used to terminate TLS for the hosts in their namespace (empty to disable)
+	TLSSecret string
+	// The number of idle keepalive connections to the pods cached by each upstream (0 to disable keepalive)
+	UpstreamKeepalive int
+	// The number of requests served through an upstream keepalive connection (0 to use the nginx default)
+	UpstreamKeepaliveRequests int
+	// The maximum lifetime of an upstream keepalive connection (empty to use the nginx default)
+	UpstreamKeepaliveTime string
+	// The strategy used to order the servers of an upstream (name, ip or insertion-stable)
+	UpstreamServerOrder string
+	// Whether a warning is logged when a pod's duplicate hosts/paths are collapsed into single routes
+	WarnOnDuplicateRoutes bool
+	// Whether upstream server weights are derived from the pods' CPU allocation when a pod does not set its weight
+	WeightByResources bool
+	// The number of connections each nginx worker process handles, unless derived from MaxConnections
+	WorkerConnections int
+	// The number of nginx worker processes (or auto to use the CPU count), empty to use the nginx default
+	WorkerProcesses string
 	// Max client request body size. nginx config: client_max_body_size. eg 10m
 	ClientMaxBodySize string
+	// The buffer size for reading client request headers (client_header_buffer_size)
+	ClientHeaderBufferSize string
+	// The charset added to the Content-Type of text responses (charset), empty to not add one
+	Charset string
+	// Whether the hosts and paths with identical servers share a single upstream
+	ConsolidateUpstreams bool
+	// The backend ({NAMESPACE}/{NAME}) whose pods serve requests not matched by any route
+	NotFoundBackend string
+}
+
+/*
+HealthCheck describes an upstream health check derived from a container probe
+*/
+type HealthCheck struct {
+	// The failures (probe failure threshold) before the pod is marked down
+	Fall int
+	// The interval (probe period) between checks in milliseconds
+	Interval int
+	// The path the check requests
+	Path string
+	// The port the check connects to
+	Port int
+	// The probe (liveness or readiness) the check was derived from
+	Probe string
+	// The successes (probe success threshold) before the pod is marked up
+	Rise int
+	// The timeout (probe timeout) of a check in milliseconds
+	Timeout int
 }
 
 /*
@@ -57,25 +236,60 @@ Incoming describes the information required to route an incoming request
 type Incoming struct {
 	Host string
 	Path string
+	// The port nginx listens on for the host (empty to use the default port)
+	Port string
+	// The path the routing path prefix is replaced with before proxying (empty to proxy the path as is)
+	Rewrite string
 }
 
 /*
 Outgoing describes the information required to proxy to a backend
 */
 type Outgoing struct {
-	IP   string
-	Port string
+	IP           string
+	PathTemplate string
+	Port         string
+	Timeouts     *Timeouts
+	// Whether the connections are upgraded to websockets
+	Websocket bool
+	Weight    int
 }
 
 /*
 PodWithRoutes contains a pod and its routes
 */
 type PodWithRoutes struct {
-	Name string
-	Namespace string
-	Status api.PodPhase
-	AnnotationHash uint64
-	Routes []*Route
+	Name                  string
+	Namespace             string
+	Created               time.Time
+	Status                api.PodPhase
+	Routable              bool
+	AnnotationHash        uint64
+	AuthMode              string
+	AuthRequest           string
+	AuthRequestSigninURL  string
+	BackendHost           string
+	CacheBypass           []string
+	CanaryPercent         int
+	CORSMethods           string
+	CORSOrigin            string
+	Gzip                  string
+	HealthCheck           *HealthCheck
+	LoadBalanceMethod     string
+	MethodRewrites        map[string]string
+	MirrorPercentage      int
+	MirrorTarget          string
+	ProxyCache            bool
+	ProxyCacheLockTimeout string
+	ProxyCacheUseStale    []string
+	ProxyIgnoreHeaders    []string
+	RequestBuffering      string
+	RoutingService        string
+	StripAuthorization    bool
+	SubFilters            []*SubFilter
+	TLSPassthroughPort    string
+	UnixSocket            string
+	Routes                []*Route
 }
 
 /*
@@ -85,3 +299,30 @@ type Route struct {
 	Incoming *Incoming
 	Outgoing *Outgoing
 }
+
+/*
+RouteIssue describes a problem found in a pod's annotations while computing its routes
+*/
+type RouteIssue struct {
+	// The description of the problem (Example: routingHosts (bad_host) is not a valid hostname/ip)
+	Message string `json:"message"`
+	// Whether the problem leaves the pod without routes, otherwise the offending value is ignored or replaced
+	NotRoutable bool `json:"notRoutable"`
+}
+
+/*
+SubFilter describes a response body rewrite
+*/
+type SubFilter struct {
+	From string
+	To   string
+}
+
+/*
+Timeouts describes the proxy timeouts used when proxying to a backend (empty values use the nginx defaults)
+*/
+type Timeouts struct {
+	Connect string
+	Read    string
+	Send    string
+}
@@ -0,0 +1,151 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/kubernetes/pkg/api"
+)
+
+const (
+	// validatePodIP is the IP of the synthetic pod the /validate endpoint computes routes for
+	validatePodIP = "10.0.0.1"
+	// validatePodName is the name of the synthetic pod the /validate endpoint computes routes for
+	validatePodName = "k8s-router-validate"
+)
+
+/*
+ValidateRequest is the /validate request body describing the pod whose routes are computed
+*/
+type ValidateRequest struct {
+	Annotations map[string]string `json:"annotations"`
+	Labels      map[string]string `json:"labels"`
+	Ports       []int32           `json:"ports"`
+}
+
+/*
+ValidateResponse is the /validate response body containing the computed routes and the routing issues found
+*/
+type ValidateResponse struct {
+	Issues []RouteIssue     `json:"issues"`
+	Routes []*ValidateRoute `json:"routes"`
+}
+
+/*
+ValidateRoute is a route computed by the /validate endpoint
+*/
+type ValidateRoute struct {
+	Host         string `json:"host"`
+	Path         string `json:"path"`
+	PathTemplate string `json:"pathTemplate,omitempty"`
+	Port         string `json:"port,omitempty"`
+	Rewrite      string `json:"rewrite,omitempty"`
+	TargetPort   string `json:"targetPort"`
+	Websocket    bool   `json:"websocket,omitempty"`
+	Weight       int    `json:"weight,omitempty"`
+}
+
+/*
+ValidateRoutes computes the routes, and collects the routing issues, for a synthetic pod built from the request
+*/
+func ValidateRoutes(config *Config, request *ValidateRequest) *ValidateResponse {
+	var ports []api.ContainerPort
+
+	for _, port := range request.Ports {
+		ports = append(ports, api.ContainerPort{
+			ContainerPort: port,
+		})
+	}
+
+	pod := &api.Pod{
+		ObjectMeta: api.ObjectMeta{
+			Annotations: request.Annotations,
+			Labels:      request.Labels,
+			Name:        validatePodName,
+		},
+		Spec: api.PodSpec{
+			Containers: []api.Container{
+				api.Container{
+					Ports: ports,
+				},
+			},
+		},
+		Status: api.PodStatus{
+			Phase: api.PodRunning,
+			PodIP: validatePodIP,
+		},
+	}
+
+	// Convert the whole pod so that the issues of every annotation are reported
+	model, issues := ConvertPodToModelWithIssues(config, pod)
+
+	response := &ValidateResponse{
+		Issues: issues,
+		Routes: []*ValidateRoute{},
+	}
+
+	if !IsRoutable(config, request.Labels) {
+		response.Issues = append(response.Issues, RouteIssue{
+			Message:     "labels do not match the routable label selector (" + config.RoutableLabelSelector.String() + ")",
+			NotRoutable: true,
+		})
+	}
+
+	for _, route := range model.Routes {
+		response.Routes = append(response.Routes, &ValidateRoute{
+			Host:         route.Incoming.Host,
+			Path:         route.Incoming.Path,
+			PathTemplate: route.Outgoing.PathTemplate,
+			Port:         route.Incoming.Port,
+			Rewrite:      route.Incoming.Rewrite,
+			TargetPort:   route.Outgoing.Port,
+			Websocket:    route.Outgoing.Websocket,
+			Weight:       route.Outgoing.Weight,
+		})
+	}
+
+	return response
+}
+
+/*
+ValidateHandler returns the handler for the /validate endpoint, which accepts a POSTed ValidateRequest and responds
+with the routes, and routing issues, the router would compute for a pod with those annotations, labels and ports
+*/
+func ValidateHandler(config *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+
+			return
+		}
+
+		var request ValidateRequest
+
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		json.NewEncoder(w).Encode(ValidateRoutes(config, &request))
+	}
+}
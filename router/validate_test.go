@@ -0,0 +1,121 @@
+/*
+Copyright © 2016 Apigee Corporation
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/*
+Test for github.com/30x/k8s-router/router/validate#ValidateHandler
+*/
+func TestValidateHandler(t *testing.T) {
+	post := func(method, body string) (int, *ValidateResponse) {
+		recorder := httptest.NewRecorder()
+		request, err := http.NewRequest(method, "/validate", strings.NewReader(body))
+
+		if err != nil {
+			t.Fatalf("Failed to create the request: %v", err)
+		}
+
+		ValidateHandler(config)(recorder, request)
+
+		if recorder.Code != http.StatusOK {
+			return recorder.Code, nil
+		}
+
+		var response ValidateResponse
+
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse the response (%s): %v", recorder.Body.String(), err)
+		}
+
+		return recorder.Code, &response
+	}
+
+	// Valid annotations
+	code, response := post("POST", `{
+  "annotations": {"routingHosts": "test.github.com", "routingPaths": "3000:/nodejs 8080:/java"},
+  "labels": {"routable": "true"},
+  "ports": [3000, 8080]
+}`)
+
+	if code != http.StatusOK {
+		t.Fatalf("Expected a 200 but found %d", code)
+	} else if len(response.Issues) != 0 {
+		t.Fatalf("Expected no issues but found: %v", response.Issues)
+	} else if len(response.Routes) != 2 {
+		t.Fatalf("Expected 2 routes but found %d", len(response.Routes))
+	}
+
+	for i, expected := range []ValidateRoute{
+		ValidateRoute{Host: "test.github.com", Path: "/nodejs", TargetPort: "3000"},
+		ValidateRoute{Host: "test.github.com", Path: "/java", TargetPort: "8080"},
+	} {
+		if *response.Routes[i] != expected {
+			t.Fatalf("Unexpected route: %+v", *response.Routes[i])
+		}
+	}
+
+	// Invalid annotations
+	code, response = post("POST", `{
+  "annotations": {"routingHosts": "test.github.com bad_host", "routingPaths": "3000:/nodejs 9090:/java"},
+  "ports": [3000]
+}`)
+
+	if code != http.StatusOK {
+		t.Fatalf("Expected a 200 but found %d", code)
+	} else if len(response.Routes) != 1 || response.Routes[0].Path != "/nodejs" {
+		t.Fatalf("Expected only the /nodejs route but found %d routes", len(response.Routes))
+	}
+
+	expectedIssues := []RouteIssue{
+		RouteIssue{Message: "routingHosts (bad_host) is not a valid hostname/ip"},
+		RouteIssue{Message: "routingPaths port (9090) is not an exposed container port"},
+		RouteIssue{Message: "labels do not match the routable label selector (routable=true)", NotRoutable: true},
+	}
+
+	if fmt.Sprint(response.Issues) != fmt.Sprint(expectedIssues) {
+		t.Fatalf("Unexpected issues: %v", response.Issues)
+	}
+
+	// Missing annotations
+	code, response = post("POST", `{"labels": {"routable": "true"}}`)
+
+	if code != http.StatusOK {
+		t.Fatalf("Expected a 200 but found %d", code)
+	} else if len(response.Routes) != 0 {
+		t.Fatalf("Expected no routes but found %d", len(response.Routes))
+	} else if fmt.Sprint(response.Issues) != fmt.Sprint([]RouteIssue{RouteIssue{Message: "Missing 'routingHosts' annotation", NotRoutable: true}}) {
+		t.Fatalf("Unexpected issues: %v", response.Issues)
+	}
+
+	// Invalid request body
+	if code, _ = post("POST", "{"); code != http.StatusBadRequest {
+		t.Fatalf("Expected a 400 for an invalid body but found %d", code)
+	}
+
+	// Invalid method
+	if code, _ = post("GET", ""); code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected a 405 for a GET but found %d", code)
+	}
+}